@@ -0,0 +1,342 @@
+package authorizationserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// adminTokens are the bearer tokens of the admin API, read from ADMIN_TOKENS as a comma separated list. Without them,
+// the admin API is disabled.
+var adminTokens = fosite.RemoveEmpty(strings.Split(os.Getenv("ADMIN_TOKENS"), ","))
+
+var errClientExists = &fosite.RFC6749Error{
+	ErrorField:       "client_exists",
+	DescriptionField: "A client with the requested client_id exists already.",
+	CodeField:        http.StatusConflict,
+}
+
+// adminClient is a client as the admin API shows and takes it: the metadata clients register themselves with, plus
+// the settings only administrators may change. Secrets are managed on their own and never shown, except for a new one
+// when it is issued.
+type adminClient struct {
+	ClientID      string                        `json:"client_id"`
+	ClientSecret  string                        `json:"client_secret,omitempty"`
+	Secrets       []adminClientSecret           `json:"secrets,omitempty"`
+	Audience      []string                      `json:"audience,omitempty"`
+	TokenExchange *sqlstore.TokenExchangePolicy `json:"token_exchange,omitempty"`
+	clientMetadata
+}
+
+// adminClientSecret is a secret of a client without its hash. The secret itself is only set when it is issued.
+type adminClientSecret struct {
+	ID        string     `json:"id"`
+	Secret    string     `json:"secret,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired,omitempty"`
+}
+
+// adminClientsEndpoint lists (`GET`) and creates (`POST`) clients. Clients are validated like at the registration
+// endpoint, but may be given any scope, audiences and a token exchange policy.
+func adminClientsEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	if !containsToken(adminTokens, bearerToken(req)) {
+		writeBearerError(rw, http.StatusUnauthorized, "invalid_token", "The admin token is missing or invalid.")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		clients, err := store.ListClients(ctx)
+		if err != nil {
+			log.Printf("Error occurred in ListClients: %+v", err)
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+
+		list := make([]*adminClient, 0, len(clients))
+		for _, client := range clients {
+			list = append(list, adminClientOf(client, ""))
+		}
+		writeAdminResponse(rw, http.StatusOK, list)
+
+	case http.MethodPost:
+		var body adminClient
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			oauth2.WriteAccessError(ctx, rw, nil, errInvalidClientMetadata.WithHint("The request body must be a JSON object of client metadata.").WithWrap(err).WithDebug(err.Error()))
+			return
+		}
+		if body.ClientID == "" {
+			body.ClientID = uuid.New().String()
+		}
+
+		if _, err := store.GetClient(ctx, body.ClientID); err == nil {
+			oauth2.WriteAccessError(ctx, rw, nil, errClientExists)
+			return
+		} else if !errors.Is(err, fosite.ErrNotFound) {
+			log.Printf("Error occurred in GetClient: %+v", err)
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+
+		if err := checkClientAudience(body.Audience); err != nil {
+			oauth2.WriteAccessError(ctx, rw, nil, err)
+			return
+		}
+
+		client := sqlstore.NewClient(body.ClientID)
+		client.Audience = body.Audience
+		client.TokenExchange = body.TokenExchange
+		secret, err := applyClientMetadata(ctx, client, &body.clientMetadata, nil)
+		if err != nil {
+			log.Printf("Error occurred in applyClientMetadata: %+v", err)
+			oauth2.WriteAccessError(ctx, rw, nil, err)
+			return
+		}
+		if err := store.CreateClient(ctx, client); err != nil {
+			log.Printf("Error occurred in CreateClient: %+v", err)
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+		writeAdminResponse(rw, http.StatusCreated, adminClientOf(client, secret))
+
+	default:
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("HTTP method is '%s', expected 'GET' or 'POST'.", req.Method))
+	}
+}
+
+// adminClientEndpoint reads (`GET`), replaces (`PUT`) and deletes (`DELETE`) the client at `/admin/clients/<id>`,
+// and manages its secrets at `/admin/clients/<id>/secrets`.
+func adminClientEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	if !containsToken(adminTokens, bearerToken(req)) {
+		writeBearerError(rw, http.StatusUnauthorized, "invalid_token", "The admin token is missing or invalid.")
+		return
+	}
+
+	id, rest, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/admin/clients/"), "/")
+	found, err := store.GetClient(ctx, id)
+	if errors.Is(err, fosite.ErrNotFound) {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrNotFound.WithHintf("There is no client '%s'.", id))
+		return
+	} else if err != nil {
+		log.Printf("Error occurred in GetClient: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+	client, ok := found.(*sqlstore.Client)
+	if !ok {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithDebug("The store must return a *sqlstore.Client."))
+		return
+	}
+
+	switch {
+	case rest == "secrets":
+		adminClientSecretsEndpoint(rw, req, client)
+		return
+	case strings.HasPrefix(rest, "secrets/"):
+		adminClientSecretEndpoint(rw, req, client, strings.TrimPrefix(rest, "secrets/"))
+		return
+	case rest != "":
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		writeAdminResponse(rw, http.StatusOK, adminClientOf(client, ""))
+
+	case http.MethodPut:
+		// The update replaces the client, except for its secrets and registration access token.
+		var body adminClient
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			oauth2.WriteAccessError(ctx, rw, nil, errInvalidClientMetadata.WithHint("The request body must be a JSON object of client metadata.").WithWrap(err).WithDebug(err.Error()))
+			return
+		}
+		if body.ClientID != "" && body.ClientID != client.GetID() {
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHint("The 'client_id' of the request body must match the client being updated."))
+			return
+		}
+
+		if err := checkClientAudience(body.Audience); err != nil {
+			oauth2.WriteAccessError(ctx, rw, nil, err)
+			return
+		}
+
+		updated := sqlstore.NewClient(client.GetID())
+		updated.Audience = body.Audience
+		updated.TokenExchange = body.TokenExchange
+		updated.RegistrationAccessTokenHash = client.RegistrationAccessTokenHash
+		secret, err := applyClientMetadata(ctx, updated, &body.clientMetadata, client)
+		if err != nil {
+			log.Printf("Error occurred in applyClientMetadata: %+v", err)
+			oauth2.WriteAccessError(ctx, rw, nil, err)
+			return
+		}
+		if err := store.UpdateClient(ctx, updated); err != nil {
+			log.Printf("Error occurred in UpdateClient: %+v", err)
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+		writeAdminResponse(rw, http.StatusOK, adminClientOf(updated, secret))
+
+	case http.MethodDelete:
+		if err := store.DeleteClient(ctx, client.GetID()); err != nil {
+			log.Printf("Error occurred in DeleteClient: %+v", err)
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+
+	default:
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("HTTP method is '%s', expected 'GET', 'PUT' or 'DELETE'.", req.Method))
+	}
+}
+
+// adminClientSecretsEndpoint issues a new secret to the client (`POST`), optionally expiring at `expires_at`. The
+// client can authenticate with all of its secrets that have not expired, so callers can be moved over to the new
+// secret before the old one is retired.
+func adminClientSecretsEndpoint(rw http.ResponseWriter, req *http.Request, client *sqlstore.Client) {
+	ctx := req.Context()
+
+	if req.Method != http.MethodPost {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("HTTP method is '%s', expected 'POST'.", req.Method))
+		return
+	}
+	if method := client.GetTokenEndpointAuthMethod(); method != "client_secret_basic" && method != "client_secret_post" {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("The client uses token_endpoint_auth_method '%s', which does not use client secrets.", method))
+		return
+	}
+
+	// The body is optional, a secret without it does not expire.
+	var body adminClientSecret
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHint("The request body must be a JSON object.").WithWrap(err).WithDebug(err.Error()))
+		return
+	}
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHint("The 'expires_at' of a new secret must be in the future."))
+		return
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		log.Printf("Error occurred in randomToken: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+	hash, err := config.GetSecretsHasher(ctx).Hash(ctx, []byte(secret))
+	if err != nil {
+		log.Printf("Error occurred in Hash: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	issued := sqlstore.NewClientSecret(hash, body.ExpiresAt)
+	client.Secrets = append(client.Secrets, issued)
+	if err := store.UpdateClient(ctx, client); err != nil {
+		log.Printf("Error occurred in UpdateClient: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+	writeAdminResponse(rw, http.StatusCreated, &adminClientSecret{ID: issued.ID, Secret: secret, ExpiresAt: issued.ExpiresAt})
+}
+
+// adminClientSecretEndpoint changes when a secret of the client expires (`PATCH`) or retires it right away
+// (`DELETE`). Setting `expires_at` gives callers still using the secret a grace period, leaving it out makes the
+// secret not expire.
+func adminClientSecretEndpoint(rw http.ResponseWriter, req *http.Request, client *sqlstore.Client, secretID string) {
+	ctx := req.Context()
+
+	index := -1
+	for i, secret := range client.Secrets {
+		if secret.ID == secretID {
+			index = i
+		}
+	}
+	if index < 0 {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrNotFound.WithHintf("The client has no secret '%s'.", secretID))
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPatch:
+		var body adminClientSecret
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHint("The request body must be a JSON object.").WithWrap(err).WithDebug(err.Error()))
+			return
+		}
+		client.Secrets[index].ExpiresAt = body.ExpiresAt
+
+	case http.MethodDelete:
+		client.Secrets = append(client.Secrets[:index], client.Secrets[index+1:]...)
+
+	default:
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("HTTP method is '%s', expected 'PATCH' or 'DELETE'.", req.Method))
+		return
+	}
+
+	if err := store.UpdateClient(ctx, client); err != nil {
+		log.Printf("Error occurred in UpdateClient: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+	if req.Method == http.MethodDelete {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeAdminResponse(rw, http.StatusOK, adminClientSecretOf(client.Secrets[index]))
+}
+
+// checkClientAudience returns errInvalidClientMetadata unless every audience of a client is a registered resource
+// server. Tokens for anything else could not be requested, nor introspected by their audience.
+func checkClientAudience(audience []string) error {
+	for _, uri := range audience {
+		if _, ok := resourceRegistry[uri]; !ok {
+			return errInvalidClientMetadata.WithHintf("The audience '%s' is not a registered resource server.", uri)
+		}
+	}
+	return nil
+}
+
+// adminClientOf returns the client as the admin API shows it. The secret is only known when it was just issued.
+func adminClientOf(client *sqlstore.Client, secret string) *adminClient {
+	shown := &adminClient{
+		ClientID:       client.GetID(),
+		ClientSecret:   secret,
+		Audience:       client.Audience,
+		TokenExchange:  client.TokenExchange,
+		clientMetadata: clientMetadataOf(client),
+	}
+	for _, s := range client.Secrets {
+		shown.Secrets = append(shown.Secrets, *adminClientSecretOf(s))
+	}
+	return shown
+}
+
+func adminClientSecretOf(secret sqlstore.ClientSecret) *adminClientSecret {
+	return &adminClientSecret{ID: secret.ID, ExpiresAt: secret.ExpiresAt, Expired: secret.Expired()}
+}
+
+func writeAdminResponse(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Printf("Error occurred in writeAdminResponse: %+v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package authorizationserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useAdminTokens enables the admin API with the given tokens until the test ends.
+func useAdminTokens(t *testing.T, tokens ...string) {
+	previous := adminTokens
+	adminTokens = tokens
+	t.Cleanup(func() { adminTokens = previous })
+}
+
+// adminRequest sends a request with the admin token "admin-token" to the admin API for clients.
+func adminRequest(method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rw := httptest.NewRecorder()
+	if path == "/admin/clients" {
+		adminClientsEndpoint(rw, req)
+	} else {
+		adminClientEndpoint(rw, req)
+	}
+	return rw
+}
+
+// clientCredentialsToken asks the token endpoint for a token with the client credentials grant and returns the
+// status.
+func clientCredentialsToken(clientID string, secret string) int {
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, secret)
+	rw := httptest.NewRecorder()
+	tokenEndpoint(rw, req)
+	return rw.Code
+}
+
+func TestAdminClientsEndpoint(t *testing.T) {
+	useAdminTokens(t, "admin-token")
+	useTestStore(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/clients", nil)
+	req.Header.Set("Authorization", "Bearer wrong-token")
+	rw := httptest.NewRecorder()
+	adminClientsEndpoint(rw, req)
+	if rw.Code != http.StatusUnauthorized {
+		t.Errorf("listing the clients with a wrong token returned %d: %s", rw.Code, rw.Body)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "registered audience", body: `{"client_id":"admin-client","grant_types":["client_credentials"],"audience":["https://photos.my-application.com"],"scope":"photos"}`, status: http.StatusCreated},
+		{name: "existing client", body: `{"client_id":"admin-client","grant_types":["client_credentials"]}`, status: http.StatusConflict},
+		{name: "unknown audience", body: `{"client_id":"other-client","grant_types":["client_credentials"],"audience":["https://unknown.example.com"]}`, status: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if rw := adminRequest(http.MethodPost, "/admin/clients", tc.body); rw.Code != tc.status {
+				t.Errorf("got %d, want %d: %s", rw.Code, tc.status, rw.Body)
+			}
+		})
+	}
+
+	rw = adminRequest(http.MethodGet, "/admin/clients", "")
+	var list []adminClient
+	if err := json.Unmarshal(rw.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].ClientID != "admin-client" {
+		t.Fatalf("listing the clients returned %d: %s", rw.Code, rw.Body)
+	}
+	if strings.Contains(rw.Body.String(), `"client_secret"`) {
+		t.Errorf("the list shows a client secret: %s", rw.Body)
+	}
+
+	if rw := adminRequest(http.MethodPut, "/admin/clients/admin-client", `{"grant_types":["client_credentials"],"audience":["https://unknown.example.com"]}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("replacing the audience with an unknown one returned %d: %s", rw.Code, rw.Body)
+	}
+	if rw := adminRequest(http.MethodPut, "/admin/clients/admin-client", `{"grant_types":["client_credentials"],"audience":["http://localhost:3846/protected"]}`); rw.Code != http.StatusOK {
+		t.Errorf("replacing the audience returned %d: %s", rw.Code, rw.Body)
+	}
+
+	if rw := adminRequest(http.MethodDelete, "/admin/clients/admin-client", ""); rw.Code != http.StatusNoContent {
+		t.Errorf("deleting the client returned %d: %s", rw.Code, rw.Body)
+	}
+	if rw := adminRequest(http.MethodGet, "/admin/clients/admin-client", ""); rw.Code != http.StatusNotFound {
+		t.Errorf("reading a deleted client returned %d: %s", rw.Code, rw.Body)
+	}
+}
+
+func TestAdminClientSecretRotation(t *testing.T) {
+	useAdminTokens(t, "admin-token")
+	useTestStore(t)
+
+	rw := adminRequest(http.MethodPost, "/admin/clients", `{"client_id":"admin-client","grant_types":["client_credentials"]}`)
+	var created adminClient
+	if err := json.Unmarshal(rw.Body.Bytes(), &created); err != nil || created.ClientSecret == "" || len(created.Secrets) != 1 {
+		t.Fatalf("creating the client returned %d: %s", rw.Code, rw.Body)
+	}
+	oldSecret, oldID := created.ClientSecret, created.Secrets[0].ID
+
+	rw = adminRequest(http.MethodPost, "/admin/clients/admin-client/secrets", "")
+	var issued adminClientSecret
+	if err := json.Unmarshal(rw.Body.Bytes(), &issued); err != nil || rw.Code != http.StatusCreated || issued.Secret == "" {
+		t.Fatalf("issuing a secret returned %d: %s", rw.Code, rw.Body)
+	}
+
+	// Both secrets work until the old one is given an expiry that passed.
+	for _, secret := range []string{oldSecret, issued.Secret} {
+		if status := clientCredentialsToken("admin-client", secret); status != http.StatusOK {
+			t.Errorf("authenticating with a current secret returned %d", status)
+		}
+	}
+	if rw := adminRequest(http.MethodPost, "/admin/clients/admin-client/secrets", `{"expires_at":"2001-01-01T00:00:00Z"}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("issuing an expired secret returned %d: %s", rw.Code, rw.Body)
+	}
+	expiresAt := time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+	if rw := adminRequest(http.MethodPatch, "/admin/clients/admin-client/secrets/"+oldID, `{"expires_at":"`+expiresAt+`"}`); rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), `"expired":true`) {
+		t.Fatalf("expiring the old secret returned %d: %s", rw.Code, rw.Body)
+	}
+	if status := clientCredentialsToken("admin-client", oldSecret); status != http.StatusUnauthorized {
+		t.Errorf("authenticating with the expired secret returned %d", status)
+	}
+	if status := clientCredentialsToken("admin-client", issued.Secret); status != http.StatusOK {
+		t.Errorf("authenticating with the new secret returned %d", status)
+	}
+
+	// A secret that is deleted stops working right away.
+	if rw := adminRequest(http.MethodDelete, "/admin/clients/admin-client/secrets/"+issued.ID, ""); rw.Code != http.StatusNoContent {
+		t.Fatalf("deleting the new secret returned %d: %s", rw.Code, rw.Body)
+	}
+	if status := clientCredentialsToken("admin-client", issued.Secret); status != http.StatusUnauthorized {
+		t.Errorf("authenticating with the deleted secret returned %d", status)
+	}
+}
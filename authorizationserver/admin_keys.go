@@ -0,0 +1,86 @@
+package authorizationserver
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+)
+
+// adminKey is a signing key as the admin API shows it, without its private part.
+type adminKey struct {
+	ID        string     `json:"kid"`
+	State     keyState   `json:"state"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+// adminKeysEndpoint lists (`GET`) the signing keys and rotates (`POST`) them, like SIGHUP does.
+func adminKeysEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	if !containsToken(adminTokens, bearerToken(req)) {
+		writeBearerError(rw, http.StatusUnauthorized, "invalid_token", "The admin token is missing or invalid.")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := keys.Rotate(); err != nil {
+			log.Printf("Error occurred in Rotate: %+v", err)
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+	default:
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("HTTP method is '%s', expected 'GET' or 'POST'.", req.Method))
+		return
+	}
+
+	managed := keys.List()
+	list := make([]*adminKey, 0, len(managed))
+	for _, key := range managed {
+		list = append(list, adminKeyOf(key))
+	}
+	writeAdminResponse(rw, http.StatusOK, list)
+}
+
+// adminKeyEndpoint revokes (`DELETE`) the signing key at `/admin/keys/<kid>`, e.g. when it was compromised. Tokens
+// signed with it no longer verify, and a revoked active key is replaced right away.
+func adminKeyEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	if !containsToken(adminTokens, bearerToken(req)) {
+		writeBearerError(rw, http.StatusUnauthorized, "invalid_token", "The admin token is missing or invalid.")
+		return
+	}
+	if req.Method != http.MethodDelete {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("HTTP method is '%s', expected 'DELETE'.", req.Method))
+		return
+	}
+
+	kid := strings.TrimPrefix(req.URL.Path, "/admin/keys/")
+	if err := keys.Revoke(kid); errors.Is(err, errUnknownKey) {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrNotFound.WithHintf("There is no signing key '%s' to revoke.", kid))
+		return
+	} else if err != nil {
+		log.Printf("Error occurred in Revoke: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+	log.Printf("Revoked signing key %s", kid)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func adminKeyOf(key managedKey) *adminKey {
+	shown := &adminKey{ID: key.ID, State: key.State, CreatedAt: key.CreatedAt}
+	if !key.RetiredAt.IsZero() {
+		shown.RetiredAt = &key.RetiredAt
+	}
+	return shown
+}
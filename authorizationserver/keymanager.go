@@ -0,0 +1,411 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite/token/jwt"
+)
+
+// keyState is the lifecycle state of a signing key. A key moves from next to active to retiring, and is dropped once
+// every token it signed has expired. A key can be revoked at any point, e.g. when it was compromised, it is dropped
+// just the same.
+type keyState string
+
+const (
+	// keyStateNext keys are published in the JWKS ahead of time, so verifiers already know them once they become
+	// active, but nothing is signed with them yet.
+	keyStateNext keyState = "next"
+	// keyStateActive is the single key new tokens are signed with.
+	keyStateActive keyState = "active"
+	// keyStateRetiring keys no longer sign but stay published until the tokens they signed have expired.
+	keyStateRetiring keyState = "retiring"
+	// keyStateRevoked keys are removed from the JWKS immediately and no longer verify anything. They are still listed
+	// until the tokens they signed would have expired.
+	keyStateRevoked keyState = "revoked"
+)
+
+var errUnknownKey = errors.New("unknown signing key")
+
+// managedKey is a signing key together with its lifecycle state.
+type managedKey struct {
+	*signingKey
+	State     keyState
+	CreatedAt time.Time
+	// RetiredAt is set when the key stops signing. The key is published until RetiredAt plus the retention period.
+	RetiredAt time.Time
+}
+
+// keyManager holds the signing keys of the authorization server and rotates them. Rotation promotes the next key to
+// active, moves the active key to retiring, and creates a fresh next key.
+type keyManager struct {
+	mu   sync.RWMutex
+	keys []*managedKey
+
+	// retention is how long a retiring key is kept, which must be at least the lifespan of the longest-lived token
+	// signed with it.
+	retention time.Duration
+
+	// path is where keys are persisted so they survive restarts. Keys are only kept in memory if empty.
+	path string
+}
+
+// newKeyManager creates a key manager. If persisted keys exist at path they are restored, otherwise initial becomes
+// the active key.
+func newKeyManager(initial *signingKey, retention time.Duration, path string) *keyManager {
+	m := &keyManager{retention: retention, path: path}
+
+	if path != "" {
+		keys, err := loadManagedKeys(path)
+		if err == nil {
+			m.keys = keys
+			return m
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Could not restore signing keys from %s: %+v", path, err)
+		}
+	}
+
+	m.keys = []*managedKey{{signingKey: initial, State: keyStateActive, CreatedAt: time.Now().UTC()}}
+	if err := m.addNextKey(); err != nil {
+		panic(err)
+	}
+	if err := m.save(); err != nil {
+		log.Printf("Could not persist signing keys to %s: %+v", path, err)
+	}
+	return m
+}
+
+// Active returns the key new tokens are signed with.
+func (m *keyManager) Active() *signingKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.keys {
+		if key.State == keyStateActive {
+			return key.signingKey
+		}
+	}
+	// newKeyManager and Rotate always leave exactly one active key behind.
+	panic("no active signing key")
+}
+
+// Key returns the key with the given id, as long as it may still be used to verify tokens.
+func (m *keyManager) Key(kid string) (*signingKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.keys {
+		if key.ID == kid && m.verifies(key, time.Now().UTC()) {
+			return key.signingKey, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", errUnknownKey, kid)
+}
+
+// PublicKeys returns the keys that belong into the JWKS: the next, the active and all retiring keys whose tokens may
+// still be in circulation.
+func (m *keyManager) PublicKeys() []jose.JSONWebKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now().UTC()
+	keys := make([]jose.JSONWebKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		if m.verifies(key, now) {
+			keys = append(keys, key.PublicJWK())
+		}
+	}
+	return keys
+}
+
+// List returns a copy of every key the manager holds, revoked ones included, in the order they were created.
+func (m *keyManager) List() []managedKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]managedKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		keys = append(keys, *key)
+	}
+	return keys
+}
+
+// Rotate promotes the next key to active and retires the previously active key.
+func (m *keyManager) Rotate() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.rotate(); err != nil {
+		return err
+	}
+	return m.save()
+}
+
+// Revoke withdraws a key immediately. Tokens signed with it no longer verify. Revoking the active key rotates first,
+// so there is always a key to sign with.
+func (m *keyManager) Revoke(kid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.keys {
+		if key.ID != kid || key.State == keyStateRevoked {
+			continue
+		}
+
+		if key.State == keyStateActive {
+			if err := m.rotate(); err != nil {
+				return err
+			}
+		}
+		if key.State == keyStateNext {
+			if err := m.addNextKey(); err != nil {
+				return err
+			}
+		}
+		key.State = keyStateRevoked
+		return m.save()
+	}
+	return fmt.Errorf("%w: %s", errUnknownKey, kid)
+}
+
+// RunRotation rotates the keys every interval until the context is canceled.
+func (m *keyManager) RunRotation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Rotate(); err != nil {
+				log.Printf("Error occurred while rotating signing keys: %+v", err)
+			}
+		}
+	}
+}
+
+// rotate must be called with the write lock held.
+func (m *keyManager) rotate() error {
+	now := time.Now().UTC()
+	m.prune(now)
+
+	var next *managedKey
+	for _, key := range m.keys {
+		if key.State == keyStateNext {
+			next = key
+		}
+	}
+	if next == nil {
+		return errors.New("no next signing key to rotate to")
+	}
+
+	for _, key := range m.keys {
+		if key.State == keyStateActive {
+			key.State = keyStateRetiring
+			key.RetiredAt = now
+		}
+	}
+	next.State = keyStateActive
+
+	return m.addNextKey()
+}
+
+// prune drops the retiring and revoked keys whose tokens have all expired, there is nothing left for them to verify.
+// Revoked next keys never signed anything and are dropped as well. It must be called with the write lock held.
+func (m *keyManager) prune(now time.Time) {
+	kept := m.keys[:0]
+	for _, key := range m.keys {
+		if (key.State == keyStateRetiring || key.State == keyStateRevoked) && !now.Before(key.RetiredAt.Add(m.retention)) {
+			continue
+		}
+		kept = append(kept, key)
+	}
+	m.keys = kept
+}
+
+// addNextKey must be called with the write lock held.
+func (m *keyManager) addNextKey() error {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	m.keys = append(m.keys, &managedKey{
+		signingKey: newSigningKey(privateKey),
+		State:      keyStateNext,
+		CreatedAt:  time.Now().UTC(),
+	})
+	return nil
+}
+
+func (m *keyManager) verifies(key *managedKey, now time.Time) bool {
+	switch key.State {
+	case keyStateNext, keyStateActive:
+		return true
+	case keyStateRetiring:
+		return now.Before(key.RetiredAt.Add(m.retention))
+	default:
+		return false
+	}
+}
+
+// persistedKey is the on-disk form of a managedKey.
+type persistedKey struct {
+	ID         string    `json:"kid"`
+	State      keyState  `json:"state"`
+	CreatedAt  time.Time `json:"created_at"`
+	RetiredAt  time.Time `json:"retired_at,omitempty"`
+	PrivateKey string    `json:"private_key"`
+}
+
+// save must be called with the write lock held.
+func (m *keyManager) save() error {
+	if m.path == "" {
+		return nil
+	}
+
+	persisted := make([]persistedKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		persisted = append(persisted, persistedKey{
+			ID:        key.ID,
+			State:     key.State,
+			CreatedAt: key.CreatedAt,
+			RetiredAt: key.RetiredAt,
+			PrivateKey: string(pem.EncodeToMemory(&pem.Block{
+				Type:  "RSA PRIVATE KEY",
+				Bytes: x509.MarshalPKCS1PrivateKey(key.PrivateKey),
+			})),
+		})
+	}
+
+	out, err := json.MarshalIndent(persisted, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, out, 0600)
+}
+
+func loadManagedKeys(path string) ([]*managedKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var persisted []persistedKey
+	if err := json.Unmarshal(raw, &persisted); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*managedKey, 0, len(persisted))
+	for _, p := range persisted {
+		block, _ := pem.Decode([]byte(p.PrivateKey))
+		if block == nil {
+			return nil, fmt.Errorf("key %s: no PEM block found", p.ID)
+		}
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", p.ID, err)
+		}
+
+		keys = append(keys, &managedKey{
+			signingKey: &signingKey{ID: p.ID, PrivateKey: privateKey},
+			State:      p.State,
+			CreatedAt:  p.CreatedAt,
+			RetiredAt:  p.RetiredAt,
+		})
+	}
+	return keys, nil
+}
+
+// keySigner is the jwt.Signer handed to fosite. It signs with whichever key is active at signing time and sets the
+// `kid` header accordingly, and it verifies with the key named in a token's `kid` header, so tokens signed before a
+// rotation remain valid.
+type keySigner struct {
+	keys *keyManager
+}
+
+func (s *keySigner) signerFor(key *signingKey) *jwt.DefaultSigner {
+	return &jwt.DefaultSigner{
+		GetPrivateKey: func(context.Context) (interface{}, error) {
+			return key.PrivateKey, nil
+		},
+	}
+}
+
+// verifierFor picks the key a token claims to be signed with. Tokens without a `kid` are checked against the active
+// key.
+func (s *keySigner) verifierFor(token string) (*jwt.DefaultSigner, error) {
+	segment, _, _ := strings.Cut(token, ".")
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		KeyID string `json:"kid"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, err
+	}
+	if header.KeyID == "" {
+		return s.signerFor(s.keys.Active()), nil
+	}
+
+	key, err := s.keys.Key(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	return s.signerFor(key), nil
+}
+
+func (s *keySigner) Generate(ctx context.Context, claims jwt.MapClaims, header jwt.Mapper) (string, string, error) {
+	key := s.keys.Active()
+	if header == nil {
+		header = jwt.NewHeaders()
+	}
+	header.Add("kid", key.ID)
+	return s.signerFor(key).Generate(ctx, claims, header)
+}
+
+func (s *keySigner) Validate(ctx context.Context, token string) (string, error) {
+	signer, err := s.verifierFor(token)
+	if err != nil {
+		return "", err
+	}
+	return signer.Validate(ctx, token)
+}
+
+func (s *keySigner) Decode(ctx context.Context, token string) (*jwt.Token, error) {
+	signer, err := s.verifierFor(token)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Decode(ctx, token)
+}
+
+func (s *keySigner) Hash(ctx context.Context, in []byte) ([]byte, error) {
+	return s.signerFor(s.keys.Active()).Hash(ctx, in)
+}
+
+func (s *keySigner) GetSignature(ctx context.Context, token string) (string, error) {
+	return s.signerFor(s.keys.Active()).GetSignature(ctx, token)
+}
+
+func (s *keySigner) GetSigningMethodLength(ctx context.Context) int {
+	return s.signerFor(s.keys.Active()).GetSigningMethodLength(ctx)
+}
@@ -0,0 +1,155 @@
+package authorizationserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// keyStates returns the state of each key by kid.
+func keyStates(keys []managedKey) map[string]keyState {
+	states := make(map[string]keyState, len(keys))
+	for _, key := range keys {
+		states[key.ID] = key.State
+	}
+	return states
+}
+
+// wantKeys checks the states of the keys the manager holds, of the keys in its file and of the keys in the JWKS.
+func wantKeys(t *testing.T, m *keyManager, want map[string]keyState) {
+	t.Helper()
+
+	if got := keyStates(m.List()); len(got) != len(want) {
+		t.Errorf("the manager holds %v, want %v", got, want)
+	} else {
+		for kid, state := range want {
+			if got[kid] != state {
+				t.Errorf("the manager holds %v, want %v", got, want)
+				break
+			}
+		}
+	}
+
+	persisted, err := loadManagedKeys(m.path)
+	if err != nil {
+		t.Fatalf("loadManagedKeys: %v", err)
+	}
+	if len(persisted) != len(want) {
+		t.Errorf("the file holds %d keys, want %d", len(persisted), len(want))
+	}
+	for _, key := range persisted {
+		if want[key.ID] != key.State {
+			t.Errorf("the file holds key %s as %q, want %q", key.ID, key.State, want[key.ID])
+		}
+	}
+
+	published := map[string]bool{}
+	for _, key := range m.PublicKeys() {
+		if !key.IsPublic() {
+			t.Errorf("the JWKS holds the private key %s", key.KeyID)
+		}
+		published[key.KeyID] = true
+	}
+	for kid, state := range want {
+		if published[kid] == (state == keyStateRevoked) {
+			t.Errorf("key %s is %s, but published is %v", kid, state, published[kid])
+		}
+		if _, err := m.Key(kid); (err == nil) != published[kid] {
+			t.Errorf("Key(%s) = %v, but published is %v", kid, err, published[kid])
+		}
+	}
+}
+
+// newTestKeyManager returns a key manager persisting its keys in the test's temporary directory.
+func newTestKeyManager(t *testing.T) *keyManager {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return newKeyManager(newSigningKey(privateKey), time.Hour, filepath.Join(t.TempDir(), "signing-keys.json"))
+}
+
+// keyIn returns the ID of the first key in the state.
+func keyIn(m *keyManager, state keyState) string {
+	for _, key := range m.List() {
+		if key.State == state {
+			return key.ID
+		}
+	}
+	return ""
+}
+
+func TestKeyRotation(t *testing.T) {
+	m := newTestKeyManager(t)
+	first, second := keyIn(m, keyStateActive), keyIn(m, keyStateNext)
+	wantKeys(t, m, map[string]keyState{first: keyStateActive, second: keyStateNext})
+
+	if err := m.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	third := keyIn(m, keyStateNext)
+	wantKeys(t, m, map[string]keyState{first: keyStateRetiring, second: keyStateActive, third: keyStateNext})
+	if m.Active().ID != second {
+		t.Errorf("the active key is %s, want %s", m.Active().ID, second)
+	}
+
+	// The keys survive a restart, the initial key is ignored then.
+	restored := newKeyManager(newSigningKey(m.Active().PrivateKey), time.Hour, m.path)
+	wantKeys(t, restored, map[string]keyState{first: keyStateRetiring, second: keyStateActive, third: keyStateNext})
+
+	// Once the tokens of the retiring key have expired, the next rotation drops it.
+	m.keys[0].RetiredAt = time.Now().Add(-2 * time.Hour)
+	if err := m.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	fourth := keyIn(m, keyStateNext)
+	wantKeys(t, m, map[string]keyState{second: keyStateRetiring, third: keyStateActive, fourth: keyStateNext})
+}
+
+func TestKeyRevocation(t *testing.T) {
+	m := newTestKeyManager(t)
+	first, second := keyIn(m, keyStateActive), keyIn(m, keyStateNext)
+
+	// Revoking the active key rotates first.
+	if err := m.Revoke(first); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	third := keyIn(m, keyStateNext)
+	wantKeys(t, m, map[string]keyState{first: keyStateRevoked, second: keyStateActive, third: keyStateNext})
+
+	// Revoking the next key creates another one.
+	if err := m.Revoke(third); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	fourth := keyIn(m, keyStateNext)
+	wantKeys(t, m, map[string]keyState{first: keyStateRevoked, second: keyStateActive, third: keyStateRevoked, fourth: keyStateNext})
+
+	if err := m.Revoke(first); !errors.Is(err, errUnknownKey) {
+		t.Errorf("revoking a revoked key returned %v, want errUnknownKey", err)
+	}
+	if err := m.Revoke("unknown"); !errors.Is(err, errUnknownKey) {
+		t.Errorf("revoking an unknown key returned %v, want errUnknownKey", err)
+	}
+
+	// The revoked next key never signed anything and is dropped on the next rotation. The revoked active key is
+	// kept until the tokens it signed would have expired.
+	if err := m.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	fifth := keyIn(m, keyStateNext)
+	wantKeys(t, m, map[string]keyState{first: keyStateRevoked, second: keyStateRetiring, fourth: keyStateActive, fifth: keyStateNext})
+
+	for _, key := range m.keys {
+		if key.ID == first {
+			key.RetiredAt = time.Now().Add(-2 * time.Hour)
+		}
+	}
+	if err := m.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	wantKeys(t, m, map[string]keyState{second: keyStateRetiring, fourth: keyStateRetiring, fifth: keyStateActive, keyIn(m, keyStateNext): keyStateNext})
+}
@@ -0,0 +1,165 @@
+package authorizationserver
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	jose "github.com/go-jose/go-jose/v3"
+)
+
+// defaultSigningKeyPath is the RSA key shipped with this example. `cert/rs256-public.pem` holds the matching public key.
+const defaultSigningKeyPath = "cert/rs256-private.pem"
+
+// defaultEncryptionKeyPath is the RSA key request objects are encrypted to, shipped with this example as well. It is
+// never used for signing.
+const defaultEncryptionKeyPath = "cert/rsa-oaep-private.pem"
+
+// signingKey bundles the RSA key used to sign JWTs with the key id published in the JWKS and in the `kid` header of
+// every token signed with it.
+type signingKey struct {
+	ID         string
+	PrivateKey *rsa.PrivateKey
+}
+
+// PublicJWK returns the public part of the key as a JSON Web Key ready to be published.
+func (k *signingKey) PublicJWK() jose.JSONWebKey {
+	return jose.JSONWebKey{
+		Key:       &k.PrivateKey.PublicKey,
+		KeyID:     k.ID,
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}
+}
+
+// encryptionKey is the RSA key clients encrypt request objects to. It is a key of its own, so a signing key is never
+// used for decryption, and it has its own `kid`.
+type encryptionKey struct {
+	ID         string
+	PrivateKey *rsa.PrivateKey
+}
+
+// PublicJWK returns the public part of the key as the JSON Web Key clients encrypt request objects to.
+func (k *encryptionKey) PublicJWK() jose.JSONWebKey {
+	return jose.JSONWebKey{
+		Key:       &k.PrivateKey.PublicKey,
+		KeyID:     k.ID,
+		Algorithm: string(jose.RSA_OAEP_256),
+		Use:       "enc",
+	}
+}
+
+// mustLoadSigningKey loads the signing key from the PEM file at SIGNING_KEY_PATH, defaulting to the key in `cert/`.
+// A key named by SIGNING_KEY_PATH must load, the server would otherwise sign with a key nobody expects. Only if the
+// key in `cert/` can not be read, an ephemeral key is generated so the example still starts, but tokens signed with it
+// can not be verified after a restart.
+func mustLoadSigningKey() *signingKey {
+	if path := os.Getenv("SIGNING_KEY_PATH"); path != "" {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			log.Fatalf("Could not load signing key from SIGNING_KEY_PATH %s: %+v", path, err)
+		}
+		return key
+	}
+
+	key, err := loadSigningKey(defaultSigningKeyPath)
+	if err == nil {
+		return key
+	}
+
+	log.Printf("Could not load signing key from %s, falling back to an ephemeral key: %+v", defaultSigningKeyPath, err)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return newSigningKey(privateKey)
+}
+
+// mustLoadEncryptionKey loads the encryption key from the PEM file at ENCRYPTION_KEY_PATH, defaulting to the key in
+// `cert/`. Like the signing key, a key named by ENCRYPTION_KEY_PATH must load, and only a missing key in `cert/` is
+// replaced by an ephemeral one.
+func mustLoadEncryptionKey() *encryptionKey {
+	if path := os.Getenv("ENCRYPTION_KEY_PATH"); path != "" {
+		privateKey, err := loadRSAPrivateKey(path)
+		if err != nil {
+			log.Fatalf("Could not load encryption key from ENCRYPTION_KEY_PATH %s: %+v", path, err)
+		}
+		return newEncryptionKey(privateKey)
+	}
+
+	privateKey, err := loadRSAPrivateKey(defaultEncryptionKeyPath)
+	if err == nil {
+		return newEncryptionKey(privateKey)
+	}
+
+	log.Printf("Could not load encryption key from %s, falling back to an ephemeral key: %+v", defaultEncryptionKeyPath, err)
+	if privateKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	return newEncryptionKey(privateKey)
+}
+
+// loadSigningKey reads a signing key, see loadRSAPrivateKey.
+func loadSigningKey(path string) (*signingKey, error) {
+	privateKey, err := loadRSAPrivateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(privateKey), nil
+}
+
+// loadRSAPrivateKey reads a PEM encoded RSA private key in PKCS #1 or PKCS #8 form.
+func loadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("expected an RSA private key but got %T", parsed)
+		}
+		return privateKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+// newSigningKey derives a stable key id from the RFC 7638 thumbprint of the public key, so the same PEM file always
+// yields the same `kid`.
+func newSigningKey(privateKey *rsa.PrivateKey) *signingKey {
+	return &signingKey{ID: keyThumbprint(privateKey), PrivateKey: privateKey}
+}
+
+// newEncryptionKey derives the key id like newSigningKey does.
+func newEncryptionKey(privateKey *rsa.PrivateKey) *encryptionKey {
+	return &encryptionKey{ID: keyThumbprint(privateKey), PrivateKey: privateKey}
+}
+
+func keyThumbprint(privateKey *rsa.PrivateKey) string {
+	thumbprint, err := (&jose.JSONWebKey{Key: &privateKey.PublicKey}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint)
+}
@@ -0,0 +1,144 @@
+package authorizationserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jose "github.com/go-jose/go-jose/v3"
+)
+
+// writePEM writes a PEM block to a file in the test's temporary directory and returns its path.
+func writePEM(t *testing.T, name string, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	ecPKCS8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	notPEM := filepath.Join(t.TempDir(), "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		path  string
+		valid bool
+	}{
+		{name: "PKCS #1", path: writePEM(t, "pkcs1.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), valid: true},
+		{name: "PKCS #8", path: writePEM(t, "pkcs8.pem", "PRIVATE KEY", pkcs8), valid: true},
+		{name: "PKCS #8 EC key", path: writePEM(t, "ec.pem", "PRIVATE KEY", ecPKCS8)},
+		{name: "public key", path: writePEM(t, "public.pem", "PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))},
+		{name: "no PEM block", path: notPEM},
+		{name: "missing file", path: filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := loadSigningKey(tc.path)
+			if !tc.valid {
+				if err == nil {
+					t.Error("loadSigningKey() accepted the file")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadSigningKey() = %v", err)
+			}
+			if !key.PrivateKey.Equal(rsaKey) {
+				t.Error("loadSigningKey() returned another key")
+			}
+			// The kid is the RFC 7638 thumbprint, the same for both encodings of the key.
+			if want := newSigningKey(rsaKey).ID; key.ID != want {
+				t.Errorf("kid = %q, want %q", key.ID, want)
+			}
+		})
+	}
+}
+
+func TestLoadDefaultSigningKey(t *testing.T) {
+	key, err := loadSigningKey(filepath.Join("..", defaultSigningKeyPath))
+	if err != nil {
+		t.Fatalf("loadSigningKey() = %v", err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join("..", "cert", "rs256-public.pem"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		t.Fatal("no PEM block in the public key")
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParsePKIXPublicKey: %v", err)
+	}
+	if !key.PrivateKey.PublicKey.Equal(public) {
+		t.Error("the shipped private key does not match the shipped public key")
+	}
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	rw := httptest.NewRecorder()
+	jwksEndpoint(rw, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rw.Code, rw.Body)
+	}
+
+	var set jose.JSONWebKeySet
+	if err := json.Unmarshal(rw.Body.Bytes(), &set); err != nil {
+		t.Fatalf("decoding the JWKS: %v", err)
+	}
+	var signing int
+	for _, key := range set.Keys {
+		if !key.IsPublic() {
+			t.Errorf("the key %q is not public", key.KeyID)
+		}
+		if key.KeyID == "" {
+			t.Error("a key has no kid")
+		}
+		if key.Use == "sig" {
+			signing++
+			if key.Algorithm != string(jose.RS256) {
+				t.Errorf("the signing key %q has alg %q, want RS256", key.KeyID, key.Algorithm)
+			}
+		}
+	}
+	if signing == 0 {
+		t.Errorf("the JWKS has no signing key: %s", rw.Body)
+	}
+
+	rw = httptest.NewRecorder()
+	jwksEndpoint(rw, httptest.NewRequest(http.MethodPost, "/.well-known/jwks.json", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST got status %d, want %d", rw.Code, http.StatusMethodNotAllowed)
+	}
+}
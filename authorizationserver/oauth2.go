@@ -1,28 +1,86 @@
 package authorizationserver
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"context"
 	"github.com/ory/fosite"
+	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/ory/fosite/compose"
 	"github.com/ory/fosite/handler/openid"
-	"github.com/ory/fosite/storage"
 	"github.com/ory/fosite/token/jwt"
 
 	"github.com/ory/fosite-example/middleware"
 )
 
 func RegisterHandlers() {
+	// Authenticate clients by certificate as well. This can not be part of the config below, the strategy uses the
+	// fosite instance which is built from the config.
+	config.ClientAuthenticationStrategy = authenticateClient
+
+	// Open the database, and delete what expired in it every hour.
+	store.Store = openExampleDatabase()
+	go runCleanup(context.Background(), time.Hour)
+
 	// Set up oauth2 endpoints. You could also use gorilla/mux or any other router.
 	http.HandleFunc("/oauth2/auth", middleware.LoggingMiddleware(authEndpoint))
 	http.HandleFunc("/oauth2/token", middleware.LoggingMiddleware(tokenEndpoint))
 
+	// pushed authorization requests, the authorize parameters are posted here instead of sent through the browser
+	http.HandleFunc("/oauth2/par", middleware.LoggingMiddleware(parEndpoint))
+
+	// dynamic client registration, and management of the registration by the client. Like the admin API below, these
+	// endpoints are not logged: their requests and responses carry client secrets and access tokens.
+	http.HandleFunc("/oauth2/register", registrationEndpoint)
+	http.HandleFunc("/oauth2/register/", registrationManagementEndpoint)
+
+	// OpenID Connect RP-initiated logout, the clients of the session are notified by front- and back-channel
+	http.HandleFunc("/oauth2/logout", middleware.LoggingMiddleware(endSessionEndpoint))
+
 	// revoke tokens
 	http.HandleFunc("/oauth2/revoke", middleware.LoggingMiddleware(revokeEndpoint))
 	http.HandleFunc("/oauth2/introspect", middleware.LoggingMiddleware(introspectionEndpoint))
+
+	// device authorization grant, for devices that can not open a browser
+	http.HandleFunc("/oauth2/device/auth", middleware.LoggingMiddleware(deviceAuthorizationEndpoint))
+	http.HandleFunc("/device", middleware.LoggingMiddleware(deviceVerificationEndpoint))
+
+	// OpenID Connect CIBA, for clients that authenticate users who are not at the browser, like a call center
+	http.HandleFunc("/oauth2/bc-authorize", middleware.LoggingMiddleware(backchannelAuthenticationEndpoint))
+	http.HandleFunc("/ciba", middleware.LoggingMiddleware(backchannelApprovalEndpoint))
+
+	// manage clients and rotate their secrets, for administrators holding one of ADMIN_TOKENS
+	http.HandleFunc("/admin/clients", adminClientsEndpoint)
+	http.HandleFunc("/admin/clients/", adminClientEndpoint)
+
+	// list, rotate and revoke the signing keys, for administrators as well
+	http.HandleFunc("/admin/keys", adminKeysEndpoint)
+	http.HandleFunc("/admin/keys/", adminKeyEndpoint)
+
+	// OpenID Connect UserInfo
+	http.HandleFunc("/userinfo", middleware.LoggingMiddleware(userinfoEndpoint))
+
+	// discovery documents, so clients only need to know the issuer URL
+	http.HandleFunc("/.well-known/openid-configuration", middleware.LoggingMiddleware(discoveryEndpoint))
+	http.HandleFunc("/.well-known/oauth-authorization-server", middleware.LoggingMiddleware(discoveryEndpoint))
+	http.HandleFunc("/.well-known/jwks.json", middleware.LoggingMiddleware(jwksEndpoint))
+
+	// rotate the signing keys on a schedule, e.g. KEY_ROTATION_INTERVAL=24h
+	if interval := os.Getenv("KEY_ROTATION_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("Invalid KEY_ROTATION_INTERVAL %q: %+v", interval, err)
+		}
+		go keys.RunRotation(context.Background(), d)
+	}
+}
+
+// RotateSigningKeys rotates the signing keys on demand. Tokens signed with the previous key remain verifiable until
+// they expire.
+func RotateSigningKeys() error {
+	return keys.Rotate()
 }
 
 // fosite requires four parameters for the server to get up and running:
@@ -30,21 +88,45 @@ func RegisterHandlers() {
 //  2. store - no auth service is generally useful unless it can remember clients and users.
 //     fosite is incredibly composable, and the store parameter enables you to build and BYODb (Bring Your Own Database)
 //  3. secret - required for code, access and refresh token generation.
-//  4. privateKey - required for id/jwt token generation.
+//  4. keys - required for id/jwt token generation, the initial key is loaded from `cert/` by default.
 var (
 	// Check the api documentation of `compose.Config` for further configuration options.
 	config = &fosite.Config{
 		AccessTokenLifespan: time.Minute * 30,
 		GlobalSecret:        secret,
+		IDTokenIssuer:       issuer,
+		AccessTokenIssuer:   issuer,
+		TokenURL:            issuer + "/oauth2/token",
+		// Trusted issuers vouch for the callers of the JWT bearer grant, see jwtBearerHandler.
+		GrantTypeJWTBearerCanSkipClientAuth: true,
 		// ...
 	}
 
+	// The issuer is the URL this authorization server is reachable at. It is published in the discovery documents
+	// and set as the `iss` claim of every ID token, so it has to match the URL clients use. Set ISSUER when running
+	// behind a proxy or on a different host.
+	issuer = issuerURL()
+
 	// This is the example storage that contains:
 	// * an OAuth2 Client with id "my-client" and secrets "foobar" and "foobaz" capable of all oauth2 and open id connect grant and response types.
-	// * a User for the resource owner password credentials grant type with username "peter" and password "secret".
 	//
+	// Clients and tokens are kept in a SQLite database (see DATABASE_DSN), so they survive restarts. It is opened by
+	// RegisterHandlers, importing the package does not create the database file.
 	// You will most likely replace this with your own logic once you set up a real world application.
-	store = storage.NewExampleStore()
+	store = new(exampleStore)
+
+	// users is the directory users log in against, both at the login page and with the resource owner password
+	// credentials grant. The UserInfo endpoint reads their claims from it. Set USERS_FILE to use your own users,
+	// see `users.example.json`.
+	users = newUserDirectory()
+
+	// resourceRegistry lists the resource servers clients may request tokens for with the `resource` parameter. Set
+	// RESOURCE_SERVERS_FILE to register your own, see `resource-servers.example.json`.
+	resourceRegistry = newResourceServers()
+
+	// trustedIssuerRegistry lists the issuers whose JWT assertions are traded for access tokens without client
+	// authentication. Set TRUSTED_ISSUERS_FILE to trust your own, see `trusted-issuers.example.json`.
+	trustedIssuerRegistry = newTrustedIssuers()
 
 	// This secret is used to sign authorize codes, access and refresh tokens.
 	// It has to be 32-bytes long for HMAC signing. This requirement can be configured via `compose.Config` above.
@@ -73,36 +155,173 @@ var (
 	// This can then be injected and decoded as the `var secret []byte` on server start.
 	secret = []byte("some-cool-secret-that-is-32bytes")
 
-	// privateKey is used to sign JWT tokens. The default strategy uses RS256 (RSA Signature with SHA-256)
-	privateKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	// keys holds the keys used to sign JWT tokens. The default strategy uses RS256 (RSA Signature with SHA-256).
+	// The initial key is loaded from a PEM file (see SIGNING_KEY_PATH). Rotated keys are kept in memory, or in the
+	// file named by SIGNING_KEYS_FILE so they survive restarts. All keys that may still verify a token are published
+	// at `/.well-known/jwks.json`.
+	keys = newKeyManager(mustLoadSigningKey(), idTokenLifespan, os.Getenv("SIGNING_KEYS_FILE"))
+
+	// requestObjectKey is the RSA key clients encrypt request objects to, loaded from ENCRYPTION_KEY_PATH or `cert/`.
+	// It is published in the JWKS next to the signing keys, but is not rotated with them.
+	requestObjectKey = mustLoadEncryptionKey()
+
+	// idTokenLifespan is the lifespan of the longest-lived token signed by the keys above. Retired keys are published
+	// for this long after a rotation.
+	idTokenLifespan = time.Hour * 6
 )
 
 // Build a fosite instance with all OAuth2 and OpenID Connect handlers enabled, plugging in our configurations as specified above.
-var oauth2 = compose.ComposeAllEnabled(config, store, privateKey)
+// These are the same handlers `compose.ComposeAllEnabled` registers, but JWTs are signed through the key manager so the
+// signing key can be rotated at runtime, access tokens are opaque or JWTs depending on the client, and the JWT bearer
+// grant accepts assertions of trusted issuers without client authentication. On top, the
+// device authorization grant of RFC 8628, token exchange of RFC 8693 and OpenID Connect CIBA are enabled.
+var oauth2 = compose.Compose(
+	config,
+	store,
+	&compose.CommonStrategy{
+		CoreStrategy:               newTokenStrategy(compose.NewOAuth2HMACStrategy(config)),
+		OpenIDConnectTokenStrategy: &openid.DefaultStrategy{Signer: signer, Config: config},
+		Signer:                     signer,
+	},
+	compose.OAuth2AuthorizeExplicitFactory,
+	compose.OAuth2AuthorizeImplicitFactory,
+	compose.OAuth2ClientCredentialsGrantFactory,
+	compose.OAuth2RefreshTokenGrantFactory,
+	compose.OAuth2ResourceOwnerPasswordCredentialsFactory,
+	jwtBearerFactory,
+
+	compose.OpenIDConnectExplicitFactory,
+	compose.OpenIDConnectImplicitFactory,
+	compose.OpenIDConnectHybridFactory,
+	compose.OpenIDConnectRefreshFactory,
+
+	compose.OAuth2TokenIntrospectionFactory,
+	compose.OAuth2TokenRevocationFactory,
+
+	compose.OAuth2PKCEFactory,
+	compose.PushedAuthorizeHandlerFactory,
+
+	deviceCodeFactory,
+	tokenExchangeFactory,
+	backchannelAuthenticationFactory,
+).(*fosite.Fosite)
+
+// signer signs with the active key and sets the matching `kid` header.
+var signer = &keySigner{keys: keys}
 
 // A session is passed from the `/auth` to the `/token` endpoint. You probably want to store data like: "Who made the request",
 // "What organization does that person belong to" and so on.
 // For our use case, the session will meet the requirements imposed by JWT access tokens, HMAC access tokens and OpenID Connect
 // ID Tokens plus a custom field
 
+// Session is the session of all requests. On top of the OpenID Connect session it keeps extra claims, which token
+// introspection returns next to the standard ones, e.g. the `act` claim of exchanged tokens.
+type Session struct {
+	*openid.DefaultSession
+	Extra map[string]interface{} `json:"extra,omitempty"`
+
+	// RefreshTokenAudience is the audience the refresh token keeps when the access token issued along is narrowed
+	// down to fewer resources, see grantResources. It is only needed until the tokens are stored.
+	RefreshTokenAudience fosite.Arguments `json:"-"`
+}
+
+// GetExtraClaims implements fosite.ExtraClaimsSession. The returned map can be modified in place.
+func (s *Session) GetExtraClaims() map[string]interface{} {
+	if s.Extra == nil {
+		s.Extra = make(map[string]interface{})
+	}
+	return s.Extra
+}
+
+// confirmation returns a member of the `cnf` claim (RFC 7800) of a token bound to a key or certificate, or "" if the
+// token is not bound that way.
+func (s *Session) confirmation(member string) string {
+	switch cnf := s.GetExtraClaims()["cnf"].(type) {
+	case map[string]interface{}:
+		value, _ := cnf[member].(string)
+		return value
+	case map[string]string:
+		return cnf[member]
+	}
+	return ""
+}
+
+// setConfirmation sets a member of the `cnf` claim, an empty value removes it. The claim is dropped once it is empty.
+func (s *Session) setConfirmation(member string, value string) {
+	cnf := map[string]interface{}{}
+	switch previous := s.GetExtraClaims()["cnf"].(type) {
+	case map[string]interface{}:
+		for k, v := range previous {
+			cnf[k] = v
+		}
+	case map[string]string:
+		for k, v := range previous {
+			cnf[k] = v
+		}
+	}
+
+	if value == "" {
+		delete(cnf, member)
+	} else {
+		cnf[member] = value
+	}
+
+	if len(cnf) == 0 {
+		delete(s.GetExtraClaims(), "cnf")
+	} else {
+		s.GetExtraClaims()["cnf"] = cnf
+	}
+}
+
+// Clone copies the session, openid.DefaultSession.Clone would drop the extra claims.
+func (s *Session) Clone() fosite.Session {
+	if s == nil {
+		return nil
+	}
+
+	clone := &Session{DefaultSession: s.DefaultSession.Clone().(*openid.DefaultSession), RefreshTokenAudience: s.RefreshTokenAudience}
+	if s.Extra != nil {
+		clone.Extra = make(map[string]interface{}, len(s.Extra))
+		for key, value := range s.Extra {
+			clone.Extra[key] = value
+		}
+	}
+	return clone
+}
+
 // newSession is a helper function for creating a new session. This may look like a lot of code but since we are
 // setting up multiple strategies it is a bit longer.
 // Usually, you could do:
 //
 //	session = new(fosite.DefaultSession)
-func newSession(user string) *openid.DefaultSession {
-	return &openid.DefaultSession{
+func newSession(user string) *Session {
+	return &Session{DefaultSession: &openid.DefaultSession{
 		Claims: &jwt.IDTokenClaims{
-			Issuer:      "https://fosite.my-application.com",
+			Issuer:      issuer,
 			Subject:     user,
 			Audience:    []string{"https://my-client.my-application.com"},
-			ExpiresAt:   time.Now().Add(time.Hour * 6),
+			ExpiresAt:   time.Now().Add(idTokenLifespan),
 			IssuedAt:    time.Now(),
 			RequestedAt: time.Now(),
-			AuthTime:    time.Now(),
 		},
 		Headers: &jwt.Headers{
 			Extra: make(map[string]interface{}),
 		},
+		Subject:  user,
+		Username: user,
+	}}
+}
+
+// issuerURL returns the issuer from the ISSUER environment variable, falling back to the local address main.go
+// listens on.
+func issuerURL() string {
+	if os.Getenv("ISSUER") != "" {
+		return os.Getenv("ISSUER")
+	}
+
+	port := "3846"
+	if os.Getenv("PORT") != "" {
+		port = os.Getenv("PORT")
 	}
+	return "http://localhost:" + port
 }
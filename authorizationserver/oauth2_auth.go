@@ -1,15 +1,24 @@
 package authorizationserver
 
 import (
-	"fmt"
 	"log"
 	"net/http"
+
+	"github.com/ory/fosite"
 )
 
 func authEndpoint(rw http.ResponseWriter, req *http.Request) {
 	// This context will be passed to all methods.
 	ctx := req.Context()
 
+	// Parameters sent as a signed request object replace the ones in the URL. Errors are not redirected, the
+	// redirect URI can not be trusted before the object was checked.
+	if err := resolveRequestObject(ctx, req); err != nil {
+		log.Printf("Error occurred in resolveRequestObject: %+v", err)
+		oauth2.WriteAuthorizeError(ctx, rw, fosite.NewAuthorizeRequest(), err)
+		return
+	}
+
 	// Let's create an AuthorizeRequest object!
 	// It will analyze the request and extract important information like scopes, response type and others.
 	ar, err := oauth2.NewAuthorizeRequest(ctx, req)
@@ -20,38 +29,72 @@ func authEndpoint(rw http.ResponseWriter, req *http.Request) {
 	}
 	// You have now access to authorizeRequest, Code ResponseTypes, Scopes ...
 
-	var requestedScopes string
-	for _, this := range ar.GetRequestedScopes() {
-		requestedScopes += fmt.Sprintf(`<li><input type="checkbox" name="scopes" value="%s">%s</li>`, this, this)
+	// A pushed request is used up once the client gets a response, but not while the login and consent pages post
+	// to it.
+	var pageShown bool
+	defer func() {
+		if !pageShown {
+			completePushedAuthorizeRequest(ctx, ar)
+		}
+	}()
+
+	// Some clients have to push their authorize requests first, so the parameters never travel through the browser.
+	if requiresPushedAuthorization(ar.GetClient()) && !isPushedAuthorizeRequest(ctx, ar) {
+		oauth2.WriteAuthorizeError(ctx, rw, ar, fosite.ErrInvalidRequest.WithHint("The OAuth 2.0 Client must use a pushed authorization request."))
+		return
+	}
+
+	// The tokens are meant for the resource servers the client names with `resource` parameters, see RFC 8707.
+	if err := requestResources(ctx, ar, ar.GetRequestForm()); err != nil {
+		log.Printf("Error occurred in requestResources: %+v", err)
+		oauth2.WriteAuthorizeError(ctx, rw, ar, err)
+		return
 	}
 
-	// Normally, this would be the place where you would check if the user is logged in and gives his consent.
-	// We're simplifying things and just checking if the request includes a valid username and password
+	// This is the place where we check if the user is logged in and gives his consent. Users who logged in before
+	// are recognized by the session cookie, everybody else has to enter a valid username and password.
 	req.ParseForm()
-	if req.PostForm.Get("username") != "peter" {
-		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
-		rw.Write([]byte(`<h1>Login page</h1>`))
-		rw.Write([]byte(fmt.Sprintf(`
-			<p>Howdy! This is the log in page. For this example, it is enough to supply the username.</p>
-			<form method="post">
-				<p>
-					By logging in, you consent to grant these scopes:
-					<ul>%s</ul>
-				</p>
-				<input type="text" name="username" /> <small>try peter</small><br>
-				<input type="submit">
-			</form>
-		`, requestedScopes)))
+	authn, err := authenticateUser(rw, req, ar)
+	if err != nil {
+		// With prompt=none the client asks us not to show any page, the user has to log in elsewhere first.
+		if promptValues(ar).Has("none") {
+			oauth2.WriteAuthorizeError(ctx, rw, ar, fosite.ErrLoginRequired)
+			return
+		}
+		action, err := pageAction(req, ar)
+		if err != nil {
+			log.Printf("Error occurred in pageAction: %+v", err)
+			oauth2.WriteAuthorizeError(ctx, rw, ar, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+		writeLoginPage(rw, req, action)
+		pageShown = true
 		return
 	}
+	user := authn.User
 
-	// let's see what scopes the user gave consent to
-	for _, scope := range req.PostForm["scopes"] {
-		ar.GrantScope(scope)
+	// Let the user decide which scopes to grant, unless they consented to them before.
+	switch outcome, err := obtainConsent(rw, req, ar, authn); {
+	case err != nil:
+		oauth2.WriteAuthorizeError(ctx, rw, ar, err)
+		return
+	case outcome == consentDenied:
+		oauth2.WriteAuthorizeError(ctx, rw, ar, fosite.ErrAccessDenied.WithHint("The resource owner denied the request."))
+		return
+	case outcome == consentPageShown:
+		pageShown = true
+		return
 	}
 
 	// Now that the user is authorized, we set up a session:
-	mySessionData := newSession("peter")
+	mySessionData := newSession(user.Subject)
+	mySessionData.Claims.AuthTime = authn.AuthTime
+	mySessionData.Claims.RequestedAt = authn.RequestedAt
+
+	// The `sid` claim lets the client tell which of its sessions a logout notification is about.
+	if authn.SessionID != "" {
+		mySessionData.Claims.Add("sid", authn.SessionID)
+	}
 
 	// When using the HMACSHA strategy you must use something that implements the HMACSessionContainer.
 	// It brings you the power of overriding the default values.
@@ -89,6 +132,13 @@ func authEndpoint(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Remember the client, so it is notified when the user logs out.
+	if authn.SessionID != "" {
+		if err := store.AddLoginSessionClient(ctx, authn.SessionID, ar.GetClient().GetID()); err != nil {
+			log.Printf("Error occurred in AddLoginSessionClient: %+v", err)
+		}
+	}
+
 	// Last but not least, send the response!
 	oauth2.WriteAuthorizeResponse(ctx, rw, ar, response)
 }
@@ -0,0 +1,490 @@
+package authorizationserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// cibaGrantType is the grant type of OpenID Connect Client-Initiated Backchannel Authentication Flow - Core 1.0
+// (CIBA).
+const cibaGrantType = "urn:openid:params:grant-type:ciba"
+
+// The token delivery modes of CIBA, a client registers one of them as its `backchannel_token_delivery_mode`.
+const (
+	// cibaPollMode clients poll the token endpoint, like devices of the device authorization grant.
+	cibaPollMode = "poll"
+	// cibaPingMode clients are told at their notification endpoint when to fetch the tokens.
+	cibaPingMode = "ping"
+	// cibaPushMode clients are sent the tokens at their notification endpoint.
+	cibaPushMode = "push"
+)
+
+const (
+	// backchannelAuthenticationLifespan is how long the user has to decide, unless the client asks for another time
+	// with `requested_expiry`.
+	backchannelAuthenticationLifespan = time.Minute * 5
+	// maxBackchannelAuthenticationLifespan is the longest `requested_expiry` accepted.
+	maxBackchannelAuthenticationLifespan = time.Minute * 30
+	// backchannelPollInterval is the minimum time clients have to wait between polls of the token endpoint.
+	backchannelPollInterval = time.Second * 5
+	// maxBindingMessageLength is the longest binding message accepted, it has to fit on the screen of a phone.
+	maxBindingMessageLength = 64
+)
+
+// The error codes of section 13 of CIBA, fosite does not define them. The token endpoint answers with the ones of the
+// device authorization grant.
+var (
+	errUnknownUserID = &fosite.RFC6749Error{
+		ErrorField:       "unknown_user_id",
+		DescriptionField: "The OpenID Provider is not able to identify which end-user the Client wishes to be authenticated by means of the hint provided in the request.",
+		CodeField:        http.StatusBadRequest,
+	}
+	errInvalidBindingMessage = &fosite.RFC6749Error{
+		ErrorField:       "invalid_binding_message",
+		DescriptionField: "The binding message is invalid or unacceptable for use in the context of the given request.",
+		CodeField:        http.StatusBadRequest,
+	}
+	errExpiredAuthReqID = errExpiredToken.WithDescription("The auth_req_id has expired. The Client will need to make a new Authentication Request.")
+)
+
+// backchannelHTTPClient notifies clients in ping and push mode. Clients that do not answer in time are not waited
+// for.
+var backchannelHTTPClient = &http.Client{Timeout: time.Second * 5}
+
+// backchannelAuthenticationResponse is the response of the backchannel authentication endpoint, see section 7.3 of
+// CIBA.
+type backchannelAuthenticationResponse struct {
+	AuthReqID string `json:"auth_req_id"`
+	ExpiresIn int64  `json:"expires_in"`
+	Interval  int64  `json:"interval,omitempty"`
+}
+
+// backchannelAuthenticationEndpoint starts CIBA. Unlike at the authorize endpoint, the user is not at the client: the
+// client names the user with a hint, and the user approves or denies the request on their authentication device.
+// Depending on its `backchannel_token_delivery_mode`, the client then polls the token endpoint with the returned
+// `auth_req_id`, is pinged when it may fetch the tokens or is sent the tokens.
+func backchannelAuthenticationEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	if req.Method != http.MethodPost {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("HTTP method is '%s', expected 'POST'.", req.Method))
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithWrap(err).WithDebug(err.Error()))
+		return
+	}
+
+	client, err := oauth2.AuthenticateClient(ctx, req, req.PostForm)
+	if err != nil {
+		log.Printf("Error occurred in AuthenticateClient: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, err)
+		return
+	}
+	c, ok := client.(*sqlstore.Client)
+	if !ok {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithDebugf("Unexpected client type %T.", client))
+		return
+	}
+	// The user is not there to notice a client pretending to be another, so only confidential clients may use CIBA.
+	if !client.GetGrantTypes().Has(cibaGrantType) || client.IsPublic() {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrUnauthorizedClient.WithHintf("The OAuth 2.0 Client is not allowed to use authorization grant '%s'.", cibaGrantType))
+		return
+	}
+	if req.PostForm.Get("request") != "" {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHint("Signed authentication requests are not supported."))
+		return
+	}
+
+	request := fosite.NewRequest()
+	request.Client = client
+	request.Form = req.PostForm
+	request.SetRequestedScopes(fosite.RemoveEmpty(strings.Split(req.PostForm.Get("scope"), " ")))
+	request.SetRequestedAudience(fosite.GetAudiences(req.PostForm))
+	if !request.GetRequestedScopes().Has("openid") {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidScope.WithHint("The 'openid' scope is required."))
+		return
+	}
+	for _, scope := range request.GetRequestedScopes() {
+		if !config.GetScopeStrategy(ctx)(client.GetScopes(), scope) {
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidScope.WithHintf("The OAuth 2.0 Client is not allowed to request scope '%s'.", scope))
+			return
+		}
+	}
+	if err := config.GetAudienceStrategy(ctx)(client.GetAudience(), request.GetRequestedAudience()); err != nil {
+		oauth2.WriteAccessError(ctx, rw, nil, err)
+		return
+	}
+	if err := requestResources(ctx, request, req.PostForm); err != nil {
+		oauth2.WriteAccessError(ctx, rw, nil, err)
+		return
+	}
+
+	user, err := backchannelUser(ctx, client, req.PostForm)
+	if err != nil {
+		oauth2.WriteAccessError(ctx, rw, nil, err)
+		return
+	}
+
+	bindingMessage := req.PostForm.Get("binding_message")
+	if utf8.RuneCountInString(bindingMessage) > maxBindingMessageLength {
+		oauth2.WriteAccessError(ctx, rw, nil, errInvalidBindingMessage.WithHintf("The binding message must not be longer than %d characters.", maxBindingMessageLength))
+		return
+	}
+
+	mode := c.BackChannelTokenDeliveryMode
+	if mode == "" {
+		mode = cibaPollMode
+	}
+	notificationToken := req.PostForm.Get("client_notification_token")
+	if mode != cibaPollMode && notificationToken == "" {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("The 'client_notification_token' parameter is required in %s mode.", mode))
+		return
+	}
+
+	lifespan := backchannelAuthenticationLifespan
+	if expiry := req.PostForm.Get("requested_expiry"); expiry != "" {
+		seconds, err := strconv.ParseInt(expiry, 10, 64)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxBackchannelAuthenticationLifespan {
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("The 'requested_expiry' must be a number of seconds up to %d.", int64(maxBackchannelAuthenticationLifespan/time.Second)))
+			return
+		}
+		lifespan = time.Duration(seconds) * time.Second
+	}
+
+	authReqID, err := randomToken()
+	if err != nil {
+		log.Printf("Error occurred in randomToken: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	auth := &sqlstore.BackchannelAuthentication{
+		AuthReqID:         authReqID,
+		Subject:           user.Subject,
+		Status:            sqlstore.BackchannelAuthenticationPending,
+		DeliveryMode:      mode,
+		NotificationToken: notificationToken,
+		BindingMessage:    bindingMessage,
+		Interval:          backchannelPollInterval,
+		ExpiresAt:         time.Now().Add(lifespan),
+		Request:           request,
+	}
+	if err := store.CreateBackchannelAuthentication(ctx, auth); err != nil {
+		log.Printf("Error occurred in CreateBackchannelAuthentication: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+	if err := authenticationDevice.RequestApproval(ctx, approvalRequestOf(auth)); err != nil {
+		log.Printf("Error occurred in RequestApproval: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrTemporarilyUnavailable.WithHint("The user could not be reached."))
+		return
+	}
+
+	response := &backchannelAuthenticationResponse{
+		AuthReqID: authReqID,
+		ExpiresIn: int64(lifespan / time.Second),
+	}
+	if mode != cibaPushMode {
+		response.Interval = int64(backchannelPollInterval / time.Second)
+	}
+
+	rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(rw).Encode(response); err != nil {
+		log.Printf("Error occurred in backchannelAuthenticationEndpoint: %+v", err)
+	}
+}
+
+// backchannelUser returns the user the client names with exactly one of the hints of section 7.1 of CIBA. The
+// `login_hint` is the subject of the user, the `id_token_hint` an ID token this server issued to the client, expired
+// ones included.
+func backchannelUser(ctx context.Context, client fosite.Client, form url.Values) (*User, error) {
+	var hints int
+	for _, name := range []string{"login_hint", "id_token_hint", "login_hint_token"} {
+		if form.Get(name) != "" {
+			hints++
+		}
+	}
+	if hints != 1 {
+		return nil, fosite.ErrInvalidRequest.WithHint("Exactly one of the 'login_hint', 'id_token_hint' and 'login_hint_token' parameters is required.")
+	}
+
+	subject := form.Get("login_hint")
+	if form.Get("login_hint_token") != "" {
+		return nil, fosite.ErrInvalidRequest.WithHint("The 'login_hint_token' parameter is not supported, use 'login_hint' or 'id_token_hint'.")
+	}
+	if token := form.Get("id_token_hint"); token != "" {
+		hint, err := parseIDTokenHint(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		if !fosite.Arguments(hint.Audience).Has(client.GetID()) {
+			return nil, fosite.ErrInvalidRequest.WithHint("The 'id_token_hint' was not issued to the OAuth 2.0 Client.")
+		}
+		subject = hint.Subject
+	}
+
+	user, err := users.GetUser(ctx, subject)
+	if errors.Is(err, fosite.ErrNotFound) {
+		return nil, errUnknownUserID
+	} else if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+	return user, nil
+}
+
+// completeBackchannelAuthentication records the decision of the user. On approval, the requested scopes and
+// audiences are granted, just like the consent page grants them. Clients in ping and push mode are notified right
+// away.
+func completeBackchannelAuthentication(ctx context.Context, auth *sqlstore.BackchannelAuthentication, approved bool) error {
+	if auth.Status != sqlstore.BackchannelAuthenticationPending || auth.ExpiresAt.Before(time.Now()) {
+		return fosite.ErrNotFound
+	}
+
+	request := auth.Request
+	status := sqlstore.BackchannelAuthenticationDenied
+	if approved {
+		// Now that the user is authorized, we set up the session the client's tokens are issued for.
+		mySessionData := newSession(auth.Subject)
+		mySessionData.Claims.AuthTime = time.Now().Truncate(time.Second)
+		mySessionData.Claims.RequestedAt = request.GetRequestedAt()
+		request.SetSession(mySessionData)
+		for _, scope := range request.GetRequestedScopes() {
+			request.GrantScope(scope)
+		}
+		grantRequestedAudience(request)
+		status = sqlstore.BackchannelAuthenticationApproved
+	}
+
+	if err := store.CompleteBackchannelAuthentication(ctx, auth.AuthReqID, status, request); err != nil {
+		return err
+	}
+	auth.Status = status
+
+	if auth.DeliveryMode == cibaPingMode || auth.DeliveryMode == cibaPushMode {
+		ctx = context.WithoutCancel(ctx)
+		go func() {
+			if err := notifyBackchannelClient(ctx, auth); err != nil {
+				log.Printf("Error occurred in notifyBackchannelClient: %+v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// notifyBackchannelClient calls the `backchannel_client_notification_endpoint` of the client with the
+// `client_notification_token` of the request, see section 10 of CIBA. Clients in ping mode are told to fetch their
+// tokens, clients in push mode are sent the tokens, or the error if the user denied the request.
+func notifyBackchannelClient(ctx context.Context, auth *sqlstore.BackchannelAuthentication) error {
+	client, ok := auth.Request.GetClient().(*sqlstore.Client)
+	if !ok || client.BackChannelClientNotificationEndpoint == "" {
+		return fmt.Errorf("the client of backchannel authentication request %q has no notification endpoint", auth.Request.GetID())
+	}
+
+	body := map[string]interface{}{"auth_req_id": auth.AuthReqID}
+	if auth.DeliveryMode == cibaPushMode {
+		var err error
+		if body, err = pushedBackchannelResult(ctx, client, auth); err != nil {
+			return err
+		}
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.BackChannelClientNotificationEndpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+auth.NotificationToken)
+
+	resp, err := backchannelHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("the notification endpoint of client %q returned status %d", client.GetID(), resp.StatusCode)
+	}
+	return nil
+}
+
+// pushedBackchannelResult issues the tokens of an approved request, as the token endpoint would, or returns the
+// error of a denied one, see section 10.3 of CIBA. The request is used up either way.
+func pushedBackchannelResult(ctx context.Context, client *sqlstore.Client, auth *sqlstore.BackchannelAuthentication) (map[string]interface{}, error) {
+	if auth.Status == sqlstore.BackchannelAuthenticationDenied {
+		if err := store.DeleteBackchannelAuthentication(ctx, auth.AuthReqID); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"auth_req_id":       auth.AuthReqID,
+			"error":             fosite.ErrAccessDenied.ErrorField,
+			"error_description": "The resource owner denied the request.",
+		}, nil
+	}
+
+	var handler *backchannelAuthenticationHandler
+	for _, h := range config.GetTokenEndpointHandlers(ctx) {
+		if h, ok := h.(*backchannelAuthenticationHandler); ok {
+			handler = h
+		}
+	}
+	if handler == nil {
+		return nil, errors.New("the CIBA grant is not enabled")
+	}
+
+	accessRequest := fosite.NewAccessRequest(newSession(""))
+	accessRequest.Client = client
+	accessRequest.GrantTypes = fosite.Arguments{cibaGrantType}
+	accessRequest.Form = url.Values{"auth_req_id": {auth.AuthReqID}}
+
+	approved, err := store.GetBackchannelAuthentication(ctx, auth.AuthReqID, accessRequest.GetSession())
+	if err != nil {
+		return nil, err
+	}
+	if err := handler.authorize(ctx, accessRequest, approved); err != nil {
+		return nil, err
+	}
+	response, err := oauth2.NewAccessResponse(ctx, accessRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	body := response.ToMap()
+	body["auth_req_id"] = auth.AuthReqID
+	return body, nil
+}
+
+// backchannelAuthenticationHandler is the token endpoint handler of the CIBA grant. Clients in poll and ping mode
+// fetch their tokens from the token endpoint with the `auth_req_id`, clients in push mode are sent them.
+type backchannelAuthenticationHandler struct {
+	grantTokenIssuer
+}
+
+// backchannelAuthenticationFactory creates the CIBA grant handler, to be passed to `compose.Compose`.
+func backchannelAuthenticationFactory(config fosite.Configurator, storage interface{}, strategy interface{}) interface{} {
+	return &backchannelAuthenticationHandler{grantTokenIssuer: newGrantTokenIssuer(config, storage, strategy)}
+}
+
+func (h *backchannelAuthenticationHandler) HandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	client := requester.GetClient()
+	if !client.GetGrantTypes().Has(cibaGrantType) {
+		return fosite.ErrUnauthorizedClient.WithHintf("The OAuth 2.0 Client is not allowed to use authorization grant '%s'.", cibaGrantType)
+	}
+
+	authReqID := requester.GetRequestForm().Get("auth_req_id")
+	if authReqID == "" {
+		return fosite.ErrInvalidRequest.WithHint("The 'auth_req_id' parameter is missing.")
+	}
+
+	auth, err := h.Store.GetBackchannelAuthentication(ctx, authReqID, requester.GetSession())
+	if errors.Is(err, fosite.ErrNotFound) {
+		return fosite.ErrInvalidGrant.WithHint("The auth_req_id is unknown or was used before.")
+	} else if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if auth.Request.GetClient().GetID() != client.GetID() {
+		return fosite.ErrInvalidGrant.WithHint("The OAuth 2.0 Client ID from this request does not match the one from the authentication request.")
+	}
+	if auth.DeliveryMode == cibaPushMode {
+		return fosite.ErrUnauthorizedClient.WithHint("The OAuth 2.0 Client uses the push mode, its tokens are sent to its notification endpoint.")
+	}
+	return h.authorize(ctx, requester, auth)
+}
+
+// authorize hydrates the token request with the decision of the user. While the user did not decide, or if the user
+// denied the request, it returns the error for the client.
+func (h *backchannelAuthenticationHandler) authorize(ctx context.Context, requester fosite.AccessRequester, auth *sqlstore.BackchannelAuthentication) error {
+	if auth.ExpiresAt.Before(time.Now()) {
+		return errExpiredAuthReqID
+	}
+
+	switch auth.Status {
+	case sqlstore.BackchannelAuthenticationPending:
+		slowDown, err := h.Store.PollBackchannelAuthentication(ctx, auth.AuthReqID)
+		if err != nil {
+			return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+		}
+		if slowDown {
+			return errSlowDown
+		}
+		return errAuthorizationPending
+	case sqlstore.BackchannelAuthenticationDenied:
+		if err := h.Store.DeleteBackchannelAuthentication(ctx, auth.AuthReqID); err != nil && !errors.Is(err, fosite.ErrNotFound) {
+			log.Printf("Error occurred in DeleteBackchannelAuthentication: %+v", err)
+		}
+		return fosite.ErrAccessDenied.WithHint("The resource owner denied the request.")
+	}
+
+	// The user approved, hydrate the token request with the decision. The session was already decoded into the
+	// requester's session.
+	requester.SetID(auth.Request.GetID())
+	for _, scope := range auth.Request.GetGrantedScopes() {
+		requester.GrantScope(scope)
+	}
+	for _, audience := range auth.Request.GetGrantedAudience() {
+		requester.GrantAudience(audience)
+	}
+
+	h.setLifespans(ctx, cibaGrantType, requester)
+	return nil
+}
+
+func (h *backchannelAuthenticationHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	// The auth_req_id is single use, whoever deletes the request first gets the tokens.
+	authReqID := requester.GetRequestForm().Get("auth_req_id")
+	if err := h.Store.DeleteBackchannelAuthentication(ctx, authReqID); errors.Is(err, fosite.ErrNotFound) {
+		return fosite.ErrInvalidGrant.WithHint("The auth_req_id was used before.")
+	} else if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	// Pushed tokens do not come as the response to a request of the client, the ID token tells which request they
+	// are for and binds the refresh token to it, see section 10.3.1 of CIBA.
+	var idTokenClaims func(refresh string) map[string]interface{}
+	if c, ok := requester.GetClient().(*sqlstore.Client); ok && c.BackChannelTokenDeliveryMode == cibaPushMode {
+		idTokenClaims = func(refresh string) map[string]interface{} {
+			claims := map[string]interface{}{"urn:openid:params:jwt:claim:auth_req_id": authReqID}
+			if refresh != "" {
+				claims["urn:openid:params:jwt:claim:rt_hash"] = tokenHash(refresh)
+			}
+			return claims
+		}
+	}
+	return h.issueTokens(ctx, cibaGrantType, requester, responder, idTokenClaims)
+}
+
+func (h *backchannelAuthenticationHandler) CanSkipClientAuth(ctx context.Context, requester fosite.AccessRequester) bool {
+	return false
+}
+
+func (h *backchannelAuthenticationHandler) CanHandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(cibaGrantType)
+}
@@ -0,0 +1,189 @@
+package authorizationserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// BackchannelAuthenticationRequest is a CIBA request as the authentication device of the user is asked to approve it.
+type BackchannelAuthenticationRequest struct {
+	// ID identifies the request when the decision is passed to CompleteBackchannelAuthentication.
+	ID         string
+	Subject    string
+	ClientID   string
+	ClientName string
+	Scopes     []string
+	// BindingMessage is shown by the client as well, so the user can tell its request apart from others.
+	BindingMessage string
+	ExpiresAt      time.Time
+}
+
+// AuthenticationDevice reaches users who are not at the client that wants to authenticate them, e.g. with a push
+// notification to an app on their phone.
+type AuthenticationDevice interface {
+	// RequestApproval asks the user to approve or deny the request. It returns once the user was reached, the decision
+	// is passed to CompleteBackchannelAuthentication later. An error fails the request with temporarily_unavailable.
+	RequestApproval(ctx context.Context, request *BackchannelAuthenticationRequest) error
+}
+
+// authenticationDevice is where CIBA requests are sent for approval. It defaults to the web page at `/ciba`, which
+// lists the pending requests of the user who logs in there.
+var authenticationDevice AuthenticationDevice = webAuthenticationDevice{}
+
+// SetAuthenticationDevice replaces the web page at `/ciba` with a real authentication device. Call it before serving
+// requests.
+func SetAuthenticationDevice(device AuthenticationDevice) {
+	authenticationDevice = device
+}
+
+// CompleteBackchannelAuthentication records the decision of the user on the request with the given ID. It returns
+// fosite.ErrNotFound if the request is unknown, expired or was decided before.
+func CompleteBackchannelAuthentication(ctx context.Context, requestID string, approved bool) error {
+	auth, err := store.GetBackchannelAuthenticationByRequestID(ctx, requestID, nil)
+	if err != nil {
+		return err
+	}
+	return completeBackchannelAuthentication(ctx, auth, approved)
+}
+
+func approvalRequestOf(auth *sqlstore.BackchannelAuthentication) *BackchannelAuthenticationRequest {
+	request := &BackchannelAuthenticationRequest{
+		ID:             auth.Request.GetID(),
+		Subject:        auth.Subject,
+		ClientID:       auth.Request.GetClient().GetID(),
+		Scopes:         auth.Request.GetRequestedScopes(),
+		BindingMessage: auth.BindingMessage,
+		ExpiresAt:      auth.ExpiresAt,
+	}
+	if c, ok := auth.Request.GetClient().(*sqlstore.Client); ok {
+		request.ClientName = c.ClientName
+	}
+	return request
+}
+
+// webAuthenticationDevice stands in for an authentication device: the user approves or denies the requests at
+// `/ciba`, which they have to have open already.
+type webAuthenticationDevice struct{}
+
+func (webAuthenticationDevice) RequestApproval(ctx context.Context, request *BackchannelAuthenticationRequest) error {
+	log.Printf("Backchannel authentication request %s of client %s is waiting for %s at %s/ciba", request.ID, request.ClientID, request.Subject, issuer)
+	return nil
+}
+
+// backchannelApprovalEndpoint is the web page of webAuthenticationDevice. It lists the pending CIBA requests of the
+// user who logs in, who approves or denies them there.
+func backchannelApprovalEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	req.ParseForm()
+	authn, err := authenticateUser(rw, req, fosite.NewAuthorizeRequest())
+	if err != nil {
+		writeLoginPage(rw, req, "")
+		return
+	}
+
+	var message string
+	if req.Method == http.MethodPost && req.PostForm.Get("request") != "" {
+		// Decisions carry the login token, so other sites can not decide for the user.
+		if req.PostForm.Get("login_token") == "" {
+			http.Error(rw, "the login token is missing", http.StatusBadRequest)
+			return
+		}
+		message, err = decideBackchannelAuthentication(ctx, authn, req.PostForm.Get("request"), req.PostForm.Get("decision") == "approve")
+		if err != nil {
+			log.Printf("Error occurred in decideBackchannelAuthentication: %+v", err)
+			http.Error(rw, "could not record the decision", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	auths, err := store.ListPendingBackchannelAuthentications(ctx, authn.User.Subject)
+	if err != nil {
+		log.Printf("Error occurred in ListPendingBackchannelAuthentications: %+v", err)
+		http.Error(rw, "could not look up the requests", http.StatusInternalServerError)
+		return
+	}
+	token, err := newLoginToken(authn)
+	if err != nil {
+		log.Printf("Error occurred in newLoginToken: %+v", err)
+		http.Error(rw, "could not look up the requests", http.StatusInternalServerError)
+		return
+	}
+	writeBackchannelApprovalPage(rw, authn.User, auths, token, message)
+}
+
+// decideBackchannelAuthentication records the decision of the user on one of their requests and returns what to tell
+// them.
+func decideBackchannelAuthentication(ctx context.Context, authn *authentication, requestID string, approved bool) (string, error) {
+	auth, err := store.GetBackchannelAuthenticationByRequestID(ctx, requestID, nil)
+	if errors.Is(err, fosite.ErrNotFound) || err == nil && auth.Subject != authn.User.Subject {
+		return "The request is unknown.", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	err = completeBackchannelAuthentication(ctx, auth, approved)
+	if errors.Is(err, fosite.ErrNotFound) {
+		return "The request expired or was decided before.", nil
+	} else if err != nil {
+		return "", err
+	}
+	if approved {
+		return "You approved the request.", nil
+	}
+	return "You denied the request.", nil
+}
+
+// writeBackchannelApprovalPage lists the pending requests of the user, each with a form to approve or deny it. The
+// page reloads itself, so new requests show up.
+func writeBackchannelApprovalPage(rw http.ResponseWriter, user *User, auths []*sqlstore.BackchannelAuthentication, loginToken string, message string) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Write([]byte(`<head><meta http-equiv="refresh" content="5; url=/ciba"></head>`))
+	rw.Write([]byte(fmt.Sprintf(`<h1>Authentication requests of %s</h1>`, html.EscapeString(user.Subject))))
+	if message != "" {
+		rw.Write([]byte(fmt.Sprintf(`<p><strong>%s</strong></p>`, html.EscapeString(message))))
+	}
+	if len(auths) == 0 {
+		rw.Write([]byte(`<p>There are no pending requests. Requests started by an application show up here.</p>`))
+		return
+	}
+
+	rw.Write([]byte(`<ul>`))
+	for _, auth := range auths {
+		request := approvalRequestOf(auth)
+		client := request.ClientID
+		if request.ClientName != "" {
+			client = request.ClientName + " (" + request.ClientID + ")"
+		}
+		rw.Write([]byte(fmt.Sprintf(`<li><p><strong>%s</strong> wants to authenticate you`, html.EscapeString(client))))
+		if request.BindingMessage != "" {
+			rw.Write([]byte(fmt.Sprintf(`, it shows <code>%s</code>`, html.EscapeString(request.BindingMessage))))
+		}
+		rw.Write([]byte(fmt.Sprintf(`.<br>Scopes: %s<br>Expires at %s</p>`,
+			html.EscapeString(strings.Join(request.Scopes, " ")), request.ExpiresAt.Format(time.RFC3339))))
+		for _, decision := range []string{"approve", "deny"} {
+			rw.Write([]byte(fmt.Sprintf(`
+				<form method="post" action="/ciba" style="display: inline">
+					<input type="hidden" name="request" value="%s">
+					<input type="hidden" name="login_token" value="%s">
+					<input type="hidden" name="decision" value="%s">
+					<input type="submit" value="%s">
+				</form>
+			`, html.EscapeString(request.ID), html.EscapeString(loginToken), decision, strings.ToUpper(decision[:1])+decision[1:])))
+		}
+		rw.Write([]byte(`</li>`))
+	}
+	rw.Write([]byte(`</ul>`))
+}
@@ -0,0 +1,244 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// newCIBAClient returns a client of the CIBA grant in the delivery mode, authenticating with secret "foobar".
+func newCIBAClient(mode string, notificationEndpoint string) *sqlstore.Client {
+	client := newRefreshClient("ciba-client")
+	client.GrantTypes = []string{cibaGrantType, "refresh_token"}
+	client.Scopes = []string{"openid", "offline"}
+	client.BackChannelTokenDeliveryMode = mode
+	client.BackChannelClientNotificationEndpoint = notificationEndpoint
+	return client
+}
+
+// startBackchannelAuthentication asks for peter to be authenticated and returns the `auth_req_id`.
+func startBackchannelAuthentication(t *testing.T) string {
+	t.Helper()
+	form := url.Values{"scope": {"openid offline"}, "login_hint": {"peter"}, "client_notification_token": {"notification-token"}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/bc-authorize", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("ciba-client", "foobar")
+	rw := httptest.NewRecorder()
+	backchannelAuthenticationEndpoint(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("backchannel authentication failed with %d: %s", rw.Code, rw.Body)
+	}
+
+	var response backchannelAuthenticationResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding the backchannel authentication response: %v", err)
+	}
+	return response.AuthReqID
+}
+
+// pollBackchannelToken asks the token endpoint for the tokens of the request, like clients in poll and ping mode do.
+func pollBackchannelToken(authReqID string) *httptest.ResponseRecorder {
+	form := url.Values{"grant_type": {cibaGrantType}, "auth_req_id": {authReqID}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("ciba-client", "foobar")
+	rw := httptest.NewRecorder()
+	tokenEndpoint(rw, req)
+	return rw
+}
+
+// decideAsPeter approves or denies the request as peter would on the page at `/ciba` and returns what peter is told.
+func decideAsPeter(t *testing.T, authReqID string, approved bool) string {
+	t.Helper()
+	ctx := context.Background()
+	auth, err := store.GetBackchannelAuthentication(ctx, authReqID, nil)
+	if err != nil {
+		t.Fatalf("GetBackchannelAuthentication: %v", err)
+	}
+	user, err := users.GetUser(ctx, "peter")
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	message, err := decideBackchannelAuthentication(ctx, &authentication{User: user}, auth.Request.GetID(), approved)
+	if err != nil {
+		t.Fatalf("decideBackchannelAuthentication: %v", err)
+	}
+	return message
+}
+
+// useNotificationEndpoint serves a notification endpoint of a client, the notifications it receives are sent to the
+// returned channel.
+func useNotificationEndpoint(t *testing.T) (string, <-chan map[string]interface{}) {
+	notifications := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var body map[string]interface{}
+		if req.Header.Get("Authorization") != "Bearer notification-token" || json.NewDecoder(req.Body).Decode(&body) != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		notifications <- body
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/notify", notifications
+}
+
+// receiveNotification waits for the notification the server sends when the user decided.
+func receiveNotification(t *testing.T, notifications <-chan map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	select {
+	case body := <-notifications:
+		return body
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client was not notified")
+		return nil
+	}
+}
+
+func TestBackchannelAuthenticationPoll(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		approved bool
+	}{
+		{name: "approve", approved: true},
+		{name: "deny"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useTestStore(t, newCIBAClient(cibaPollMode, ""))
+			authReqID := startBackchannelAuthentication(t)
+
+			wantTokenError(t, pollBackchannelToken(authReqID), "authorization_pending")
+			// Polling again right away is too fast.
+			wantTokenError(t, pollBackchannelToken(authReqID), "slow_down")
+
+			if message := decideAsPeter(t, authReqID, tc.approved); strings.Contains(message, "unknown") {
+				t.Fatalf("peter was told: %s", message)
+			}
+			// Requests are decided once.
+			if message := decideAsPeter(t, authReqID, !tc.approved); message != "The request expired or was decided before." {
+				t.Errorf("deciding again, peter was told: %s", message)
+			}
+
+			rw := pollBackchannelToken(authReqID)
+			if !tc.approved {
+				wantTokenError(t, rw, "access_denied")
+			} else if rw.Code != http.StatusOK {
+				t.Fatalf("the token request returned %d: %s", rw.Code, rw.Body)
+			} else if accessToken, refreshToken := decodeTestTokens(t, rw); accessToken == "" || refreshToken == "" {
+				t.Errorf("got token response %s, want an access and a refresh token", rw.Body)
+			}
+
+			// The auth_req_id is single use.
+			wantTokenError(t, pollBackchannelToken(authReqID), "invalid_grant")
+		})
+	}
+}
+
+func TestBackchannelAuthenticationExpiry(t *testing.T) {
+	ctx := context.Background()
+	client := newCIBAClient(cibaPollMode, "")
+	s := useTestStore(t, client)
+
+	request := fosite.NewRequest()
+	request.Client = client
+	request.SetRequestedScopes(fosite.Arguments{"openid"})
+	if err := s.CreateBackchannelAuthentication(ctx, &sqlstore.BackchannelAuthentication{
+		AuthReqID:    "expired-auth-req-id",
+		Subject:      "peter",
+		Status:       sqlstore.BackchannelAuthenticationPending,
+		DeliveryMode: cibaPollMode,
+		Interval:     backchannelPollInterval,
+		ExpiresAt:    time.Now().Add(-time.Second),
+		Request:      request,
+	}); err != nil {
+		t.Fatalf("CreateBackchannelAuthentication: %v", err)
+	}
+
+	wantTokenError(t, pollBackchannelToken("expired-auth-req-id"), "expired_token")
+
+	// The user can not approve it anymore either.
+	if message := decideAsPeter(t, "expired-auth-req-id", true); message != "The request expired or was decided before." {
+		t.Errorf("peter was told: %s", message)
+	}
+}
+
+func TestBackchannelAuthenticationSubject(t *testing.T) {
+	ctx := context.Background()
+	s := useTestStore(t, newCIBAClient(cibaPollMode, ""))
+	authReqID := startBackchannelAuthentication(t)
+	auth, err := s.GetBackchannelAuthentication(ctx, authReqID, nil)
+	if err != nil {
+		t.Fatalf("GetBackchannelAuthentication: %v", err)
+	}
+
+	// Only the user the client named can decide, others do not even learn that the request exists.
+	other := &authentication{User: &User{Subject: "someone-else"}}
+	if message, err := decideBackchannelAuthentication(ctx, other, auth.Request.GetID(), true); err != nil || message != "The request is unknown." {
+		t.Errorf("decideBackchannelAuthentication() = %q, %v", message, err)
+	}
+	if auth, err := s.GetBackchannelAuthentication(ctx, authReqID, nil); err != nil || auth.Status != sqlstore.BackchannelAuthenticationPending {
+		t.Errorf("the request is %v after another user decided, %v", auth, err)
+	}
+}
+
+func TestBackchannelAuthenticationPing(t *testing.T) {
+	endpoint, notifications := useNotificationEndpoint(t)
+	useTestStore(t, newCIBAClient(cibaPingMode, endpoint))
+	authReqID := startBackchannelAuthentication(t)
+
+	decideAsPeter(t, authReqID, true)
+	if body := receiveNotification(t, notifications); body["auth_req_id"] != authReqID || len(body) != 1 {
+		t.Errorf("the client was pinged with %v", body)
+	}
+
+	if rw := pollBackchannelToken(authReqID); rw.Code != http.StatusOK {
+		t.Fatalf("the token request returned %d: %s", rw.Code, rw.Body)
+	}
+	wantTokenError(t, pollBackchannelToken(authReqID), "invalid_grant")
+}
+
+func TestBackchannelAuthenticationPush(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		approved bool
+	}{
+		{name: "approve", approved: true},
+		{name: "deny"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			endpoint, notifications := useNotificationEndpoint(t)
+			useTestStore(t, newCIBAClient(cibaPushMode, endpoint))
+			authReqID := startBackchannelAuthentication(t)
+
+			// Clients in push mode do not poll.
+			wantTokenError(t, pollBackchannelToken(authReqID), "unauthorized_client")
+
+			decideAsPeter(t, authReqID, tc.approved)
+			body := receiveNotification(t, notifications)
+			if body["auth_req_id"] != authReqID {
+				t.Errorf("the client was sent %v", body)
+			}
+			if !tc.approved {
+				if body["error"] != "access_denied" {
+					t.Errorf("the client was sent %v, want error access_denied", body)
+				}
+			} else if body["access_token"] == nil || body["id_token"] == nil {
+				t.Errorf("the client was sent %v, want an access and an ID token", body)
+			}
+
+			// The result is sent once, the request is gone afterwards.
+			if _, err := store.GetBackchannelAuthentication(context.Background(), authReqID, nil); err == nil {
+				t.Error("the request is kept after the result was sent")
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package authorizationserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// clientAssertionJWTBearerType is the `client_assertion_type` of JWT client assertions, see section 2.2 of RFC 7523.
+const clientAssertionJWTBearerType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// The client authentication methods of section 9 of OpenID Connect Core 1.0 that use JWT client assertions.
+const (
+	privateKeyJWT   = "private_key_jwt"
+	clientSecretJWT = "client_secret_jwt"
+)
+
+// maxClientAssertionLifespan is how far in the future the `exp` claim of a client assertion may be. The `jti` of every
+// assertion is remembered until it expires, so assertions must be short-lived.
+const maxClientAssertionLifespan = time.Hour
+
+// clientSecretJWTAlgs are the algorithms `client_secret_jwt` assertions may be signed with. `private_key_jwt`
+// assertions use the asymmetric algorithms of request objects.
+var clientSecretJWTAlgs = []string{string(jose.HS256), string(jose.HS384), string(jose.HS512)}
+
+// authenticateClient is the client authentication strategy of all endpoints. It adds the mutual TLS methods of
+// RFC 8705 to the methods fosite's default strategy supports, and verifies JWT client assertions itself.
+func authenticateClient(ctx context.Context, req *http.Request, form url.Values) (fosite.Client, error) {
+	if assertionType := form.Get("client_assertion_type"); assertionType == clientAssertionJWTBearerType {
+		return authenticateClientAssertion(ctx, req, form)
+	} else if assertionType != "" {
+		return nil, fosite.ErrInvalidRequest.WithHintf("Unknown client_assertion_type '%s'.", assertionType)
+	}
+
+	// Clients authenticating with a certificate only send their ID. Anything else is up to fosite.
+	_, _, basicAuth := req.BasicAuth()
+	clientID := form.Get("client_id")
+	if clientID == "" || basicAuth || form.Get("client_secret") != "" {
+		return oauth2.DefaultClientAuthenticationStrategy(ctx, req, form)
+	}
+
+	client, err := store.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, fosite.ErrInvalidClient.WithWrap(err).WithDebug(err.Error())
+	}
+
+	c, ok := client.(*sqlstore.Client)
+	if !ok {
+		return oauth2.DefaultClientAuthenticationStrategy(ctx, req, form)
+	}
+	switch c.GetTokenEndpointAuthMethod() {
+	case tlsClientAuth, selfSignedTLSClientAuth:
+		if err := authenticateClientCertificate(ctx, req, c); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return oauth2.DefaultClientAuthenticationStrategy(ctx, req, form)
+}
+
+// authenticateClientAssertion authenticates a client by a JWT client assertion (RFC 7523), signed with one of its
+// keys for `private_key_jwt` or with its assertion secret for `client_secret_jwt`. fosite supports the former only,
+// and only with the token endpoint as audience.
+//
+// The audience may be the issuer, the token endpoint or the endpoint the assertion is sent to, so the same checks
+// apply at the token and revocation endpoints. Every assertion can be used once.
+func authenticateClientAssertion(ctx context.Context, req *http.Request, form url.Values) (fosite.Client, error) {
+	assertion := form.Get("client_assertion")
+	if assertion == "" {
+		return nil, fosite.ErrInvalidRequest.WithHintf("The client_assertion request parameter must be set when using client_assertion_type of '%s'.", clientAssertionJWTBearerType)
+	}
+
+	jws, err := jose.ParseSigned(assertion)
+	if err != nil {
+		return nil, fosite.ErrInvalidClient.WithHint("Unable to parse the 'client_assertion'.").WithWrap(err).WithDebug(err.Error())
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, fosite.ErrInvalidClient.WithHint("The 'client_assertion' must have exactly one signature.")
+	}
+	alg := jws.Signatures[0].Header.Algorithm
+
+	// The client is known from the `sub` claim before the signature can be checked, the claims are only trusted
+	// once it is.
+	claims, err := decodeClaims(jws.UnsafePayloadWithoutVerification())
+	if err != nil {
+		return nil, fosite.ErrInvalidClient.WithHint("Unable to decode the claims of the 'client_assertion'.").WithWrap(err).WithDebug(err.Error())
+	}
+	clientID, _ := claims["sub"].(string)
+	if clientID == "" {
+		return nil, fosite.ErrInvalidClient.WithHint("The claim 'sub' from the 'client_assertion' is undefined.")
+	}
+	if id := form.Get("client_id"); id != "" && id != clientID {
+		return nil, fosite.ErrInvalidClient.WithHint("The claim 'sub' from the 'client_assertion' must match the 'client_id' parameter.")
+	}
+
+	client, err := store.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, fosite.ErrInvalidClient.WithWrap(err).WithDebug(err.Error())
+	}
+	c, ok := client.(*sqlstore.Client)
+	if !ok {
+		return nil, fosite.ErrInvalidClient.WithHint("The server configuration does not support OpenID Connect specific authentication methods.")
+	}
+
+	if enforced := c.TokenEndpointAuthSigningAlgorithm; enforced != "" && enforced != alg {
+		return nil, fosite.ErrInvalidClient.WithHintf("The 'client_assertion' uses signing algorithm '%s' but the requested OAuth 2.0 Client enforces signing algorithm '%s'.", alg, enforced)
+	}
+	switch c.GetTokenEndpointAuthMethod() {
+	case privateKeyJWT:
+		if !fosite.Arguments(requestObjectSigningAlgs).Has(alg) {
+			return nil, fosite.ErrInvalidClient.WithHintf("The 'client_assertion' uses unsupported signing algorithm '%s'.", alg)
+		}
+		if _, err := verifyWithClientJWKS(ctx, c, jws); err != nil {
+			return nil, fosite.ErrInvalidClient.WithHint("Unable to verify the integrity of the 'client_assertion' value.").WithWrap(err).WithDebug(err.Error())
+		}
+	case clientSecretJWT:
+		if !fosite.Arguments(clientSecretJWTAlgs).Has(alg) {
+			return nil, fosite.ErrInvalidClient.WithHintf("The 'client_assertion' uses unsupported signing algorithm '%s'.", alg)
+		}
+		if c.AssertionSecret == "" {
+			return nil, fosite.ErrInvalidClient.WithHint("The OAuth 2.0 Client has no assertion secret.")
+		}
+		if _, err := jws.Verify([]byte(c.AssertionSecret)); err != nil {
+			return nil, fosite.ErrInvalidClient.WithHint("Unable to verify the integrity of the 'client_assertion' value.").WithWrap(err).WithDebug(err.Error())
+		}
+	default:
+		return nil, fosite.ErrInvalidClient.WithHintf("This requested OAuth 2.0 client only supports client authentication method '%s', however 'client_assertion' was provided in the request.", c.GetTokenEndpointAuthMethod())
+	}
+
+	if err := checkAssertionClaims(ctx, req, claims, clientID, "client_assertion:"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// checkAssertionClaims checks the claims of a JWT client assertion the subject signed: it must be issued by the
+// subject itself, meant for this server, unexpired and short-lived, and its `jti` must not have been used before. The
+// `jti` is remembered under the prefix until the assertion expires.
+func checkAssertionClaims(ctx context.Context, req *http.Request, claims map[string]interface{}, subject string, jtiPrefix string) error {
+	if iss, _ := claims["iss"].(string); iss != subject {
+		return fosite.ErrInvalidClient.WithHint("Claim 'iss' from 'client_assertion' must match the 'client_id' of the OAuth 2.0 Client.")
+	}
+	audience := fosite.Arguments(audienceClaim(claims))
+	if !audience.HasOneOf(issuer, issuer+"/oauth2/token", requestURL(req)) {
+		return fosite.ErrInvalidClient.WithHintf("Claim 'aud' from 'client_assertion' must contain the issuer '%s' or the endpoint the assertion is sent to.", issuer)
+	}
+
+	now := time.Now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return fosite.ErrInvalidClient.WithHint("Claim 'exp' from 'client_assertion' must be set but is not.")
+	} else if !now.Before(exp) {
+		return fosite.ErrInvalidClient.WithHint("The 'client_assertion' has expired.")
+	} else if exp.After(now.Add(maxClientAssertionLifespan)) {
+		return fosite.ErrInvalidClient.WithHintf("Claim 'exp' from 'client_assertion' must be within %s from now.", maxClientAssertionLifespan)
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Before(nbf) {
+		return fosite.ErrInvalidClient.WithHint("The 'client_assertion' is not valid yet.")
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return fosite.ErrInvalidClient.WithHint("Claim 'jti' from 'client_assertion' must be set but is not.")
+	}
+	if err := store.SetClientAssertionJWT(ctx, jtiPrefix+subject+":"+jti, exp); errors.Is(err, fosite.ErrJTIKnown) {
+		return fosite.ErrJTIKnown.WithHint("Claim 'jti' from 'client_assertion' MUST only be used once.")
+	} else if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+	return nil
+}
+
+// verifyWithClientJWKS checks the signature against the keys of the client and returns the payload. Keys behind a
+// `jwks_uri` are fetched again once if none of them fits, the client may have rotated its keys since the set was
+// cached.
+func verifyWithClientJWKS(ctx context.Context, client fosite.OpenIDConnectClient, jws *jose.JSONWebSignature) ([]byte, error) {
+	set, err := clientJWKS(ctx, client, false)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := verifyWithClientKeys(jws, set)
+	if err == nil || client.GetJSONWebKeysURI() == "" {
+		return payload, err
+	}
+
+	if set, err = clientJWKS(ctx, client, true); err != nil {
+		return nil, err
+	}
+	return verifyWithClientKeys(jws, set)
+}
+
+// decodeClaims decodes the claims of a JWT, keeping numbers as json.Number for numericDate.
+func decodeClaims(payload []byte) (map[string]interface{}, error) {
+	var claims map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// audienceClaim returns the `aud` claim, which may be a single string or an array.
+func audienceClaim(claims map[string]interface{}) []string {
+	var audience []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+	}
+	return audience
+}
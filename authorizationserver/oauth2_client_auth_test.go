@@ -0,0 +1,144 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// newTestJWKS serves the public part of key at a `jwks_uri`, standing in for the keys endpoint of a client.
+func newTestJWKS(t *testing.T, key *rsa.PrivateKey, kid string) string {
+	t.Helper()
+
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: kid, Algorithm: string(jose.RS256), Use: "sig"}}}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/jwks.json"
+}
+
+// signTestAssertion signs the claims as a client assertion with the key.
+func signTestAssertion(t *testing.T, alg jose.SignatureAlgorithm, key interface{}, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, (&jose.SignerOptions{}).WithHeader(jose.HeaderKey("kid"), kid))
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	assertion, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatalf("CompactSerialize: %v", err)
+	}
+	return assertion
+}
+
+func TestAuthenticateClientAssertion(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	jwtClient := sqlstore.NewClient("jwt-client")
+	jwtClient.TokenEndpointAuthMethod = privateKeyJWT
+	jwtClient.JSONWebKeysURI = newTestJWKS(t, key, "key-1")
+	secretClient := sqlstore.NewClient("secret-jwt-client")
+	secretClient.TokenEndpointAuthMethod = clientSecretJWT
+	secretClient.AssertionSecret = "a-secret-of-at-least-32-bytes-long!"
+	useTestStore(t, jwtClient, secretClient)
+
+	claims := func(clientID string, overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": clientID,
+			"sub": clientID,
+			"aud": issuer + "/oauth2/token",
+			"jti": uuid.New().String(),
+			"exp": time.Now().Add(5 * time.Minute).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	// The cases run in order, the replayed assertion is used for the first time by the case before.
+	replayed := signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", nil))
+
+	for _, tc := range []struct {
+		name      string
+		assertion string
+		valid     bool
+	}{
+		{name: "private_key_jwt", assertion: signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", nil)), valid: true},
+		{name: "issuer as aud", assertion: signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", map[string]interface{}{"aud": issuer})), valid: true},
+		{name: "aud among others", assertion: signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", map[string]interface{}{"aud": []string{"https://other.example.com", issuer}})), valid: true},
+		{name: "foreign aud", assertion: signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", map[string]interface{}{"aud": "https://other.example.com/oauth2/token"}))},
+		{name: "no aud", assertion: signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", map[string]interface{}{"aud": nil}))},
+		{name: "expired", assertion: signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}))},
+		{name: "no exp", assertion: signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", map[string]interface{}{"exp": nil}))},
+		{name: "exp too far ahead", assertion: signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", map[string]interface{}{"exp": time.Now().Add(2 * maxClientAssertionLifespan).Unix()}))},
+		{name: "not valid yet", assertion: signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", map[string]interface{}{"nbf": time.Now().Add(time.Minute).Unix()}))},
+		{name: "no jti", assertion: signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", map[string]interface{}{"jti": nil}))},
+		{name: "first use of a jti", assertion: replayed, valid: true},
+		{name: "replayed jti", assertion: replayed},
+		{name: "iss of another client", assertion: signTestAssertion(t, jose.RS256, key, "key-1", claims("jwt-client", map[string]interface{}{"iss": "secret-jwt-client"}))},
+		{name: "signed with another key", assertion: signTestAssertion(t, jose.RS256, otherKey, "key-1", claims("jwt-client", nil))},
+		{name: "client_secret_jwt", assertion: signTestAssertion(t, jose.HS256, []byte(secretClient.AssertionSecret), "", claims("secret-jwt-client", nil)), valid: true},
+		{name: "client_secret_jwt with another secret", assertion: signTestAssertion(t, jose.HS256, []byte("another-secret-of-at-least-32-bytes"), "", claims("secret-jwt-client", nil))},
+		{name: "HMAC for private_key_jwt", assertion: signTestAssertion(t, jose.HS256, []byte(secretClient.AssertionSecret), "", claims("jwt-client", nil))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			form := url.Values{"client_assertion_type": {clientAssertionJWTBearerType}, "client_assertion": {tc.assertion}}
+			req := httptest.NewRequest(http.MethodPost, "/oauth2/token", nil)
+			client, err := authenticateClient(ctx, req, form)
+			if tc.valid {
+				if err != nil {
+					t.Fatalf("authenticateClient() = %v", fosite.ErrorToRFC6749Error(err).GetDescription())
+				}
+				if payload, _ := decodeClaims(mustPayload(t, tc.assertion)); client.GetID() != payload["sub"] {
+					t.Errorf("authenticated %s instead of the client of the assertion", client.GetID())
+				}
+			} else if err == nil {
+				t.Error("authenticateClient() accepted the assertion")
+			}
+		})
+	}
+}
+
+func mustPayload(t *testing.T, assertion string) []byte {
+	t.Helper()
+	jws, err := jose.ParseSigned(assertion)
+	if err != nil {
+		t.Fatalf("ParseSigned: %v", err)
+	}
+	return jws.UnsafePayloadWithoutVerification()
+}
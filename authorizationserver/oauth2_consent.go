@@ -0,0 +1,190 @@
+package authorizationserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// consentLifespan is how long a remembered consent is valid. Set CONSENT_LIFESPAN (e.g. `720h`) to let consents
+// expire, by default they are remembered until they are revoked.
+var consentLifespan = consentLifespanFromEnv()
+
+func consentLifespanFromEnv() time.Duration {
+	lifespan := os.Getenv("CONSENT_LIFESPAN")
+	if lifespan == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(lifespan)
+	if err != nil {
+		log.Fatalf("Invalid CONSENT_LIFESPAN %q: %+v", lifespan, err)
+	}
+	return d
+}
+
+// consentOutcome is the result of obtainConsent.
+type consentOutcome int
+
+const (
+	// consentGranted means the scopes the user consented to are granted on the request.
+	consentGranted consentOutcome = iota
+	// consentDenied means the user declined the request.
+	consentDenied
+	// consentPageShown means the consent page was written, the user did not decide yet.
+	consentPageShown
+)
+
+// obtainConsent grants the scopes the user consents to on the request. The consent page is shown unless the user
+// consented to all requested scopes and resource servers before. It returns fosite.ErrConsentRequired if the page would have to be shown
+// but the client asked for `prompt=none`.
+func obtainConsent(rw http.ResponseWriter, req *http.Request, ar fosite.AuthorizeRequester, authn *authentication) (consentOutcome, error) {
+	ctx := req.Context()
+
+	switch req.PostForm.Get("consent") {
+	case "allow":
+		// let's see what scopes the user gave consent to
+		for _, scope := range req.PostForm["scopes"] {
+			if ar.GetRequestedScopes().Has(scope) {
+				ar.GrantScope(scope)
+			}
+		}
+		grantRequestedAudience(ar)
+		if req.PostForm.Get("remember") == "true" {
+			if err := rememberConsent(ctx, ar, authn.User.Subject); err != nil {
+				log.Printf("Error occurred in rememberConsent: %+v", err)
+			}
+		}
+		return consentGranted, nil
+	case "deny":
+		return consentDenied, nil
+	}
+
+	required, err := consentRequired(ctx, ar, authn.User.Subject)
+	if err != nil {
+		log.Printf("Error occurred in consentRequired: %+v", err)
+		return 0, fosite.ErrServerError.WithWrap(err)
+	}
+
+	if !required {
+		for _, scope := range ar.GetRequestedScopes() {
+			ar.GrantScope(scope)
+		}
+		grantRequestedAudience(ar)
+		return consentGranted, nil
+	}
+
+	if promptValues(ar).Has("none") {
+		return 0, fosite.ErrConsentRequired
+	}
+
+	token, err := newLoginToken(authn)
+	if err != nil {
+		log.Printf("Error occurred in newLoginToken: %+v", err)
+		return 0, fosite.ErrServerError.WithWrap(err)
+	}
+	action, err := pageAction(req, ar)
+	if err != nil {
+		log.Printf("Error occurred in pageAction: %+v", err)
+		return 0, fosite.ErrServerError.WithWrap(err)
+	}
+	writeConsentPage(rw, ar, authn.User, token, action)
+	return consentPageShown, nil
+}
+
+// promptValues returns the values of the OpenID Connect `prompt` parameter.
+func promptValues(ar fosite.AuthorizeRequester) fosite.Arguments {
+	return fosite.RemoveEmpty(strings.Split(ar.GetRequestForm().Get("prompt"), " "))
+}
+
+// consentRequired reports whether the user has to be asked for consent, which is the case if the request forces it
+// with `prompt=consent` or the user did not consent to all requested scopes and resource servers before.
+func consentRequired(ctx context.Context, ar fosite.AuthorizeRequester, subject string) (bool, error) {
+	if promptValues(ar).Has("consent") {
+		return true, nil
+	}
+
+	consent, err := store.GetConsent(ctx, subject, ar.GetClient().GetID())
+	if errors.Is(err, fosite.ErrNotFound) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return !consent.Scopes.Has(ar.GetRequestedScopes()...) || !consent.Audience.Has(ar.GetRequestedAudience()...), nil
+}
+
+// rememberConsent stores the scopes and audience the user granted, so later requests for the same or fewer scopes and
+// resource servers skip the consent page.
+func rememberConsent(ctx context.Context, ar fosite.AuthorizeRequester, subject string) error {
+	consent := &sqlstore.Consent{
+		Subject:   subject,
+		ClientID:  ar.GetClient().GetID(),
+		Scopes:    ar.GetGrantedScopes(),
+		Audience:  ar.GetGrantedAudience(),
+		GrantedAt: time.Now(),
+	}
+	if consentLifespan > 0 {
+		consent.ExpiresAt = consent.GrantedAt.Add(consentLifespan)
+	}
+	return store.SaveConsent(ctx, consent)
+}
+
+// writeConsentPage asks the user which of the requested scopes to grant. Like the login page, the form is posted to
+// action.
+func writeConsentPage(rw http.ResponseWriter, ar fosite.AuthorizeRequester, user *User, loginToken string, action string) {
+	var requestedScopes string
+	for _, this := range ar.GetRequestedScopes() {
+		requestedScopes += fmt.Sprintf(`<li><input type="checkbox" name="scopes" value="%s" checked>%s</li>`, html.EscapeString(this), html.EscapeString(this))
+	}
+
+	// Tell the user which resource servers the access is meant for, if the client named any.
+	var audience string
+	for _, this := range ar.GetRequestedAudience() {
+		name := this
+		if resource, ok := resourceRegistry[this]; ok {
+			name = resource.DisplayName()
+		}
+		audience += fmt.Sprintf(`<li>%s</li>`, html.EscapeString(name))
+	}
+	if audience != "" {
+		audience = fmt.Sprintf(`<p>The access is limited to:<ul>%s</ul></p>`, audience)
+	}
+
+	// Registered clients may have told us their name and logo.
+	application := html.EscapeString(ar.GetClient().GetID())
+	if c, ok := ar.GetClient().(*sqlstore.Client); ok {
+		if c.ClientName != "" {
+			application = html.EscapeString(c.ClientName)
+		}
+		if c.LogoURI != "" {
+			application = fmt.Sprintf(`<img src="%s" alt="" height="16"> %s`, html.EscapeString(c.LogoURI), application)
+		}
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write([]byte(`<h1>Consent page</h1>`))
+	rw.Write([]byte(fmt.Sprintf(`
+		<p>Hi %s! The application <strong>%s</strong> would like to access your account.</p>
+		<form method="post" action="%s">
+			<input type="hidden" name="login_token" value="%s" />
+			<p>
+				Grant these scopes:
+				<ul>%s</ul>
+			</p>
+			%s
+			<input type="checkbox" name="remember" value="true" checked> Remember my decision<br>
+			<button type="submit" name="consent" value="allow">Allow</button>
+			<button type="submit" name="consent" value="deny">Deny</button>
+		</form>
+	`, html.EscapeString(user.LoginName()), application, html.EscapeString(action), loginToken, requestedScopes, audience)))
+}
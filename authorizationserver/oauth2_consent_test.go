@@ -0,0 +1,64 @@
+package authorizationserver
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+func TestConsentRequired(t *testing.T) {
+	ctx := context.Background()
+	protected, photos := "http://localhost:3846/protected", "https://photos.my-application.com"
+	client := sqlstore.NewClient("consent-client")
+	s := useTestStore(t, client)
+
+	// The user consented to two scopes for the protected resource.
+	if err := s.SaveConsent(ctx, &sqlstore.Consent{
+		Subject:   "peter",
+		ClientID:  "consent-client",
+		Scopes:    fosite.Arguments{"openid", "offline"},
+		Audience:  fosite.Arguments{protected},
+		GrantedAt: time.Now(),
+	}); err != nil {
+		t.Fatalf("SaveConsent: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		subject  string
+		scopes   fosite.Arguments
+		audience fosite.Arguments
+		prompt   string
+		required bool
+	}{
+		{name: "same scopes and audience", subject: "peter", scopes: fosite.Arguments{"openid", "offline"}, audience: fosite.Arguments{protected}},
+		{name: "fewer scopes", subject: "peter", scopes: fosite.Arguments{"openid"}, audience: fosite.Arguments{protected}},
+		{name: "no audience", subject: "peter", scopes: fosite.Arguments{"openid"}},
+		{name: "another scope", subject: "peter", scopes: fosite.Arguments{"openid", "fosite"}, audience: fosite.Arguments{protected}, required: true},
+		{name: "another resource server", subject: "peter", scopes: fosite.Arguments{"openid"}, audience: fosite.Arguments{photos}, required: true},
+		{name: "an additional resource server", subject: "peter", scopes: fosite.Arguments{"openid"}, audience: fosite.Arguments{protected, photos}, required: true},
+		{name: "prompt=consent", subject: "peter", scopes: fosite.Arguments{"openid"}, prompt: "consent", required: true},
+		{name: "another user", subject: "alice", scopes: fosite.Arguments{"openid"}, required: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ar := fosite.NewAuthorizeRequest()
+			ar.Client = client
+			ar.RequestedScope = tc.scopes
+			ar.RequestedAudience = tc.audience
+			ar.Form = url.Values{"prompt": {tc.prompt}}
+
+			required, err := consentRequired(ctx, ar, tc.subject)
+			if err != nil {
+				t.Fatalf("consentRequired: %v", err)
+			}
+			if required != tc.required {
+				t.Errorf("consentRequired() = %v, want %v", required, tc.required)
+			}
+		})
+	}
+}
@@ -0,0 +1,411 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// deviceCodeGrantType is the grant type of RFC 8628.
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+const (
+	// deviceCodeLifespan is how long the user has to enter the user code.
+	deviceCodeLifespan = time.Minute * 10
+	// devicePollInterval is the minimum time devices have to wait between polls of the token endpoint.
+	devicePollInterval = time.Second * 5
+	// userCodeCharset are the characters of user codes. There are no vowels, so codes never spell words, and no
+	// characters that are easily confused.
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	// userCodeLength is the number of characters of a user code, shown as two groups of four.
+	userCodeLength = 8
+)
+
+// The error codes of section 3.5 of RFC 8628, fosite does not define them.
+var (
+	errAuthorizationPending = &fosite.RFC6749Error{
+		ErrorField:       "authorization_pending",
+		DescriptionField: "The authorization request is still pending as the end user hasn't yet completed the user-interaction steps.",
+		CodeField:        http.StatusBadRequest,
+	}
+	errSlowDown = &fosite.RFC6749Error{
+		ErrorField:       "slow_down",
+		DescriptionField: "The authorization request is still pending and polling should continue, but the interval MUST be increased by 5 seconds for this and all subsequent requests.",
+		CodeField:        http.StatusBadRequest,
+	}
+	errExpiredToken = &fosite.RFC6749Error{
+		ErrorField:       "expired_token",
+		DescriptionField: "The device_code has expired, and the device authorization session has concluded.",
+		CodeField:        http.StatusBadRequest,
+	}
+)
+
+// deviceAuthorizationResponse is the response of the device authorization endpoint, see section 3.2 of RFC 8628.
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// deviceAuthorizationEndpoint starts the device authorization grant. The device shows the returned user code and
+// verification URI to the user, and polls the token endpoint with the device code until the user approved or denied
+// the request at `/device`.
+func deviceAuthorizationEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	if req.Method != http.MethodPost {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("HTTP method is '%s', expected 'POST'.", req.Method))
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithWrap(err).WithDebug(err.Error()))
+		return
+	}
+
+	// Public clients, which devices usually are, only send their client_id.
+	client, err := oauth2.AuthenticateClient(ctx, req, req.PostForm)
+	if err != nil {
+		log.Printf("Error occurred in AuthenticateClient: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, err)
+		return
+	}
+	if !client.GetGrantTypes().Has(deviceCodeGrantType) {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrUnauthorizedClient.WithHintf("The OAuth 2.0 Client is not allowed to use authorization grant '%s'.", deviceCodeGrantType))
+		return
+	}
+
+	request := fosite.NewRequest()
+	request.Client = client
+	request.Form = req.PostForm
+	request.SetRequestedScopes(fosite.RemoveEmpty(strings.Split(req.PostForm.Get("scope"), " ")))
+	request.SetRequestedAudience(fosite.GetAudiences(req.PostForm))
+	for _, scope := range request.GetRequestedScopes() {
+		if !config.GetScopeStrategy(ctx)(client.GetScopes(), scope) {
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidScope.WithHintf("The OAuth 2.0 Client is not allowed to request scope '%s'.", scope))
+			return
+		}
+	}
+	if err := config.GetAudienceStrategy(ctx)(client.GetAudience(), request.GetRequestedAudience()); err != nil {
+		oauth2.WriteAccessError(ctx, rw, nil, err)
+		return
+	}
+	if err := requestResources(ctx, request, req.PostForm); err != nil {
+		oauth2.WriteAccessError(ctx, rw, nil, err)
+		return
+	}
+
+	deviceCode, err := randomDeviceCode()
+	if err != nil {
+		log.Printf("Error occurred in randomDeviceCode: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+	userCode, err := randomUserCode()
+	if err != nil {
+		log.Printf("Error occurred in randomUserCode: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	if err := store.CreateDeviceAuthorization(ctx, deviceCodeSignature(deviceCode), &sqlstore.DeviceAuthorization{
+		UserCode:  userCode,
+		Interval:  devicePollInterval,
+		ExpiresAt: time.Now().Add(deviceCodeLifespan),
+		Request:   request,
+	}); err != nil {
+		log.Printf("Error occurred in CreateDeviceAuthorization: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(rw).Encode(&deviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationURI:         issuer + "/device",
+		VerificationURIComplete: issuer + "/device?user_code=" + url.QueryEscape(formatUserCode(userCode)),
+		ExpiresIn:               int64(deviceCodeLifespan / time.Second),
+		Interval:                int64(devicePollInterval / time.Second),
+	}); err != nil {
+		log.Printf("Error occurred in deviceAuthorizationEndpoint: %+v", err)
+	}
+}
+
+// deviceVerificationEndpoint is the page users enter the user code at. Once the code is known, the user logs in and
+// confirms the request of the device.
+func deviceVerificationEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	req.ParseForm()
+	userCode := normalizeUserCode(req.Form.Get("user_code"))
+	if userCode == "" {
+		writeUserCodePage(rw, "")
+		return
+	}
+
+	// Keep the user code in the URL, so the login and consent forms post back to it.
+	if req.URL.Query().Get("user_code") == "" {
+		http.Redirect(rw, req, "/device?user_code="+url.QueryEscape(formatUserCode(userCode)), http.StatusSeeOther)
+		return
+	}
+
+	auth, err := store.GetDeviceAuthorizationByUserCode(ctx, userCode, nil)
+	if errors.Is(err, fosite.ErrNotFound) {
+		writeUserCodePage(rw, "The code is unknown. Please check it and try again.")
+		return
+	} else if err != nil {
+		log.Printf("Error occurred in GetDeviceAuthorizationByUserCode: %+v", err)
+		http.Error(rw, "could not look up the code", http.StatusInternalServerError)
+		return
+	}
+	if auth.Status != sqlstore.DeviceAuthorizationPending || auth.ExpiresAt.Before(time.Now()) {
+		writeUserCodePage(rw, "The code expired or was used before. Please start over on your device.")
+		return
+	}
+
+	request, ok := auth.Request.(*fosite.Request)
+	if !ok {
+		http.Error(rw, "unexpected device authorization request", http.StatusInternalServerError)
+		return
+	}
+	ar := fosite.NewAuthorizeRequest()
+	ar.Request = *request
+
+	authn, err := authenticateUser(rw, req, ar)
+	if err != nil {
+		writeLoginPage(rw, req, "")
+		return
+	}
+
+	// A link with the user code is all it takes to phish for a device approval (see section 5.4 of RFC 8628), so the
+	// user always confirms on a page naming the client and the scopes. Neither the session cookie nor a remembered
+	// consent approve the device, only the decision posted from that page, which carries the login token.
+	decision := req.PostForm.Get("consent")
+	if req.Method != http.MethodPost || req.PostForm.Get("login_token") == "" || (decision != "allow" && decision != "deny") {
+		token, err := newLoginToken(authn)
+		if err != nil {
+			log.Printf("Error occurred in newLoginToken: %+v", err)
+			writeDeviceResultPage(rw, "Something went wrong, please start over on your device.")
+			return
+		}
+		writeConsentPage(rw, ar, authn.User, token, "")
+		return
+	}
+
+	outcome, err := obtainConsent(rw, req, ar, authn)
+	if err != nil {
+		writeDeviceResultPage(rw, "Something went wrong, please start over on your device.")
+		return
+	}
+
+	switch outcome {
+	case consentPageShown:
+		return
+	case consentDenied:
+		if err := store.CompleteDeviceAuthorization(ctx, userCode, sqlstore.DeviceAuthorizationDenied, ar); err != nil {
+			log.Printf("Error occurred in CompleteDeviceAuthorization: %+v", err)
+		}
+		writeDeviceResultPage(rw, "You denied the request. You can close this window.")
+		return
+	}
+
+	// Now that the user is authorized, we set up the session the device's tokens are issued for.
+	mySessionData := newSession(authn.User.Subject)
+	mySessionData.Claims.AuthTime = authn.AuthTime
+	mySessionData.Claims.RequestedAt = authn.RequestedAt
+	ar.SetSession(mySessionData)
+
+	if err := store.CompleteDeviceAuthorization(ctx, userCode, sqlstore.DeviceAuthorizationApproved, ar); err != nil {
+		log.Printf("Error occurred in CompleteDeviceAuthorization: %+v", err)
+		writeDeviceResultPage(rw, "Something went wrong, please start over on your device.")
+		return
+	}
+	writeDeviceResultPage(rw, "Your device is now connected. You can close this window.")
+}
+
+// writeUserCodePage asks the user for the code shown on their device.
+func writeUserCodePage(rw http.ResponseWriter, message string) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write([]byte(`<h1>Connect a device</h1>`))
+	if message != "" {
+		rw.Write([]byte(fmt.Sprintf(`<p><strong>%s</strong></p>`, html.EscapeString(message))))
+	}
+	rw.Write([]byte(`
+		<p>Enter the code shown on your device.</p>
+		<form method="post">
+			<input type="text" name="user_code" placeholder="XXXX-XXXX" autocomplete="off" /><br>
+			<input type="submit">
+		</form>
+	`))
+}
+
+// writeDeviceResultPage tells the user how the device authorization ended.
+func writeDeviceResultPage(rw http.ResponseWriter, message string) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write([]byte(`<h1>Connect a device</h1>`))
+	rw.Write([]byte(fmt.Sprintf(`<p>%s</p>`, html.EscapeString(message))))
+}
+
+// randomDeviceCode returns a device code with 256 bits of entropy.
+func randomDeviceCode() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// deviceCodeSignature is what is stored in place of the device code, so a leaked database does not leak device codes.
+func deviceCodeSignature(deviceCode string) string {
+	sum := sha256.Sum256([]byte(deviceCode))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomUserCode returns a user code in its normalized form, see normalizeUserCode.
+func randomUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeCharset))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeCharset[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode upper-cases a user code as typed by the user and drops everything that is not part of the code,
+// like the dash.
+func normalizeUserCode(code string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(code) {
+		if strings.ContainsRune(userCodeCharset, c) {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// formatUserCode splits a user code in two groups, which are easier to read and type.
+func formatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// deviceCodeHandler is the token endpoint handler of the device code grant. The device polls it until the user
+// approved or denied the request.
+type deviceCodeHandler struct {
+	grantTokenIssuer
+}
+
+// deviceCodeFactory creates the device code grant handler, to be passed to `compose.Compose`.
+func deviceCodeFactory(config fosite.Configurator, storage interface{}, strategy interface{}) interface{} {
+	return &deviceCodeHandler{grantTokenIssuer: newGrantTokenIssuer(config, storage, strategy)}
+}
+
+func (h *deviceCodeHandler) HandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	client := requester.GetClient()
+	if !client.GetGrantTypes().Has(deviceCodeGrantType) {
+		return fosite.ErrUnauthorizedClient.WithHintf("The OAuth 2.0 Client is not allowed to use authorization grant '%s'.", deviceCodeGrantType)
+	}
+
+	deviceCode := requester.GetRequestForm().Get("device_code")
+	if deviceCode == "" {
+		return fosite.ErrInvalidRequest.WithHint("The 'device_code' parameter is missing.")
+	}
+
+	signature := deviceCodeSignature(deviceCode)
+	auth, err := h.Store.GetDeviceAuthorization(ctx, signature, requester.GetSession())
+	if errors.Is(err, fosite.ErrNotFound) {
+		return fosite.ErrInvalidGrant.WithHint("The device code is unknown or was used before.")
+	} else if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if auth.Request.GetClient().GetID() != client.GetID() {
+		return fosite.ErrInvalidGrant.WithHint("The OAuth 2.0 Client ID from this request does not match the one from the device authorization request.")
+	}
+	if auth.ExpiresAt.Before(time.Now()) {
+		return errExpiredToken
+	}
+
+	switch auth.Status {
+	case sqlstore.DeviceAuthorizationPending:
+		slowDown, err := h.Store.PollDeviceAuthorization(ctx, signature)
+		if err != nil {
+			return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+		}
+		if slowDown {
+			return errSlowDown
+		}
+		return errAuthorizationPending
+	case sqlstore.DeviceAuthorizationDenied:
+		if err := h.Store.DeleteDeviceAuthorization(ctx, signature); err != nil && !errors.Is(err, fosite.ErrNotFound) {
+			log.Printf("Error occurred in DeleteDeviceAuthorization: %+v", err)
+		}
+		return fosite.ErrAccessDenied.WithHint("The resource owner denied the request.")
+	}
+
+	// The user approved, hydrate the token request with the decision. The session was already decoded into the
+	// requester's session.
+	requester.SetID(auth.Request.GetID())
+	for _, scope := range auth.Request.GetGrantedScopes() {
+		requester.GrantScope(scope)
+	}
+	for _, audience := range auth.Request.GetGrantedAudience() {
+		requester.GrantAudience(audience)
+	}
+
+	h.setLifespans(ctx, deviceCodeGrantType, requester)
+	return nil
+}
+
+func (h *deviceCodeHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	// Device codes are single use, whoever deletes the authorization first gets the tokens.
+	if err := h.Store.DeleteDeviceAuthorization(ctx, deviceCodeSignature(requester.GetRequestForm().Get("device_code"))); errors.Is(err, fosite.ErrNotFound) {
+		return fosite.ErrInvalidGrant.WithHint("The device code was used before.")
+	} else if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	return h.issueTokens(ctx, deviceCodeGrantType, requester, responder, nil)
+}
+
+func (h *deviceCodeHandler) CanSkipClientAuth(ctx context.Context, requester fosite.AccessRequester) bool {
+	return false
+}
+
+func (h *deviceCodeHandler) CanHandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(deviceCodeGrantType)
+}
@@ -0,0 +1,200 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// loginTokenField finds the login token in the forms of the consent page.
+var loginTokenField = regexp.MustCompile(`name="login_token" value="([^"]+)"`)
+
+// newDeviceClient returns a public client for the device authorization grant, like "my-device".
+func newDeviceClient() *sqlstore.Client {
+	client := sqlstore.NewClient("device-client")
+	client.Public = true
+	client.GrantTypes = []string{deviceCodeGrantType, "refresh_token"}
+	client.Scopes = []string{"openid", "offline"}
+	client.TokenEndpointAuthMethod = "none"
+	return client
+}
+
+// startDeviceAuthorization asks the device authorization endpoint for a device and a user code.
+func startDeviceAuthorization(t *testing.T) *deviceAuthorizationResponse {
+	t.Helper()
+	form := url.Values{"client_id": {"device-client"}, "scope": {"openid offline"}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/device/auth", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw := httptest.NewRecorder()
+	deviceAuthorizationEndpoint(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("device authorization failed with %d: %s", rw.Code, rw.Body)
+	}
+
+	var response deviceAuthorizationResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding the device authorization response: %v", err)
+	}
+	return &response
+}
+
+// pollDeviceToken polls the token endpoint like the device does.
+func pollDeviceToken(deviceCode string) *httptest.ResponseRecorder {
+	form := url.Values{"grant_type": {deviceCodeGrantType}, "device_code": {deviceCode}, "client_id": {"device-client"}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw := httptest.NewRecorder()
+	tokenEndpoint(rw, req)
+	return rw
+}
+
+// verifyDevice sends a request to the verification page for the user code, with the given cookie if any.
+func verifyDevice(method string, userCode string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/device?"+url.Values{"user_code": {userCode}}.Encode(), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rw := httptest.NewRecorder()
+	deviceVerificationEndpoint(rw, req)
+	return rw
+}
+
+// wantTokenError checks that the token endpoint answered with the error code.
+func wantTokenError(t *testing.T, rw *httptest.ResponseRecorder, code string) {
+	t.Helper()
+	var response struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil || response.Error != code {
+		t.Errorf("got %d: %s, want error %s", rw.Code, rw.Body, code)
+	}
+}
+
+func TestDeviceCodePolling(t *testing.T) {
+	ctx := context.Background()
+	s := useTestStore(t, newDeviceClient())
+	device := startDeviceAuthorization(t)
+
+	wantTokenError(t, pollDeviceToken(device.DeviceCode), "authorization_pending")
+
+	// Polling again right away is too fast, the device has to wait longer from now on.
+	wantTokenError(t, pollDeviceToken(device.DeviceCode), "slow_down")
+	auth, err := s.GetDeviceAuthorization(ctx, deviceCodeSignature(device.DeviceCode), newSession(""))
+	if err != nil {
+		t.Fatalf("GetDeviceAuthorization: %v", err)
+	}
+	if want := devicePollInterval + 5*time.Second; auth.Interval != want {
+		t.Errorf("the interval is %v, want %v", auth.Interval, want)
+	}
+
+	wantTokenError(t, pollDeviceToken("unknown"), "invalid_grant")
+}
+
+func TestDeviceCodeExpiry(t *testing.T) {
+	ctx := context.Background()
+	client := newDeviceClient()
+	s := useTestStore(t, client)
+
+	request := fosite.NewRequest()
+	request.Client = client
+	request.SetRequestedScopes(fosite.Arguments{"openid"})
+	if err := s.CreateDeviceAuthorization(ctx, deviceCodeSignature("expired-device-code"), &sqlstore.DeviceAuthorization{
+		UserCode:  "BCDFGHJK",
+		Interval:  devicePollInterval,
+		ExpiresAt: time.Now().Add(-time.Second),
+		Request:   request,
+	}); err != nil {
+		t.Fatalf("CreateDeviceAuthorization: %v", err)
+	}
+
+	wantTokenError(t, pollDeviceToken("expired-device-code"), "expired_token")
+
+	// The user can not approve it anymore either.
+	rw := verifyDevice(http.MethodGet, "BCDF-GHJK", nil, newTestLoginSession(t, time.Now(), time.Now().Add(time.Hour)))
+	if !strings.Contains(rw.Body.String(), "expired") {
+		t.Errorf("the verification page for an expired code shows: %s", rw.Body)
+	}
+}
+
+func TestDeviceVerification(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name     string
+		decision string
+		approved bool
+	}{
+		{name: "approve", decision: "allow", approved: true},
+		{name: "deny", decision: "deny"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := useTestStore(t, newDeviceClient())
+			device := startDeviceAuthorization(t)
+			session := newTestLoginSession(t, time.Now(), time.Now().Add(time.Hour))
+
+			// Even with a session and a remembered consent, the link alone does not approve the device, it shows
+			// the confirmation page. Neither does a decision without the login token of that page.
+			if err := s.SaveConsent(ctx, &sqlstore.Consent{Subject: "peter", ClientID: "device-client", Scopes: fosite.Arguments{"openid", "offline"}, GrantedAt: time.Now()}); err != nil {
+				t.Fatalf("SaveConsent: %v", err)
+			}
+			page := verifyDevice(http.MethodGet, device.UserCode, nil, session)
+			if !strings.Contains(page.Body.String(), "device-client") || !strings.Contains(page.Body.String(), `value="offline"`) {
+				t.Errorf("the confirmation page does not name the client and the scopes: %s", page.Body)
+			}
+			verifyDevice(http.MethodPost, device.UserCode, url.Values{"consent": {"allow"}, "scopes": {"openid", "offline"}}, session)
+			verifyDevice(http.MethodGet, device.UserCode, url.Values{"consent": {"allow"}, "login_token": {"forged"}}, session)
+			auth, err := s.GetDeviceAuthorizationByUserCode(ctx, normalizeUserCode(device.UserCode), nil)
+			if err != nil {
+				t.Fatalf("GetDeviceAuthorizationByUserCode: %v", err)
+			}
+			if auth.Status != sqlstore.DeviceAuthorizationPending {
+				t.Fatalf("the device authorization is %s without confirmation", auth.Status)
+			}
+
+			// Users who are not logged in log in first, and confirm next.
+			if rw := verifyDevice(http.MethodGet, device.UserCode, nil, nil); !strings.Contains(rw.Body.String(), `name="password"`) {
+				t.Fatalf("no login page is shown: %s", rw.Body)
+			}
+			page = verifyDevice(http.MethodPost, device.UserCode, url.Values{"username": {"peter"}, "password": {"secret"}}, nil)
+			match := loginTokenField.FindStringSubmatch(page.Body.String())
+			if match == nil {
+				t.Fatalf("no confirmation page is shown after logging in: %s", page.Body)
+			}
+			verifyDevice(http.MethodPost, device.UserCode, url.Values{"login_token": {match[1]}, "consent": {tc.decision}, "scopes": {"openid", "offline"}}, nil)
+
+			rw := pollDeviceToken(device.DeviceCode)
+			if !tc.approved {
+				wantTokenError(t, rw, "access_denied")
+				return
+			}
+			if rw.Code != http.StatusOK {
+				t.Fatalf("the device got %d: %s", rw.Code, rw.Body)
+			}
+			var response struct {
+				AccessToken  string `json:"access_token"`
+				RefreshToken string `json:"refresh_token"`
+				IDToken      string `json:"id_token"`
+			}
+			if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding the token response: %v", err)
+			}
+			if response.AccessToken == "" || response.RefreshToken == "" || response.IDToken == "" {
+				t.Errorf("got token response %s, want an access, refresh and ID token", rw.Body)
+			}
+
+			// Device codes are single use.
+			wantTokenError(t, pollDeviceToken(device.DeviceCode), "invalid_grant")
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/ory/fosite"
+	fositeoauth2 "github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/ory/fosite/handler/pkce"
+
+	"github.com/ory/fosite-example/dpop"
+)
+
+// discoveryDocument is served both as OpenID Connect Discovery 1.0 (`/.well-known/openid-configuration`) and as
+// RFC 8414 authorization server metadata (`/.well-known/oauth-authorization-server`). The OpenID Connect document is a
+// superset of the RFC 8414 one, so a single document satisfies both.
+type discoveryDocument struct {
+	Issuer                                     string            `json:"issuer"`
+	AuthorizationEndpoint                      string            `json:"authorization_endpoint"`
+	TokenEndpoint                              string            `json:"token_endpoint"`
+	RevocationEndpoint                         string            `json:"revocation_endpoint"`
+	IntrospectionEndpoint                      string            `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint                string            `json:"device_authorization_endpoint"`
+	PushedAuthorizationRequestEndpoint         string            `json:"pushed_authorization_request_endpoint"`
+	RegistrationEndpoint                       string            `json:"registration_endpoint,omitempty"`
+	RequirePushedAuthorizationRequests         bool              `json:"require_pushed_authorization_requests"`
+	UserinfoEndpoint                           string            `json:"userinfo_endpoint"`
+	JWKSURI                                    string            `json:"jwks_uri"`
+	ScopesSupported                            []string          `json:"scopes_supported"`
+	ResponseTypesSupported                     []string          `json:"response_types_supported"`
+	ResponseModesSupported                     []string          `json:"response_modes_supported"`
+	GrantTypesSupported                        []string          `json:"grant_types_supported"`
+	SubjectTypesSupported                      []string          `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported           []string          `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported          []string          `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgValuesSupported []string          `json:"token_endpoint_auth_signing_alg_values_supported"`
+	RevocationEndpointAuthMethodsSupported     []string          `json:"revocation_endpoint_auth_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported  []string          `json:"introspection_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported              []string          `json:"code_challenge_methods_supported,omitempty"`
+	ClaimsSupported                            []string          `json:"claims_supported"`
+	RequestParameterSupported                  bool              `json:"request_parameter_supported"`
+	RequestURIParameterSupported               bool              `json:"request_uri_parameter_supported"`
+	RequireRequestURIRegistration              bool              `json:"require_request_uri_registration"`
+	RequestObjectSigningAlgValuesSupported     []string          `json:"request_object_signing_alg_values_supported"`
+	RequestObjectEncryptionAlgValuesSupported  []string          `json:"request_object_encryption_alg_values_supported"`
+	RequestObjectEncryptionEncValuesSupported  []string          `json:"request_object_encryption_enc_values_supported"`
+	DPoPSigningAlgValuesSupported              []string          `json:"dpop_signing_alg_values_supported"`
+	TLSClientCertificateBoundAccessTokens      bool              `json:"tls_client_certificate_bound_access_tokens"`
+	MTLSEndpointAliases                        map[string]string `json:"mtls_endpoint_aliases,omitempty"`
+	EndSessionEndpoint                         string            `json:"end_session_endpoint"`
+	FrontChannelLogoutSupported                bool              `json:"frontchannel_logout_supported"`
+	FrontChannelLogoutSessionSupported         bool              `json:"frontchannel_logout_session_supported"`
+	BackChannelLogoutSupported                 bool              `json:"backchannel_logout_supported"`
+	BackChannelLogoutSessionSupported          bool              `json:"backchannel_logout_session_supported"`
+	BackchannelAuthenticationEndpoint          string            `json:"backchannel_authentication_endpoint"`
+	BackchannelTokenDeliveryModesSupported     []string          `json:"backchannel_token_delivery_modes_supported"`
+	BackchannelUserCodeParameterSupported      bool              `json:"backchannel_user_code_parameter_supported"`
+	IntrospectionSigningAlgValuesSupported     []string          `json:"introspection_signing_alg_values_supported"`
+	IntrospectionEncryptionAlgValuesSupported  []string          `json:"introspection_encryption_alg_values_supported"`
+	IntrospectionEncryptionEncValuesSupported  []string          `json:"introspection_encryption_enc_values_supported"`
+
+	IntrospectionEndpointAuthSigningAlgValuesSupported []string `json:"introspection_endpoint_auth_signing_alg_values_supported"`
+}
+
+// grantTypeCandidates are the grant types fosite ships handlers for, plus the ones added by this server. Only the ones a registered token endpoint
+// handler claims are advertised.
+var grantTypeCandidates = []string{
+	"authorization_code",
+	"refresh_token",
+	"client_credentials",
+	"password",
+	jwtBearerGrantType,
+	deviceCodeGrantType,
+	tokenExchangeGrantType,
+	cibaGrantType,
+}
+
+// tokenEndpointAuthMethods are the client authentication methods fosite's default client authentication strategy
+// understands, plus the JWT assertion and mutual TLS methods added by authenticateClient. All endpoints that
+// authenticate clients accept them.
+var tokenEndpointAuthMethods = []string{"client_secret_basic", "client_secret_post", privateKeyJWT, clientSecretJWT, "none", tlsClientAuth, selfSignedTLSClientAuth}
+
+// introspectionEndpointAuthMethods are how resource servers authenticate at the introspection endpoint, see
+// authenticateResourceServer. Clients can not introspect tokens.
+var introspectionEndpointAuthMethods = []string{"client_secret_basic", "client_secret_post", privateKeyJWT}
+
+// claimsSupported lists the ID token claims and the standard claims the UserInfo endpoint returns.
+var claimsSupported = []string{
+	"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "c_hash", "sid",
+	"name", "given_name", "family_name", "middle_name", "nickname", "preferred_username", "profile", "picture",
+	"website", "gender", "birthdate", "zoneinfo", "locale", "updated_at",
+	"email", "email_verified", "address", "phone_number", "phone_number_verified",
+}
+
+func discoveryEndpoint(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	rw.Header().Set("Cache-Control", "public, max-age=3600")
+	if err := json.NewEncoder(rw).Encode(newDiscoveryDocument(req.Context())); err != nil {
+		log.Printf("Error occurred in discoveryEndpoint: %+v", err)
+	}
+}
+
+// newDiscoveryDocument builds the metadata from the handlers `compose.ComposeAllEnabled` registered on the config,
+// so the document never advertises something the server would reject.
+func newDiscoveryDocument(ctx context.Context) *discoveryDocument {
+	doc := &discoveryDocument{
+		Issuer:                                     issuer,
+		AuthorizationEndpoint:                      issuer + "/oauth2/auth",
+		TokenEndpoint:                              issuer + "/oauth2/token",
+		RevocationEndpoint:                         issuer + "/oauth2/revoke",
+		IntrospectionEndpoint:                      issuer + "/oauth2/introspect",
+		DeviceAuthorizationEndpoint:                issuer + "/oauth2/device/auth",
+		PushedAuthorizationRequestEndpoint:         issuer + "/oauth2/par",
+		RequirePushedAuthorizationRequests:         config.EnforcePushedAuthorize(ctx),
+		UserinfoEndpoint:                           issuer + "/userinfo",
+		JWKSURI:                                    issuer + "/.well-known/jwks.json",
+		ScopesSupported:                            []string{"openid", "offline", "offline_access", "profile", "email", "address", "phone"},
+		ResponseModesSupported:                     []string{"query", "fragment", "form_post"},
+		SubjectTypesSupported:                      []string{"public"},
+		IDTokenSigningAlgValuesSupported:           []string{"RS256"},
+		TokenEndpointAuthMethodsSupported:          tokenEndpointAuthMethods,
+		TokenEndpointAuthSigningAlgValuesSupported: append(append([]string{}, requestObjectSigningAlgs...), clientSecretJWTAlgs...),
+		RevocationEndpointAuthMethodsSupported:     tokenEndpointAuthMethods,
+		IntrospectionEndpointAuthMethodsSupported:  introspectionEndpointAuthMethods,
+		ClaimsSupported:                            claimsSupported,
+		RequestParameterSupported:                  true,
+		RequestURIParameterSupported:               true,
+		RequireRequestURIRegistration:              true,
+		RequestObjectSigningAlgValuesSupported:     requestObjectSigningAlgs,
+		RequestObjectEncryptionAlgValuesSupported:  requestObjectEncryptionAlgs,
+		RequestObjectEncryptionEncValuesSupported:  requestObjectEncryptionEncs,
+		DPoPSigningAlgValuesSupported:              dpop.SigningAlgs,
+		TLSClientCertificateBoundAccessTokens:      true,
+		EndSessionEndpoint:                         issuer + "/oauth2/logout",
+		FrontChannelLogoutSupported:                true,
+		FrontChannelLogoutSessionSupported:         true,
+		BackChannelLogoutSupported:                 true,
+		BackChannelLogoutSessionSupported:          true,
+		BackchannelAuthenticationEndpoint:          issuer + "/oauth2/bc-authorize",
+		BackchannelTokenDeliveryModesSupported:     []string{cibaPollMode, cibaPingMode, cibaPushMode},
+		BackchannelUserCodeParameterSupported:      false,
+		IntrospectionSigningAlgValuesSupported:     introspectionSigningAlgs,
+		IntrospectionEncryptionAlgValuesSupported:  introspectionEncryptionAlgs,
+		IntrospectionEncryptionEncValuesSupported:  introspectionEncryptionEncs,
+	}
+
+	// Clients can only register themselves with an initial access token, without any the endpoint is off.
+	if len(initialAccessTokens) > 0 {
+		doc.RegistrationEndpoint = issuer + "/oauth2/register"
+	}
+
+	// Resource servers sign their client assertions like clients do with `private_key_jwt`.
+	doc.IntrospectionEndpointAuthSigningAlgValuesSupported = requestObjectSigningAlgs
+
+	// Requests that need a client certificate go to the mutual TLS listener, see section 5 of RFC 8705.
+	if mtlsURL != "" {
+		doc.MTLSEndpointAliases = map[string]string{
+			"token_endpoint":                        mtlsURL + "/oauth2/token",
+			"revocation_endpoint":                   mtlsURL + "/oauth2/revoke",
+			"device_authorization_endpoint":         mtlsURL + "/oauth2/device/auth",
+			"backchannel_authentication_endpoint":   mtlsURL + "/oauth2/bc-authorize",
+			"pushed_authorization_request_endpoint": mtlsURL + "/oauth2/par",
+			"userinfo_endpoint":                     mtlsURL + "/userinfo",
+		}
+	}
+
+	for _, grantType := range grantTypeCandidates {
+		probe := fosite.NewAccessRequest(newSession(""))
+		probe.GrantTypes = fosite.Arguments{grantType}
+		for _, handler := range config.GetTokenEndpointHandlers(ctx) {
+			if handler.CanHandleTokenEndpointRequest(ctx, probe) {
+				doc.GrantTypesSupported = append(doc.GrantTypesSupported, grantType)
+				break
+			}
+		}
+	}
+
+	for _, handler := range config.GetAuthorizeEndpointHandlers(ctx) {
+		switch handler.(type) {
+		case *fositeoauth2.AuthorizeExplicitGrantHandler:
+			doc.ResponseTypesSupported = append(doc.ResponseTypesSupported, "code")
+		case *fositeoauth2.AuthorizeImplicitGrantTypeHandler:
+			doc.ResponseTypesSupported = append(doc.ResponseTypesSupported, "token")
+			doc.GrantTypesSupported = append(doc.GrantTypesSupported, "implicit")
+		case *openid.OpenIDConnectImplicitHandler:
+			doc.ResponseTypesSupported = append(doc.ResponseTypesSupported, "id_token", "id_token token")
+		case *openid.OpenIDConnectHybridHandler:
+			doc.ResponseTypesSupported = append(doc.ResponseTypesSupported, "code id_token", "code token", "code id_token token")
+		case *pkce.Handler:
+			doc.CodeChallengeMethodsSupported = append(doc.CodeChallengeMethodsSupported, "S256")
+			if config.GetEnablePKCEPlainChallengeMethod(ctx) {
+				doc.CodeChallengeMethodsSupported = append(doc.CodeChallengeMethodsSupported, "plain")
+			}
+		}
+	}
+
+	return doc
+}
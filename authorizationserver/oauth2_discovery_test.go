@@ -0,0 +1,70 @@
+package authorizationserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ory/fosite"
+)
+
+func TestDiscoveryEndpoint(t *testing.T) {
+	for _, path := range []string{"/.well-known/openid-configuration", "/.well-known/oauth-authorization-server"} {
+		t.Run(path, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			discoveryEndpoint(rw, httptest.NewRequest(http.MethodGet, path, nil))
+			if rw.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rw.Code, rw.Body)
+			}
+
+			var doc struct {
+				Issuer                        string           `json:"issuer"`
+				AuthorizationEndpoint         string           `json:"authorization_endpoint"`
+				TokenEndpoint                 string           `json:"token_endpoint"`
+				RevocationEndpoint            string           `json:"revocation_endpoint"`
+				IntrospectionEndpoint         string           `json:"introspection_endpoint"`
+				ResponseTypesSupported        fosite.Arguments `json:"response_types_supported"`
+				GrantTypesSupported           fosite.Arguments `json:"grant_types_supported"`
+				CodeChallengeMethodsSupported fosite.Arguments `json:"code_challenge_methods_supported"`
+				SubjectTypesSupported         fosite.Arguments `json:"subject_types_supported"`
+				IDTokenSigningAlgs            fosite.Arguments `json:"id_token_signing_alg_values_supported"`
+			}
+			if err := json.Unmarshal(rw.Body.Bytes(), &doc); err != nil {
+				t.Fatalf("decoding the discovery document: %v", err)
+			}
+
+			for _, endpoint := range []struct{ name, got, want string }{
+				{"issuer", doc.Issuer, issuer},
+				{"authorization_endpoint", doc.AuthorizationEndpoint, issuer + "/oauth2/auth"},
+				{"token_endpoint", doc.TokenEndpoint, issuer + "/oauth2/token"},
+				{"revocation_endpoint", doc.RevocationEndpoint, issuer + "/oauth2/revoke"},
+				{"introspection_endpoint", doc.IntrospectionEndpoint, issuer + "/oauth2/introspect"},
+			} {
+				if endpoint.got != endpoint.want {
+					t.Errorf("%s = %q, want %q", endpoint.name, endpoint.got, endpoint.want)
+				}
+			}
+
+			// The document is derived from the registered handlers, so what fosite supports must show up.
+			if !doc.ResponseTypesSupported.Has("code", "id_token", "code id_token") {
+				t.Errorf("response_types_supported = %v", doc.ResponseTypesSupported)
+			}
+			if !doc.GrantTypesSupported.Has("authorization_code", "refresh_token", "client_credentials") {
+				t.Errorf("grant_types_supported = %v", doc.GrantTypesSupported)
+			}
+			if !doc.CodeChallengeMethodsSupported.Has("S256") {
+				t.Errorf("code_challenge_methods_supported = %v", doc.CodeChallengeMethodsSupported)
+			}
+			if !doc.SubjectTypesSupported.Has("public") || !doc.IDTokenSigningAlgs.Has("RS256") {
+				t.Errorf("subject_types_supported = %v, id_token_signing_alg_values_supported = %v", doc.SubjectTypesSupported, doc.IDTokenSigningAlgs)
+			}
+		})
+	}
+
+	rw := httptest.NewRecorder()
+	discoveryEndpoint(rw, httptest.NewRequest(http.MethodPost, "/.well-known/openid-configuration", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST got status %d, want %d", rw.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,161 @@
+package authorizationserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/dpop"
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+const (
+	// dpopProofWindow is how far the `iat` claim of a proof may be from now. It covers clock skew and the time a
+	// request takes, proofs are made right before the request they are sent with.
+	dpopProofWindow = time.Minute
+
+	// dpopNonceLifespan is how long a nonce handed out by this server is accepted.
+	dpopNonceLifespan = time.Minute * 5
+)
+
+// The errors of section 12.2 of RFC 9449, fosite does not define them.
+var (
+	errInvalidDPoPProof = &fosite.RFC6749Error{
+		ErrorField:       "invalid_dpop_proof",
+		DescriptionField: "The DPoP proof is invalid.",
+		CodeField:        http.StatusBadRequest,
+	}
+	errUseDPoPNonce = &fosite.RFC6749Error{
+		ErrorField:       "use_dpop_nonce",
+		DescriptionField: "The DPoP proof must contain the nonce of the DPoP-Nonce header.",
+		CodeField:        http.StatusBadRequest,
+	}
+)
+
+// dpopNonce is the content of the nonces this server hands out. They are signed instead of stored, so any nonce that
+// has not expired yet is accepted.
+type dpopNonce struct {
+	ExpiresAt int64 `json:"exp"`
+}
+
+// checkDPoPProof checks the DPoP proof of a request to the token endpoint or the UserInfo endpoint and returns it, or
+// nil if the request carries none. accessToken is the token the request is authorized with, if any.
+//
+// Every proof must carry a nonce of this server and can be used once. A fresh nonce is set on the response to every
+// request with a proof, so the client can always make its next proof right away.
+func checkDPoPProof(rw http.ResponseWriter, req *http.Request, accessToken string) (*dpop.Proof, error) {
+	ctx := req.Context()
+
+	headers := req.Header.Values(dpop.HeaderName)
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	nonce, err := newDPoPNonce()
+	if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+	rw.Header().Set(dpop.NonceHeaderName, nonce)
+
+	if len(headers) > 1 {
+		return nil, errInvalidDPoPProof.WithHint("The request must carry exactly one DPoP proof.")
+	}
+
+	proof, err := dpop.Parse(headers[0])
+	if err != nil {
+		return nil, errInvalidDPoPProof.WithWrap(err).WithDebug(err.Error())
+	}
+	if err := proof.Validate(req.Method, requestURL(req), accessToken, dpopProofWindow); err != nil {
+		return nil, errInvalidDPoPProof.WithWrap(err).WithDebug(err.Error())
+	}
+	if err := verifyDPoPNonce(proof.Nonce); err != nil {
+		return nil, errUseDPoPNonce.WithWrap(err).WithDebug(err.Error())
+	}
+
+	// Proofs are only accepted within the window, so their IDs only need to be remembered that long.
+	jti := "dpop:" + proof.Thumbprint + ":" + proof.JTI
+	expiresAt := time.Unix(proof.IssuedAt, 0).Add(dpopProofWindow)
+	if err := store.SetClientAssertionJWT(ctx, jti, expiresAt); errors.Is(err, fosite.ErrJTIKnown) {
+		return nil, errInvalidDPoPProof.WithHint("The DPoP proof has been used before.")
+	} else if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	return proof, nil
+}
+
+// bindDPoPProof binds the access token about to be issued for the request to the key of the proof, by adding the
+// `cnf` claim of section 6 of RFC 9449 to the session. Without a proof, the token is a bearer token, unless the client
+// must use DPoP.
+//
+// Refresh tokens of public clients are bound to the key as well: such a client must refresh with a proof of the key
+// it obtained the refresh token with. Confidential clients authenticate anyway and may switch keys.
+func bindDPoPProof(ar fosite.AccessRequester, proof *dpop.Proof) error {
+	session, ok := ar.GetSession().(*Session)
+	if !ok {
+		return fosite.ErrServerError.WithDebug("The session of the token endpoint must be a *Session.")
+	}
+
+	client := ar.GetClient()
+	if ar.GetGrantTypes().ExactOne("refresh_token") && client.IsPublic() {
+		if bound := session.confirmation("jkt"); bound != "" && (proof == nil || proof.Thumbprint != bound) {
+			return errInvalidDPoPProof.WithHint("The refresh token is bound to a DPoP key, the proof must be made with it.")
+		}
+	}
+
+	if proof == nil {
+		if c, ok := client.(*sqlstore.Client); ok && c.DPoPBoundAccessTokens {
+			return errInvalidDPoPProof.WithHint("The OAuth 2.0 Client must send a DPoP proof.")
+		}
+		session.setConfirmation("jkt", "")
+		return nil
+	}
+
+	session.setConfirmation("jkt", proof.Thumbprint)
+	return nil
+}
+
+// accessTokenFromRequest returns the access token of the `Authorization` header and its scheme, either Bearer or
+// DPoP. Like fosite.AccessTokenFromRequest, it falls back to the `access_token` form parameter for bearer tokens.
+func accessTokenFromRequest(req *http.Request) (scheme string, token string) {
+	if s, t, ok := strings.Cut(req.Header.Get("Authorization"), " "); ok {
+		switch {
+		case strings.EqualFold(s, "Bearer"):
+			return "Bearer", t
+		case strings.EqualFold(s, dpop.Scheme):
+			return dpop.Scheme, t
+		}
+	}
+	return "Bearer", fosite.AccessTokenFromRequest(req)
+}
+
+// writeDPoPError writes an error response for a protected resource requested with a DPoP-bound access token, see
+// section 7.1 of RFC 9449.
+func writeDPoPError(rw http.ResponseWriter, status int, code string, description string) {
+	rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`%s algs="%s", error="%s", error_description="%s"`,
+		dpop.Scheme, strings.Join(dpop.SigningAlgs, " "), code, description))
+	rw.WriteHeader(status)
+}
+
+func newDPoPNonce() (string, error) {
+	return signValue("dpop-nonce", dpopNonce{ExpiresAt: time.Now().Add(dpopNonceLifespan).Unix()})
+}
+
+func verifyDPoPNonce(nonce string) error {
+	if nonce == "" {
+		return errors.New("the proof carries no nonce")
+	}
+
+	var n dpopNonce
+	if err := verifyValue("dpop-nonce", nonce, &n); err != nil {
+		return err
+	}
+	if time.Now().After(time.Unix(n.ExpiresAt, 0)) {
+		return errors.New("the nonce has expired")
+	}
+	return nil
+}
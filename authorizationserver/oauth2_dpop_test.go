@@ -0,0 +1,190 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/dpop"
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// newTestDPoPKey returns a key for DPoP proofs and its thumbprint.
+func newTestDPoPKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	jkt, err := dpop.Thumbprint(&jose.JSONWebKey{Key: &key.PublicKey})
+	if err != nil {
+		t.Fatalf("Thumbprint: %v", err)
+	}
+	return key, jkt
+}
+
+// signTestProof signs a DPoP proof for a request to the token endpoint with the key. The claims can be changed
+// before they are signed.
+func signTestProof(t *testing.T, key *ecdsa.PrivateKey, nonce string, change func(p *dpop.Proof)) string {
+	t.Helper()
+
+	claims := dpop.Proof{JTI: uuid.New().String(), Method: http.MethodPost, URL: issuer + "/oauth2/token", IssuedAt: time.Now().Unix(), Nonce: nonce}
+	if change != nil {
+		change(&claims)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{EmbedJWK: true}).WithType("dpop+jwt"))
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	proof, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatalf("CompactSerialize: %v", err)
+	}
+	return proof
+}
+
+// dpopTokenRequest asks for a token with the client credentials of "dpop-client", sending the proofs.
+func dpopTokenRequest(proofs ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("dpop-client", "foobar")
+	for _, proof := range proofs {
+		req.Header.Add(dpop.HeaderName, proof)
+	}
+	rw := httptest.NewRecorder()
+	tokenEndpoint(rw, req)
+	return rw
+}
+
+func TestTokenEndpointDPoP(t *testing.T) {
+	ctx := context.Background()
+	client := newRefreshClient("dpop-client")
+	client.GrantTypes = []string{"client_credentials"}
+	s := useTestStore(t, client)
+	key, jkt := newTestDPoPKey(t)
+
+	// The first proof has no nonce yet, the server hands one out.
+	rw := dpopTokenRequest(signTestProof(t, key, "", nil))
+	wantTokenError(t, rw, "use_dpop_nonce")
+	nonce := rw.Header().Get(dpop.NonceHeaderName)
+	if nonce == "" {
+		t.Fatal("the server did not send a nonce")
+	}
+
+	proof := signTestProof(t, key, nonce, nil)
+	rw = dpopTokenRequest(proof)
+	var response struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("the token request with the nonce returned %d: %s", rw.Code, rw.Body)
+	}
+	if response.TokenType != dpop.TokenType {
+		t.Errorf("got token_type %q, want %q", response.TokenType, dpop.TokenType)
+	}
+	stored, err := s.GetAccessTokenSession(ctx, testTokenStrategy.AccessTokenSignature(ctx, response.AccessToken), newSession(""))
+	if err != nil {
+		t.Fatalf("GetAccessTokenSession: %v", err)
+	}
+	if got := stored.GetSession().(*Session).confirmation("jkt"); got != jkt {
+		t.Errorf("the access token is bound to %q, want %q", got, jkt)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		proofs []string
+		code   string
+	}{
+		{name: "replayed", proofs: []string{proof}, code: "invalid_dpop_proof"},
+		{name: "two proofs", proofs: []string{signTestProof(t, key, nonce, nil), signTestProof(t, key, nonce, nil)}, code: "invalid_dpop_proof"},
+		{name: "other method", proofs: []string{signTestProof(t, key, nonce, func(p *dpop.Proof) { p.Method = http.MethodGet })}, code: "invalid_dpop_proof"},
+		{name: "other URL", proofs: []string{signTestProof(t, key, nonce, func(p *dpop.Proof) { p.URL = issuer + "/userinfo" })}, code: "invalid_dpop_proof"},
+		{name: "stale", proofs: []string{signTestProof(t, key, nonce, func(p *dpop.Proof) { p.IssuedAt = time.Now().Add(-2 * dpopProofWindow).Unix() })}, code: "invalid_dpop_proof"},
+		{name: "forged nonce", proofs: []string{signTestProof(t, key, "forged", nil)}, code: "use_dpop_nonce"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			wantTokenError(t, dpopTokenRequest(tc.proofs...), tc.code)
+		})
+	}
+
+	// Without a proof, the client gets a bearer token, unless it must use DPoP.
+	rw = dpopTokenRequest()
+	if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil || !strings.EqualFold(response.TokenType, "bearer") {
+		t.Errorf("the token request without a proof returned %d: %s", rw.Code, rw.Body)
+	}
+	client.DPoPBoundAccessTokens = true
+	if err := s.UpdateClient(ctx, client); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	wantTokenError(t, dpopTokenRequest(), "invalid_dpop_proof")
+}
+
+func TestBindDPoPProof(t *testing.T) {
+	_, boundKey := newTestDPoPKey(t)
+	_, otherKey := newTestDPoPKey(t)
+
+	for _, tc := range []struct {
+		name   string
+		public bool
+		bound  string
+		proof  string
+		valid  bool
+		want   string
+	}{
+		{name: "public client with the bound key", public: true, bound: boundKey, proof: boundKey, valid: true, want: boundKey},
+		{name: "public client with another key", public: true, bound: boundKey, proof: otherKey},
+		{name: "public client without proof", public: true, bound: boundKey},
+		{name: "public client with an unbound token", public: true, proof: otherKey, valid: true, want: otherKey},
+		{name: "confidential client with another key", bound: boundKey, proof: otherKey, valid: true, want: otherKey},
+		{name: "confidential client without proof", bound: boundKey, valid: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := sqlstore.NewClient("some-client")
+			client.Public = tc.public
+			session := newSession("peter")
+			session.setConfirmation("jkt", tc.bound)
+			ar := fosite.NewAccessRequest(session)
+			ar.Client = client
+			ar.GrantTypes = fosite.Arguments{"refresh_token"}
+
+			var proof *dpop.Proof
+			if tc.proof != "" {
+				proof = &dpop.Proof{Thumbprint: tc.proof}
+			}
+			err := bindDPoPProof(ar, proof)
+			if !tc.valid {
+				if err == nil {
+					t.Error("bindDPoPProof() accepted the proof")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("bindDPoPProof() = %v", err)
+			}
+			if got := session.confirmation("jkt"); got != tc.want {
+				t.Errorf("the new tokens are bound to %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
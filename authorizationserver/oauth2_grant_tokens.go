@@ -0,0 +1,108 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"github.com/ory/fosite"
+	fositeoauth2 "github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/handler/openid"
+)
+
+// grantTokenIssuer issues the tokens of the grants this server adds on behalf of a user, the device code grant and
+// CIBA, just like the authorize code grant issues them.
+type grantTokenIssuer struct {
+	Store           *exampleStore
+	Strategy        fositeoauth2.CoreStrategy
+	IDTokenStrategy openid.OpenIDConnectTokenStrategy
+	Config          fosite.Configurator
+}
+
+func newGrantTokenIssuer(config fosite.Configurator, storage interface{}, strategy interface{}) grantTokenIssuer {
+	return grantTokenIssuer{
+		Store:           storage.(*exampleStore),
+		Strategy:        strategy.(fositeoauth2.CoreStrategy),
+		IDTokenStrategy: strategy.(openid.OpenIDConnectTokenStrategy),
+		Config:          config,
+	}
+}
+
+// setLifespans sets when the access and refresh token of the grant expire.
+func (t *grantTokenIssuer) setLifespans(ctx context.Context, grantType string, requester fosite.AccessRequester) {
+	client := requester.GetClient()
+
+	atLifespan := fosite.GetEffectiveLifespan(client, fosite.GrantType(grantType), fosite.AccessToken, t.Config.GetAccessTokenLifespan(ctx))
+	requester.GetSession().SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(atLifespan).Round(time.Second))
+
+	rtLifespan := fosite.GetEffectiveLifespan(client, fosite.GrantType(grantType), fosite.RefreshToken, t.Config.GetRefreshTokenLifespan(ctx))
+	if rtLifespan > -1 {
+		requester.GetSession().SetExpiresAt(fosite.RefreshToken, time.Now().UTC().Add(rtLifespan).Round(time.Second))
+	}
+}
+
+// issueTokens issues an access token, a refresh token if the user granted one of the refresh token scopes and an ID
+// token if the user granted the `openid` scope. idTokenClaims may add claims of the grant to the ID token, it is
+// passed the refresh token, which is empty if none was issued.
+func (t *grantTokenIssuer) issueTokens(ctx context.Context, grantType string, requester fosite.AccessRequester, responder fosite.AccessResponder, idTokenClaims func(refresh string) map[string]interface{}) error {
+	access, accessSignature, err := t.Strategy.GenerateAccessToken(ctx, requester)
+	if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+	if err := t.Store.CreateAccessTokenSession(ctx, accessSignature, requester.Sanitize([]string{})); err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	responder.SetAccessToken(access)
+	responder.SetTokenType("bearer")
+	responder.SetExpiresIn(time.Until(requester.GetSession().GetExpiresAt(fosite.AccessToken)).Round(time.Second))
+	responder.SetScopes(requester.GetGrantedScopes())
+
+	// Issue a refresh token if the user granted one of the refresh token scopes, like the authorize code grant does.
+	var refresh string
+	if scopes := t.Config.GetRefreshTokenScopes(ctx); (len(scopes) == 0 || requester.GetGrantedScopes().HasOneOf(scopes...)) &&
+		requester.GetClient().GetGrantTypes().Has("refresh_token") {
+		var refreshSignature string
+		refresh, refreshSignature, err = t.Strategy.GenerateRefreshToken(ctx, requester)
+		if err != nil {
+			return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+		}
+		if err := t.Store.CreateRefreshTokenSession(ctx, refreshSignature, accessSignature, requester.Sanitize([]string{})); err != nil {
+			return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+		}
+		responder.SetExtra("refresh_token", refresh)
+	}
+
+	if requester.GetGrantedScopes().Has("openid") {
+		// The tokens are stored, the claims added now only end up in this ID token.
+		if session, ok := requester.GetSession().(openid.Session); ok {
+			claims := session.IDTokenClaims()
+			if !fosite.Arguments(claims.Audience).Has(requester.GetClient().GetID()) {
+				claims.Audience = append(claims.Audience, requester.GetClient().GetID())
+			}
+			claims.AccessTokenHash = tokenHash(access)
+			if idTokenClaims != nil {
+				for key, value := range idTokenClaims(refresh) {
+					claims.Add(key, value)
+				}
+			}
+		}
+
+		lifespan := fosite.GetEffectiveLifespan(requester.GetClient(), fosite.GrantType(grantType), fosite.IDToken, t.Config.GetIDTokenLifespan(ctx))
+		idToken, err := t.IDTokenStrategy.GenerateIDToken(ctx, lifespan, requester)
+		if err != nil {
+			return err
+		}
+		responder.SetExtra("id_token", idToken)
+	}
+
+	return nil
+}
+
+// tokenHash is the hash of a token as ID tokens carry it, e.g. as `at_hash`: the left half of its SHA-256 hash, as ID
+// tokens are signed with RS256.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
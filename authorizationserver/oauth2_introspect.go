@@ -1,19 +1,234 @@
 package authorizationserver
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/dpop"
 )
 
 func introspectionEndpoint(rw http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	mySessionData := newSession("")
-	ir, err := oauth2.NewIntrospectionRequest(ctx, req, mySessionData)
-	if err != nil {
+
+	server, ir, err := newResourceServerIntrospectionRequest(ctx, req, mySessionData)
+
+	// Callers asking for a JWT get inactive tokens reported as a JWT as well, errors are always plain JSON.
+	wantsJWT := acceptsIntrospectionJWT(req)
+	if err != nil && (!wantsJWT || !errors.Is(err, fosite.ErrInactiveToken)) {
 		log.Printf("Error occurred in NewIntrospectionRequest: %+v", err)
 		oauth2.WriteIntrospectionError(ctx, rw, err)
 		return
 	}
+	if !wantsJWT {
+		writeIntrospectionResponse(ctx, rw, ir)
+		return
+	}
+
+	if err := writeIntrospectionJWT(ctx, rw, server, ir); err != nil {
+		log.Printf("Error occurred in writeIntrospectionJWT: %+v", err)
+		oauth2.WriteIntrospectionError(ctx, rw, err)
+	}
+}
+
+// newResourceServerIntrospectionRequest is fosite.Fosite.NewIntrospectionRequest for resource servers: only resource
+// servers allowed to introspect may call it, clients may not. Tokens whose audience does not include the resource
+// server are reported as inactive, so it learns nothing about tokens meant for others. The resource server is
+// returned as well.
+func newResourceServerIntrospectionRequest(ctx context.Context, req *http.Request, session fosite.Session) (*ResourceServer, fosite.IntrospectionResponder, error) {
+	if req.Method != http.MethodPost {
+		return nil, &fosite.IntrospectionResponse{Active: false}, fosite.ErrInvalidRequest.WithHintf("HTTP method is '%s' but expected 'POST'.", req.Method)
+	} else if err := req.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		return nil, &fosite.IntrospectionResponse{Active: false}, fosite.ErrInvalidRequest.WithHint("Unable to parse HTTP body, make sure to send a properly formatted form request body.").WithWrap(err).WithDebug(err.Error())
+	} else if len(req.PostForm) == 0 {
+		return nil, &fosite.IntrospectionResponse{Active: false}, fosite.ErrInvalidRequest.WithHint("The POST body can not be empty.")
+	}
+
+	// Any other error would be written as an inactive token.
+	server, err := authenticateResourceServer(ctx, req)
+	if err != nil {
+		return nil, &fosite.IntrospectionResponse{Active: false}, err
+	}
+	if !server.AllowIntrospection {
+		return nil, &fosite.IntrospectionResponse{Active: false}, fosite.ErrRequestUnauthorized.WithHint("The resource server is not allowed to introspect tokens.")
+	}
+
+	scopes := fosite.RemoveEmpty(strings.Split(req.PostForm.Get("scope"), " "))
+	tokenUse, ar, err := oauth2.IntrospectToken(ctx, req.PostForm.Get("token"), fosite.TokenUse(req.PostForm.Get("token_type_hint")), session, scopes...)
+	if err != nil {
+		return server, &fosite.IntrospectionResponse{Active: false}, fosite.ErrInactiveToken.WithHint("An introspection strategy indicated that the token is inactive.").WithWrap(err).WithDebug(err.Error())
+	}
+	if !ar.GetGrantedAudience().Has(server.URI) {
+		return server, &fosite.IntrospectionResponse{Active: false}, fosite.ErrInactiveToken.WithHint("The token is not meant for the resource server.")
+	}
+
+	// Tokens bound to a DPoP key are DPoP tokens, see section 6.2 of RFC 9449.
+	ir := &fosite.IntrospectionResponse{Active: true, AccessRequester: ar, TokenUse: tokenUse}
+	if tokenUse == fosite.AccessToken {
+		ir.AccessTokenType = fosite.BearerAccessToken
+		if stored, ok := ar.GetSession().(*Session); ok && stored.confirmation("jkt") != "" {
+			ir.AccessTokenType = dpop.TokenType
+		}
+	}
+	return server, ir, nil
+}
+
+// writeIntrospectionResponse writes the introspection response as JSON.
+func writeIntrospectionResponse(ctx context.Context, rw http.ResponseWriter, ir fosite.IntrospectionResponder) {
+	response, err := introspectionResponse(ctx, ir)
+	if err != nil {
+		log.Printf("Error occurred in introspectionResponse: %+v", err)
+		oauth2.WriteIntrospectionError(ctx, rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Pragma", "no-cache")
+	if err := json.NewEncoder(rw).Encode(response); err != nil {
+		log.Printf("Error occurred in writeIntrospectionResponse: %+v", err)
+	}
+}
+
+// introspectionResponse returns the members of the response fosite writes for the introspected token, plus the
+// `token_type` of access tokens, which fosite leaves out.
+func introspectionResponse(ctx context.Context, ir fosite.IntrospectionResponder) (map[string]interface{}, error) {
+	recorder := httptest.NewRecorder()
+	oauth2.WriteIntrospectionResponse(ctx, recorder, ir)
+	decoder := json.NewDecoder(recorder.Body)
+	decoder.UseNumber()
+	var response map[string]interface{}
+	if err := decoder.Decode(&response); err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+	// Timestamps like `exp` stay integers, they would be signed as floats otherwise.
+	for name, value := range response {
+		if number, ok := value.(json.Number); ok {
+			if n, err := number.Int64(); err == nil {
+				response[name] = n
+			} else if f, err := number.Float64(); err == nil {
+				response[name] = f
+			}
+		}
+	}
 
-	oauth2.WriteIntrospectionResponse(ctx, rw, ir)
+	if ir.IsActive() && ir.GetAccessTokenType() != "" {
+		response["token_type"] = ir.GetAccessTokenType()
+	}
+	return response, nil
+}
+
+// authenticateResourceServer authenticates a resource server by the ID and secret it sends with basic auth, encoded
+// like client credentials, or as `client_id` and `client_secret` in the body. Resource servers with keys may send a
+// `private_key_jwt` client assertion instead.
+func authenticateResourceServer(ctx context.Context, req *http.Request) (*ResourceServer, error) {
+	if assertionType := req.PostForm.Get("client_assertion_type"); assertionType == clientAssertionJWTBearerType {
+		return authenticateResourceServerAssertion(ctx, req)
+	} else if assertionType != "" {
+		return nil, fosite.ErrInvalidRequest.WithHintf("Unknown client_assertion_type '%s'.", assertionType)
+	}
+
+	id, secret, ok := req.BasicAuth()
+	if ok {
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return nil, fosite.ErrInvalidRequest.WithHint("The resource server ID in the HTTP authorization header could not be decoded from 'application/x-www-form-urlencoded'.").WithWrap(err).WithDebug(err.Error())
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, fosite.ErrInvalidRequest.WithHint("The resource server secret in the HTTP authorization header could not be decoded from 'application/x-www-form-urlencoded'.").WithWrap(err).WithDebug(err.Error())
+		}
+	} else {
+		id, secret = req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+	}
+	if id == "" || secret == "" {
+		return nil, fosite.ErrRequestUnauthorized.WithHint("The resource server must authenticate with its ID and secret.")
+	}
+
+	server := resourceRegistry.byID(id)
+	if server == nil || server.SecretHash == "" {
+		return nil, fosite.ErrRequestUnauthorized.WithHint("The resource server credentials are invalid.")
+	}
+	if err := comparePassword(server.SecretHash, secret); err != nil {
+		return nil, fosite.ErrRequestUnauthorized.WithHint("The resource server credentials are invalid.").WithWrap(err).WithDebug(err.Error())
+	}
+	return server, nil
+}
+
+// authenticateResourceServerAssertion authenticates a resource server by a `private_key_jwt` client assertion signed
+// with one of its keys. The claims are checked like those of clients, see authenticateClientAssertion, and every
+// assertion can be used once.
+func authenticateResourceServerAssertion(ctx context.Context, req *http.Request) (*ResourceServer, error) {
+	assertion := req.PostForm.Get("client_assertion")
+	if assertion == "" {
+		return nil, fosite.ErrInvalidRequest.WithHintf("The client_assertion request parameter must be set when using client_assertion_type of '%s'.", clientAssertionJWTBearerType)
+	}
+
+	jws, err := jose.ParseSigned(assertion)
+	if err != nil {
+		return nil, fosite.ErrRequestUnauthorized.WithHint("Unable to parse the 'client_assertion'.").WithWrap(err).WithDebug(err.Error())
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, fosite.ErrRequestUnauthorized.WithHint("The 'client_assertion' must have exactly one signature.")
+	}
+	if alg := jws.Signatures[0].Header.Algorithm; !fosite.Arguments(requestObjectSigningAlgs).Has(alg) {
+		return nil, fosite.ErrRequestUnauthorized.WithHintf("The 'client_assertion' uses unsupported signing algorithm '%s'.", alg)
+	}
+
+	claims, err := decodeClaims(jws.UnsafePayloadWithoutVerification())
+	if err != nil {
+		return nil, fosite.ErrRequestUnauthorized.WithHint("Unable to decode the claims of the 'client_assertion'.").WithWrap(err).WithDebug(err.Error())
+	}
+	id, _ := claims["sub"].(string)
+	if id == "" {
+		return nil, fosite.ErrRequestUnauthorized.WithHint("The claim 'sub' from the 'client_assertion' is undefined.")
+	}
+	if formID := req.PostForm.Get("client_id"); formID != "" && formID != id {
+		return nil, fosite.ErrRequestUnauthorized.WithHint("The claim 'sub' from the 'client_assertion' must match the 'client_id' parameter.")
+	}
+
+	server := resourceRegistry.byID(id)
+	if server == nil || (server.JWKS == nil && server.JWKSURI == "") {
+		return nil, fosite.ErrRequestUnauthorized.WithHint("The resource server credentials are invalid.")
+	}
+	if err := verifyWithResourceServerKeys(ctx, server, jws); err != nil {
+		return nil, fosite.ErrRequestUnauthorized.WithHint("Unable to verify the integrity of the 'client_assertion' value.").WithWrap(err).WithDebug(err.Error())
+	}
+
+	// The claims are checked like those of client assertions, but failures must be reported as unauthorized, fosite
+	// writes other errors of the introspection endpoint as an inactive token.
+	if err := checkAssertionClaims(ctx, req, claims, id, "resource_server_assertion:"); err != nil {
+		rfcErr := fosite.ErrorToRFC6749Error(err)
+		if errors.Is(err, fosite.ErrServerError) {
+			return nil, rfcErr
+		}
+		return nil, fosite.ErrRequestUnauthorized.WithHint(rfcErr.HintField).WithWrap(err).WithDebug(err.Error())
+	}
+	return server, nil
+}
+
+// verifyWithResourceServerKeys checks the signature against the keys of the resource server. Keys behind a `jwks_uri`
+// are fetched again once if none of them fits, like those of clients.
+func verifyWithResourceServerKeys(ctx context.Context, server *ResourceServer, jws *jose.JSONWebSignature) error {
+	set, err := server.keys(ctx, false)
+	if err != nil {
+		return err
+	}
+	if _, err = verifyWithClientKeys(jws, set); err == nil || server.JWKSURI == "" {
+		return err
+	}
+
+	if set, err = server.keys(ctx, true); err != nil {
+		return err
+	}
+	_, err = verifyWithClientKeys(jws, set)
+	return err
 }
@@ -0,0 +1,132 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+)
+
+// introspectionJWTMediaType is what resource servers accept to get the introspection response as a signed JWT, see
+// section 4 of RFC 9701. Without it, they get plain JSON.
+const introspectionJWTMediaType = "application/token-introspection+jwt"
+
+// introspectionJWTType is the `typ` header of JWT introspection responses.
+const introspectionJWTType = "token-introspection+jwt"
+
+var (
+	// introspectionSigningAlgs are the algorithms JWT introspection responses are signed with, those of the server's
+	// keys.
+	introspectionSigningAlgs = []string{string(jose.RS256)}
+
+	// introspectionEncryptionAlgs and introspectionEncryptionEncs are the algorithms JWT introspection responses may be
+	// encrypted with, to an RSA or EC key of the resource server.
+	introspectionEncryptionAlgs = []string{
+		string(jose.RSA_OAEP), string(jose.RSA_OAEP_256),
+		string(jose.ECDH_ES), string(jose.ECDH_ES_A128KW), string(jose.ECDH_ES_A256KW),
+	}
+	introspectionEncryptionEncs = requestObjectEncryptionEncs
+)
+
+// acceptsIntrospectionJWT reports whether the caller asked for a JWT introspection response.
+func acceptsIntrospectionJWT(req *http.Request) bool {
+	for _, accept := range req.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			if parsed, _, err := mime.ParseMediaType(mediaType); err == nil && parsed == introspectionJWTMediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeIntrospectionJWT writes the introspection response as a JWT signed by the server, see section 5 of RFC 9701. It
+// carries the response writeIntrospectionResponse would have written in its `token_introspection` claim and names the resource
+// server as `aud`, so the resource server can keep it as proof that the token was active. If the resource server has
+// an `introspection_encrypted_response_alg`, the JWT is encrypted to its key as well.
+func writeIntrospectionJWT(ctx context.Context, rw http.ResponseWriter, server *ResourceServer, ir fosite.IntrospectionResponder) error {
+	response, err := introspectionResponse(ctx, ir)
+	if err != nil {
+		return err
+	}
+
+	claims := jwt.MapClaims{
+		"iss":                 issuer,
+		"aud":                 server.ID,
+		"iat":                 time.Now().UTC().Unix(),
+		"token_introspection": response,
+	}
+	header := jwt.NewHeaders()
+	header.Add("typ", introspectionJWTType)
+	token, _, err := signer.Generate(ctx, claims, header)
+	if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if server.IntrospectionEncryptedResponseAlg != "" {
+		if token, err = encryptIntrospectionJWT(ctx, server, token); err != nil {
+			return err
+		}
+	}
+
+	rw.Header().Set("Content-Type", introspectionJWTMediaType)
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Pragma", "no-cache")
+	_, err = rw.Write([]byte(token))
+	return err
+}
+
+// encryptIntrospectionJWT encrypts the signed response to the first key of the resource server that fits its
+// `introspection_encrypted_response_alg`, making it a nested JWT.
+func encryptIntrospectionJWT(ctx context.Context, server *ResourceServer, token string) (string, error) {
+	alg := jose.KeyAlgorithm(server.IntrospectionEncryptedResponseAlg)
+	enc := jose.A128CBC_HS256
+	if server.IntrospectionEncryptedResponseEnc != "" {
+		enc = jose.ContentEncryption(server.IntrospectionEncryptedResponseEnc)
+	}
+
+	set, err := server.keys(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	var recipient *jose.Recipient
+	for _, key := range set.Keys {
+		if key.Use == "sig" || (key.Algorithm != "" && key.Algorithm != string(alg)) {
+			continue
+		}
+		switch key.Key.(type) {
+		case *rsa.PublicKey:
+			if !strings.HasPrefix(string(alg), "RSA") {
+				continue
+			}
+		case *ecdsa.PublicKey:
+			if !strings.HasPrefix(string(alg), "ECDH") {
+				continue
+			}
+		default:
+			continue
+		}
+		recipient = &jose.Recipient{Algorithm: alg, Key: key.Key, KeyID: key.KeyID}
+		break
+	}
+	if recipient == nil {
+		return "", fosite.ErrServerError.WithHintf("The resource server has no key to encrypt the introspection response to with '%s'.", alg)
+	}
+
+	encrypter, err := jose.NewEncrypter(enc, *recipient, (&jose.EncrypterOptions{}).WithContentType("JWT"))
+	if err != nil {
+		return "", fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+	jwe, err := encrypter.Encrypt([]byte(token))
+	if err != nil {
+		return "", fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+	return jwe.CompactSerialize()
+}
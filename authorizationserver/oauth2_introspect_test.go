@@ -0,0 +1,327 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// useResourceServer registers the resource server until the test ends.
+func useResourceServer(t *testing.T, server *ResourceServer) {
+	t.Helper()
+	resourceRegistry[server.URI] = server
+	t.Cleanup(func() { delete(resourceRegistry, server.URI) })
+}
+
+func TestIntrospectionClientAssertion(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	s := useTestStore(t, sqlstore.NewClient("introspected-client"))
+	useResourceServer(t, &ResourceServer{
+		URI:                "https://jwt-api.my-application.com",
+		ID:                 "jwt-resource-server",
+		JWKSURI:            newTestJWKS(t, key, "rs-key"),
+		AllowIntrospection: true,
+	})
+
+	// An access token meant for the resource server.
+	client, err := s.GetClient(ctx, "introspected-client")
+	if err != nil {
+		t.Fatalf("GetClient: %v", err)
+	}
+	session := newSession("peter")
+	session.SetExpiresAt(fosite.AccessToken, time.Now().Add(time.Hour))
+	request := fosite.NewRequest()
+	request.Client = client
+	request.Session = session
+	request.GrantedAudience = fosite.Arguments{"https://jwt-api.my-application.com"}
+	accessToken, signature, err := testTokenStrategy.GenerateAccessToken(ctx, request)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	if err := s.CreateAccessTokenSession(ctx, signature, request); err != nil {
+		t.Fatalf("CreateAccessTokenSession: %v", err)
+	}
+
+	claims := func(modify func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "jwt-resource-server",
+			"sub": "jwt-resource-server",
+			"aud": issuer + "/oauth2/introspect",
+			"exp": time.Now().Add(time.Minute).Unix(),
+			"jti": uuid.New().String(),
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+	replayed := signTestAssertion(t, jose.RS256, key, "rs-key", claims(nil))
+
+	for _, tc := range []struct {
+		name      string
+		form      url.Values
+		wantCode  int
+		wantError string
+	}{
+		{
+			name:     "valid assertion",
+			form:     url.Values{"client_assertion": {replayed}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:      "replayed assertion",
+			form:      url.Values{"client_assertion": {replayed}},
+			wantCode:  http.StatusUnauthorized,
+			wantError: "only be used once",
+		},
+		{
+			name:      "signed with another key",
+			form:      url.Values{"client_assertion": {signTestAssertion(t, jose.RS256, otherKey, "rs-key", claims(nil))}},
+			wantCode:  http.StatusUnauthorized,
+			wantError: "integrity",
+		},
+		{
+			name: "meant for another server",
+			form: url.Values{"client_assertion": {signTestAssertion(t, jose.RS256, key, "rs-key", claims(func(c map[string]interface{}) {
+				c["aud"] = "https://other.example.com"
+			}))}},
+			wantCode:  http.StatusUnauthorized,
+			wantError: "'aud'",
+		},
+		{
+			name: "expired",
+			form: url.Values{"client_assertion": {signTestAssertion(t, jose.RS256, key, "rs-key", claims(func(c map[string]interface{}) {
+				c["exp"] = time.Now().Add(-time.Minute).Unix()
+			}))}},
+			wantCode:  http.StatusUnauthorized,
+			wantError: "expired",
+		},
+		{
+			name: "resource server without keys",
+			form: url.Values{"client_assertion": {signTestAssertion(t, jose.RS256, key, "rs-key", claims(func(c map[string]interface{}) {
+				c["iss"], c["sub"] = "my-resource-server", "my-resource-server"
+			}))}},
+			wantCode:  http.StatusUnauthorized,
+			wantError: "credentials are invalid",
+		},
+		{
+			name:      "secret for a resource server without secret",
+			form:      url.Values{"client_id": {"jwt-resource-server"}, "client_secret": {"foobar"}},
+			wantCode:  http.StatusUnauthorized,
+			wantError: "credentials are invalid",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			form := tc.form
+			if form.Has("client_assertion") {
+				form.Set("client_assertion_type", clientAssertionJWTBearerType)
+			}
+			form.Set("token", accessToken)
+			req := httptest.NewRequest(http.MethodPost, "/oauth2/introspect", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rw := httptest.NewRecorder()
+			introspectionEndpoint(rw, req)
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rw.Code, tc.wantCode, rw.Body)
+			}
+			if tc.wantError != "" && !strings.Contains(rw.Body.String(), tc.wantError) {
+				t.Errorf("the error does not mention %q: %s", tc.wantError, rw.Body)
+			}
+			if tc.wantCode == http.StatusOK && !strings.Contains(rw.Body.String(), `"active":true`) {
+				t.Errorf("the token meant for the resource server is not active: %s", rw.Body)
+			}
+		})
+	}
+}
+
+func TestIntrospectionTokenType(t *testing.T) {
+	ctx := context.Background()
+	client := sqlstore.NewClient("introspected-client")
+	s := useTestStore(t, client)
+
+	for _, tc := range []struct {
+		name string
+		jkt  string
+		want string
+	}{
+		{name: "bearer", want: "bearer"},
+		{name: "DPoP", jkt: "some-thumbprint", want: "DPoP"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			session := newSession("peter")
+			session.SetExpiresAt(fosite.AccessToken, time.Now().Add(time.Hour))
+			session.setConfirmation("jkt", tc.jkt)
+			request := fosite.NewRequest()
+			request.Client = client
+			request.Session = session
+			request.GrantedAudience = fosite.Arguments{"http://localhost:3846/protected"}
+			accessToken, signature, err := testTokenStrategy.GenerateAccessToken(ctx, request)
+			if err != nil {
+				t.Fatalf("GenerateAccessToken: %v", err)
+			}
+			if err := s.CreateAccessTokenSession(ctx, signature, request); err != nil {
+				t.Fatalf("CreateAccessTokenSession: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/oauth2/introspect", strings.NewReader(url.Values{"token": {accessToken}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth("my-resource-server", "foobar")
+			rw := httptest.NewRecorder()
+			introspectionEndpoint(rw, req)
+
+			var response struct {
+				Active    bool   `json:"active"`
+				TokenType string `json:"token_type"`
+				Cnf       struct {
+					JKT string `json:"jkt"`
+				} `json:"cnf"`
+			}
+			if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil || !response.Active {
+				t.Fatalf("introspection returned %d: %s", rw.Code, rw.Body)
+			}
+			if response.TokenType != tc.want || response.Cnf.JKT != tc.jkt {
+				t.Errorf("got token_type %q and cnf.jkt %q, want %q and %q", response.TokenType, response.Cnf.JKT, tc.want, tc.jkt)
+			}
+		})
+	}
+}
+
+func TestIntrospectionJWT(t *testing.T) {
+	ctx := context.Background()
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	client := sqlstore.NewClient("introspected-client")
+	s := useTestStore(t, client)
+	server := &ResourceServer{
+		URI:                "https://encrypted-api.my-application.com",
+		ID:                 "encrypted-resource-server",
+		SecretHash:         "$2a$10$IxMdI6d.LIRZPpSfEwNoeu4rY3FhDREsxFJXikcgdRRAStxUlsuEO", // = "foobar"
+		AllowIntrospection: true,
+		// The signing key comes first, it must never be encrypted to.
+		JWKS: &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &signingKey.PublicKey, KeyID: "sig-key", Use: "sig"},
+			{Key: &ecKey.PublicKey, KeyID: "ec-key", Use: "enc"},
+			{Key: &rsaKey.PublicKey, KeyID: "rsa-key", Algorithm: string(jose.RSA_OAEP_256), Use: "enc"},
+		}},
+	}
+	useResourceServer(t, server)
+
+	session := newSession("peter")
+	session.SetExpiresAt(fosite.AccessToken, time.Now().Add(time.Hour))
+	request := fosite.NewRequest()
+	request.Client = client
+	request.Session = session
+	request.GrantedAudience = fosite.Arguments{server.URI}
+	accessToken, signature, err := testTokenStrategy.GenerateAccessToken(ctx, request)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	if err := s.CreateAccessTokenSession(ctx, signature, request); err != nil {
+		t.Fatalf("CreateAccessTokenSession: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		token      string
+		alg        string
+		decryptKey interface{}
+		kid        string
+		active     bool
+	}{
+		{name: "signed", token: accessToken, active: true},
+		{name: "inactive token", token: "unknown-token"},
+		{name: "encrypted to the EC key", token: accessToken, alg: string(jose.ECDH_ES_A128KW), decryptKey: ecKey, kid: "ec-key", active: true},
+		{name: "encrypted to the RSA key", token: accessToken, alg: string(jose.RSA_OAEP_256), decryptKey: rsaKey, kid: "rsa-key", active: true},
+		{name: "encrypted inactive token", token: "unknown-token", alg: string(jose.RSA_OAEP_256), decryptKey: rsaKey, kid: "rsa-key"},
+		{name: "no key for the algorithm", token: accessToken, alg: string(jose.RSA_OAEP)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server.IntrospectionEncryptedResponseAlg = tc.alg
+			req := httptest.NewRequest(http.MethodPost, "/oauth2/introspect", strings.NewReader(url.Values{"token": {tc.token}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", introspectionJWTMediaType)
+			req.SetBasicAuth("encrypted-resource-server", "foobar")
+			rw := httptest.NewRecorder()
+			introspectionEndpoint(rw, req)
+
+			if tc.alg != "" && tc.decryptKey == nil {
+				// The response is not sent in the clear instead.
+				if rw.Header().Get("Content-Type") == introspectionJWTMediaType || strings.Contains(rw.Body.String(), `"active":true`) {
+					t.Errorf("got %s: %s", rw.Header().Get("Content-Type"), rw.Body)
+				}
+				return
+			}
+			if rw.Code != http.StatusOK || rw.Header().Get("Content-Type") != introspectionJWTMediaType {
+				t.Fatalf("got %d with %s: %s", rw.Code, rw.Header().Get("Content-Type"), rw.Body)
+			}
+
+			signed := rw.Body.String()
+			if tc.decryptKey != nil {
+				jwe, err := jose.ParseEncrypted(signed)
+				if err != nil {
+					t.Fatalf("ParseEncrypted: %v", err)
+				}
+				if jwe.Header.KeyID != tc.kid {
+					t.Errorf("the response is encrypted to %q, want %q", jwe.Header.KeyID, tc.kid)
+				}
+				plaintext, err := jwe.Decrypt(tc.decryptKey)
+				if err != nil {
+					t.Fatalf("Decrypt: %v", err)
+				}
+				signed = string(plaintext)
+			}
+
+			decoded, err := signer.Decode(ctx, signed)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if typ := decoded.Header["typ"]; typ != introspectionJWTType {
+				t.Errorf("the typ header is %v", typ)
+			}
+			claims := decoded.Claims
+			if claims["iss"] != issuer || claims["aud"] != "encrypted-resource-server" || claims["iat"] == nil {
+				t.Errorf("got claims %v", claims)
+			}
+			introspection, _ := claims["token_introspection"].(map[string]interface{})
+			if introspection["active"] != tc.active {
+				t.Errorf("got token_introspection %v, want active %v", claims["token_introspection"], tc.active)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package authorizationserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	jose "github.com/go-jose/go-jose/v3"
+)
+
+// jwksEndpoint publishes the public keys tokens are signed with, so clients and resource servers can verify ID tokens
+// offline. The `kid` of each key matches the `kid` header of the tokens it signed. The key request objects are encrypted to is
+// published along with `use` enc.
+func jwksEndpoint(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The set includes the next key ahead of its activation and retired keys until their tokens have expired, so
+	// verifiers caching this document never miss a key.
+	set := jose.JSONWebKeySet{
+		Keys: append(keys.PublicKeys(), requestObjectKey.PublicJWK()),
+	}
+
+	rw.Header().Set("Content-Type", "application/jwk-set+json;charset=UTF-8")
+	rw.Header().Set("Cache-Control", "public, max-age=3600")
+	if err := json.NewEncoder(rw).Encode(set); err != nil {
+		log.Printf("Error occurred in jwksEndpoint: %+v", err)
+	}
+}
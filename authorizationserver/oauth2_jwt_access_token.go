@@ -0,0 +1,137 @@
+package authorizationserver
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	fositeoauth2 "github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/token/jwt"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// jwtAccessTokenType is the `typ` header of JWT access tokens, see section 2.1 of RFC 9068.
+const jwtAccessTokenType = "at+jwt"
+
+// The values of ACCESS_TOKEN_STRATEGY and of the `access_token_strategy` of clients.
+const (
+	opaqueAccessTokens = "opaque"
+	jwtAccessTokens    = "jwt"
+)
+
+// accessTokenStrategy is the kind of access tokens clients that do not choose get, read from ACCESS_TOKEN_STRATEGY.
+// Opaque HMAC tokens by default.
+var accessTokenStrategy = mustLoadAccessTokenStrategy()
+
+func mustLoadAccessTokenStrategy() string {
+	switch strategy := os.Getenv("ACCESS_TOKEN_STRATEGY"); strategy {
+	case "":
+		return opaqueAccessTokens
+	case opaqueAccessTokens, jwtAccessTokens:
+		return strategy
+	default:
+		log.Fatalf("Invalid ACCESS_TOKEN_STRATEGY %q, expected %q or %q", strategy, opaqueAccessTokens, jwtAccessTokens)
+		return ""
+	}
+}
+
+// tokenStrategy issues opaque HMAC access tokens or JWT access tokens following RFC 9068, depending on the client.
+// Refresh tokens and authorize codes are always HMAC tokens. Both kinds of access tokens are accepted everywhere, a
+// JWT has three segments where an HMAC token has two.
+//
+// JWT access tokens are stored like HMAC tokens, under their signature, so they can still be introspected and
+// revoked. Resource servers that verify them offline do not learn about revocation, though.
+type tokenStrategy struct {
+	fositeoauth2.CoreStrategy
+	jwt *fositeoauth2.DefaultJWTStrategy
+}
+
+func newTokenStrategy(hmac fositeoauth2.CoreStrategy) *tokenStrategy {
+	return &tokenStrategy{
+		CoreStrategy: hmac,
+		jwt:          &fositeoauth2.DefaultJWTStrategy{Signer: signer, HMACSHAStrategy: hmac, Config: config},
+	}
+}
+
+func (s *tokenStrategy) AccessTokenSignature(ctx context.Context, token string) string {
+	if isJWT(token) {
+		return s.jwt.AccessTokenSignature(ctx, token)
+	}
+	return s.CoreStrategy.AccessTokenSignature(ctx, token)
+}
+
+func (s *tokenStrategy) ValidateAccessToken(ctx context.Context, requester fosite.Requester, token string) error {
+	if isJWT(token) {
+		return s.jwt.ValidateAccessToken(ctx, requester, token)
+	}
+	return s.CoreStrategy.ValidateAccessToken(ctx, requester, token)
+}
+
+// GenerateAccessToken issues a JWT access token if the client asked for them, or all clients get them. The claims are
+// those of section 2.2 of RFC 9068, plus the `cnf` and `act` claims of bound and exchanged tokens.
+func (s *tokenStrategy) GenerateAccessToken(ctx context.Context, requester fosite.Requester) (string, string, error) {
+	if !usesJWTAccessTokens(requester.GetClient()) {
+		return s.CoreStrategy.GenerateAccessToken(ctx, requester)
+	}
+
+	session, ok := requester.GetSession().(*Session)
+	if !ok {
+		return "", "", fosite.ErrServerError.WithDebug("The session of JWT access tokens must be a *Session.")
+	}
+
+	now := time.Now().UTC()
+	expiresAt := session.GetExpiresAt(fosite.AccessToken)
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(config.GetAccessTokenLifespan(ctx))
+	}
+
+	// Tokens of the client credentials grant have no subject, they stand for the client itself.
+	subject := session.GetSubject()
+	if subject == "" {
+		subject = requester.GetClient().GetID()
+	}
+
+	// Tokens not meant for any particular resource are meant for this server, e.g. for the UserInfo endpoint.
+	audience := []string(requester.GetGrantedAudience())
+	if len(audience) == 0 {
+		audience = []string{issuer}
+	}
+
+	claims := jwt.MapClaims{}
+	for key, value := range session.Extra {
+		claims[key] = value
+	}
+	claims["iss"] = issuer
+	claims["sub"] = subject
+	claims["aud"] = audience
+	claims["client_id"] = requester.GetClient().GetID()
+	claims["iat"] = now.Unix()
+	claims["exp"] = expiresAt.Unix()
+	claims["jti"] = uuid.New().String()
+	claims["scope"] = strings.Join(requester.GetGrantedScopes(), " ")
+	if session.Claims != nil && !session.Claims.AuthTime.IsZero() {
+		claims["auth_time"] = session.Claims.AuthTime.Unix()
+	}
+
+	header := jwt.NewHeaders()
+	header.Add("typ", jwtAccessTokenType)
+	return signer.Generate(ctx, claims, header)
+}
+
+// usesJWTAccessTokens reports whether the client gets JWT access tokens, by its own `access_token_strategy` or else by
+// ACCESS_TOKEN_STRATEGY.
+func usesJWTAccessTokens(client fosite.Client) bool {
+	if c, ok := client.(*sqlstore.Client); ok && c.AccessTokenStrategy != "" {
+		return c.AccessTokenStrategy == jwtAccessTokens
+	}
+	return accessTokenStrategy == jwtAccessTokens
+}
+
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
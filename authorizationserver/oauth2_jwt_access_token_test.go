@@ -0,0 +1,77 @@
+package authorizationserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+func TestJWTAccessToken(t *testing.T) {
+	ctx := context.Background()
+	strategy := newTokenStrategy(testTokenStrategy)
+	client := sqlstore.NewClient("jwt-client")
+	client.AccessTokenStrategy = jwtAccessTokens
+
+	for _, tc := range []struct {
+		name     string
+		subject  string
+		audience fosite.Arguments
+		jkt      string
+		wantSub  string
+		wantAud  []interface{}
+	}{
+		{name: "audience granted", subject: "peter", audience: fosite.Arguments{"http://localhost:3846/protected"}, wantSub: "peter", wantAud: []interface{}{"http://localhost:3846/protected"}},
+		{name: "no audience granted", subject: "peter", wantSub: "peter", wantAud: []interface{}{issuer}},
+		{name: "client credentials", wantSub: "jwt-client", wantAud: []interface{}{issuer}},
+		{name: "DPoP bound", subject: "peter", jkt: "some-thumbprint", wantSub: "peter", wantAud: []interface{}{issuer}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			session := newSession(tc.subject)
+			session.SetExpiresAt(fosite.AccessToken, time.Now().Add(time.Hour))
+			session.setConfirmation("jkt", tc.jkt)
+			request := fosite.NewRequest()
+			request.Client = client
+			request.Session = session
+			request.GrantedScope = fosite.Arguments{"openid", "photos"}
+			request.GrantedAudience = tc.audience
+
+			token, _, err := strategy.GenerateAccessToken(ctx, request)
+			if err != nil {
+				t.Fatalf("GenerateAccessToken: %v", err)
+			}
+			if err := strategy.ValidateAccessToken(ctx, request, token); err != nil {
+				t.Errorf("ValidateAccessToken: %v", err)
+			}
+
+			decoded, err := signer.Decode(ctx, token)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if typ := decoded.Header["typ"]; typ != jwtAccessTokenType {
+				t.Errorf("the typ header is %v, want %s", typ, jwtAccessTokenType)
+			}
+
+			claims := decoded.Claims
+			if claims["iss"] != issuer || claims["sub"] != tc.wantSub || claims["client_id"] != "jwt-client" || claims["scope"] != "openid photos" {
+				t.Errorf("got claims %v", claims)
+			}
+			if aud, _ := claims["aud"].([]interface{}); len(aud) != len(tc.wantAud) || aud[0] != tc.wantAud[0] {
+				t.Errorf("the aud claim is %v, want %v", claims["aud"], tc.wantAud)
+			}
+			for _, name := range []string{"iat", "exp", "jti"} {
+				if claims[name] == nil {
+					t.Errorf("the %s claim is missing", name)
+				}
+			}
+
+			cnf, _ := claims["cnf"].(map[string]interface{})
+			if tc.jkt == "" && claims["cnf"] != nil || tc.jkt != "" && cnf["jkt"] != tc.jkt {
+				t.Errorf("the cnf claim is %v, want jkt %q", claims["cnf"], tc.jkt)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package authorizationserver
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/ory/fosite/handler/rfc7523"
+)
+
+// jwtBearerGrantType is the grant type of RFC 7523, JWT assertions are traded for access tokens.
+const jwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// jwtBearerHandler is fosite's RFC 7523 handler, extended for trusted issuers: callers presenting an assertion of a
+// trusted issuer need no client credentials, the tokens are issued to the client of the issuer. Assertions of other
+// issuers, whose keys are registered per subject, still require client authentication.
+type jwtBearerHandler struct {
+	*rfc7523.Handler
+}
+
+// jwtBearerFactory creates the JWT bearer grant handler, to be passed to `compose.Compose` instead of
+// `compose.RFC7523AssertionGrantFactory`.
+func jwtBearerFactory(config fosite.Configurator, storage interface{}, strategy interface{}) interface{} {
+	return &jwtBearerHandler{Handler: compose.RFC7523AssertionGrantFactory(config, storage, strategy).(*rfc7523.Handler)}
+}
+
+func (h *jwtBearerHandler) HandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+	r, ok := requester.(*fosite.AccessRequest)
+	if !ok {
+		return fosite.ErrServerError.WithDebug("The access request must be a *fosite.AccessRequest.")
+	}
+
+	// Client authentication may be skipped, see GrantTypeJWTBearerCanSkipClientAuth, so fosite does not check the
+	// grant types of the client either. Without authentication, the client is fosite's empty default. fosite also
+	// falls back to it when authentication failed, callers sending credentials that are wrong are rejected here.
+	if r.Client == nil || r.Client.GetID() == "" {
+		if sentClientCredentials(ctx, r.GetRequestForm()) {
+			return fosite.ErrInvalidClient.WithHint("Client authentication failed.")
+		}
+		client, err := trustedIssuerClient(ctx, r.GetRequestForm().Get("assertion"))
+		if err != nil {
+			return err
+		}
+		r.Client = client
+	} else if !r.Client.GetGrantTypes().Has(jwtBearerGrantType) {
+		return fosite.ErrUnauthorizedClient.WithHintf("The OAuth 2.0 Client is not allowed to use authorization grant '%s'.", jwtBearerGrantType)
+	}
+
+	if err := h.Handler.HandleTokenEndpointRequest(ctx, requester); err != nil {
+		return err
+	}
+
+	// The assertion allowed the scopes, the client has to be allowed them as well.
+	for _, scope := range r.GetRequestedScopes() {
+		if !config.GetScopeStrategy(ctx)(r.Client.GetScopes(), scope) {
+			return fosite.ErrInvalidScope.WithHintf("The OAuth 2.0 Client is not allowed to request scope '%s'.", scope)
+		}
+	}
+
+	// fosite grants the audience of the assertion, which is this server. The tokens are meant for the audience the
+	// caller requests instead, see grantResources.
+	r.GrantedAudience = fosite.Arguments{}
+	return config.GetAudienceStrategy(ctx)(r.Client.GetAudience(), r.GetRequestedAudience())
+}
+
+// sentClientCredentials reports whether the token request carries client credentials of any kind.
+func sentClientCredentials(ctx context.Context, form url.Values) bool {
+	if req, ok := ctx.Value(fosite.RequestContextKey).(*http.Request); ok {
+		if _, _, ok := req.BasicAuth(); ok {
+			return true
+		}
+	}
+	return form.Get("client_id") != "" || form.Get("client_secret") != "" || form.Get("client_assertion") != ""
+}
+
+// trustedIssuerClient returns the client the tokens of the issuer of the assertion are issued to. The assertion is
+// verified by fosite afterwards.
+func trustedIssuerClient(ctx context.Context, assertion string) (fosite.Client, error) {
+	var claims jwt.Claims
+	if token, err := jwt.ParseSigned(assertion); err == nil {
+		_ = token.UnsafeClaimsWithoutVerification(&claims)
+	}
+
+	trusted, ok := trustedIssuerRegistry[claims.Issuer]
+	if !ok {
+		return nil, fosite.ErrInvalidClient.WithHint("Client authentication is required for assertions of issuers that are not trusted.")
+	}
+	client, err := store.GetClient(ctx, trusted.ClientID)
+	if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+	return client, nil
+}
+
+// GetPublicKey returns the signing key of a trusted issuer, provided the issuer may assert the subject. Other issuers
+// have their keys registered per subject.
+func (s *exampleStore) GetPublicKey(ctx context.Context, issuer string, subject string, keyID string) (*jose.JSONWebKey, error) {
+	trusted, ok := trustedIssuerRegistry[issuer]
+	if !ok {
+		return s.Store.GetPublicKey(ctx, issuer, subject, keyID)
+	}
+	if _, ok := trusted.scopesFor(subject); !ok {
+		return nil, fosite.ErrNotFound
+	}
+
+	set, err := trusted.keys(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	keys := set.Key(keyID)
+	if len(keys) == 0 && trusted.JWKSURI != "" {
+		// The issuer may have rotated its keys since they were cached.
+		if set, err = trusted.keys(ctx, true); err != nil {
+			return nil, err
+		}
+		keys = set.Key(keyID)
+	}
+	for _, key := range keys {
+		if key.Use == "" || key.Use == "sig" {
+			return &key, nil
+		}
+	}
+	return nil, fosite.ErrNotFound
+}
+
+// GetPublicKeys returns the signing keys of a trusted issuer, provided the issuer may assert the subject. Other
+// issuers have their keys registered per subject.
+func (s *exampleStore) GetPublicKeys(ctx context.Context, issuer string, subject string) (*jose.JSONWebKeySet, error) {
+	trusted, ok := trustedIssuerRegistry[issuer]
+	if !ok {
+		return s.Store.GetPublicKeys(ctx, issuer, subject)
+	}
+	if _, ok := trusted.scopesFor(subject); !ok {
+		return nil, fosite.ErrNotFound
+	}
+
+	set, err := trusted.keys(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	signing := &jose.JSONWebKeySet{}
+	for _, key := range set.Keys {
+		if key.Use == "" || key.Use == "sig" {
+			signing.Keys = append(signing.Keys, key)
+		}
+	}
+	if len(signing.Keys) == 0 {
+		return nil, fosite.ErrNotFound
+	}
+	return signing, nil
+}
+
+// GetPublicKeyScopes returns the scopes the rules of a trusted issuer allow for the subject. Other issuers have the
+// scopes registered with their keys.
+func (s *exampleStore) GetPublicKeyScopes(ctx context.Context, issuer string, subject string, keyID string) ([]string, error) {
+	trusted, ok := trustedIssuerRegistry[issuer]
+	if !ok {
+		return s.Store.GetPublicKeyScopes(ctx, issuer, subject, keyID)
+	}
+	scopes, ok := trusted.scopesFor(subject)
+	if !ok {
+		return nil, fosite.ErrNotFound
+	}
+	return scopes, nil
+}
@@ -0,0 +1,148 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/google/uuid"
+)
+
+// useTrustedIssuers replaces the trusted issuer registry until the test ends.
+func useTrustedIssuers(t *testing.T, issuers ...*TrustedIssuer) {
+	previous := trustedIssuerRegistry
+	trustedIssuerRegistry = make(trustedIssuers, len(issuers))
+	for _, trusted := range issuers {
+		trustedIssuerRegistry[trusted.Issuer] = trusted
+	}
+	t.Cleanup(func() { trustedIssuerRegistry = previous })
+}
+
+// newTestBearerAssertion signs an assertion of the issuer about the subject for the token endpoint.
+func newTestBearerAssertion(t *testing.T, key *rsa.PrivateKey, issuer string, subject string) string {
+	t.Helper()
+	return signTestAssertion(t, jose.RS256, key, "issuer-key", map[string]interface{}{
+		"iss": issuer,
+		"sub": subject,
+		"aud": config.TokenURL,
+		"jti": uuid.New().String(),
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	})
+}
+
+// jwtBearerTokenRequest trades the assertion for an access token, authenticating as the client if it is not empty.
+func jwtBearerTokenRequest(assertion string, scope string, clientID string, secret string) *httptest.ResponseRecorder {
+	form := url.Values{"grant_type": {jwtBearerGrantType}, "assertion": {assertion}, "scope": {scope}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	rw := httptest.NewRecorder()
+	tokenEndpoint(rw, req)
+	return rw
+}
+
+func TestJWTBearerTrustedIssuer(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	trusted := &TrustedIssuer{
+		Issuer:   "https://ca.example.com",
+		JWKS:     &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "issuer-key", Algorithm: string(jose.RS256), Use: "sig"}}},
+		ClientID: "batch-jobs",
+		Rules:    []TrustedIssuerRule{{Subject: "batch-*", Scopes: []string{"fosite"}}},
+	}
+	useTrustedIssuers(t, trusted)
+	s := useTestStore(t, trusted.client())
+
+	for _, tc := range []struct {
+		name    string
+		issuer  string
+		subject string
+		scope   string
+		code    string
+	}{
+		{name: "subject matched by a rule", issuer: trusted.Issuer, subject: "batch-nightly", scope: "fosite"},
+		{name: "subject not matched by the rules", issuer: trusted.Issuer, subject: "peter", scope: "fosite", code: "invalid_grant"},
+		{name: "scope outside the rule", issuer: trusted.Issuer, subject: "batch-nightly", scope: "fosite photos", code: "invalid_scope"},
+		{name: "untrusted issuer", issuer: "https://other.example.com", subject: "batch-nightly", scope: "fosite", code: "invalid_client"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := jwtBearerTokenRequest(newTestBearerAssertion(t, key, tc.issuer, tc.subject), tc.scope, "", "")
+			if tc.code != "" {
+				wantTokenError(t, rw, tc.code)
+				return
+			}
+			if rw.Code != http.StatusOK {
+				t.Fatalf("the token request returned %d: %s", rw.Code, rw.Body)
+			}
+
+			// The token is issued to the client of the issuer.
+			accessToken, _ := decodeTestTokens(t, rw)
+			ar, err := s.GetAccessTokenSession(ctx, testTokenStrategy.AccessTokenSignature(ctx, accessToken), newSession(""))
+			if err != nil {
+				t.Fatalf("GetAccessTokenSession: %v", err)
+			}
+			if ar.GetClient().GetID() != "batch-jobs" || ar.GetSession().GetSubject() != tc.subject {
+				t.Errorf("the token was issued to client %q for subject %q", ar.GetClient().GetID(), ar.GetSession().GetSubject())
+			}
+		})
+	}
+
+	// Assertions are single use.
+	assertion := newTestBearerAssertion(t, key, trusted.Issuer, "batch-nightly")
+	if rw := jwtBearerTokenRequest(assertion, "fosite", "", ""); rw.Code != http.StatusOK {
+		t.Fatalf("the token request returned %d: %s", rw.Code, rw.Body)
+	}
+	wantTokenError(t, jwtBearerTokenRequest(assertion, "fosite", "", ""), "jti_known")
+
+	// The client of the issuer has no credentials, nobody can authenticate as it.
+	wantTokenError(t, jwtBearerTokenRequest(newTestBearerAssertion(t, key, trusted.Issuer, "batch-nightly"), "fosite", "batch-jobs", ""), "invalid_client")
+	form := url.Values{"grant_type": {"client_credentials"}, "client_id": {"batch-jobs"}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw := httptest.NewRecorder()
+	tokenEndpoint(rw, req)
+	wantTokenError(t, rw, "invalid_client")
+}
+
+func TestJWTBearerRegisteredKey(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	useTrustedIssuers(t)
+
+	client := newRefreshClient("bearer-client")
+	client.GrantTypes = []string{jwtBearerGrantType}
+	client.Scopes = []string{"fosite"}
+	s := useTestStore(t, client)
+	if err := s.AddJWTBearerKey(ctx, "https://idp.example.com", "peter", &jose.JSONWebKey{Key: &key.PublicKey, KeyID: "issuer-key", Algorithm: string(jose.RS256), Use: "sig"}, []string{"fosite"}); err != nil {
+		t.Fatalf("AddJWTBearerKey: %v", err)
+	}
+
+	// The issuer is not trusted, only authenticated clients may present its assertions.
+	wantTokenError(t, jwtBearerTokenRequest(newTestBearerAssertion(t, key, "https://idp.example.com", "peter"), "fosite", "", ""), "invalid_client")
+	if rw := jwtBearerTokenRequest(newTestBearerAssertion(t, key, "https://idp.example.com", "peter"), "fosite", "bearer-client", "foobar"); rw.Code != http.StatusOK {
+		t.Errorf("the token request of the client returned %d: %s", rw.Code, rw.Body)
+	}
+
+	// Clients without the grant type may not.
+	other := newRefreshClient("other-client")
+	if err := s.CreateClient(ctx, other); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	wantTokenError(t, jwtBearerTokenRequest(newTestBearerAssertion(t, key, "https://idp.example.com", "peter"), "fosite", "other-client", "foobar"), "unauthorized_client")
+}
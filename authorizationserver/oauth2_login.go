@@ -0,0 +1,233 @@
+package authorizationserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+const (
+	// loginTokenLifespan is how long the consent page may be submitted after logging in.
+	loginTokenLifespan = time.Minute * 10
+
+	// loginSessionCookie is the name of the cookie that keeps the user logged in at the authorization server.
+	loginSessionCookie = "authorization_session"
+)
+
+// loginSessionLifespan is how long users stay logged in, set SESSION_LIFESPAN to change it.
+var loginSessionLifespan = loginSessionLifespanFromEnv()
+
+func loginSessionLifespanFromEnv() time.Duration {
+	lifespan := os.Getenv("SESSION_LIFESPAN")
+	if lifespan == "" {
+		return time.Hour * 24
+	}
+
+	d, err := time.ParseDuration(lifespan)
+	if err != nil {
+		log.Fatalf("Invalid SESSION_LIFESPAN %q: %+v", lifespan, err)
+	}
+	return d
+}
+
+// authentication is the outcome of a successful login.
+type authentication struct {
+	User *User
+	// SessionID identifies the login session, it is the `sid` claim of ID tokens. It is empty if the session could
+	// not be stored.
+	SessionID string
+	// AuthTime is when the user entered their credentials.
+	AuthTime time.Time
+	// RequestedAt is when the authorize request the user logged in for started. For a login that spans the login and
+	// the consent page, it is the time the credentials were posted.
+	RequestedAt time.Time
+}
+
+// loginToken proves that the user logged in. It is carried from the login page to the consent page in a hidden form
+// field, so the user does not have to enter their password twice.
+type loginToken struct {
+	Subject     string `json:"sub"`
+	SessionID   string `json:"sid,omitempty"`
+	AuthTime    int64  `json:"auth_time"`
+	RequestedAt int64  `json:"requested_at"`
+	ExpiresAt   int64  `json:"exp"`
+}
+
+func newLoginToken(a *authentication) (string, error) {
+	return signValue("login", &loginToken{
+		Subject:     a.User.Subject,
+		SessionID:   a.SessionID,
+		AuthTime:    a.AuthTime.Unix(),
+		RequestedAt: a.RequestedAt.Unix(),
+		ExpiresAt:   time.Now().Add(loginTokenLifespan).Unix(),
+	})
+}
+
+// loginSession is the content of the session cookie. It remembers who logged in and when, so users who logged in once
+// skip the login page until the session expires or they log out. The session is stored as well, logging out deletes
+// it.
+type loginSession struct {
+	ID        string `json:"sid"`
+	Subject   string `json:"sub"`
+	AuthTime  int64  `json:"auth_time"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// setLoginSession starts a session for the user who just logged in and sets its ID on the authentication.
+func setLoginSession(ctx context.Context, rw http.ResponseWriter, a *authentication) error {
+	id, err := randomToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := a.AuthTime.Add(loginSessionLifespan)
+	if err := store.CreateLoginSession(ctx, &sqlstore.LoginSession{
+		ID:        id,
+		Subject:   a.User.Subject,
+		AuthTime:  a.AuthTime,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return err
+	}
+
+	value, err := signValue("session", &loginSession{
+		ID:        id,
+		Subject:   a.User.Subject,
+		AuthTime:  a.AuthTime.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     loginSessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(issuer, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	a.SessionID = id
+	return nil
+}
+
+// clearLoginSession removes the session cookie.
+func clearLoginSession(rw http.ResponseWriter) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     loginSessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(issuer, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// getLoginSession returns the session of the cookie, or nil if there is no valid session. Sessions the user logged
+// out of are not valid anymore.
+func getLoginSession(req *http.Request) *loginSession {
+	cookie, err := req.Cookie(loginSessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	var session loginSession
+	if err := verifyValue("session", cookie.Value, &session); err != nil {
+		return nil
+	}
+	if time.Unix(session.ExpiresAt, 0).Before(time.Now()) {
+		return nil
+	}
+	if _, err := store.GetLoginSession(req.Context(), session.ID); err != nil {
+		if !errors.Is(err, fosite.ErrNotFound) {
+			log.Printf("Error occurred in GetLoginSession: %+v", err)
+		}
+		return nil
+	}
+	return &session
+}
+
+// authenticateUser returns who logged in, either with the username and password posted to the login page, with the
+// login token posted to the consent page or earlier with the session cookie. The session cookie is ignored if the
+// client asks for a fresh login with `prompt=login` or if the login is older than `max_age` allows. It returns
+// fosite.ErrNotFound if the user has to log in.
+func authenticateUser(rw http.ResponseWriter, req *http.Request, ar fosite.AuthorizeRequester) (*authentication, error) {
+	ctx := req.Context()
+
+	if username := req.PostForm.Get("username"); username != "" {
+		user, err := users.Authenticate(ctx, username, req.PostForm.Get("password"))
+		if err != nil {
+			if !errors.Is(err, fosite.ErrNotFound) {
+				log.Printf("Error occurred in Authenticate: %+v", err)
+			}
+			return nil, err
+		}
+
+		// auth_time has a resolution of seconds, truncate so it never appears to lie before the request.
+		now := time.Now().Truncate(time.Second)
+		a := &authentication{User: user, AuthTime: now, RequestedAt: now}
+		if err := setLoginSession(ctx, rw, a); err != nil {
+			log.Printf("Error occurred in setLoginSession: %+v", err)
+		}
+		return a, nil
+	}
+
+	if signed := req.PostForm.Get("login_token"); signed != "" {
+		var token loginToken
+		if err := verifyValue("login", signed, &token); err != nil || time.Unix(token.ExpiresAt, 0).Before(time.Now()) {
+			return nil, fosite.ErrNotFound
+		}
+
+		user, err := users.GetUser(ctx, token.Subject)
+		if err != nil {
+			return nil, err
+		}
+		return &authentication{User: user, SessionID: token.SessionID, AuthTime: time.Unix(token.AuthTime, 0), RequestedAt: time.Unix(token.RequestedAt, 0)}, nil
+	}
+
+	session := getLoginSession(req)
+	if session == nil || promptValues(ar).Has("login") {
+		return nil, fosite.ErrNotFound
+	}
+
+	authTime := time.Unix(session.AuthTime, 0)
+	if maxAge, err := strconv.ParseInt(ar.GetRequestForm().Get("max_age"), 10, 64); err == nil && authTime.Add(time.Duration(maxAge)*time.Second).Before(time.Now()) {
+		return nil, fosite.ErrNotFound
+	}
+
+	user, err := users.GetUser(ctx, session.Subject)
+	if err != nil {
+		return nil, err
+	}
+	return &authentication{User: user, SessionID: session.ID, AuthTime: authTime, RequestedAt: time.Now()}, nil
+}
+
+// writeLoginPage asks the user for their username and password. The form is posted to action, an empty action posts
+// to the page itself.
+func writeLoginPage(rw http.ResponseWriter, req *http.Request, action string) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write([]byte(`<h1>Login page</h1>`))
+	if req.PostForm.Get("username") != "" {
+		rw.Write([]byte(`<p><strong>The username or password is wrong.</strong></p>`))
+	}
+	rw.Write([]byte(fmt.Sprintf(`
+		<p>Howdy! This is the log in page.</p>
+		<form method="post" action="%s">
+			<input type="text" name="username" /> <small>try peter</small><br>
+			<input type="password" name="password" /> <small>try secret</small><br>
+			<input type="submit">
+		</form>
+	`, html.EscapeString(action))))
+}
@@ -0,0 +1,169 @@
+package authorizationserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// newTestLoginSession stores a session of peter, who logged in at authTime, and returns its cookie.
+func newTestLoginSession(t *testing.T, authTime time.Time, expiresAt time.Time) *http.Cookie {
+	t.Helper()
+	id, err := randomToken()
+	if err != nil {
+		t.Fatalf("randomToken: %v", err)
+	}
+	if err := store.CreateLoginSession(context.Background(), &sqlstore.LoginSession{ID: id, Subject: "peter", AuthTime: authTime, ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("CreateLoginSession: %v", err)
+	}
+	value, err := signValue("session", &loginSession{ID: id, Subject: "peter", AuthTime: authTime.Unix(), ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		t.Fatalf("signValue: %v", err)
+	}
+	return &http.Cookie{Name: loginSessionCookie, Value: value}
+}
+
+func TestAuthenticateUser(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	loggedIn := now.Add(-time.Hour)
+	session := newTestLoginSession(t, loggedIn, now.Add(time.Hour))
+	loginToken, err := signValue("login", &loginToken{Subject: "peter", AuthTime: loggedIn.Unix(), RequestedAt: now.Add(-time.Minute).Unix(), ExpiresAt: now.Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("signValue: %v", err)
+	}
+	// The cookie of a session the user logged out of, which is deleted from the store.
+	loggedOut, err := signValue("session", &loginSession{ID: "logged-out", Subject: "peter", AuthTime: loggedIn.Unix(), ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("signValue: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		form     url.Values
+		query    url.Values
+		cookie   *http.Cookie
+		authTime time.Time
+		fresh    bool
+	}{
+		{name: "nobody"},
+		{name: "password", form: url.Values{"username": {"peter"}, "password": {"secret"}}, fresh: true},
+		{name: "wrong password", form: url.Values{"username": {"peter"}, "password": {"wrong"}}},
+		{name: "password with prompt=login", form: url.Values{"username": {"peter"}, "password": {"secret"}}, query: url.Values{"prompt": {"login"}}, fresh: true},
+		{name: "login token", form: url.Values{"login_token": {loginToken}}, authTime: loggedIn},
+		{name: "forged login token", form: url.Values{"login_token": {session.Value}}},
+		{name: "session", cookie: session, authTime: loggedIn},
+		{name: "session with prompt=login", cookie: session, query: url.Values{"prompt": {"login"}}},
+		{name: "session within max_age", cookie: session, query: url.Values{"max_age": {"7200"}}, authTime: loggedIn},
+		{name: "session older than max_age", cookie: session, query: url.Values{"max_age": {"60"}}},
+		{name: "logged out session", cookie: &http.Cookie{Name: loginSessionCookie, Value: loggedOut}},
+		{name: "expired session", cookie: newTestLoginSession(t, loggedIn, now.Add(-time.Second))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/oauth2/auth?"+tc.query.Encode(), strings.NewReader(tc.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			req.ParseForm()
+			ar := fosite.NewAuthorizeRequest()
+			ar.Form = tc.query
+			if ar.Form == nil {
+				ar.Form = url.Values{}
+			}
+
+			rw := httptest.NewRecorder()
+			authn, err := authenticateUser(rw, req, ar)
+			if !tc.fresh && tc.authTime.IsZero() {
+				if !errors.Is(err, fosite.ErrNotFound) {
+					t.Fatalf("authenticateUser() = %v, want fosite.ErrNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("authenticateUser() = %v", err)
+			}
+			if authn.User.Subject != "peter" {
+				t.Errorf("authenticateUser() returned %s, want peter", authn.User.Subject)
+			}
+
+			var cookie *http.Cookie
+			for _, c := range rw.Result().Cookies() {
+				if c.Name == loginSessionCookie {
+					cookie = c
+				}
+			}
+			if tc.fresh {
+				// Posting the password is a new login, which starts a session.
+				if authn.AuthTime.Before(now) || authn.AuthTime.After(time.Now()) {
+					t.Errorf("auth_time is %v, want the time of the login", authn.AuthTime)
+				}
+				if cookie == nil || !cookie.HttpOnly {
+					t.Errorf("got session cookie %v, want an HttpOnly cookie", cookie)
+				}
+				return
+			}
+			if !authn.AuthTime.Equal(tc.authTime) {
+				t.Errorf("auth_time is %v, want %v", authn.AuthTime, tc.authTime)
+			}
+			if cookie != nil {
+				t.Errorf("the session was renewed without a login")
+			}
+		})
+	}
+}
+
+func TestAuthEndpointPromptNone(t *testing.T) {
+	query := url.Values{
+		"client_id":     {"my-client"},
+		"redirect_uri":  {"http://localhost:3846/callback"},
+		"response_type": {"code"},
+		"scope":         {"openid"},
+		"state":         {"some-random-state-foobar"},
+		"nonce":         {"some-random-nonce-foobar"},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		cookie *http.Cookie
+		query  url.Values
+		error  string
+	}{
+		{name: "not logged in", query: url.Values{"prompt": {"none"}}, error: "login_required"},
+		{name: "not logged in recently enough", cookie: newTestLoginSession(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour)), query: url.Values{"prompt": {"none"}, "max_age": {"60"}}, error: "login_required"},
+		{name: "logged in without consent", cookie: newTestLoginSession(t, time.Now(), time.Now().Add(time.Hour)), query: url.Values{"prompt": {"none"}, "scope": {"openid profile phone address email"}}, error: "consent_required"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := url.Values{}
+			for k, v := range query {
+				q[k] = v
+			}
+			for k, v := range tc.query {
+				q[k] = v
+			}
+			req := httptest.NewRequest(http.MethodGet, "/oauth2/auth?"+q.Encode(), nil)
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			rw := httptest.NewRecorder()
+			authEndpoint(rw, req)
+
+			// No page is shown, the error is sent back to the client.
+			location, err := url.Parse(rw.Header().Get("Location"))
+			if rw.Code != http.StatusSeeOther || err != nil {
+				t.Fatalf("got status %d: %s", rw.Code, rw.Body)
+			}
+			if got := location.Query().Get("error"); got != tc.error {
+				t.Errorf("got error %q, want %q", got, tc.error)
+			}
+		})
+	}
+}
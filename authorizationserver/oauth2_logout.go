@@ -0,0 +1,376 @@
+package authorizationserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+const (
+	// logoutTokenType is the `typ` header of logout tokens, see section 2.4 of OpenID Connect Back-Channel Logout 1.0.
+	logoutTokenType = "logout+jwt"
+
+	// backChannelLogoutEvent is the member of the `events` claim that makes a JWT a logout token.
+	backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+	// logoutTokenLifespan is how long logout tokens are valid. They are delivered right away, so it is short.
+	logoutTokenLifespan = time.Minute * 2
+)
+
+// logoutHTTPClient delivers logout tokens. Clients that do not answer in time are not waited for.
+var logoutHTTPClient = &http.Client{Timeout: time.Second * 5}
+
+// logoutRequest is a validated RP-initiated logout request.
+type logoutRequest struct {
+	// Hint is what the `id_token_hint` says about the user, nil if the request has none.
+	Hint *idTokenHint
+	// RedirectURI is where the user is sent after logging out, including the `state`. It is empty if the client did
+	// not ask for a redirect.
+	RedirectURI string
+}
+
+// idTokenHint is the content of an ID token the client sends to name the user it wants to log out.
+type idTokenHint struct {
+	Subject   string
+	SessionID string
+	Audience  []string
+	// Expired is set if the ID token is no longer valid. It still tells who the client means, but is not trusted to
+	// name a session to end.
+	Expired bool
+}
+
+// endSessionEndpoint implements OpenID Connect RP-Initiated Logout 1.0. It ends the login session of the user and
+// notifies every client the user authorized during the session: clients with a `frontchannel_logout_uri` are loaded
+// in iframes of the page confirming the logout, clients with a `backchannel_logout_uri` are sent a logout token.
+//
+// Users are asked to confirm the logout unless the client proves who is logged in with an `id_token_hint`, so other
+// sites can not log them out. Browsers without a session cookie can only end the session named by the hint, which
+// takes an unexpired ID token and the confirmation of the user.
+func endSessionEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		writeLogoutError(rw, fosite.ErrInvalidRequest.WithHint("Unable to parse the request.").WithWrap(err))
+		return
+	}
+
+	lr, err := newLogoutRequest(ctx, req.Form)
+	if err != nil {
+		log.Printf("Error occurred in newLogoutRequest: %+v", err)
+		writeLogoutError(rw, err)
+		return
+	}
+
+	// The session of the browser ends, or the one the ID token was issued in if the browser sent no cookie. Anyone
+	// holding an ID token can send it, so the latter needs the user to confirm even if the token is fresh.
+	session := getLoginSession(req)
+	confirmed := req.PostForm.Get("logout") == "confirm"
+	var sessionID, subject string
+	switch {
+	case session != nil:
+		if !confirmed && (lr.Hint == nil || lr.Hint.Subject != session.Subject) {
+			writeLogoutConfirmationPage(rw, req.Form)
+			return
+		}
+		sessionID = session.ID
+	case lr.Hint != nil && !lr.Hint.Expired:
+		if !confirmed {
+			writeLogoutConfirmationPage(rw, req.Form)
+			return
+		}
+		sessionID, subject = lr.Hint.SessionID, lr.Hint.Subject
+	}
+	clearLoginSession(rw)
+
+	frontChannelURIs, err := endLoginSession(ctx, sessionID, subject)
+	if err != nil {
+		log.Printf("Error occurred in endLoginSession: %+v", err)
+	}
+	writeLoggedOutPage(rw, frontChannelURIs, lr.RedirectURI)
+}
+
+// newLogoutRequest checks the `id_token_hint`, `client_id` and `post_logout_redirect_uri` parameters. The redirect URI
+// must be registered for the client, which is named by the ID token or `client_id`.
+func newLogoutRequest(ctx context.Context, form url.Values) (*logoutRequest, error) {
+	lr := &logoutRequest{}
+	if token := form.Get("id_token_hint"); token != "" {
+		hint, err := parseIDTokenHint(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		lr.Hint = hint
+	}
+
+	clientID := form.Get("client_id")
+	if lr.Hint != nil {
+		if clientID != "" && !fosite.Arguments(lr.Hint.Audience).Has(clientID) {
+			return nil, fosite.ErrInvalidRequest.WithHint("The 'client_id' is not an audience of the 'id_token_hint'.")
+		}
+		// ID tokens may have other audiences besides the client they were issued to.
+		if clientID == "" {
+			for _, audience := range lr.Hint.Audience {
+				if _, err := store.GetClient(ctx, audience); err == nil {
+					clientID = audience
+					break
+				}
+			}
+		}
+	}
+
+	redirectURI := form.Get("post_logout_redirect_uri")
+	if redirectURI == "" {
+		return lr, nil
+	}
+	if clientID == "" {
+		return nil, fosite.ErrInvalidRequest.WithHint("The 'post_logout_redirect_uri' parameter requires an 'id_token_hint' or 'client_id'.")
+	}
+	client, err := store.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, fosite.ErrInvalidClient.WithHint("The requested OAuth 2.0 Client does not exist.").WithWrap(err).WithDebug(err.Error())
+	}
+	if c, ok := client.(*sqlstore.Client); !ok || !fosite.Arguments(c.PostLogoutRedirectURIs).Has(redirectURI) {
+		return nil, fosite.ErrInvalidRequest.WithHint("The 'post_logout_redirect_uri' is not registered for the OAuth 2.0 Client.")
+	}
+
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return nil, fosite.ErrInvalidRequest.WithHint("The 'post_logout_redirect_uri' is malformed.").WithWrap(err).WithDebug(err.Error())
+	}
+	if state := form.Get("state"); state != "" {
+		query := u.Query()
+		query.Set("state", state)
+		u.RawQuery = query.Encode()
+	}
+	lr.RedirectURI = u.String()
+	return lr, nil
+}
+
+// parseIDTokenHint accepts ID tokens signed by this server, expired ones included: the user may well have been logged
+// in longer than the ID token is valid. Expired ones are marked as such.
+func parseIDTokenHint(ctx context.Context, token string) (*idTokenHint, error) {
+	parsed, err := signer.Decode(ctx, token)
+	var validationErr *jwt.ValidationError
+	expired := err != nil && errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired
+	if err != nil && !expired {
+		return nil, fosite.ErrInvalidRequest.WithHint("The 'id_token_hint' is invalid.").WithWrap(err).WithDebug(err.Error())
+	}
+
+	claims := parsed.Claims
+	if !claims.VerifyIssuer(issuer, true) {
+		return nil, fosite.ErrInvalidRequest.WithHint("The 'id_token_hint' was not issued by this server.")
+	}
+	if !isIDToken(parsed) {
+		return nil, fosite.ErrInvalidRequest.WithHint("The 'id_token_hint' is not an ID token.")
+	}
+
+	hint := &idTokenHint{
+		Subject:   jwt.ToString(claims["sub"]),
+		SessionID: jwt.ToString(claims["sid"]),
+		Expired:   expired,
+	}
+	switch aud := claims["aud"].(type) {
+	case string:
+		hint.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			hint.Audience = append(hint.Audience, jwt.ToString(a))
+		}
+	}
+	if hint.Subject == "" {
+		return nil, fosite.ErrInvalidRequest.WithHint("The 'id_token_hint' lacks the 'sub' claim.")
+	}
+	return hint, nil
+}
+
+// endLoginSession deletes the login session and sends logout tokens to the clients of the session that registered a
+// `backchannel_logout_uri`. It returns the front-channel logout URLs to load in iframes. A session that was ended
+// before is ignored, as is one that does not belong to subject unless subject is empty.
+func endLoginSession(ctx context.Context, id string, subject string) ([]string, error) {
+	if id == "" {
+		return nil, nil
+	}
+	session, err := store.GetLoginSession(ctx, id)
+	if errors.Is(err, fosite.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if subject != "" && subject != session.Subject {
+		return nil, nil
+	}
+	if err := store.DeleteLoginSession(ctx, id); err != nil {
+		return nil, err
+	}
+
+	// The logout is done, delivering the tokens must not be cut short by the user leaving the page.
+	ctx = context.WithoutCancel(ctx)
+
+	var frontChannelURIs []string
+	var wg sync.WaitGroup
+	for _, clientID := range session.ClientIDs {
+		client, err := store.GetClient(ctx, clientID)
+		if err != nil {
+			log.Printf("Error occurred in GetClient: %+v", err)
+			continue
+		}
+		c, ok := client.(*sqlstore.Client)
+		if !ok {
+			continue
+		}
+
+		if c.FrontChannelLogoutURI != "" {
+			frontChannelURIs = append(frontChannelURIs, frontChannelLogoutURL(c, session.ID))
+		}
+		if c.BackChannelLogoutURI != "" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := sendLogoutToken(ctx, c, session); err != nil {
+					log.Printf("Error occurred in sendLogoutToken: %+v", err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return frontChannelURIs, nil
+}
+
+// frontChannelLogoutURL returns the `frontchannel_logout_uri` of the client, with the `iss` and `sid` query
+// parameters if the client requires them.
+func frontChannelLogoutURL(client *sqlstore.Client, sessionID string) string {
+	if !client.FrontChannelLogoutSessionRequired {
+		return client.FrontChannelLogoutURI
+	}
+
+	u, err := url.Parse(client.FrontChannelLogoutURI)
+	if err != nil {
+		return client.FrontChannelLogoutURI
+	}
+	query := u.Query()
+	query.Set("iss", issuer)
+	query.Set("sid", sessionID)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// sendLogoutToken posts a logout token to the `backchannel_logout_uri` of the client, see section 2.5 of OpenID
+// Connect Back-Channel Logout 1.0.
+func sendLogoutToken(ctx context.Context, client *sqlstore.Client, session *sqlstore.LoginSession) error {
+	now := time.Now().UTC()
+	claims := jwt.MapClaims{
+		"iss":    issuer,
+		"sub":    session.Subject,
+		"aud":    []string{client.GetID()},
+		"iat":    now.Unix(),
+		"exp":    now.Add(logoutTokenLifespan).Unix(),
+		"jti":    uuid.New().String(),
+		"sid":    session.ID,
+		"events": map[string]interface{}{backChannelLogoutEvent: map[string]interface{}{}},
+	}
+	header := jwt.NewHeaders()
+	header.Add("typ", logoutTokenType)
+	token, _, err := signer.Generate(ctx, claims, header)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.BackChannelLogoutURI, strings.NewReader(url.Values{"logout_token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := logoutHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the back-channel logout URI of client %q returned status %d", client.GetID(), resp.StatusCode)
+	}
+	return nil
+}
+
+// writeLogoutConfirmationPage asks the user whether to log out. The form posts the parameters of the logout request
+// back to the endpoint.
+func writeLogoutConfirmationPage(rw http.ResponseWriter, form url.Values) {
+	var fields string
+	for _, name := range []string{"id_token_hint", "client_id", "post_logout_redirect_uri", "state"} {
+		if value := form.Get(name); value != "" {
+			fields += fmt.Sprintf(`<input type="hidden" name="%s" value="%s" />`, name, html.EscapeString(value))
+		}
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Write([]byte(fmt.Sprintf(`<h1>Logout</h1>
+		<p>Do you want to log out?</p>
+		<form method="post">
+			%s
+			<button type="submit" name="logout" value="confirm">Log out</button>
+		</form>
+	`, fields)))
+}
+
+// writeLoggedOutPage tells the user they logged out. The front-channel logout URLs of the clients are loaded in
+// hidden iframes; once they loaded, or after a few seconds, the user is sent to the redirect URI.
+func writeLoggedOutPage(rw http.ResponseWriter, frontChannelURIs []string, redirectURI string) {
+	var frames string
+	for _, uri := range frontChannelURIs {
+		frames += fmt.Sprintf(`<iframe src="%s" style="display:none"></iframe>`, html.EscapeString(uri))
+	}
+
+	var next string
+	if redirectURI != "" {
+		next = fmt.Sprintf(`<p><a id="continue" href="%s">Continue</a></p>
+		<script type="text/javascript">
+			(function () {
+				var frames = document.getElementsByTagName('iframe'), pending = frames.length;
+				function proceed() { window.location.replace(document.getElementById('continue').href); }
+				for (var i = 0; i < frames.length; i++) {
+					frames[i].addEventListener('load', function () { if (--pending === 0) proceed(); });
+				}
+				if (pending === 0) proceed();
+				setTimeout(proceed, 5000);
+			})();
+		</script>`, html.EscapeString(redirectURI))
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Write([]byte(fmt.Sprintf(`<h1>Logged out</h1>
+		<p>You have been logged out.</p>
+		%s
+		%s
+	`, frames, next)))
+}
+
+// writeLogoutError shows why a logout request was rejected. The user is not redirected, the redirect URI can not be
+// trusted.
+func writeLogoutError(rw http.ResponseWriter, err error) {
+	rfcErr := fosite.ErrorToRFC6749Error(err)
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(rfcErr.CodeField)
+	rw.Write([]byte(fmt.Sprintf(`<h1>Logout failed</h1>
+		<p>%s</p>
+	`, html.EscapeString(rfcErr.GetDescription()))))
+}
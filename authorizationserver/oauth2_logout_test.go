@@ -0,0 +1,253 @@
+package authorizationserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// newTestIDToken signs an ID token of this server for the subject, issued to the client during the login session.
+func newTestIDToken(t *testing.T, subject string, clientID string, sessionID string) string {
+	t.Helper()
+
+	claims := jwt.MapClaims{"iss": issuer, "sub": subject, "aud": clientID, "sid": sessionID, "exp": time.Now().Add(time.Hour).Unix()}
+	token, _, err := signer.Generate(context.Background(), claims, jwt.NewHeaders())
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	return token
+}
+
+func TestParseIDTokenHint(t *testing.T) {
+	ctx := context.Background()
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"iss": issuer, "sub": "peter", "aud": "my-client", "sid": "session", "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		name    string
+		typ     string
+		claims  jwt.MapClaims
+		valid   bool
+		expired bool
+	}{
+		{name: "ID token", typ: "JWT", claims: claims(nil), valid: true},
+		{name: "expired ID token", typ: "JWT", claims: claims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}), valid: true, expired: true},
+		{name: "JWT access token", typ: jwtAccessTokenType, claims: claims(nil)},
+		{name: "logout token", typ: logoutTokenType, claims: claims(nil)},
+		{name: "introspection response", typ: introspectionJWTType, claims: claims(nil)},
+		{name: "logout token without its typ", typ: "JWT", claims: claims(jwt.MapClaims{"events": map[string]interface{}{backChannelLogoutEvent: map[string]interface{}{}}})},
+		{name: "foreign issuer", typ: "JWT", claims: claims(jwt.MapClaims{"iss": "https://attacker.example.com"})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			header := jwt.NewHeaders()
+			header.Add("typ", tc.typ)
+			token, _, err := signer.Generate(ctx, tc.claims, header)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+
+			hint, err := parseIDTokenHint(ctx, token)
+			if tc.valid {
+				if err != nil {
+					t.Fatalf("parseIDTokenHint() = %v, want the ID token to be accepted", err)
+				}
+				if hint.Subject != "peter" || hint.SessionID != "session" || len(hint.Audience) != 1 || hint.Expired != tc.expired {
+					t.Errorf("parseIDTokenHint() = %+v", hint)
+				}
+			} else if err == nil {
+				t.Error("parseIDTokenHint() accepted a token that is not an ID token of this server")
+			}
+		})
+	}
+}
+
+func TestNewLogoutRequest(t *testing.T) {
+	ctx := context.Background()
+	app := sqlstore.NewClient("app")
+	app.PostLogoutRedirectURIs = []string{"https://app.example.com/logged-out"}
+	other := sqlstore.NewClient("other-app")
+	other.PostLogoutRedirectURIs = []string{"https://other.example.com/logged-out"}
+	useTestStore(t, app, other)
+	hint := newTestIDToken(t, "peter", "app", "session")
+
+	for _, tc := range []struct {
+		name         string
+		form         url.Values
+		wantRedirect string
+		valid        bool
+	}{
+		{name: "no parameters", form: url.Values{}, valid: true},
+		{
+			name:         "redirect named by client_id",
+			form:         url.Values{"client_id": {"app"}, "post_logout_redirect_uri": {"https://app.example.com/logged-out"}, "state": {"xyz"}},
+			wantRedirect: "https://app.example.com/logged-out?state=xyz",
+			valid:        true,
+		},
+		{
+			name:         "redirect named by id_token_hint",
+			form:         url.Values{"id_token_hint": {hint}, "post_logout_redirect_uri": {"https://app.example.com/logged-out"}},
+			wantRedirect: "https://app.example.com/logged-out",
+			valid:        true,
+		},
+		{name: "unregistered redirect", form: url.Values{"client_id": {"app"}, "post_logout_redirect_uri": {"https://attacker.example.com/"}}},
+		{name: "redirect of another client", form: url.Values{"client_id": {"app"}, "post_logout_redirect_uri": {"https://other.example.com/logged-out"}}},
+		{name: "redirect without client", form: url.Values{"post_logout_redirect_uri": {"https://app.example.com/logged-out"}}},
+		{name: "client_id not an audience of the hint", form: url.Values{"id_token_hint": {hint}, "client_id": {"other-app"}}},
+		{name: "unknown client", form: url.Values{"client_id": {"unknown"}, "post_logout_redirect_uri": {"https://app.example.com/logged-out"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lr, err := newLogoutRequest(ctx, tc.form)
+			if !tc.valid {
+				if err == nil {
+					t.Errorf("newLogoutRequest() accepted the request, redirecting to %q", lr.RedirectURI)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newLogoutRequest() = %v", fosite.ErrorToRFC6749Error(err).GetDescription())
+			}
+			if lr.RedirectURI != tc.wantRedirect {
+				t.Errorf("got redirect %q, want %q", lr.RedirectURI, tc.wantRedirect)
+			}
+		})
+	}
+}
+
+func TestBackChannelLogout(t *testing.T) {
+	ctx := context.Background()
+
+	received := make(chan string, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received <- req.PostFormValue("logout_token")
+	}))
+	defer receiver.Close()
+
+	app := sqlstore.NewClient("app")
+	app.BackChannelLogoutURI = receiver.URL + "/logout/backchannel"
+	frontChannelApp := sqlstore.NewClient("front-channel-app")
+	frontChannelApp.FrontChannelLogoutURI = "https://front.example.com/logout"
+	unrelated := sqlstore.NewClient("unrelated-app")
+	unrelated.BackChannelLogoutURI = receiver.URL + "/unrelated"
+	s := useTestStore(t, app, frontChannelApp, unrelated)
+
+	session := &sqlstore.LoginSession{ID: "session", Subject: "peter", AuthTime: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.CreateLoginSession(ctx, session); err != nil {
+		t.Fatalf("CreateLoginSession: %v", err)
+	}
+	for _, clientID := range []string{"app", "front-channel-app"} {
+		if err := s.AddLoginSessionClient(ctx, "session", clientID); err != nil {
+			t.Fatalf("AddLoginSessionClient: %v", err)
+		}
+	}
+
+	// The client logs the user out with an ID token, the browser sends no session cookie. The user confirmed.
+	form := url.Values{"id_token_hint": {newTestIDToken(t, "peter", "app", "session")}, "logout": {"confirm"}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/logout", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw := httptest.NewRecorder()
+	endSessionEndpoint(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("logout failed with %d: %s", rw.Code, rw.Body)
+	}
+	if !strings.Contains(rw.Body.String(), "https://front.example.com/logout") {
+		t.Errorf("the logged out page does not load the front-channel logout URI: %s", rw.Body)
+	}
+
+	var logoutToken string
+	select {
+	case logoutToken = <-received:
+	default:
+		t.Fatal("no logout token was delivered")
+	}
+	select {
+	case <-received:
+		t.Error("a client the user did not authorize during the session was sent a logout token")
+	default:
+	}
+
+	parsed, err := signer.Decode(ctx, logoutToken)
+	if err != nil {
+		t.Fatalf("the logout token does not verify: %v", err)
+	}
+	claims := parsed.Claims
+	if typ := parsed.Header["typ"]; typ != logoutTokenType {
+		t.Errorf("got typ %v, want %s", typ, logoutTokenType)
+	}
+	if claims["iss"] != issuer || claims["sub"] != "peter" || claims["sid"] != "session" || !claims.VerifyAudience("app", true) {
+		t.Errorf("got logout token claims %v", claims)
+	}
+	if events, _ := claims["events"].(map[string]interface{}); events[backChannelLogoutEvent] == nil {
+		t.Errorf("the logout token lacks the back-channel logout event: %v", claims["events"])
+	}
+	if _, ok := claims["nonce"]; ok || claims["jti"] == "" {
+		t.Errorf("the logout token must have a jti and no nonce: %v", claims)
+	}
+
+	if _, err := s.GetLoginSession(ctx, "session"); err == nil {
+		t.Error("the login session was not ended")
+	}
+}
+
+func TestLogoutWithoutSessionCookie(t *testing.T) {
+	ctx := context.Background()
+	expired := func() string {
+		token, _, err := signer.Generate(ctx, jwt.MapClaims{"iss": issuer, "sub": "peter", "aud": "app", "sid": "session", "exp": time.Now().Add(-time.Hour).Unix()}, jwt.NewHeaders())
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		return token
+	}()
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		form   url.Values
+		ended  bool
+	}{
+		{name: "ID token", method: http.MethodGet, form: url.Values{"id_token_hint": {newTestIDToken(t, "peter", "app", "session")}}},
+		{name: "ID token without confirmation", method: http.MethodPost, form: url.Values{"id_token_hint": {newTestIDToken(t, "peter", "app", "session")}}},
+		{name: "confirmation in the query", method: http.MethodGet, form: url.Values{"id_token_hint": {newTestIDToken(t, "peter", "app", "session")}, "logout": {"confirm"}}},
+		{name: "expired ID token", method: http.MethodPost, form: url.Values{"id_token_hint": {expired}, "logout": {"confirm"}}},
+		{name: "ID token of another user", method: http.MethodPost, form: url.Values{"id_token_hint": {newTestIDToken(t, "alice", "app", "session")}, "logout": {"confirm"}}},
+		{name: "confirmed ID token", method: http.MethodPost, form: url.Values{"id_token_hint": {newTestIDToken(t, "peter", "app", "session")}, "logout": {"confirm"}}, ended: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := useTestStore(t, sqlstore.NewClient("app"))
+			if err := s.CreateLoginSession(ctx, &sqlstore.LoginSession{ID: "session", Subject: "peter", AuthTime: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+				t.Fatalf("CreateLoginSession: %v", err)
+			}
+
+			var req *http.Request
+			if tc.method == http.MethodGet {
+				req = httptest.NewRequest(http.MethodGet, "/oauth2/logout?"+tc.form.Encode(), nil)
+			} else {
+				req = httptest.NewRequest(http.MethodPost, "/oauth2/logout", strings.NewReader(tc.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			rw := httptest.NewRecorder()
+			endSessionEndpoint(rw, req)
+			if rw.Code != http.StatusOK {
+				t.Fatalf("logout failed with %d: %s", rw.Code, rw.Body)
+			}
+
+			_, err := s.GetLoginSession(ctx, "session")
+			if ended := err != nil; ended != tc.ended {
+				t.Errorf("the login session was ended: %v, want %v", ended, tc.ended)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// The client authentication methods of section 2 of RFC 8705.
+const (
+	tlsClientAuth           = "tls_client_auth"
+	selfSignedTLSClientAuth = "self_signed_tls_client_auth"
+)
+
+var (
+	// clientCAs are the certificate authorities that issue the certificates of clients using `tls_client_auth`, read
+	// from the PEM file named by MTLS_CA_FILE. Without it, only `self_signed_tls_client_auth` is available.
+	clientCAs = mustLoadClientCAs()
+
+	// mtlsURL is the URL of the mutual TLS listener main.go starts when MTLS_PORT is set, "" without one. Clients send
+	// requests that need their certificate there. Set MTLS_URL when running behind a proxy or on a different host.
+	mtlsURL = mutualTLSURL()
+)
+
+func mustLoadClientCAs() *x509.CertPool {
+	path := os.Getenv("MTLS_CA_FILE")
+	if path == "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Could not read the client certificate authorities from %s: %+v", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		log.Fatalf("Could not find a PEM encoded certificate in %s", path)
+	}
+	return pool
+}
+
+func mutualTLSURL() string {
+	if os.Getenv("MTLS_URL") != "" {
+		return os.Getenv("MTLS_URL")
+	}
+	if os.Getenv("MTLS_PORT") != "" {
+		return "https://localhost:" + os.Getenv("MTLS_PORT")
+	}
+	return ""
+}
+
+// requestURL returns the URL the client sent the request to. The server may sit behind a proxy, so it is derived from
+// the issuer, or from the mutual TLS URL for requests that came in over TLS.
+func requestURL(req *http.Request) string {
+	if req.TLS != nil && mtlsURL != "" {
+		return mtlsURL + req.URL.Path
+	}
+	return issuer + req.URL.Path
+}
+
+// clientCertificate returns the certificate the client presented at the mutual TLS listener, or nil.
+func clientCertificate(req *http.Request) *x509.Certificate {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return req.TLS.PeerCertificates[0]
+}
+
+// clientCertificateThumbprint returns the `x5t#S256` thumbprint of the client certificate of the request, or "".
+func clientCertificateThumbprint(req *http.Request) string {
+	cert := clientCertificate(req)
+	if cert == nil {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authenticateClientCertificate authenticates a client using one of the methods of RFC 8705 by the certificate of
+// the request. The listener only asks for a certificate, whether it is trusted depends on the method of the client.
+func authenticateClientCertificate(ctx context.Context, req *http.Request, client *sqlstore.Client) error {
+	cert := clientCertificate(req)
+	if cert == nil {
+		return fosite.ErrInvalidClient.WithHintf("The OAuth 2.0 Client supports client authentication method '%s', but the request was not made with a client certificate.", client.GetTokenEndpointAuthMethod())
+	}
+
+	switch client.GetTokenEndpointAuthMethod() {
+	case tlsClientAuth:
+		if clientCAs == nil {
+			return fosite.ErrInvalidClient.WithHintf("This authorization server has no certificate authorities for client authentication method '%s'.", tlsClientAuth)
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, c := range req.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+		if _, err := cert.Verify(x509.VerifyOptions{
+			Roots:         clientCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err != nil {
+			return fosite.ErrInvalidClient.WithHint("The client certificate was not issued by a trusted certificate authority.").WithWrap(err).WithDebug(err.Error())
+		}
+		if !matchesCertificateMetadata(client, cert) {
+			return fosite.ErrInvalidClient.WithHint("The client certificate does not match the 'tls_client_auth_*' metadata of the OAuth 2.0 Client.")
+		}
+		return nil
+
+	case selfSignedTLSClientAuth:
+		// The certificate must be one of the `x5c` chains of the client's keys. Keys behind a `jwks_uri` are fetched
+		// again once, the client may have rolled its certificate.
+		for _, refresh := range []bool{false, true} {
+			if refresh && client.GetJSONWebKeysURI() == "" {
+				break
+			}
+
+			set, err := clientJWKS(ctx, client, refresh)
+			if err != nil {
+				return err
+			}
+			for _, key := range set.Keys {
+				if len(key.Certificates) > 0 && key.Certificates[0].Equal(cert) {
+					return nil
+				}
+			}
+		}
+		return fosite.ErrInvalidClient.WithHint("The client certificate is not registered in the JSON Web Keys of the OAuth 2.0 Client.")
+	}
+
+	return fosite.ErrInvalidClient.WithHintf("Client authentication method '%s' does not use client certificates.", client.GetTokenEndpointAuthMethod())
+}
+
+// matchesCertificateMetadata checks the certificate against the one `tls_client_auth_*` value the client registered,
+// see section 2.1.2 of RFC 8705. The subject DN is compared in the form of pkix.Name.String.
+func matchesCertificateMetadata(client *sqlstore.Client, cert *x509.Certificate) bool {
+	switch {
+	case client.TLSClientAuthSubjectDN != "":
+		return cert.Subject.String() == client.TLSClientAuthSubjectDN
+	case client.TLSClientAuthSANDNS != "":
+		return fosite.Arguments(cert.DNSNames).Has(client.TLSClientAuthSANDNS)
+	case client.TLSClientAuthSANURI != "":
+		for _, uri := range cert.URIs {
+			if uri.String() == client.TLSClientAuthSANURI {
+				return true
+			}
+		}
+	case client.TLSClientAuthSANIP != "":
+		ip := net.ParseIP(client.TLSClientAuthSANIP)
+		for _, address := range cert.IPAddresses {
+			if address.Equal(ip) {
+				return true
+			}
+		}
+	case client.TLSClientAuthSANEmail != "":
+		return fosite.Arguments(cert.EmailAddresses).Has(client.TLSClientAuthSANEmail)
+	}
+	return false
+}
+
+// bindClientCertificate binds the access token about to be issued for the request to the client certificate of the
+// request, by adding the `x5t#S256` member to the `cnf` claim, see section 3 of RFC 8705. Requests without a
+// certificate get unbound tokens, unless the client asked for bound ones only.
+//
+// Refresh tokens of public clients are bound to the certificate as well, like with DPoP.
+func bindClientCertificate(ar fosite.AccessRequester, req *http.Request) error {
+	session, ok := ar.GetSession().(*Session)
+	if !ok {
+		return fosite.ErrServerError.WithDebug("The session of the token endpoint must be a *Session.")
+	}
+
+	thumbprint := clientCertificateThumbprint(req)
+	client := ar.GetClient()
+	if ar.GetGrantTypes().ExactOne("refresh_token") && client.IsPublic() {
+		if bound := session.confirmation("x5t#S256"); bound != "" && bound != thumbprint {
+			return fosite.ErrInvalidGrant.WithHint("The refresh token is bound to a client certificate the request was not made with.")
+		}
+	}
+
+	if thumbprint == "" {
+		if c, ok := client.(*sqlstore.Client); ok && c.TLSClientCertificateBoundAccessTokens {
+			return fosite.ErrInvalidRequest.WithHint("The OAuth 2.0 Client must make token requests with its client certificate.")
+		}
+	}
+
+	session.setConfirmation("x5t#S256", thumbprint)
+	return nil
+}
@@ -0,0 +1,218 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// testCertificate is a certificate generated for a test, with its key.
+type testCertificate struct {
+	*x509.Certificate
+	Key *ecdsa.PrivateKey
+}
+
+// newTestCertificate issues a client certificate for the DNS name, signed by parent or self-signed if parent is nil.
+// A certificate authority is issued if dnsName is empty.
+func newTestCertificate(t *testing.T, parent *testCertificate, commonName string, dnsName string) *testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("rand.Int: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if dnsName == "" {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{dnsName}
+	}
+
+	issuerCert, issuerKey := template, key
+	if parent != nil {
+		issuerCert, issuerKey = parent.Certificate, parent.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuerCert, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return &testCertificate{Certificate: cert, Key: key}
+}
+
+// useClientCAs trusts the certificate authority for `tls_client_auth` until the test ends.
+func useClientCAs(t *testing.T, ca *testCertificate) {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate)
+	previous := clientCAs
+	clientCAs = pool
+	t.Cleanup(func() { clientCAs = previous })
+}
+
+// withCertificate makes the request look like it came in over the mutual TLS listener with the certificate.
+func withCertificate(req *http.Request, cert *testCertificate) *http.Request {
+	req.TLS = &tls.ConnectionState{}
+	if cert != nil {
+		req.TLS.PeerCertificates = []*x509.Certificate{cert.Certificate}
+	}
+	return req
+}
+
+func newMTLSClient(id string, method string) *sqlstore.Client {
+	client := sqlstore.NewClient(id)
+	client.GrantTypes = []string{"client_credentials"}
+	client.Scopes = []string{"fosite", "openid"}
+	client.TokenEndpointAuthMethod = method
+	return client
+}
+
+func TestAuthenticateClientCertificate(t *testing.T) {
+	ctx := context.Background()
+	ca := newTestCertificate(t, nil, "Example CA", "")
+	otherCA := newTestCertificate(t, nil, "Other CA", "")
+	useClientCAs(t, ca)
+
+	issued := newTestCertificate(t, ca, "mtls-client", "client.example.com")
+	issuedByOther := newTestCertificate(t, otherCA, "mtls-client", "client.example.com")
+	selfSigned := newTestCertificate(t, nil, "self-signed-client", "self-signed.example.com")
+	otherSelfSigned := newTestCertificate(t, nil, "self-signed-client", "self-signed.example.com")
+
+	bySANDNS := newMTLSClient("mtls-client", tlsClientAuth)
+	bySANDNS.TLSClientAuthSANDNS = "client.example.com"
+	bySubjectDN := newMTLSClient("mtls-client", tlsClientAuth)
+	bySubjectDN.TLSClientAuthSubjectDN = "CN=mtls-client"
+	byOtherSAN := newMTLSClient("mtls-client", tlsClientAuth)
+	byOtherSAN.TLSClientAuthSANDNS = "other.example.com"
+	selfSignedClient := newMTLSClient("self-signed-client", selfSignedTLSClientAuth)
+	selfSignedClient.JSONWebKeys = &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key: &selfSigned.Key.PublicKey, Certificates: []*x509.Certificate{selfSigned.Certificate},
+	}}}
+	secretClient := newMTLSClient("secret-client", "client_secret_basic")
+
+	for _, tc := range []struct {
+		name   string
+		client *sqlstore.Client
+		cert   *testCertificate
+		valid  bool
+	}{
+		{name: "tls_client_auth by SAN DNS", client: bySANDNS, cert: issued, valid: true},
+		{name: "tls_client_auth by subject DN", client: bySubjectDN, cert: issued, valid: true},
+		{name: "tls_client_auth with another SAN", client: byOtherSAN, cert: issued},
+		{name: "tls_client_auth by an untrusted CA", client: bySANDNS, cert: issuedByOther},
+		{name: "tls_client_auth with a self-signed certificate", client: bySubjectDN, cert: selfSigned},
+		{name: "tls_client_auth without certificate", client: bySANDNS},
+		{name: "self_signed_tls_client_auth", client: selfSignedClient, cert: selfSigned, valid: true},
+		{name: "self_signed_tls_client_auth with another certificate", client: selfSignedClient, cert: otherSelfSigned},
+		{name: "self_signed_tls_client_auth with a CA issued certificate", client: selfSignedClient, cert: issued},
+		{name: "client_secret_basic", client: secretClient, cert: issued},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := withCertificate(httptest.NewRequest(http.MethodPost, "/oauth2/token", nil), tc.cert)
+			err := authenticateClientCertificate(ctx, req, tc.client)
+			if tc.valid && err != nil {
+				t.Errorf("authenticateClientCertificate() = %v", fosite.ErrorToRFC6749Error(err).GetDescription())
+			} else if !tc.valid && err == nil {
+				t.Error("authenticateClientCertificate() accepted the certificate")
+			}
+		})
+	}
+}
+
+func TestCertificateBoundAccessToken(t *testing.T) {
+	ca := newTestCertificate(t, nil, "Example CA", "")
+	useClientCAs(t, ca)
+	cert := newTestCertificate(t, ca, "mtls-client", "client.example.com")
+	otherCert := newTestCertificate(t, ca, "mtls-client", "client.example.com")
+
+	client := newMTLSClient("mtls-client", tlsClientAuth)
+	client.TLSClientAuthSANDNS = "client.example.com"
+	client.TLSClientCertificateBoundAccessTokens = true
+	useTestStore(t, client)
+
+	tokenRequest := func(cert *testCertificate) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {"client_credentials"}, "client_id": {"mtls-client"}, "scope": {"fosite"}}
+		req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cert != nil {
+			req = withCertificate(req, cert)
+		}
+		rw := httptest.NewRecorder()
+		tokenEndpoint(rw, req)
+		return rw
+	}
+
+	// The client must make token requests with its certificate.
+	if rw := tokenRequest(nil); rw.Code == http.StatusOK {
+		t.Fatalf("a token was issued without a client certificate: %s", rw.Body)
+	}
+	rw := tokenRequest(cert)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("token request failed with %d: %s", rw.Code, rw.Body)
+	}
+	var response struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding the token response: %v", err)
+	}
+
+	// The token is bound to the certificate: requests with any other certificate, or none, are rejected before the
+	// scope is even checked.
+	for _, tc := range []struct {
+		name       string
+		cert       *testCertificate
+		wantStatus int
+	}{
+		{name: "same certificate", cert: cert, wantStatus: http.StatusForbidden},
+		{name: "other certificate", cert: otherCert, wantStatus: http.StatusUnauthorized},
+		{name: "no certificate", wantStatus: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+			req.Header.Set("Authorization", "Bearer "+response.AccessToken)
+			if tc.cert != nil {
+				req = withCertificate(req, tc.cert)
+			}
+			rw := httptest.NewRecorder()
+			userinfoEndpoint(rw, req)
+			if rw.Code != tc.wantStatus {
+				t.Errorf("got status %d (%s), want %d", rw.Code, rw.Header().Get("WWW-Authenticate"), tc.wantStatus)
+			}
+			if tc.wantStatus == http.StatusUnauthorized && !strings.Contains(rw.Header().Get("WWW-Authenticate"), "client certificate") {
+				t.Errorf("the token was not rejected for its certificate binding: %s", rw.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
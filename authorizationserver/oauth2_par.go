@@ -0,0 +1,153 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// pageFormKeys are the fields the login and consent pages post. They are not part of the authorize request and are
+// dropped when a request is pushed.
+var pageFormKeys = []string{"username", "password", "login_token", "consent", "scopes", "remember"}
+
+// parEndpoint implements pushed authorization requests (RFC 9126). The client posts the authorize parameters here
+// instead of putting them in the URL and gets back a `request_uri` to send the user to `/oauth2/auth` with.
+func parEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	// The parameters may be pushed as a signed request object as well. A `request_uri` is not allowed here, fosite
+	// rejects it below.
+	req.ParseForm()
+	if req.Form.Get("request_uri") == "" {
+		if err := resolveRequestObject(ctx, req); err != nil {
+			log.Printf("Error occurred in resolveRequestObject: %+v", err)
+			oauth2.WritePushedAuthorizeError(ctx, rw, fosite.NewAuthorizeRequest(), err)
+			return
+		}
+	}
+
+	// The client is authenticated like at the token endpoint and the request is validated like an authorize
+	// request, so errors surface before the user is redirected anywhere.
+	ar, err := oauth2.NewPushedAuthorizeRequest(ctx, req)
+	if err != nil {
+		log.Printf("Error occurred in NewPushedAuthorizeRequest: %+v", err)
+		oauth2.WritePushedAuthorizeError(ctx, rw, ar, err)
+		return
+	}
+	if err := requestResources(ctx, ar, ar.GetRequestForm()); err != nil {
+		log.Printf("Error occurred in requestResources: %+v", err)
+		oauth2.WritePushedAuthorizeError(ctx, rw, ar, err)
+		return
+	}
+
+	// Nobody logged in yet, the session only records when the request expires.
+	response, err := oauth2.NewPushedAuthorizeResponse(ctx, ar, new(fosite.DefaultSession))
+	if err != nil {
+		log.Printf("Error occurred in NewPushedAuthorizeResponse: %+v", err)
+		oauth2.WritePushedAuthorizeError(ctx, rw, ar, err)
+		return
+	}
+
+	oauth2.WritePushedAuthorizeResponse(ctx, rw, ar, response)
+}
+
+// isPushedAuthorizeRequest reports whether the authorize request was loaded from a pushed authorization request.
+func isPushedAuthorizeRequest(ctx context.Context, ar fosite.AuthorizeRequester) bool {
+	return strings.HasPrefix(ar.GetRequestForm().Get("request_uri"), config.GetPushedAuthorizeRequestURIPrefix(ctx))
+}
+
+// sentRequestObject reports whether the page was requested with a request object, by value or by reference.
+// resolveRequestObject removed it from the form, but not from the URL or the body.
+func sentRequestObject(req *http.Request) bool {
+	for _, form := range []url.Values{req.URL.Query(), req.PostForm} {
+		if form.Get("request") != "" || form.Get("request_uri") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// requiresPushedAuthorization reports whether the client may only start authorize requests at the pushed
+// authorization request endpoint.
+func requiresPushedAuthorization(client fosite.Client) bool {
+	c, ok := client.(*sqlstore.Client)
+	return ok && c.RequirePushedAuthorizationRequests
+}
+
+// pageAction returns the URL the login and consent pages post to. That is the URL of the page itself, unless the
+// request was pushed: then the pages post to its request URI, which stays valid until the client got its response or
+// the request expired. This keeps the authorize parameters out of the browser for the whole flow. Requests sent as a
+// request object are pushed first, a request object can not be used twice.
+func pageAction(req *http.Request, ar fosite.AuthorizeRequester) (string, error) {
+	ctx := req.Context()
+
+	var requestURI string
+	switch {
+	case isPushedAuthorizeRequest(ctx, ar):
+		requestURI = ar.GetRequestForm().Get("request_uri")
+	case sentRequestObject(req):
+		var err error
+		if requestURI, err = pushRequest(ctx, ar); err != nil {
+			return "", err
+		}
+	default:
+		return "", nil
+	}
+	return "/oauth2/auth?" + url.Values{"client_id": {ar.GetClient().GetID()}, "request_uri": {requestURI}}.Encode(), nil
+}
+
+// completePushedAuthorizeRequest deletes a pushed authorization request once the client got its response, so its
+// request URI can not be used again. fosite would delete it right when it is loaded, see exampleStore.
+func completePushedAuthorizeRequest(ctx context.Context, ar fosite.AuthorizeRequester) {
+	if !isPushedAuthorizeRequest(ctx, ar) {
+		return
+	}
+	if err := store.Store.DeletePARSession(ctx, ar.GetRequestForm().Get("request_uri")); err != nil {
+		log.Printf("Error occurred in DeletePARSession: %+v", err)
+	}
+}
+
+// pushRequest stores a copy of an authorize request as a pushed authorization request under a new request URI.
+func pushRequest(ctx context.Context, ar fosite.AuthorizeRequester) (string, error) {
+	pushed := fosite.NewAuthorizeRequest()
+	pushed.Merge(ar)
+	pushed.RedirectURI = ar.GetRedirectURI()
+	pushed.ResponseTypes = ar.GetResponseTypes()
+	pushed.State = ar.GetState()
+	pushed.ResponseMode = ar.GetResponseMode()
+	pushed.DefaultResponseMode = ar.GetDefaultResponseMode()
+
+	pushed.Form = url.Values{}
+	for key, values := range ar.GetRequestForm() {
+		pushed.Form[key] = values
+	}
+	pushed.Form.Del("request_uri")
+	for _, key := range pageFormKeys {
+		pushed.Form.Del(key)
+	}
+
+	session := new(fosite.DefaultSession)
+	session.SetExpiresAt(fosite.PushedAuthorizeRequestContext, time.Now().UTC().Add(config.GetPushedAuthorizeContextLifespan(ctx)))
+	pushed.SetSession(session)
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	requestURI := config.GetPushedAuthorizeRequestURIPrefix(ctx) + base64.RawURLEncoding.EncodeToString(b)
+
+	if err := store.CreatePARSession(ctx, requestURI, pushed); err != nil {
+		return "", err
+	}
+	return requestURI, nil
+}
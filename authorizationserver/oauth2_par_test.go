@@ -0,0 +1,175 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// pageActionField finds the URL the login and consent pages post to.
+var pageActionField = regexp.MustCompile(`action="([^"]*)"`)
+
+// newPARClient returns a confidential client with secret "foobar" for the authorization code grant.
+func newPARClient(requirePushed bool) *sqlstore.Client {
+	client := sqlstore.NewClient("par-client")
+	client.Secret = []byte(`$2a$10$IxMdI6d.LIRZPpSfEwNoeu4rY3FhDREsxFJXikcgdRRAStxUlsuEO`) // = "foobar"
+	client.RedirectURIs = []string{"http://localhost:8080/callback"}
+	client.ResponseTypes = []string{"code"}
+	client.GrantTypes = []string{"authorization_code"}
+	client.Scopes = []string{"openid"}
+	client.TokenEndpointAuthMethod = "client_secret_basic"
+	client.RequirePushedAuthorizationRequests = requirePushed
+	return client
+}
+
+// authorizeParams are the parameters of the authorize requests of "par-client".
+var authorizeParams = url.Values{
+	"client_id":     {"par-client"},
+	"response_type": {"code"},
+	"scope":         {"openid"},
+	"state":         {"some-state-value"},
+	"redirect_uri":  {"http://localhost:8080/callback"},
+}
+
+// pushTestRequest pushes the authorize parameters of "par-client" and returns the request URI.
+func pushTestRequest(t *testing.T) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/par", strings.NewReader(authorizeParams.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("par-client", "foobar")
+	rw := httptest.NewRecorder()
+	parEndpoint(rw, req)
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("pushing the request returned %d: %s", rw.Code, rw.Body)
+	}
+
+	var response struct {
+		RequestURI string `json:"request_uri"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding the pushed authorization response: %v", err)
+	}
+	return response.RequestURI
+}
+
+// authorizeTestRequest sends a request to the authorize endpoint.
+func authorizeTestRequest(method string, target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw := httptest.NewRecorder()
+	authEndpoint(rw, req)
+	return rw
+}
+
+// countPushedRequests returns how many pushed authorization requests are stored.
+func countPushedRequests(t *testing.T, s *sqlstore.Store) int {
+	t.Helper()
+	var n int
+	if err := s.DB().QueryRowContext(context.Background(), `SELECT COUNT(*) FROM requests WHERE kind = 'par'`).Scan(&n); err != nil {
+		t.Fatalf("counting pushed requests: %v", err)
+	}
+	return n
+}
+
+func TestPushedAuthorizationRequest(t *testing.T) {
+	s := useTestStore(t, newPARClient(false))
+	requestURI := pushTestRequest(t)
+	target := "/oauth2/auth?" + url.Values{"client_id": {"par-client"}, "request_uri": {requestURI}}.Encode()
+
+	// The login page posts to the request URI it was loaded from, reloading it works as well.
+	var page *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		page = authorizeTestRequest(http.MethodGet, target, nil)
+		if match := pageActionField.FindStringSubmatch(page.Body.String()); match == nil || html.UnescapeString(match[1]) != target {
+			t.Fatalf("the login page does not post to the pushed request: %d %s", page.Code, page.Body)
+		}
+	}
+
+	// So does the consent page.
+	page = authorizeTestRequest(http.MethodPost, target, url.Values{"username": {"peter"}, "password": {"secret"}})
+	token := loginTokenField.FindStringSubmatch(page.Body.String())
+	if match := pageActionField.FindStringSubmatch(page.Body.String()); token == nil || match == nil || html.UnescapeString(match[1]) != target {
+		t.Fatalf("the consent page does not post to the pushed request: %d %s", page.Code, page.Body)
+	}
+	if n := countPushedRequests(t, s); n != 1 {
+		t.Errorf("%d pushed requests are stored, want the one pushed by the client", n)
+	}
+
+	rw := authorizeTestRequest(http.MethodPost, target, url.Values{"login_token": {token[1]}, "consent": {"allow"}, "scopes": {"openid"}})
+	location, err := url.Parse(rw.Header().Get("Location"))
+	if err != nil || location.Query().Get("code") == "" || location.Query().Get("state") != "some-state-value" {
+		t.Fatalf("got %d to %q, want a redirect with a code", rw.Code, rw.Header().Get("Location"))
+	}
+
+	// The client got its response, the request URI is used up.
+	if n := countPushedRequests(t, s); n != 0 {
+		t.Errorf("%d pushed requests are stored after the response, want none", n)
+	}
+	if rw := authorizeTestRequest(http.MethodGet, target, nil); pageActionField.MatchString(rw.Body.String()) {
+		t.Errorf("the request URI was accepted after the response: %s", rw.Body)
+	}
+}
+
+func TestRequirePushedAuthorizationRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		requirePushed bool
+	}{
+		{name: "not required", requirePushed: false},
+		{name: "required", requirePushed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useTestStore(t, newPARClient(tc.requirePushed))
+
+			rw := authorizeTestRequest(http.MethodGet, "/oauth2/auth?"+authorizeParams.Encode(), nil)
+			if shown := pageActionField.MatchString(rw.Body.String()); shown == tc.requirePushed {
+				t.Errorf("the login page is shown for an authorize request that was not pushed: %t", shown)
+			}
+			if tc.requirePushed {
+				location, err := url.Parse(rw.Header().Get("Location"))
+				if err != nil || location.Query().Get("error") != "invalid_request" {
+					t.Errorf("got %d to %q, want a redirect with error invalid_request", rw.Code, rw.Header().Get("Location"))
+				}
+			}
+
+			target := "/oauth2/auth?" + url.Values{"client_id": {"par-client"}, "request_uri": {pushTestRequest(t)}}.Encode()
+			if rw := authorizeTestRequest(http.MethodGet, target, nil); !pageActionField.MatchString(rw.Body.String()) {
+				t.Errorf("the login page is not shown for a pushed request: %d %s", rw.Code, rw.Body)
+			}
+		})
+	}
+}
+
+func TestPAREndpointErrors(t *testing.T) {
+	useTestStore(t, newPARClient(false))
+
+	for _, tc := range []struct {
+		name   string
+		form   url.Values
+		secret string
+		status int
+	}{
+		{name: "wrong secret", form: authorizeParams, secret: "wrong", status: http.StatusUnauthorized},
+		{name: "request_uri", form: url.Values{"client_id": {"par-client"}, "request_uri": {"urn:ietf:params:oauth:request_uri:something"}}, secret: "foobar", status: http.StatusBadRequest},
+		{name: "unregistered redirect_uri", form: url.Values{"client_id": {"par-client"}, "response_type": {"code"}, "redirect_uri": {"http://evil.example.com/callback"}, "state": {"some-state-value"}}, secret: "foobar", status: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/oauth2/par", strings.NewReader(tc.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth("par-client", tc.secret)
+			rw := httptest.NewRecorder()
+			parEndpoint(rw, req)
+			if rw.Code != tc.status {
+				t.Errorf("got %d, want %d: %s", rw.Code, tc.status, rw.Body)
+			}
+		})
+	}
+}
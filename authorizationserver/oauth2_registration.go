@@ -0,0 +1,588 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// The error codes of section 3.2.2 of RFC 7591, fosite does not define them.
+var (
+	errInvalidRedirectURI = &fosite.RFC6749Error{
+		ErrorField:       "invalid_redirect_uri",
+		DescriptionField: "The value of one or more redirection URIs is invalid.",
+		CodeField:        http.StatusBadRequest,
+	}
+	errInvalidClientMetadata = &fosite.RFC6749Error{
+		ErrorField:       "invalid_client_metadata",
+		DescriptionField: "The value of one of the client metadata fields is invalid and the server has rejected this request.",
+		CodeField:        http.StatusBadRequest,
+	}
+)
+
+// initialAccessTokens are the tokens that allow registering clients, read from INITIAL_ACCESS_TOKENS as a comma
+// separated list. Without them, clients can not register themselves.
+var initialAccessTokens = fosite.RemoveEmpty(strings.Split(os.Getenv("INITIAL_ACCESS_TOKENS"), ","))
+
+// selfRegisteredGrantTypes are the grant types clients registering themselves may use. Grants that issue tokens
+// without a user, or trust whatever the client asserts, are left to clients an administrator created.
+var selfRegisteredGrantTypes = fosite.Arguments{"authorization_code", "refresh_token", deviceCodeGrantType}
+
+// defaultClientScope is the scope of clients that register without one.
+const defaultClientScope = "openid"
+
+// clientMetadata is what a client registers, the metadata of section 2 of RFC 7591 and of the extensions this server
+// implements. Privileged settings, like the audiences and the token exchange policy, are not part of it.
+type clientMetadata struct {
+	RedirectURIs                          []string            `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod               string              `json:"token_endpoint_auth_method,omitempty"`
+	TokenEndpointAuthSigningAlg           string              `json:"token_endpoint_auth_signing_alg,omitempty"`
+	GrantTypes                            []string            `json:"grant_types,omitempty"`
+	ResponseTypes                         []string            `json:"response_types,omitempty"`
+	Scope                                 string              `json:"scope,omitempty"`
+	ClientName                            string              `json:"client_name,omitempty"`
+	ClientURI                             string              `json:"client_uri,omitempty"`
+	LogoURI                               string              `json:"logo_uri,omitempty"`
+	TOSURI                                string              `json:"tos_uri,omitempty"`
+	PolicyURI                             string              `json:"policy_uri,omitempty"`
+	Contacts                              []string            `json:"contacts,omitempty"`
+	JWKSURI                               string              `json:"jwks_uri,omitempty"`
+	JWKS                                  *jose.JSONWebKeySet `json:"jwks,omitempty"`
+	RequestURIs                           []string            `json:"request_uris,omitempty"`
+	RequestObjectSigningAlg               string              `json:"request_object_signing_alg,omitempty"`
+	AccessTokenStrategy                   string              `json:"access_token_strategy,omitempty"`
+	RequirePushedAuthorizationRequests    bool                `json:"require_pushed_authorization_requests,omitempty"`
+	DPoPBoundAccessTokens                 bool                `json:"dpop_bound_access_tokens,omitempty"`
+	TLSClientAuthSubjectDN                string              `json:"tls_client_auth_subject_dn,omitempty"`
+	TLSClientAuthSANDNS                   string              `json:"tls_client_auth_san_dns,omitempty"`
+	TLSClientAuthSANURI                   string              `json:"tls_client_auth_san_uri,omitempty"`
+	TLSClientAuthSANIP                    string              `json:"tls_client_auth_san_ip,omitempty"`
+	TLSClientAuthSANEmail                 string              `json:"tls_client_auth_san_email,omitempty"`
+	TLSClientCertificateBoundAccessTokens bool                `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+	PostLogoutRedirectURIs                []string            `json:"post_logout_redirect_uris,omitempty"`
+	FrontChannelLogoutURI                 string              `json:"frontchannel_logout_uri,omitempty"`
+	FrontChannelLogoutSessionRequired     bool                `json:"frontchannel_logout_session_required,omitempty"`
+	BackChannelLogoutURI                  string              `json:"backchannel_logout_uri,omitempty"`
+	BackChannelLogoutSessionRequired      bool                `json:"backchannel_logout_session_required,omitempty"`
+	BackChannelTokenDeliveryMode          string              `json:"backchannel_token_delivery_mode,omitempty"`
+	BackChannelClientNotificationEndpoint string              `json:"backchannel_client_notification_endpoint,omitempty"`
+}
+
+// clientInformation is the response of the registration endpoint, see section 3.2.1 of RFC 7591, and of reads and
+// updates of a registration, see section 3 of RFC 7592. Clients send it back when updating their registration.
+type clientInformation struct {
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientSecretExpiresAt   *int64 `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
+	clientMetadata
+}
+
+// registrationEndpoint registers a client from its metadata (RFC 7591). The response carries the client ID, a secret
+// if the authentication method needs one, and the registration access token the client reads, updates and deletes
+// its registration with at `registration_client_uri`.
+func registrationEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	if len(initialAccessTokens) == 0 {
+		http.NotFound(rw, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("HTTP method is '%s', expected 'POST'.", req.Method))
+		return
+	}
+	if !containsToken(initialAccessTokens, bearerToken(req)) {
+		writeBearerError(rw, http.StatusUnauthorized, "invalid_token", "The initial access token is missing or invalid.")
+		return
+	}
+
+	var info clientInformation
+	if err := json.NewDecoder(req.Body).Decode(&info); err != nil {
+		oauth2.WriteAccessError(ctx, rw, nil, errInvalidClientMetadata.WithHint("The request body must be a JSON object of client metadata.").WithWrap(err).WithDebug(err.Error()))
+		return
+	}
+
+	client := sqlstore.NewClient(uuid.New().String())
+	secret, err := applyClientMetadata(ctx, client, &info.clientMetadata, nil)
+	if err == nil {
+		err = checkSelfRegistration(ctx, client, nil)
+	}
+	if err != nil {
+		log.Printf("Error occurred in applyClientMetadata: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, err)
+		return
+	}
+
+	registrationAccessToken, err := randomToken()
+	if err != nil {
+		log.Printf("Error occurred in randomToken: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+	client.RegistrationAccessTokenHash = registrationAccessTokenHash(registrationAccessToken)
+
+	if err := store.CreateClient(ctx, client); err != nil {
+		log.Printf("Error occurred in CreateClient: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	writeClientInformation(rw, http.StatusCreated, client, secret, registrationAccessToken)
+}
+
+// registrationManagementEndpoint is the client configuration endpoint of RFC 7592 at `/oauth2/register/<client id>`.
+// It authenticates the client by its registration access token, not by its client credentials.
+func registrationManagementEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	// Unknown clients and wrong tokens look the same, so the endpoint does not reveal which clients exist.
+	registrationAccessToken := bearerToken(req)
+	client, err := registeredClient(ctx, strings.TrimPrefix(req.URL.Path, "/oauth2/register/"), registrationAccessToken)
+	if err != nil {
+		log.Printf("Error occurred in registeredClient: %+v", err)
+		writeBearerError(rw, http.StatusUnauthorized, "invalid_token", "The registration access token is invalid.")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		writeClientInformation(rw, http.StatusOK, client, "", registrationAccessToken)
+
+	case http.MethodPut:
+		// The update replaces the registration, metadata the client leaves out is reset to the defaults.
+		var info clientInformation
+		if err := json.NewDecoder(req.Body).Decode(&info); err != nil {
+			oauth2.WriteAccessError(ctx, rw, nil, errInvalidClientMetadata.WithHint("The request body must be a JSON object of client metadata.").WithWrap(err).WithDebug(err.Error()))
+			return
+		}
+		if info.ClientID != client.GetID() {
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHint("The 'client_id' of the request body must match the client being updated."))
+			return
+		}
+		if info.ClientSecret != "" && !matchesClientSecret(ctx, client, info.ClientSecret) {
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHint("The 'client_secret' of the request body does not match the secret of the client."))
+			return
+		}
+
+		// Settings only administrators may change are kept.
+		updated := sqlstore.NewClient(client.GetID())
+		updated.Audience = client.Audience
+		updated.TokenExchange = client.TokenExchange
+		updated.RegistrationAccessTokenHash = client.RegistrationAccessTokenHash
+		secret, err := applyClientMetadata(ctx, updated, &info.clientMetadata, client)
+		if err == nil {
+			err = checkSelfRegistration(ctx, updated, client)
+		}
+		if err != nil {
+			log.Printf("Error occurred in applyClientMetadata: %+v", err)
+			oauth2.WriteAccessError(ctx, rw, nil, err)
+			return
+		}
+		if err := store.UpdateClient(ctx, updated); err != nil {
+			log.Printf("Error occurred in UpdateClient: %+v", err)
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+		writeClientInformation(rw, http.StatusOK, updated, secret, registrationAccessToken)
+
+	case http.MethodDelete:
+		// Deleting the client revokes its tokens and grants as well, see section 2.3 of RFC 7592.
+		if err := store.DeleteClient(ctx, client.GetID()); err != nil {
+			log.Printf("Error occurred in DeleteClient: %+v", err)
+			oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+
+	default:
+		oauth2.WriteAccessError(ctx, rw, nil, fosite.ErrInvalidRequest.WithHintf("HTTP method is '%s', expected 'GET', 'PUT' or 'DELETE'.", req.Method))
+	}
+}
+
+// registeredClient loads a client registered at the registration endpoint, if the registration access token is the
+// one it was issued.
+func registeredClient(ctx context.Context, id string, registrationAccessToken string) (*sqlstore.Client, error) {
+	client, err := store.GetClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := client.(*sqlstore.Client)
+	if !ok || c.RegistrationAccessTokenHash == "" || registrationAccessToken == "" ||
+		subtle.ConstantTimeCompare([]byte(c.RegistrationAccessTokenHash), []byte(registrationAccessTokenHash(registrationAccessToken))) != 1 {
+		return nil, errors.New("the registration access token does not belong to the client")
+	}
+	return c, nil
+}
+
+// bearerToken returns the bearer token of the Authorization header. Unlike fosite.AccessTokenFromRequest, it never
+// reads the body, which holds the client metadata.
+func bearerToken(req *http.Request) string {
+	if scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return token
+	}
+	return ""
+}
+
+// containsToken reports whether token is one of tokens, in constant time.
+func containsToken(tokens []string, token string) bool {
+	var found bool
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+// registrationAccessTokenHash is what is stored in place of a registration access token. The token is random, so a
+// plain hash is enough.
+func registrationAccessTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomToken returns a random string with 256 bits of entropy, for secrets and registration access tokens.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// matchesClientSecret reports whether secret is the current client secret or assertion secret of the client.
+func matchesClientSecret(ctx context.Context, client *sqlstore.Client, secret string) bool {
+	if client.AssertionSecret != "" {
+		return subtle.ConstantTimeCompare([]byte(client.AssertionSecret), []byte(secret)) == 1
+	}
+	for _, s := range client.Secrets {
+		if !s.Expired() && config.GetSecretsHasher(ctx).Compare(ctx, s.Hash, []byte(secret)) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// applyClientMetadata validates the metadata, fills in the defaults of section 2 of RFC 7591 and sets it on the
+// client. Grant types, response types and algorithms are checked against the discovery document, so a client can
+// only register what the server advertises.
+//
+// Clients authenticating with a secret get a new one, which is returned, unless previous is the registration being
+// updated and already has one.
+func applyClientMetadata(ctx context.Context, client *sqlstore.Client, m *clientMetadata, previous *sqlstore.Client) (string, error) {
+	doc := newDiscoveryDocument(ctx)
+
+	if m.TokenEndpointAuthMethod == "" {
+		m.TokenEndpointAuthMethod = "client_secret_basic"
+	}
+	if len(m.GrantTypes) == 0 {
+		m.GrantTypes = []string{"authorization_code"}
+	}
+	if len(m.ResponseTypes) == 0 && fosite.Arguments(m.GrantTypes).Has("authorization_code") {
+		m.ResponseTypes = []string{"code"}
+	}
+	if m.Scope == "" {
+		m.Scope = defaultClientScope
+	}
+
+	if !fosite.Arguments(tokenEndpointAuthMethods).Has(m.TokenEndpointAuthMethod) {
+		return "", errInvalidClientMetadata.WithHintf("The token_endpoint_auth_method '%s' is not supported.", m.TokenEndpointAuthMethod)
+	}
+	for _, grantType := range m.GrantTypes {
+		if !fosite.Arguments(doc.GrantTypesSupported).Has(grantType) {
+			return "", errInvalidClientMetadata.WithHintf("The grant type '%s' is not supported.", grantType)
+		}
+	}
+	for _, responseType := range m.ResponseTypes {
+		if !fosite.Arguments(doc.ResponseTypesSupported).Has(responseType) {
+			return "", errInvalidClientMetadata.WithHintf("The response type '%s' is not supported.", responseType)
+		}
+
+		// The response types must go with the grant types, see the table of section 2.1 of RFC 7591.
+		parts := fosite.Arguments(strings.Split(responseType, " "))
+		if parts.Has("code") && !fosite.Arguments(m.GrantTypes).Has("authorization_code") {
+			return "", errInvalidClientMetadata.WithHintf("The response type '%s' requires the grant type 'authorization_code'.", responseType)
+		}
+		if parts.HasOneOf("token", "id_token") && !fosite.Arguments(m.GrantTypes).Has("implicit") {
+			return "", errInvalidClientMetadata.WithHintf("The response type '%s' requires the grant type 'implicit'.", responseType)
+		}
+	}
+	scopes := fosite.RemoveEmpty(strings.Split(m.Scope, " "))
+
+	if fosite.Arguments(m.GrantTypes).HasOneOf("authorization_code", "implicit") && len(m.RedirectURIs) == 0 {
+		return "", errInvalidRedirectURI.WithHint("Clients using the authorization_code or implicit grant must register redirect_uris.")
+	}
+	for _, redirectURI := range m.RedirectURIs {
+		u, err := url.Parse(redirectURI)
+		if err != nil || !fosite.IsValidRedirectURI(u) || !config.GetRedirectSecureChecker(ctx)(ctx, u) {
+			return "", errInvalidRedirectURI.WithHintf("The redirect URI '%s' must be an absolute URL without a fragment, using https unless it points to localhost.", redirectURI)
+		}
+	}
+	for _, redirectURI := range m.PostLogoutRedirectURIs {
+		u, err := url.Parse(redirectURI)
+		if err != nil || !fosite.IsValidRedirectURI(u) || !config.GetRedirectSecureChecker(ctx)(ctx, u) {
+			return "", errInvalidClientMetadata.WithHintf("The post logout redirect URI '%s' must be an absolute URL without a fragment, using https unless it points to localhost.", redirectURI)
+		}
+	}
+
+	// Clients in ping and push mode are sent the notification token, and in push mode the tokens, like a redirect
+	// URI would be.
+	if fosite.Arguments(m.GrantTypes).Has(cibaGrantType) {
+		switch m.BackChannelTokenDeliveryMode {
+		case cibaPollMode:
+		case cibaPingMode, cibaPushMode:
+			u, err := url.Parse(m.BackChannelClientNotificationEndpoint)
+			if err != nil || !fosite.IsValidRedirectURI(u) || !config.GetRedirectSecureChecker(ctx)(ctx, u) {
+				return "", errInvalidClientMetadata.WithHintf("Clients using the %s mode must register a backchannel_client_notification_endpoint, an absolute URL without a fragment, using https unless it points to localhost.", m.BackChannelTokenDeliveryMode)
+			}
+		default:
+			return "", errInvalidClientMetadata.WithHintf("Clients using the grant type '%s' must register a backchannel_token_delivery_mode of '%s', '%s' or '%s'.", cibaGrantType, cibaPollMode, cibaPingMode, cibaPushMode)
+		}
+	}
+
+	for name, value := range map[string]string{
+		"client_uri":              m.ClientURI,
+		"logo_uri":                m.LogoURI,
+		"tos_uri":                 m.TOSURI,
+		"policy_uri":              m.PolicyURI,
+		"jwks_uri":                m.JWKSURI,
+		"frontchannel_logout_uri": m.FrontChannelLogoutURI,
+		"backchannel_logout_uri":  m.BackChannelLogoutURI,
+	} {
+		if value != "" && !isWebURL(value) {
+			return "", errInvalidClientMetadata.WithHintf("The %s '%s' must be an absolute http or https URL.", name, value)
+		}
+	}
+	if u, err := url.Parse(m.BackChannelLogoutURI); err == nil && u.Fragment != "" {
+		return "", errInvalidClientMetadata.WithHint("The backchannel_logout_uri must not contain a fragment.")
+	}
+	for _, requestURI := range m.RequestURIs {
+		if !isWebURL(requestURI) {
+			return "", errInvalidClientMetadata.WithHintf("The request URI '%s' must be an absolute http or https URL.", requestURI)
+		}
+	}
+
+	if m.JWKS != nil && m.JWKSURI != "" {
+		return "", errInvalidClientMetadata.WithHint("The jwks and jwks_uri parameters must not be used together.")
+	}
+	if m.JWKS != nil {
+		for _, key := range m.JWKS.Keys {
+			if !key.Valid() || !key.IsPublic() {
+				return "", errInvalidClientMetadata.WithHint("The jwks must only contain valid public keys.")
+			}
+		}
+	}
+	if alg := m.TokenEndpointAuthSigningAlg; alg != "" && !fosite.Arguments(doc.TokenEndpointAuthSigningAlgValuesSupported).Has(alg) {
+		return "", errInvalidClientMetadata.WithHintf("The token_endpoint_auth_signing_alg '%s' is not supported.", alg)
+	}
+	if alg := m.RequestObjectSigningAlg; alg != "" && !fosite.Arguments(doc.RequestObjectSigningAlgValuesSupported).Has(alg) {
+		return "", errInvalidClientMetadata.WithHintf("The request_object_signing_alg '%s' is not supported.", alg)
+	}
+	if m.AccessTokenStrategy != "" && m.AccessTokenStrategy != opaqueAccessTokens && m.AccessTokenStrategy != jwtAccessTokens {
+		return "", errInvalidClientMetadata.WithHintf("The access_token_strategy must be '%s' or '%s'.", opaqueAccessTokens, jwtAccessTokens)
+	}
+	if m.TLSClientAuthSANIP != "" && net.ParseIP(m.TLSClientAuthSANIP) == nil {
+		return "", errInvalidClientMetadata.WithHintf("The tls_client_auth_san_ip '%s' is not an IP address.", m.TLSClientAuthSANIP)
+	}
+
+	switch m.TokenEndpointAuthMethod {
+	case "none":
+		if fosite.Arguments(m.GrantTypes).Has("client_credentials") {
+			return "", errInvalidClientMetadata.WithHint("Clients using token_endpoint_auth_method 'none' can not use the client_credentials grant.")
+		}
+		if fosite.Arguments(m.GrantTypes).Has(cibaGrantType) {
+			return "", errInvalidClientMetadata.WithHintf("Clients using token_endpoint_auth_method 'none' can not use the grant type '%s'.", cibaGrantType)
+		}
+	case privateKeyJWT, selfSignedTLSClientAuth:
+		if m.JWKS == nil && m.JWKSURI == "" {
+			return "", errInvalidClientMetadata.WithHintf("Clients using token_endpoint_auth_method '%s' must register jwks or jwks_uri.", m.TokenEndpointAuthMethod)
+		}
+	case tlsClientAuth:
+		var n int
+		for _, value := range []string{m.TLSClientAuthSubjectDN, m.TLSClientAuthSANDNS, m.TLSClientAuthSANURI, m.TLSClientAuthSANIP, m.TLSClientAuthSANEmail} {
+			if value != "" {
+				n++
+			}
+		}
+		if n != 1 {
+			return "", errInvalidClientMetadata.WithHintf("Clients using token_endpoint_auth_method '%s' must register exactly one of the tls_client_auth_* parameters.", tlsClientAuth)
+		}
+	}
+
+	var secret string
+	switch m.TokenEndpointAuthMethod {
+	case "client_secret_basic", "client_secret_post":
+		if previous != nil && len(previous.Secrets) > 0 {
+			client.Secrets = previous.Secrets
+			break
+		}
+
+		var err error
+		if secret, err = randomToken(); err != nil {
+			return "", fosite.ErrServerError.WithWrap(err)
+		}
+		hash, err := config.GetSecretsHasher(ctx).Hash(ctx, []byte(secret))
+		if err != nil {
+			return "", fosite.ErrServerError.WithWrap(err)
+		}
+		client.Secrets = []sqlstore.ClientSecret{sqlstore.NewClientSecret(hash, nil)}
+	case clientSecretJWT:
+		if previous != nil && previous.AssertionSecret != "" {
+			client.AssertionSecret = previous.AssertionSecret
+			break
+		}
+
+		var err error
+		if secret, err = randomToken(); err != nil {
+			return "", fosite.ErrServerError.WithWrap(err)
+		}
+		client.AssertionSecret = secret
+	}
+
+	client.RedirectURIs = m.RedirectURIs
+	client.GrantTypes = m.GrantTypes
+	client.ResponseTypes = m.ResponseTypes
+	client.Scopes = scopes
+	client.Public = m.TokenEndpointAuthMethod == "none"
+	client.TokenEndpointAuthMethod = m.TokenEndpointAuthMethod
+	client.TokenEndpointAuthSigningAlgorithm = m.TokenEndpointAuthSigningAlg
+	client.JSONWebKeysURI = m.JWKSURI
+	client.JSONWebKeys = m.JWKS
+	client.RequestURIs = m.RequestURIs
+	client.RequestObjectSigningAlgorithm = m.RequestObjectSigningAlg
+	client.AccessTokenStrategy = m.AccessTokenStrategy
+	client.ClientName = m.ClientName
+	client.ClientURI = m.ClientURI
+	client.LogoURI = m.LogoURI
+	client.TOSURI = m.TOSURI
+	client.PolicyURI = m.PolicyURI
+	client.Contacts = m.Contacts
+	client.RequirePushedAuthorizationRequests = m.RequirePushedAuthorizationRequests
+	client.DPoPBoundAccessTokens = m.DPoPBoundAccessTokens
+	client.TLSClientAuthSubjectDN = m.TLSClientAuthSubjectDN
+	client.TLSClientAuthSANDNS = m.TLSClientAuthSANDNS
+	client.TLSClientAuthSANURI = m.TLSClientAuthSANURI
+	client.TLSClientAuthSANIP = m.TLSClientAuthSANIP
+	client.TLSClientAuthSANEmail = m.TLSClientAuthSANEmail
+	client.TLSClientCertificateBoundAccessTokens = m.TLSClientCertificateBoundAccessTokens
+	client.PostLogoutRedirectURIs = m.PostLogoutRedirectURIs
+	client.FrontChannelLogoutURI = m.FrontChannelLogoutURI
+	client.FrontChannelLogoutSessionRequired = m.FrontChannelLogoutSessionRequired
+	client.BackChannelLogoutURI = m.BackChannelLogoutURI
+	client.BackChannelLogoutSessionRequired = m.BackChannelLogoutSessionRequired
+	client.BackChannelTokenDeliveryMode = m.BackChannelTokenDeliveryMode
+	client.BackChannelClientNotificationEndpoint = m.BackChannelClientNotificationEndpoint
+	return secret, nil
+}
+
+// checkSelfRegistration rejects the grant types of a client registering itself other than selfRegisteredGrantTypes,
+// and the scopes the discovery document does not advertise, unless an administrator gave them to the client before.
+// Administrators may give clients any grant type and scope.
+func checkSelfRegistration(ctx context.Context, client *sqlstore.Client, previous *sqlstore.Client) error {
+	allowedGrantTypes := append(fosite.Arguments{}, selfRegisteredGrantTypes...)
+	allowedScopes := fosite.Arguments(newDiscoveryDocument(ctx).ScopesSupported)
+	if previous != nil {
+		allowedGrantTypes = append(allowedGrantTypes, previous.GrantTypes...)
+		allowedScopes = append(allowedScopes, previous.Scopes...)
+	}
+
+	for _, grantType := range client.GrantTypes {
+		if !allowedGrantTypes.Has(grantType) {
+			return errInvalidClientMetadata.WithHintf("The grant type '%s' is not available to registered clients.", grantType)
+		}
+	}
+	for _, scope := range client.Scopes {
+		if !allowedScopes.Has(scope) {
+			return errInvalidClientMetadata.WithHintf("The scope '%s' is not supported.", scope)
+		}
+	}
+	return nil
+}
+
+// isWebURL reports whether value is an absolute http or https URL.
+func isWebURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// clientMetadataOf returns the metadata of a client as it registered it.
+func clientMetadataOf(client *sqlstore.Client) clientMetadata {
+	return clientMetadata{
+		RedirectURIs:                          client.RedirectURIs,
+		TokenEndpointAuthMethod:               client.TokenEndpointAuthMethod,
+		TokenEndpointAuthSigningAlg:           client.TokenEndpointAuthSigningAlgorithm,
+		GrantTypes:                            client.GrantTypes,
+		ResponseTypes:                         client.ResponseTypes,
+		Scope:                                 strings.Join(client.Scopes, " "),
+		ClientName:                            client.ClientName,
+		ClientURI:                             client.ClientURI,
+		LogoURI:                               client.LogoURI,
+		TOSURI:                                client.TOSURI,
+		PolicyURI:                             client.PolicyURI,
+		Contacts:                              client.Contacts,
+		JWKSURI:                               client.JSONWebKeysURI,
+		JWKS:                                  client.JSONWebKeys,
+		RequestURIs:                           client.RequestURIs,
+		RequestObjectSigningAlg:               client.RequestObjectSigningAlgorithm,
+		AccessTokenStrategy:                   client.AccessTokenStrategy,
+		RequirePushedAuthorizationRequests:    client.RequirePushedAuthorizationRequests,
+		DPoPBoundAccessTokens:                 client.DPoPBoundAccessTokens,
+		TLSClientAuthSubjectDN:                client.TLSClientAuthSubjectDN,
+		TLSClientAuthSANDNS:                   client.TLSClientAuthSANDNS,
+		TLSClientAuthSANURI:                   client.TLSClientAuthSANURI,
+		TLSClientAuthSANIP:                    client.TLSClientAuthSANIP,
+		TLSClientAuthSANEmail:                 client.TLSClientAuthSANEmail,
+		TLSClientCertificateBoundAccessTokens: client.TLSClientCertificateBoundAccessTokens,
+		PostLogoutRedirectURIs:                client.PostLogoutRedirectURIs,
+		FrontChannelLogoutURI:                 client.FrontChannelLogoutURI,
+		FrontChannelLogoutSessionRequired:     client.FrontChannelLogoutSessionRequired,
+		BackChannelLogoutURI:                  client.BackChannelLogoutURI,
+		BackChannelLogoutSessionRequired:      client.BackChannelLogoutSessionRequired,
+		BackChannelTokenDeliveryMode:          client.BackChannelTokenDeliveryMode,
+		BackChannelClientNotificationEndpoint: client.BackChannelClientNotificationEndpoint,
+	}
+}
+
+// writeClientInformation writes the registration of the client. The secret is only known when it was just issued.
+func writeClientInformation(rw http.ResponseWriter, status int, client *sqlstore.Client, secret string, registrationAccessToken string) {
+	info := &clientInformation{
+		ClientID:                client.GetID(),
+		ClientSecret:            secret,
+		RegistrationAccessToken: registrationAccessToken,
+		RegistrationClientURI:   issuer + "/oauth2/register/" + url.PathEscape(client.GetID()),
+		clientMetadata:          clientMetadataOf(client),
+	}
+	if secret != "" {
+		// Secrets do not expire.
+		info.ClientSecretExpiresAt = new(int64)
+	}
+
+	rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Pragma", "no-cache")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(info); err != nil {
+		log.Printf("Error occurred in writeClientInformation: %+v", err)
+	}
+}
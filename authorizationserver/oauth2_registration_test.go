@@ -0,0 +1,164 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// useInitialAccessTokens enables client registration with the given tokens until the test ends.
+func useInitialAccessTokens(t *testing.T, tokens ...string) {
+	previous := initialAccessTokens
+	initialAccessTokens = tokens
+	t.Cleanup(func() { initialAccessTokens = previous })
+}
+
+// registerTestClient posts the metadata to the registration endpoint, with the initial access token if any.
+func registerTestClient(token string, metadata string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/register", strings.NewReader(metadata))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rw := httptest.NewRecorder()
+	registrationEndpoint(rw, req)
+	return rw
+}
+
+func TestRegistrationDisabled(t *testing.T) {
+	useTestStore(t)
+	useInitialAccessTokens(t)
+
+	if rw := registerTestClient("", `{"redirect_uris":["http://localhost:8080/callback"]}`); rw.Code != http.StatusNotFound {
+		t.Errorf("registering without initial access tokens configured returned %d: %s", rw.Code, rw.Body)
+	}
+	if doc := newDiscoveryDocument(context.Background()); doc.RegistrationEndpoint != "" {
+		t.Errorf("the discovery document advertises the registration endpoint %s", doc.RegistrationEndpoint)
+	}
+}
+
+func TestRegistrationEndpoint(t *testing.T) {
+	useInitialAccessTokens(t, "initial-token")
+
+	for _, tc := range []struct {
+		name       string
+		token      string
+		metadata   string
+		status     int
+		grantTypes []string
+		scopes     []string
+	}{
+		{name: "without initial access token", metadata: `{"redirect_uris":["http://localhost:8080/callback"]}`, status: http.StatusUnauthorized},
+		{name: "wrong initial access token", token: "wrong", metadata: `{"redirect_uris":["http://localhost:8080/callback"]}`, status: http.StatusUnauthorized},
+		{name: "defaults", token: "initial-token", metadata: `{"redirect_uris":["http://localhost:8080/callback"]}`, status: http.StatusCreated, grantTypes: []string{"authorization_code"}, scopes: []string{"openid"}},
+		{name: "device", token: "initial-token", metadata: `{"grant_types":["` + deviceCodeGrantType + `","refresh_token"],"token_endpoint_auth_method":"none","scope":"openid offline profile"}`, status: http.StatusCreated, grantTypes: []string{deviceCodeGrantType, "refresh_token"}, scopes: []string{"openid", "offline", "profile"}},
+		{name: "client_credentials", token: "initial-token", metadata: `{"grant_types":["client_credentials"]}`, status: http.StatusBadRequest},
+		{name: "CIBA", token: "initial-token", metadata: `{"grant_types":["` + cibaGrantType + `"],"backchannel_token_delivery_mode":"poll"}`, status: http.StatusBadRequest},
+		{name: "JWT bearer", token: "initial-token", metadata: `{"grant_types":["` + jwtBearerGrantType + `"]}`, status: http.StatusBadRequest},
+		{name: "implicit", token: "initial-token", metadata: `{"grant_types":["implicit"],"response_types":["id_token"],"redirect_uris":["http://localhost:8080/callback"]}`, status: http.StatusBadRequest},
+		{name: "scope not advertised", token: "initial-token", metadata: `{"redirect_uris":["http://localhost:8080/callback"],"scope":"openid photos"}`, status: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := useTestStore(t)
+			rw := registerTestClient(tc.token, tc.metadata)
+			if rw.Code != tc.status {
+				t.Fatalf("got status %d, want %d: %s", rw.Code, tc.status, rw.Body)
+			}
+			if tc.status != http.StatusCreated {
+				return
+			}
+
+			var info clientInformation
+			if err := json.Unmarshal(rw.Body.Bytes(), &info); err != nil {
+				t.Fatalf("decoding the client information: %v", err)
+			}
+			client, err := s.GetClient(context.Background(), info.ClientID)
+			if err != nil {
+				t.Fatalf("GetClient: %v", err)
+			}
+			if got := client.GetGrantTypes(); len(got) != len(tc.grantTypes) || !got.Has(tc.grantTypes...) {
+				t.Errorf("the client has grant types %v, want %v", got, tc.grantTypes)
+			}
+			if got := client.GetScopes(); len(got) != len(tc.scopes) || !got.Has(tc.scopes...) {
+				t.Errorf("the client has scopes %v, want %v", got, tc.scopes)
+			}
+		})
+	}
+}
+
+func TestRegistrationUpdateKeepsAdministratorSettings(t *testing.T) {
+	ctx := context.Background()
+	useInitialAccessTokens(t, "initial-token")
+
+	// An administrator gave a registered client the client_credentials grant and a scope of its own.
+	client := sqlstore.NewClient("registered-client")
+	client.GrantTypes = []string{"client_credentials"}
+	client.Scopes = []string{"photos"}
+	client.TokenEndpointAuthMethod = "none"
+	client.RegistrationAccessTokenHash = registrationAccessTokenHash("registration-token")
+	useTestStore(t, client)
+
+	req := httptest.NewRequest(http.MethodPut, "/oauth2/register/registered-client", strings.NewReader(`{"client_id":"registered-client","grant_types":["client_credentials","refresh_token"],"scope":"photos openid"}`))
+	req.Header.Set("Authorization", "Bearer registration-token")
+	rw := httptest.NewRecorder()
+	registrationManagementEndpoint(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("updating the registration returned %d: %s", rw.Code, rw.Body)
+	}
+
+	// It may not add the grant types it could not register itself, though.
+	req = httptest.NewRequest(http.MethodPut, "/oauth2/register/registered-client", strings.NewReader(`{"client_id":"registered-client","grant_types":["client_credentials","`+jwtBearerGrantType+`"],"scope":"photos"}`))
+	req.Header.Set("Authorization", "Bearer registration-token")
+	rw = httptest.NewRecorder()
+	registrationManagementEndpoint(rw, req)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("adding the JWT bearer grant returned %d: %s", rw.Code, rw.Body)
+	}
+	if updated, err := store.GetClient(ctx, "registered-client"); err != nil || updated.GetGrantTypes().Has(jwtBearerGrantType) {
+		t.Errorf("GetClient() = %v, %v", updated, err)
+	}
+}
+
+func TestRegistrationDeleteRevokesTokens(t *testing.T) {
+	ctx := context.Background()
+	useInitialAccessTokens(t, "initial-token")
+	s := useTestStore(t)
+
+	rw := registerTestClient("initial-token", `{"grant_types":["`+deviceCodeGrantType+`","refresh_token"],"token_endpoint_auth_method":"none","scope":"offline"}`)
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("registering returned %d: %s", rw.Code, rw.Body)
+	}
+	var info clientInformation
+	if err := json.Unmarshal(rw.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decoding the client information: %v", err)
+	}
+	client, err := s.GetClient(ctx, info.ClientID)
+	if err != nil {
+		t.Fatalf("GetClient: %v", err)
+	}
+	refreshToken := newTestRefreshToken(t, s, client.(*sqlstore.Client))
+
+	req := httptest.NewRequest(http.MethodDelete, "/oauth2/register/"+info.ClientID, nil)
+	req.Header.Set("Authorization", "Bearer "+info.RegistrationAccessToken)
+	rw = httptest.NewRecorder()
+	registrationManagementEndpoint(rw, req)
+	if rw.Code != http.StatusNoContent {
+		t.Fatalf("deleting the registration returned %d: %s", rw.Code, rw.Body)
+	}
+
+	// Even a client that takes over the ID can not use the tokens.
+	if err := s.CreateClient(ctx, newRefreshClient(info.ClientID)); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	if _, err := s.GetRefreshTokenSession(ctx, testTokenStrategy.RefreshTokenSignature(ctx, refreshToken), newSession("")); !errors.Is(err, fosite.ErrNotFound) {
+		t.Errorf("GetRefreshTokenSession() = %v, want fosite.ErrNotFound", err)
+	}
+}
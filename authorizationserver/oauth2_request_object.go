@@ -0,0 +1,311 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite"
+)
+
+const (
+	// maxRequestObjectSize limits how much is read from a `request_uri`.
+	maxRequestObjectSize = 64 << 10
+
+	// maxRequestObjectLifespan is how far in the future the `exp` claim of a request object may be. Like the `jti` of
+	// client assertions, the `jti` of every request object is remembered until it expires.
+	maxRequestObjectLifespan = time.Hour
+)
+
+var (
+	// requestObjectSigningAlgs are the algorithms request objects may be signed with. Unsigned request objects, and
+	// symmetric algorithms which would need the client secret in the clear, are rejected.
+	requestObjectSigningAlgs = []string{
+		string(jose.RS256), string(jose.RS384), string(jose.RS512),
+		string(jose.PS256), string(jose.PS384), string(jose.PS512),
+		string(jose.ES256), string(jose.ES384), string(jose.ES512),
+	}
+
+	// requestObjectEncryptionAlgs and requestObjectEncryptionEncs are the algorithms request objects may be
+	// encrypted with, to the encryption keys published at `/.well-known/jwks.json`.
+	requestObjectEncryptionAlgs = []string{string(jose.RSA_OAEP), string(jose.RSA_OAEP_256)}
+	requestObjectEncryptionEncs = []string{
+		string(jose.A128CBC_HS256), string(jose.A192CBC_HS384), string(jose.A256CBC_HS512),
+		string(jose.A128GCM), string(jose.A192GCM), string(jose.A256GCM),
+	}
+)
+
+// requestObjectClaims are the claims of a request object that describe the object itself. They are checked, but not
+// copied into the authorize request.
+var requestObjectClaims = []string{"iss", "aud", "exp", "nbf", "iat", "jti"}
+
+// resolveRequestObject handles JWT-secured authorization requests (RFC 9101). If the request carries a `request`
+// object, or a `request_uri` registered by the client, the object is decrypted if needed, its signature is checked
+// against the keys of the client and its parameters are copied into the form, taking precedence over the query. The
+// `request` and `request_uri` parameters are removed, so fosite sees a plain authorize request. The `request_uri` of
+// pushed authorization requests is left alone, fosite resolves it.
+func resolveRequestObject(ctx context.Context, req *http.Request) error {
+	if err := req.ParseForm(); err != nil {
+		return fosite.ErrInvalidRequest.WithHint("Unable to parse the request.").WithWrap(err).WithDebug(err.Error())
+	}
+
+	object, location := req.Form.Get("request"), req.Form.Get("request_uri")
+	if location != "" && strings.HasPrefix(location, config.GetPushedAuthorizeRequestURIPrefix(ctx)) {
+		return nil
+	} else if object == "" && location == "" {
+		return nil
+	} else if object != "" && location != "" {
+		return fosite.ErrInvalidRequest.WithHint("The 'request' and 'request_uri' parameters were both given, but you can use at most one.")
+	}
+
+	clientID := req.Form.Get("client_id")
+	if clientID == "" {
+		return fosite.ErrInvalidRequest.WithHint("The 'client_id' parameter is required next to a request object.")
+	}
+	client, err := store.GetClient(ctx, clientID)
+	if err != nil {
+		return fosite.ErrInvalidClient.WithHint("The requested OAuth 2.0 Client does not exist.").WithWrap(err).WithDebug(err.Error())
+	}
+	oidcClient, ok := client.(fosite.OpenIDConnectClient)
+	if !ok {
+		return fosite.ErrRequestNotSupported.WithHint("The OAuth 2.0 Client does not support request objects.")
+	}
+
+	if location != "" {
+		if !fosite.Arguments(oidcClient.GetRequestURIs()).Has(location) {
+			return fosite.ErrInvalidRequestURI.WithHintf("Request URI '%s' is not registered by the OAuth 2.0 Client.", location)
+		}
+		if object, err = fetchRequestObject(ctx, location); err != nil {
+			return fosite.ErrInvalidRequestURI.WithHintf("Unable to fetch the request object from '%s'.", location).WithWrap(err).WithDebug(err.Error())
+		}
+	}
+
+	claims, err := verifyRequestObject(ctx, clientID, oidcClient, object)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range claims {
+		if fosite.Arguments(requestObjectClaims).Has(key) {
+			continue
+		}
+		v, err := requestObjectFormValue(value)
+		if err != nil {
+			return fosite.ErrInvalidRequestObject.WithHintf("The request object claim '%s' can not be used.", key).WithWrap(err).WithDebug(err.Error())
+		}
+		req.Form.Set(key, v)
+	}
+	req.Form.Del("request")
+	req.Form.Del("request_uri")
+	return nil
+}
+
+// fetchRequestObject loads a request object from a `request_uri` the client registered.
+func fetchRequestObject(ctx context.Context, location string) (string, error) {
+	response, err := config.GetHTTPClient(ctx).Get(location)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("expected status code %d but got %d", http.StatusOK, response.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxRequestObjectSize))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// verifyRequestObject decrypts the request object if it is encrypted, checks its signature and claims and returns
+// them.
+func verifyRequestObject(ctx context.Context, clientID string, client fosite.OpenIDConnectClient, object string) (map[string]interface{}, error) {
+	// A JWE has five parts, a JWS three. Encrypted request objects are nested, the plaintext is a signed request
+	// object.
+	if strings.Count(object, ".") == 4 {
+		plaintext, err := decryptRequestObject(object)
+		if err != nil {
+			return nil, fosite.ErrInvalidRequestObject.WithHint("Unable to decrypt the request object.").WithWrap(err).WithDebug(err.Error())
+		}
+		object = plaintext
+	}
+
+	jws, err := jose.ParseSigned(object)
+	if err != nil {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("The request object is not a signed JWT.").WithWrap(err).WithDebug(err.Error())
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("The request object must have exactly one signature.")
+	}
+
+	header := jws.Signatures[0].Header
+	if !fosite.Arguments(requestObjectSigningAlgs).Has(header.Algorithm) {
+		return nil, fosite.ErrInvalidRequestObject.WithHintf("The request object uses unsupported signing algorithm '%s'.", header.Algorithm)
+	}
+	if alg := client.GetRequestObjectSigningAlgorithm(); alg != "" && alg != header.Algorithm {
+		return nil, fosite.ErrInvalidRequestObject.WithHintf("The request object uses signing algorithm '%s', but the OAuth 2.0 Client enforces signing algorithm '%s'.", header.Algorithm, alg)
+	}
+
+	payload, err := verifyWithClientJWKS(ctx, client, jws)
+	if err != nil {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("Unable to verify the signature of the request object.").WithWrap(err).WithDebug(err.Error())
+	}
+
+	claims, err := decodeClaims(payload)
+	if err != nil {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("The request object payload is not a JSON object.").WithWrap(err).WithDebug(err.Error())
+	}
+
+	if err := validateRequestObjectClaims(ctx, clientID, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validateRequestObjectClaims checks that the request object was issued by the client for this server, is valid now
+// and was not used before. A request object that leaked from the browser can not be replayed.
+func validateRequestObjectClaims(ctx context.Context, clientID string, claims map[string]interface{}) error {
+	if iss, _ := claims["iss"].(string); iss != clientID {
+		return fosite.ErrInvalidRequestObject.WithHint("The 'iss' claim of the request object must be the client ID.")
+	}
+	if id, ok := claims["client_id"]; ok && id != clientID {
+		return fosite.ErrInvalidRequestObject.WithHint("The 'client_id' claim of the request object must match the 'client_id' parameter.")
+	}
+
+	if !fosite.Arguments(audienceClaim(claims)).Has(issuer) {
+		return fosite.ErrInvalidRequestObject.WithHintf("The 'aud' claim of the request object must contain '%s'.", issuer)
+	}
+
+	now := time.Now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return fosite.ErrInvalidRequestObject.WithHint("The 'exp' claim of the request object must be set.")
+	} else if !now.Before(exp) {
+		return fosite.ErrInvalidRequestObject.WithHint("The request object has expired.")
+	} else if exp.After(now.Add(maxRequestObjectLifespan)) {
+		return fosite.ErrInvalidRequestObject.WithHintf("The 'exp' claim of the request object must be within %s from now.", maxRequestObjectLifespan)
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Before(nbf) {
+		return fosite.ErrInvalidRequestObject.WithHint("The request object is not valid yet.")
+	}
+
+	if _, ok := claims["request"]; ok {
+		return fosite.ErrInvalidRequestObject.WithHint("The request object must not contain the 'request' parameter.")
+	}
+	if _, ok := claims["request_uri"]; ok {
+		return fosite.ErrInvalidRequestObject.WithHint("The request object must not contain the 'request_uri' parameter.")
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return fosite.ErrInvalidRequestObject.WithHint("The 'jti' claim of the request object must be set.")
+	}
+	if err := store.SetClientAssertionJWT(ctx, "request_object:"+clientID+":"+jti, exp); errors.Is(err, fosite.ErrJTIKnown) {
+		return fosite.ErrInvalidRequestObject.WithHint("The request object was used before, its 'jti' claim must be unique.")
+	} else if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+	return nil
+}
+
+// decryptRequestObject decrypts a request object with the encryption key. A `kid` header, if any, must name it.
+func decryptRequestObject(object string) (string, error) {
+	jwe, err := jose.ParseEncrypted(object)
+	if err != nil {
+		return "", err
+	}
+
+	if !fosite.Arguments(requestObjectEncryptionAlgs).Has(jwe.Header.Algorithm) {
+		return "", fmt.Errorf("unsupported key management algorithm %q", jwe.Header.Algorithm)
+	}
+	if enc, _ := jwe.Header.ExtraHeaders[jose.HeaderKey("enc")].(string); !fosite.Arguments(requestObjectEncryptionEncs).Has(enc) {
+		return "", fmt.Errorf("unsupported content encryption algorithm %q", enc)
+	}
+
+	if kid := jwe.Header.KeyID; kid != "" && kid != requestObjectKey.ID {
+		return "", fmt.Errorf("%w: %s", errUnknownKey, kid)
+	}
+
+	plaintext, err := jwe.Decrypt(requestObjectKey.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// clientJWKS returns the keys the client registered, either inline or by `jwks_uri`. The keys behind a `jwks_uri`
+// are cached, refresh ignores the cache.
+func clientJWKS(ctx context.Context, client fosite.OpenIDConnectClient, refresh bool) (*jose.JSONWebKeySet, error) {
+	if set := client.GetJSONWebKeys(); set != nil {
+		return set, nil
+	}
+
+	if location := client.GetJSONWebKeysURI(); location != "" {
+		set, err := config.GetJWKSFetcherStrategy(ctx).Resolve(ctx, location, refresh)
+		if err != nil {
+			return nil, fosite.ErrInvalidClient.WithHintf("Unable to fetch the JSON Web Keys of the OAuth 2.0 Client from '%s'.", location).WithWrap(err).WithDebug(err.Error())
+		}
+		return set, nil
+	}
+
+	return nil, fosite.ErrInvalidClient.WithHint("The OAuth 2.0 Client has no JSON Web Keys registered, but they are needed to complete the request.")
+}
+
+// verifyWithClientKeys checks the signature against the key named by the `kid` header, or against every signing key
+// of the set if there is no `kid`.
+func verifyWithClientKeys(jws *jose.JSONWebSignature, set *jose.JSONWebKeySet) ([]byte, error) {
+	header := jws.Signatures[0].Header
+
+	candidates := set.Keys
+	if header.KeyID != "" {
+		candidates = set.Key(header.KeyID)
+	}
+
+	for _, key := range candidates {
+		if key.Use == "enc" || (key.Algorithm != "" && key.Algorithm != header.Algorithm) {
+			continue
+		}
+		if payload, err := jws.Verify(key); err == nil {
+			return payload, nil
+		}
+	}
+	return nil, errors.New("no registered key verifies the signature")
+}
+
+// requestObjectFormValue turns a claim of a request object into a form value. Structured claims, like `claims`, are
+// passed on as JSON.
+func requestObjectFormValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		raw, err := json.Marshal(v)
+		return string(raw), err
+	}
+}
+
+// numericDate reads a JWT NumericDate claim.
+func numericDate(value interface{}) (time.Time, bool) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
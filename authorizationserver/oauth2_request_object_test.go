@@ -0,0 +1,159 @@
+package authorizationserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+func TestDecryptRequestObject(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		key   jose.JSONWebKey
+		valid bool
+	}{
+		{name: "encryption key", key: requestObjectKey.PublicJWK(), valid: true},
+		{name: "encryption key without kid", key: jose.JSONWebKey{Key: requestObjectKey.PublicJWK().Key}, valid: true},
+		{name: "signing key", key: keys.Active().PublicJWK()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			encrypter, err := jose.NewEncrypter(jose.A128CBC_HS256, jose.Recipient{Algorithm: jose.RSA_OAEP_256, Key: tc.key.Key, KeyID: tc.key.KeyID}, nil)
+			if err != nil {
+				t.Fatalf("NewEncrypter: %v", err)
+			}
+			jwe, err := encrypter.Encrypt([]byte("signed.request.object"))
+			if err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+			object, err := jwe.CompactSerialize()
+			if err != nil {
+				t.Fatalf("CompactSerialize: %v", err)
+			}
+
+			plaintext, err := decryptRequestObject(object)
+			if tc.valid && (err != nil || plaintext != "signed.request.object") {
+				t.Errorf("decryptRequestObject() = %q, %v", plaintext, err)
+			} else if !tc.valid && err == nil {
+				t.Error("decryptRequestObject() decrypted a request object encrypted to a signing key")
+			}
+		})
+	}
+}
+
+// newTestRequestObject signs an authorize request of the client "request-object-client" with the key. The claims
+// override the defaults, a nil claim is left out.
+func newTestRequestObject(t *testing.T, key *rsa.PrivateKey, overrides map[string]interface{}) string {
+	t.Helper()
+
+	claims := map[string]interface{}{
+		"iss":           "request-object-client",
+		"aud":           issuer,
+		"exp":           time.Now().Add(5 * time.Minute).Unix(),
+		"jti":           uuid.New().String(),
+		"client_id":     "request-object-client",
+		"response_type": "code",
+		"redirect_uri":  "http://localhost:8080/callback",
+		"scope":         "openid",
+		"state":         "some-state-value",
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return signTestAssertion(t, jose.RS256, key, "key-1", claims)
+}
+
+// newRequestObjectClient returns a client that signs its request objects with the key.
+func newRequestObjectClient(t *testing.T, key *rsa.PrivateKey) *sqlstore.Client {
+	client := sqlstore.NewClient("request-object-client")
+	client.RedirectURIs = []string{"http://localhost:8080/callback"}
+	client.ResponseTypes = []string{"code"}
+	client.GrantTypes = []string{"authorization_code"}
+	client.Scopes = []string{"openid"}
+	client.JSONWebKeysURI = newTestJWKS(t, key, "key-1")
+	return client
+}
+
+func TestVerifyRequestObject(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	client := newRequestObjectClient(t, key)
+	useTestStore(t, client)
+
+	// The cases run in order, the replayed object is used for the first time by the case before.
+	replayed := newTestRequestObject(t, key, nil)
+
+	for _, tc := range []struct {
+		name   string
+		object string
+		valid  bool
+	}{
+		{name: "valid", object: newTestRequestObject(t, key, nil), valid: true},
+		{name: "iss of another client", object: newTestRequestObject(t, key, map[string]interface{}{"iss": "my-client"})},
+		{name: "foreign aud", object: newTestRequestObject(t, key, map[string]interface{}{"aud": "https://other.example.com"})},
+		{name: "expired", object: newTestRequestObject(t, key, map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()})},
+		{name: "no exp", object: newTestRequestObject(t, key, map[string]interface{}{"exp": nil})},
+		{name: "exp too far ahead", object: newTestRequestObject(t, key, map[string]interface{}{"exp": time.Now().Add(2 * maxRequestObjectLifespan).Unix()})},
+		{name: "not valid yet", object: newTestRequestObject(t, key, map[string]interface{}{"nbf": time.Now().Add(time.Minute).Unix()})},
+		{name: "no jti", object: newTestRequestObject(t, key, map[string]interface{}{"jti": nil})},
+		{name: "first use of a jti", object: replayed, valid: true},
+		{name: "replayed jti", object: replayed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := verifyRequestObject(ctx, "request-object-client", client, tc.object)
+			if tc.valid && err != nil {
+				t.Errorf("verifyRequestObject() = %v", fosite.ErrorToRFC6749Error(err).GetDescription())
+			} else if !tc.valid && err == nil {
+				t.Error("verifyRequestObject() accepted the request object")
+			}
+		})
+	}
+}
+
+func TestRequestObjectLoginPage(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	useTestStore(t, newRequestObjectClient(t, key))
+
+	// The login page does not post the request object again, it could only be used once. It posts to a pushed copy of
+	// the request instead.
+	target := "/oauth2/auth?" + url.Values{"client_id": {"request-object-client"}, "request": {newTestRequestObject(t, key, nil)}}.Encode()
+	page := authorizeTestRequest(http.MethodGet, target, nil)
+	match := pageActionField.FindStringSubmatch(page.Body.String())
+	if match == nil {
+		t.Fatalf("no login page is shown: %d %s", page.Code, page.Body)
+	}
+	action := html.UnescapeString(match[1])
+	if !strings.Contains(action, "request_uri="+url.QueryEscape(config.GetPushedAuthorizeRequestURIPrefix(context.Background()))) {
+		t.Fatalf("the login page posts to %q, want a pushed request", action)
+	}
+
+	if rw := authorizeTestRequest(http.MethodGet, target, nil); rw.Code == http.StatusOK {
+		t.Errorf("the request object was accepted twice: %s", rw.Body)
+	}
+
+	rw := authorizeTestRequest(http.MethodPost, action, url.Values{"username": {"peter"}, "password": {"secret"}})
+	if !loginTokenField.MatchString(rw.Body.String()) {
+		t.Errorf("logging in at the pushed request does not show the consent page: %d %s", rw.Code, rw.Body)
+	}
+}
@@ -0,0 +1,14 @@
+package authorizationserver
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain sets the server up like main.go does, which installs the client authentication strategy as well. The
+// database is kept in memory, tests do not leave a file behind.
+func TestMain(m *testing.M) {
+	os.Setenv("DATABASE_DSN", "file::memory:")
+	RegisterHandlers()
+	os.Exit(m.Run())
+}
@@ -1,8 +1,14 @@
 package authorizationserver
 
 import (
+	"errors"
 	"log"
 	"net/http"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/dpop"
+	"github.com/ory/fosite-example/sqlstore"
 )
 
 func tokenEndpoint(rw http.ResponseWriter, req *http.Request) {
@@ -12,6 +18,14 @@ func tokenEndpoint(rw http.ResponseWriter, req *http.Request) {
 	// Create an empty session object which will be passed to the request handlers
 	mySessionData := newSession("")
 
+	// A DPoP proof binds the issued access token to the client's key, see RFC 9449.
+	proof, err := checkDPoPProof(rw, req, "")
+	if err != nil {
+		log.Printf("Error occurred in checkDPoPProof: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, fosite.NewAccessRequest(mySessionData), err)
+		return
+	}
+
 	// This will create an access request object and iterate through the registered TokenEndpointHandlers to validate the request.
 	accessRequest, err := oauth2.NewAccessRequest(ctx, req, mySessionData)
 
@@ -21,6 +35,19 @@ func tokenEndpoint(rw http.ResponseWriter, req *http.Request) {
 	// * ...
 	if err != nil {
 		log.Printf("Error occurred in NewAccessRequest: %+v", err)
+
+		// A rotated refresh token was used again, fosite revoked all tokens of its family.
+		var reused *sqlstore.RefreshTokenReusedError
+		if errors.As(err, &reused) {
+			emitSecurityEvent(ctx, securityEvent{
+				Type:       eventRefreshTokenReused,
+				ClientID:   reused.ClientID,
+				Subject:    reused.Subject,
+				FamilyID:   reused.FamilyID,
+				RemoteAddr: req.RemoteAddr,
+			})
+		}
+
 		oauth2.WriteAccessError(ctx, rw, accessRequest, err)
 		return
 	}
@@ -34,6 +61,27 @@ func tokenEndpoint(rw http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	// Grant the audience the tokens are meant for, or narrow it down to the `resource` parameters, see RFC 8707.
+	if err := grantResources(ctx, accessRequest, accessRequest.GetRequestForm()); err != nil {
+		log.Printf("Error occurred in grantResources: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, accessRequest, err)
+		return
+	}
+
+	// Bind the tokens to the DPoP key and the client certificate of the request. The handlers above replaced the
+	// session with the stored one of the code or refresh token, so the binding is set on the session the new tokens
+	// are issued with.
+	if err := bindDPoPProof(accessRequest, proof); err != nil {
+		log.Printf("Error occurred in bindDPoPProof: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, accessRequest, err)
+		return
+	}
+	if err := bindClientCertificate(accessRequest, req); err != nil {
+		log.Printf("Error occurred in bindClientCertificate: %+v", err)
+		oauth2.WriteAccessError(ctx, rw, accessRequest, err)
+		return
+	}
+
 	// Next we create a response for the access request. Again, we iterate through the TokenEndpointHandlers
 	// and aggregate the result in response.
 	response, err := oauth2.NewAccessResponse(ctx, accessRequest)
@@ -43,6 +91,10 @@ func tokenEndpoint(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if proof != nil {
+		response.SetTokenType(dpop.TokenType)
+	}
+
 	// All done, send the response.
 	oauth2.WriteAccessResponse(ctx, rw, accessRequest, response)
 
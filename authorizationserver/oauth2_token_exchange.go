@@ -0,0 +1,287 @@
+package authorizationserver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+	fositeoauth2 "github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/token/jwt"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// tokenExchangeGrantType is the grant type of RFC 8693.
+const tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// The token type identifiers of section 3 of RFC 8693 this server understands. Access tokens are the only tokens it
+// issues by token exchange.
+const (
+	accessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+	idTokenType     = "urn:ietf:params:oauth:token-type:id_token"
+)
+
+// errInvalidTarget is the error of section 2 of RFC 8707, fosite does not define it.
+var errInvalidTarget = &fosite.RFC6749Error{
+	ErrorField:       "invalid_target",
+	DescriptionField: "The requested resource is invalid, missing, unknown, or malformed.",
+	CodeField:        http.StatusBadRequest,
+}
+
+// exchangedToken is what the token exchange grant knows about a subject or actor token.
+type exchangedToken struct {
+	Subject string
+	// Clients are the client the token was issued to and the clients named as its audience.
+	Clients   []string
+	Scopes    fosite.Arguments
+	ExpiresAt time.Time
+	// Act is the `act` claim of a token that was obtained by delegation before.
+	Act map[string]interface{}
+}
+
+// tokenExchangeHandler is the token endpoint handler of the token exchange grant. It accepts access tokens and ID
+// tokens issued by this server and issues access tokens for another audience. What a client may exchange is set by
+// the token exchange policy of the client.
+type tokenExchangeHandler struct {
+	Store    *exampleStore
+	Strategy fositeoauth2.CoreStrategy
+	Signer   jwt.Signer
+	Config   fosite.Configurator
+}
+
+// tokenExchangeFactory creates the token exchange grant handler, to be passed to `compose.Compose`.
+func tokenExchangeFactory(config fosite.Configurator, storage interface{}, strategy interface{}) interface{} {
+	return &tokenExchangeHandler{
+		Store:    storage.(*exampleStore),
+		Strategy: strategy.(fositeoauth2.CoreStrategy),
+		Signer:   strategy.(jwt.Signer),
+		Config:   config,
+	}
+}
+
+func (h *tokenExchangeHandler) HandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	client := requester.GetClient()
+	if !client.GetGrantTypes().Has(tokenExchangeGrantType) {
+		return fosite.ErrUnauthorizedClient.WithHintf("The OAuth 2.0 Client is not allowed to use authorization grant '%s'.", tokenExchangeGrantType)
+	}
+	c, ok := client.(*sqlstore.Client)
+	if !ok || c.TokenExchange == nil {
+		return fosite.ErrUnauthorizedClient.WithHint("The OAuth 2.0 Client has no token exchange policy.")
+	}
+	policy := c.TokenExchange
+
+	form := requester.GetRequestForm()
+	if tokenType := form.Get("requested_token_type"); tokenType != "" && tokenType != accessTokenType {
+		return fosite.ErrInvalidRequest.WithHintf("The requested token type '%s' is not supported, only access tokens are issued.", tokenType)
+	}
+
+	subject, err := h.validateToken(ctx, "subject", form.Get("subject_token"), form.Get("subject_token_type"))
+	if err != nil {
+		return err
+	}
+	if !issuedTo(subject, client.GetID()) && !fosite.Arguments(subject.Clients).HasOneOf(policy.SubjectClients...) {
+		return fosite.ErrInvalidRequest.WithHint("The OAuth 2.0 Client is not allowed to exchange the subject token.")
+	}
+
+	// With an actor token the client acts on behalf of the subject, the actor is recorded in the `act` claim. Earlier
+	// actors are nested inside, the most recent actor comes first.
+	act := subject.Act
+	if actorToken := form.Get("actor_token"); actorToken != "" {
+		if !policy.Delegation {
+			return fosite.ErrInvalidRequest.WithHint("The OAuth 2.0 Client is not allowed to act on behalf of the subject.")
+		}
+
+		actor, err := h.validateToken(ctx, "actor", actorToken, form.Get("actor_token_type"))
+		if err != nil {
+			return err
+		}
+		if !issuedTo(actor, client.GetID()) {
+			return fosite.ErrInvalidRequest.WithHint("The actor token was not issued to the OAuth 2.0 Client.")
+		}
+
+		act = map[string]interface{}{"sub": actor.Subject}
+		if subject.Act != nil {
+			act["act"] = subject.Act
+		}
+	} else if form.Get("actor_token_type") != "" {
+		return fosite.ErrInvalidRequest.WithHint("The 'actor_token_type' parameter must only be sent with 'actor_token'.")
+	} else if !policy.Impersonation {
+		return fosite.ErrInvalidRequest.WithHint("The OAuth 2.0 Client is not allowed to impersonate the subject, send an 'actor_token'.")
+	}
+
+	// The new token is always for a target the policy lists, `resource` is treated like `audience`.
+	targets := append(requester.GetRequestedAudience(), form["resource"]...)
+	if len(targets) == 0 {
+		return fosite.ErrInvalidRequest.WithHint("The 'audience' or 'resource' parameter is missing.")
+	}
+	if err := h.Config.GetAudienceStrategy(ctx)(policy.Audiences, targets); err != nil {
+		return errInvalidTarget.WithHint("The OAuth 2.0 Client is not allowed to exchange tokens for the requested audience.").WithWrap(err).WithDebug(err.Error())
+	}
+	for _, target := range targets {
+		requester.GrantAudience(target)
+	}
+
+	// Tokens can only be narrowed down. Without a `scope` parameter the new token keeps the scopes of the subject
+	// token the client may request.
+	scopes, explicit := requester.GetRequestedScopes(), true
+	if len(scopes) == 0 {
+		scopes, explicit = subject.Scopes, false
+	}
+	for _, scope := range scopes {
+		if subject.Scopes.Has(scope) && h.Config.GetScopeStrategy(ctx)(client.GetScopes(), scope) {
+			requester.GrantScope(scope)
+		} else if explicit {
+			return fosite.ErrInvalidScope.WithHintf("The scope '%s' was not granted to the subject token or is not allowed for the OAuth 2.0 Client.", scope)
+		}
+	}
+
+	session, ok := requester.GetSession().(*Session)
+	if !ok {
+		return fosite.ErrServerError.WithDebug("The session of the token exchange grant must be a *Session.")
+	}
+	session.Subject = subject.Subject
+	session.Claims.Subject = subject.Subject
+	if act != nil {
+		session.GetExtraClaims()["act"] = act
+	}
+
+	// The new token never outlives the subject token.
+	atLifespan := fosite.GetEffectiveLifespan(client, tokenExchangeGrantType, fosite.AccessToken, h.Config.GetAccessTokenLifespan(ctx))
+	expiresAt := time.Now().UTC().Add(atLifespan).Round(time.Second)
+	if !subject.ExpiresAt.IsZero() && subject.ExpiresAt.Before(expiresAt) {
+		expiresAt = subject.ExpiresAt
+	}
+	session.SetExpiresAt(fosite.AccessToken, expiresAt)
+
+	return nil
+}
+
+func (h *tokenExchangeHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	access, accessSignature, err := h.Strategy.GenerateAccessToken(ctx, requester)
+	if err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+	if err := h.Store.CreateAccessTokenSession(ctx, accessSignature, requester.Sanitize([]string{})); err != nil {
+		return fosite.ErrServerError.WithWrap(err).WithDebug(err.Error())
+	}
+
+	responder.SetAccessToken(access)
+	responder.SetTokenType("bearer")
+	responder.SetExpiresIn(time.Until(requester.GetSession().GetExpiresAt(fosite.AccessToken)).Round(time.Second))
+	responder.SetScopes(requester.GetGrantedScopes())
+	responder.SetExtra("issued_token_type", accessTokenType)
+	return nil
+}
+
+func (h *tokenExchangeHandler) CanSkipClientAuth(ctx context.Context, requester fosite.AccessRequester) bool {
+	return false
+}
+
+func (h *tokenExchangeHandler) CanHandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(tokenExchangeGrantType)
+}
+
+// validateToken checks a subject or actor token, name tells which one for error messages.
+func (h *tokenExchangeHandler) validateToken(ctx context.Context, name string, token string, tokenType string) (*exchangedToken, error) {
+	if token == "" {
+		return nil, fosite.ErrInvalidRequest.WithHintf("The '%s_token' parameter is missing.", name)
+	}
+
+	switch tokenType {
+	case accessTokenType:
+		return h.validateAccessToken(ctx, token)
+	case idTokenType:
+		return h.validateIDToken(ctx, token)
+	case "":
+		return nil, fosite.ErrInvalidRequest.WithHintf("The '%s_token_type' parameter is missing.", name)
+	default:
+		return nil, fosite.ErrInvalidRequest.WithHintf("The %s token type '%s' is not supported.", name, tokenType)
+	}
+}
+
+func (h *tokenExchangeHandler) validateAccessToken(ctx context.Context, token string) (*exchangedToken, error) {
+	session := newSession("")
+	requester, err := h.Store.GetAccessTokenSession(ctx, h.Strategy.AccessTokenSignature(ctx, token), session)
+	if err != nil {
+		return nil, fosite.ErrInvalidRequest.WithHint("The access token is invalid, expired or revoked.").WithWrap(err).WithDebug(err.Error())
+	}
+	if err := h.Strategy.ValidateAccessToken(ctx, requester, token); err != nil {
+		return nil, fosite.ErrInvalidRequest.WithHint("The access token is invalid, expired or revoked.").WithWrap(err).WithDebug(err.Error())
+	}
+
+	t := &exchangedToken{
+		Subject:   session.GetSubject(),
+		Clients:   append([]string{requester.GetClient().GetID()}, requester.GetGrantedAudience()...),
+		Scopes:    requester.GetGrantedScopes(),
+		ExpiresAt: session.GetExpiresAt(fosite.AccessToken),
+	}
+	// Tokens of the client credentials grant have no subject, they stand for the client itself.
+	if t.Subject == "" {
+		t.Subject = requester.GetClient().GetID()
+	}
+	if act, ok := session.Extra["act"].(map[string]interface{}); ok {
+		t.Act = act
+	}
+	return t, nil
+}
+
+// validateIDToken accepts ID tokens signed by this server. ID tokens prove who the user is but carry no scopes, so the
+// exchanged token gets none either.
+func (h *tokenExchangeHandler) validateIDToken(ctx context.Context, token string) (*exchangedToken, error) {
+	parsed, err := h.Signer.Decode(ctx, token)
+	if err != nil {
+		return nil, fosite.ErrInvalidRequest.WithHint("The ID token is invalid or expired.").WithWrap(err).WithDebug(err.Error())
+	}
+
+	claims := parsed.Claims
+	if !claims.VerifyIssuer(issuer, true) {
+		return nil, fosite.ErrInvalidRequest.WithHint("The ID token was not issued by this server.")
+	}
+	if !isIDToken(parsed) {
+		return nil, fosite.ErrInvalidRequest.WithHint("The token is not an ID token.")
+	}
+
+	t := &exchangedToken{
+		Subject:   jwt.ToString(claims["sub"]),
+		ExpiresAt: jwt.ToTime(claims["exp"]),
+	}
+	switch aud := claims["aud"].(type) {
+	case string:
+		t.Clients = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			t.Clients = append(t.Clients, jwt.ToString(a))
+		}
+	}
+	if t.Subject == "" || t.ExpiresAt.IsZero() {
+		return nil, fosite.ErrInvalidRequest.WithHint("The ID token lacks the 'sub' or 'exp' claim.")
+	}
+	return t, nil
+}
+
+// isIDToken tells ID tokens apart from the other JWTs signed with the server's keys: JWT access tokens (`at+jwt`),
+// logout tokens (`logout+jwt`) and introspection responses (`token-introspection+jwt`) all carry `iss`, `sub` and
+// often `exp` as well. fosite signs ID tokens with the `typ` header JWT, and only logout tokens carry `events`.
+func isIDToken(token *jwt.Token) bool {
+	typ, _ := token.Header[string(jwt.JWTHeaderType)].(string)
+	if typ != "" && !strings.EqualFold(typ, jwt.JWTHeaderTypeValue) {
+		return false
+	}
+	_, hasEvents := token.Claims["events"]
+	return !hasEvents
+}
+
+// issuedTo reports whether the token was issued to the client or names it as audience.
+func issuedTo(t *exchangedToken, clientID string) bool {
+	return fosite.Arguments(t.Clients).Has(clientID)
+}
@@ -0,0 +1,51 @@
+package authorizationserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite/token/jwt"
+)
+
+func TestValidateIDTokenRejectsOtherJWTs(t *testing.T) {
+	ctx := context.Background()
+	h := &tokenExchangeHandler{Signer: signer}
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"iss": issuer, "sub": "peter", "aud": "my-client", "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		name   string
+		typ    string
+		claims jwt.MapClaims
+		valid  bool
+	}{
+		{name: "ID token", typ: "JWT", claims: claims(nil), valid: true},
+		{name: "JWT access token", typ: jwtAccessTokenType, claims: claims(nil)},
+		{name: "logout token", typ: logoutTokenType, claims: claims(nil)},
+		{name: "introspection response", typ: introspectionJWTType, claims: claims(nil)},
+		{name: "logout token without its typ", typ: "JWT", claims: claims(jwt.MapClaims{"events": map[string]interface{}{backChannelLogoutEvent: map[string]interface{}{}}})},
+		{name: "foreign issuer", typ: "JWT", claims: claims(jwt.MapClaims{"iss": "https://attacker.example.com"})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			header := jwt.NewHeaders()
+			header.Add("typ", tc.typ)
+			token, _, err := signer.Generate(ctx, tc.claims, header)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+
+			_, err = h.validateIDToken(ctx, token)
+			if tc.valid && err != nil {
+				t.Errorf("validateIDToken() = %v, want the ID token to be accepted", err)
+			} else if !tc.valid && err == nil {
+				t.Error("validateIDToken() accepted a token that is not an ID token of this server")
+			}
+		})
+	}
+}
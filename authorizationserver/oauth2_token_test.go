@@ -0,0 +1,133 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// testTokenStrategy computes the signatures the opaque tokens of the server are stored by.
+var testTokenStrategy = compose.NewOAuth2HMACStrategy(config)
+
+// newRefreshClient returns a client that may refresh tokens, authenticating with secret "foobar".
+func newRefreshClient(id string) *sqlstore.Client {
+	client := sqlstore.NewClient(id)
+	client.Secret = []byte(`$2a$10$IxMdI6d.LIRZPpSfEwNoeu4rY3FhDREsxFJXikcgdRRAStxUlsuEO`) // = "foobar"
+	client.GrantTypes = []string{"refresh_token"}
+	client.Scopes = []string{"offline"}
+	client.TokenEndpointAuthMethod = "client_secret_basic"
+	return client
+}
+
+// newTestRefreshToken stores a refresh token of peter for the client, with the given audience, and returns it.
+func newTestRefreshToken(t *testing.T, s *sqlstore.Store, client *sqlstore.Client, audience ...string) string {
+	t.Helper()
+	ctx := context.Background()
+
+	session := newSession("peter")
+	session.SetExpiresAt(fosite.RefreshToken, time.Now().Add(time.Hour))
+	request := fosite.NewRequest()
+	request.Client = client
+	request.Session = session
+	request.RequestedScope = fosite.Arguments{"offline"}
+	request.GrantedScope = fosite.Arguments{"offline"}
+	request.RequestedAudience = audience
+	request.GrantedAudience = audience
+
+	token, signature, err := testTokenStrategy.GenerateRefreshToken(ctx, request)
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	if err := s.CreateRefreshTokenSession(ctx, signature, "", request); err != nil {
+		t.Fatalf("CreateRefreshTokenSession: %v", err)
+	}
+	return token
+}
+
+// refreshTestToken redeems a refresh token of a client made by newRefreshClient at the token endpoint.
+func refreshTestToken(refreshToken string, form url.Values) *httptest.ResponseRecorder {
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("refresh-client", "foobar")
+	rw := httptest.NewRecorder()
+	tokenEndpoint(rw, req)
+	return rw
+}
+
+// decodeTestTokens returns the access and refresh token of a token response.
+func decodeTestTokens(t *testing.T, rw *httptest.ResponseRecorder) (accessToken string, refreshToken string) {
+	t.Helper()
+	var response struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding the token response: %v", err)
+	}
+	return response.AccessToken, response.RefreshToken
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	ctx := context.Background()
+	client := newRefreshClient("refresh-client")
+	s := useTestStore(t, client)
+
+	events := make(chan securityEvent, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		var event securityEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("decoding the security event: %v", err)
+		}
+		events <- event
+	}))
+	t.Cleanup(receiver.Close)
+	previous := securityEventsURL
+	securityEventsURL = receiver.URL
+	t.Cleanup(func() { securityEventsURL = previous })
+
+	rotated := newTestRefreshToken(t, s, client)
+	original, err := s.GetRefreshTokenSession(ctx, testTokenStrategy.RefreshTokenSignature(ctx, rotated), newSession(""))
+	if err != nil {
+		t.Fatalf("GetRefreshTokenSession: %v", err)
+	}
+	rw := refreshTestToken(rotated, url.Values{})
+	if rw.Code != http.StatusOK {
+		t.Fatalf("refreshing failed with %d: %s", rw.Code, rw.Body)
+	}
+	accessToken, refreshToken := decodeTestTokens(t, rw)
+
+	// Replaying the rotated token fails and revokes the whole family, including the tokens issued for it.
+	if rw := refreshTestToken(rotated, url.Values{}); rw.Code != http.StatusBadRequest || !strings.Contains(rw.Body.String(), "invalid_grant") {
+		t.Fatalf("replaying the rotated refresh token returned %d: %s", rw.Code, rw.Body)
+	}
+	if rw := refreshTestToken(refreshToken, url.Values{}); rw.Code == http.StatusOK {
+		t.Errorf("the refresh token of the revoked family was accepted: %s", rw.Body)
+	}
+	if _, err := s.GetAccessTokenSession(ctx, testTokenStrategy.AccessTokenSignature(ctx, accessToken), newSession("")); !errors.Is(err, fosite.ErrNotFound) {
+		t.Errorf("GetAccessTokenSession() = %v, want fosite.ErrNotFound", err)
+	}
+
+	select {
+	case event := <-events:
+		if event.Type != eventRefreshTokenReused || event.ClientID != "refresh-client" || event.Subject != "peter" || event.FamilyID != original.GetID() {
+			t.Errorf("got security event %+v, want %s of family %s of refresh-client and peter", event, eventRefreshTokenReused, original.GetID())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no security event was posted")
+	}
+}
@@ -0,0 +1,98 @@
+package authorizationserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/dpop"
+)
+
+// userinfoEndpoint implements the OpenID Connect UserInfo endpoint. It accepts an access token granted the `openid`
+// scope and returns the claims of the token's subject that the granted scopes allow. DPoP-bound access tokens must be
+// sent with the DPoP scheme and a proof of the key they are bound to, certificate-bound ones over a connection
+// authenticated with the certificate.
+func userinfoEndpoint(rw http.ResponseWriter, req *http.Request) {
+	// This context will be passed to all methods.
+	ctx := req.Context()
+
+	scheme, token := accessTokenFromRequest(req)
+	writeError := writeBearerError
+	if scheme == dpop.Scheme {
+		writeError = writeDPoPError
+	}
+
+	if token == "" {
+		writeError(rw, http.StatusUnauthorized, "invalid_request", "The request is missing an access token.")
+		return
+	}
+
+	// Let fosite check that the token is an active access token.
+	session := newSession("")
+	tokenUse, ar, err := oauth2.IntrospectToken(ctx, token, fosite.AccessToken, session)
+	if err != nil || tokenUse != fosite.AccessToken {
+		log.Printf("Error occurred in IntrospectToken: %+v", err)
+		writeError(rw, http.StatusUnauthorized, "invalid_token", "The access token is expired, revoked or malformed.")
+		return
+	}
+
+	if jkt := session.confirmation("jkt"); jkt != "" || scheme == dpop.Scheme {
+		if scheme != dpop.Scheme {
+			writeDPoPError(rw, http.StatusUnauthorized, "invalid_token", "The access token is bound to a DPoP key and must be sent with the DPoP scheme.")
+			return
+		}
+
+		proof, err := checkDPoPProof(rw, req, token)
+		if errors.Is(err, errUseDPoPNonce) {
+			writeDPoPError(rw, http.StatusUnauthorized, "use_dpop_nonce", "The DPoP proof must contain the nonce of the DPoP-Nonce header.")
+			return
+		} else if err != nil {
+			log.Printf("Error occurred in checkDPoPProof: %+v", err)
+			writeDPoPError(rw, http.StatusUnauthorized, "invalid_dpop_proof", "The DPoP proof is invalid.")
+			return
+		} else if proof == nil {
+			writeDPoPError(rw, http.StatusUnauthorized, "invalid_dpop_proof", "The request is missing a DPoP proof.")
+			return
+		} else if proof.Thumbprint != jkt {
+			writeDPoPError(rw, http.StatusUnauthorized, "invalid_dpop_proof", "The DPoP proof was not made with the key the access token is bound to.")
+			return
+		}
+	}
+
+	// Tokens bound to a client certificate are only accepted over a connection authenticated with it, see RFC 8705.
+	if x5t := session.confirmation("x5t#S256"); x5t != "" && clientCertificateThumbprint(req) != x5t {
+		writeError(rw, http.StatusUnauthorized, "invalid_token", "The access token is bound to a client certificate the request was not made with.")
+		return
+	}
+
+	if !ar.GetGrantedScopes().Has("openid") {
+		writeError(rw, http.StatusForbidden, "insufficient_scope", "The access token was not granted the openid scope.")
+		return
+	}
+
+	user, err := users.GetUser(ctx, ar.GetSession().GetSubject())
+	if errors.Is(err, fosite.ErrNotFound) {
+		writeError(rw, http.StatusUnauthorized, "invalid_token", "The subject of the access token does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("Error occurred in GetUser: %+v", err)
+		http.Error(rw, "could not look up the user", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(rw).Encode(user.Claims(ar.GetGrantedScopes())); err != nil {
+		log.Printf("Error occurred in userinfoEndpoint: %+v", err)
+	}
+}
+
+// writeBearerError writes an RFC 6750 error response.
+func writeBearerError(rw http.ResponseWriter, status int, code string, description string) {
+	rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s", error_description="%s"`, code, description))
+	rw.WriteHeader(status)
+}
@@ -0,0 +1,151 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+)
+
+func TestUserClaims(t *testing.T) {
+	user := &User{
+		Subject:       "peter",
+		Name:          "Peter Example",
+		UpdatedAt:     time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Email:         "peter@example.com",
+		EmailVerified: true,
+		PhoneNumber:   "+1 555 0100",
+		Address:       &UserAddress{Country: "US"},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		scopes fosite.Arguments
+		want   []string
+	}{
+		{name: "openid", scopes: fosite.Arguments{"openid"}, want: []string{"sub"}},
+		{name: "profile", scopes: fosite.Arguments{"openid", "profile"}, want: []string{"name", "sub", "updated_at"}},
+		{name: "email", scopes: fosite.Arguments{"openid", "email"}, want: []string{"email", "email_verified", "sub"}},
+		{name: "address", scopes: fosite.Arguments{"openid", "address"}, want: []string{"address", "sub"}},
+		{name: "phone", scopes: fosite.Arguments{"openid", "phone"}, want: []string{"phone_number", "phone_number_verified", "sub"}},
+		{name: "unknown scope", scopes: fosite.Arguments{"openid", "fosite"}, want: []string{"sub"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := user.Claims(tc.scopes)
+			var got []string
+			for name := range claims {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+				t.Errorf("Claims(%v) = %v, want %v", tc.scopes, got, tc.want)
+			}
+		})
+	}
+
+	// Claims the user has no value for are left out, even if the scope grants them.
+	if claims := (&User{Subject: "alice"}).Claims(fosite.Arguments{"openid", "profile", "email", "address", "phone"}); len(claims) != 1 {
+		t.Errorf("Claims() of a user without data = %v, want only sub", claims)
+	}
+}
+
+// newTestAccessToken stores an access token of the subject for the example client, granted the scopes, and returns it.
+func newTestAccessToken(t *testing.T, subject string, scopes ...string) string {
+	t.Helper()
+	ctx := context.Background()
+
+	client, err := store.GetClient(ctx, "my-client")
+	if err != nil {
+		t.Fatalf("GetClient: %v", err)
+	}
+	session := newSession(subject)
+	session.SetExpiresAt(fosite.AccessToken, time.Now().Add(time.Hour))
+	request := fosite.NewRequest()
+	request.Client = client
+	request.Session = session
+	request.RequestedScope = scopes
+	request.GrantedScope = scopes
+
+	token, signature, err := compose.NewOAuth2HMACStrategy(config).GenerateAccessToken(ctx, request)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	if err := store.CreateAccessTokenSession(ctx, signature, request); err != nil {
+		t.Fatalf("CreateAccessTokenSession: %v", err)
+	}
+	return token
+}
+
+func TestUserinfoEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		scheme     string
+		token      string
+		wantStatus int
+		wantError  string
+		wantClaims []string
+		noClaims   []string
+	}{
+		{name: "no token", wantStatus: http.StatusUnauthorized, wantError: "invalid_request"},
+		{name: "no token with the DPoP scheme", scheme: "DPoP", wantStatus: http.StatusUnauthorized, wantError: "invalid_request"},
+		{name: "unknown token", token: "not-a-token", wantStatus: http.StatusUnauthorized, wantError: "invalid_token"},
+		{name: "without openid", token: newTestAccessToken(t, "peter", "profile"), wantStatus: http.StatusForbidden, wantError: "insufficient_scope"},
+		{name: "unknown subject", token: newTestAccessToken(t, "nobody", "openid"), wantStatus: http.StatusUnauthorized, wantError: "invalid_token"},
+		{
+			name: "profile", token: newTestAccessToken(t, "peter", "openid", "profile"), wantStatus: http.StatusOK,
+			wantClaims: []string{"sub", "name"}, noClaims: []string{"email", "phone_number", "address"},
+		},
+		{
+			name: "email", token: newTestAccessToken(t, "peter", "openid", "email"), wantStatus: http.StatusOK,
+			wantClaims: []string{"sub", "email", "email_verified"}, noClaims: []string{"name"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme := tc.scheme
+			if scheme == "" {
+				scheme = "Bearer"
+			}
+			req := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+			if tc.token != "" || tc.scheme != "" {
+				req.Header.Set("Authorization", scheme+" "+tc.token)
+			}
+			rw := httptest.NewRecorder()
+			userinfoEndpoint(rw, req)
+
+			if rw.Code != tc.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rw.Code, tc.wantStatus, rw.Body)
+			}
+			if challenge := rw.Header().Get("WWW-Authenticate"); tc.wantError != "" && (!strings.HasPrefix(challenge, scheme+" ") || !strings.Contains(challenge, `error="`+tc.wantError+`"`)) {
+				t.Errorf("WWW-Authenticate = %q, want a %s challenge with error %q", challenge, scheme, tc.wantError)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var claims map[string]interface{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &claims); err != nil {
+				t.Fatalf("decoding the claims: %v", err)
+			}
+			if claims["sub"] != "peter" {
+				t.Errorf("sub = %v, want peter", claims["sub"])
+			}
+			for _, name := range tc.wantClaims {
+				if _, ok := claims[name]; !ok {
+					t.Errorf("the claim %s is missing", name)
+				}
+			}
+			for _, name := range tc.noClaims {
+				if _, ok := claims[name]; ok {
+					t.Errorf("the claim %s was returned without its scope", name)
+				}
+			}
+		})
+	}
+}
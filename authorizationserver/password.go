@@ -0,0 +1,74 @@
+package authorizationserver
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errPasswordMismatch = errors.New("password does not match")
+
+// comparePassword checks a password against a bcrypt hash (`$2a$`, `$2b$`, `$2y$`, as created by
+// `htpasswd -nbBC 10 "" <password>`) or an argon2id hash in PHC string format (`$argon2id$v=19$m=...,t=...,p=...$salt$key`,
+// as created by the `argon2` command line tool).
+func comparePassword(hash string, password string) error {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return errPasswordMismatch
+		} else if err != nil {
+			return err
+		}
+		return nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return compareArgon2id(hash, password)
+	default:
+		return fmt.Errorf("unsupported password hash format")
+	}
+}
+
+func compareArgon2id(hash string, password string) error {
+	// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return fmt.Errorf("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return fmt.Errorf("malformed argon2id hash: %w", err)
+	}
+	if version != argon2.Version {
+		return fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return fmt.Errorf("malformed argon2id hash: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return fmt.Errorf("malformed argon2id key: %w", err)
+	}
+
+	derived := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(derived, key) != 1 {
+		return errPasswordMismatch
+	}
+	return nil
+}
+
+// unknownUserHash is compared against when a username does not exist, so the response time does not reveal which
+// usernames are taken.
+const unknownUserHash = "$2a$10$znUZERoANMryuUQeN0SpSuoFINO5BF6ubKJ5S4RB.tiGMEI.56C/S"
@@ -0,0 +1,151 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// argon2idHash hashes the password in PHC string format, like the `argon2` command line tool.
+func argon2idHash(password string) string {
+	salt := []byte("some-salt-value!")
+	key := argon2.IDKey([]byte(password), salt, 1, 8*1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 8*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestComparePassword(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		hash     string
+		password string
+		wantErr  error
+		invalid  bool
+	}{
+		{name: "bcrypt", hash: string(bcryptHash), password: "secret"},
+		{name: "bcrypt mismatch", hash: string(bcryptHash), password: "wrong", wantErr: errPasswordMismatch},
+		{name: "argon2id", hash: argon2idHash("secret"), password: "secret"},
+		{name: "argon2id mismatch", hash: argon2idHash("secret"), password: "wrong", wantErr: errPasswordMismatch},
+		{name: "argon2id of another version", hash: "$argon2id$v=16$m=8192,t=1,p=1$c2FsdA$a2V5", password: "secret", invalid: true},
+		{name: "malformed argon2id", hash: "$argon2id$v=19$m=8192", password: "secret", invalid: true},
+		{name: "malformed bcrypt", hash: "$2a$10$short", password: "secret", invalid: true},
+		{name: "plain text", hash: "secret", password: "secret", invalid: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := comparePassword(tc.hash, tc.password)
+			switch {
+			case tc.invalid:
+				if err == nil || errors.Is(err, errPasswordMismatch) {
+					t.Errorf("comparePassword() = %v, want an error about the hash", err)
+				}
+			case !errors.Is(err, tc.wantErr) || (tc.wantErr == nil && err != nil):
+				t.Errorf("comparePassword() = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnknownUserHash(t *testing.T) {
+	// Unknown users are checked against unknownUserHash to take as long as known ones, which only works if it is a
+	// valid hash as expensive as those of the users.
+	cost, err := bcrypt.Cost([]byte(unknownUserHash))
+	if err != nil {
+		t.Fatalf("unknownUserHash is not a bcrypt hash: %v", err)
+	}
+	for _, user := range exampleUsers {
+		if userCost, err := bcrypt.Cost([]byte(user.PasswordHash)); err == nil && userCost != cost {
+			t.Errorf("unknownUserHash has cost %d, but %s has %d", cost, user.Subject, userCost)
+		}
+	}
+}
+
+func TestMemoryUserDirectoryAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+	directory := NewMemoryUserDirectory(
+		&User{Subject: "peter", PasswordHash: string(hash)},
+		&User{Subject: "user-2", Username: "alice", PasswordHash: argon2idHash("wonderland")},
+		&User{Subject: "bob"},
+	)
+
+	for _, tc := range []struct {
+		name     string
+		username string
+		password string
+		subject  string
+	}{
+		{name: "bcrypt", username: "peter", password: "secret", subject: "peter"},
+		{name: "argon2id by username", username: "alice", password: "wonderland", subject: "user-2"},
+		{name: "wrong password", username: "peter", password: "wrong"},
+		{name: "subject instead of username", username: "user-2", password: "wonderland"},
+		{name: "unknown user", username: "mallory", password: "secret"},
+		{name: "user without password", username: "bob", password: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			user, err := directory.Authenticate(ctx, tc.username, tc.password)
+			if tc.subject == "" {
+				// Unknown users and wrong passwords look the same to the caller.
+				if !errors.Is(err, fosite.ErrNotFound) {
+					t.Errorf("Authenticate() = %v, want fosite.ErrNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() = %v", err)
+			}
+			if user.Subject != tc.subject {
+				t.Errorf("Authenticate() returned %s, want %s", user.Subject, tc.subject)
+			}
+		})
+	}
+}
+
+func TestFileUserDirectoryReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.json")
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Chtimes: %v", err)
+		}
+	}
+
+	now := time.Now()
+	write(fmt.Sprintf(`[{"sub": "alice", "password_hash": %q}]`, argon2idHash("wonderland")), now.Add(-time.Minute))
+	directory, err := NewFileUserDirectory(path)
+	if err != nil {
+		t.Fatalf("NewFileUserDirectory: %v", err)
+	}
+	if _, err := directory.Authenticate(ctx, "alice", "wonderland"); err != nil {
+		t.Fatalf("Authenticate() = %v", err)
+	}
+
+	// Users are picked up from the file once it changes.
+	write(fmt.Sprintf(`[{"sub": "bob", "password_hash": %q}]`, argon2idHash("builder")), now)
+	if _, err := directory.Authenticate(ctx, "bob", "builder"); err != nil {
+		t.Errorf("Authenticate() of an added user = %v", err)
+	}
+	if _, err := directory.Authenticate(ctx, "alice", "wonderland"); !errors.Is(err, fosite.ErrNotFound) {
+		t.Errorf("Authenticate() of a removed user = %v, want fosite.ErrNotFound", err)
+	}
+}
@@ -0,0 +1,238 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite"
+)
+
+// ResourceServer is an entry of the resource server registry. Clients ask for tokens meant for a resource server by
+// sending its URI as `resource` parameter, see RFC 8707.
+//
+// Resource servers are principals of their own, apart from clients: with credentials and the permission to, they
+// introspect the tokens meant for them. They can not obtain tokens.
+type ResourceServer struct {
+	// URI identifies the resource server, it becomes the audience of the tokens issued for it.
+	URI string `json:"uri"`
+	// Name is shown to users on the consent page.
+	Name string `json:"name,omitempty"`
+
+	// ID is what the resource server authenticates as at the introspection endpoint. With SecretHash, it sends its
+	// secret by basic auth or as `client_secret`; SecretHash is a bcrypt or argon2id hash, see comparePassword. With
+	// JWKS or JWKSURI, it may send a `private_key_jwt` client assertion signed with one of its keys instead.
+	ID         string `json:"id,omitempty"`
+	SecretHash string `json:"secret_hash,omitempty"`
+	// AllowIntrospection permits the resource server to introspect tokens. Tokens not meant for it are reported as
+	// inactive.
+	AllowIntrospection bool `json:"allow_introspection,omitempty"`
+
+	// JWKS or JWKSURI are the keys of the resource server. With IntrospectionEncryptedResponseAlg, JWT introspection
+	// responses (RFC 9701) are encrypted to one of them, using IntrospectionEncryptedResponseEnc or else A128CBC-HS256.
+	JWKSURI                           string              `json:"jwks_uri,omitempty"`
+	JWKS                              *jose.JSONWebKeySet `json:"jwks,omitempty"`
+	IntrospectionEncryptedResponseAlg string              `json:"introspection_encrypted_response_alg,omitempty"`
+	IntrospectionEncryptedResponseEnc string              `json:"introspection_encrypted_response_enc,omitempty"`
+}
+
+// DisplayName returns the name users are shown for the resource server.
+func (r *ResourceServer) DisplayName() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.URI
+}
+
+// keys returns the keys client assertions of the resource server are verified with and JWT introspection responses
+// are encrypted to. Keys behind a `jwks_uri` are cached, unless refresh is set.
+func (r *ResourceServer) keys(ctx context.Context, refresh bool) (*jose.JSONWebKeySet, error) {
+	if r.JWKS != nil {
+		return r.JWKS, nil
+	}
+	if r.JWKSURI == "" {
+		return nil, fosite.ErrServerError.WithHintf("The resource server '%s' has no keys registered.", r.URI)
+	}
+	set, err := config.GetJWKSFetcherStrategy(ctx).Resolve(ctx, r.JWKSURI, refresh)
+	if err != nil {
+		return nil, fosite.ErrServerError.WithHintf("Unable to fetch the keys of the resource server from '%s'.", r.JWKSURI).WithWrap(err).WithDebug(err.Error())
+	}
+	return set, nil
+}
+
+// resourceServers are the resource servers tokens can be requested for, by URI.
+type resourceServers map[string]*ResourceServer
+
+// byID returns the resource server with the given credentials ID, or nil.
+func (s resourceServers) byID(id string) *ResourceServer {
+	for _, server := range s {
+		if server.ID != "" && server.ID == id {
+			return server
+		}
+	}
+	return nil
+}
+
+// newResourceServers reads the registry from the JSON file named by RESOURCE_SERVERS_FILE, see
+// `resource-servers.example.json`. Without it, the registry contains the protected resource of the example and the
+// photos API.
+func newResourceServers() resourceServers {
+	entries := exampleResourceServers
+	if path := os.Getenv("RESOURCE_SERVERS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Error occurred in ReadFile: %+v", err)
+		}
+		entries = nil
+		if err := json.Unmarshal(data, &entries); err != nil {
+			log.Fatalf("Invalid RESOURCE_SERVERS_FILE %q: %+v", path, err)
+		}
+	}
+
+	servers := make(resourceServers, len(entries))
+	for _, entry := range entries {
+		if err := validResourceURI(entry.URI); err != nil {
+			log.Fatalf("Invalid resource server %q: %+v", entry.URI, err)
+		}
+		if entry.ID != "" && servers.byID(entry.ID) != nil {
+			log.Fatalf("Invalid resource server %q: the id %q is taken", entry.URI, entry.ID)
+		}
+		if entry.JWKS != nil && entry.JWKSURI != "" {
+			log.Fatalf("Invalid resource server %q: jwks and jwks_uri are mutually exclusive", entry.URI)
+		}
+		hasKeys := entry.JWKS != nil || entry.JWKSURI != ""
+		if entry.ID != "" && entry.SecretHash == "" && !hasKeys {
+			log.Fatalf("Invalid resource server %q: an id requires a secret_hash or keys to authenticate with", entry.URI)
+		}
+		if entry.SecretHash != "" && entry.ID == "" {
+			log.Fatalf("Invalid resource server %q: a secret_hash requires an id", entry.URI)
+		}
+		if entry.AllowIntrospection && entry.ID == "" {
+			log.Fatalf("Invalid resource server %q: introspection requires an id and a secret_hash or keys", entry.URI)
+		}
+		if alg := entry.IntrospectionEncryptedResponseAlg; alg != "" && !fosite.Arguments(introspectionEncryptionAlgs).Has(alg) {
+			log.Fatalf("Invalid resource server %q: the introspection_encrypted_response_alg %q is not supported", entry.URI, alg)
+		}
+		if enc := entry.IntrospectionEncryptedResponseEnc; enc != "" && (entry.IntrospectionEncryptedResponseAlg == "" || !fosite.Arguments(introspectionEncryptionEncs).Has(enc)) {
+			log.Fatalf("Invalid resource server %q: the introspection_encrypted_response_enc %q is not supported", entry.URI, enc)
+		}
+		if entry.IntrospectionEncryptedResponseAlg != "" && !hasKeys {
+			log.Fatalf("Invalid resource server %q: encrypted introspection responses require jwks or jwks_uri", entry.URI)
+		}
+		servers[entry.URI] = entry
+	}
+	return servers
+}
+
+// exampleResourceServers registers the protected resource of the example, which introspects tokens as
+// "my-resource-server" with secret "foobar", and the photos API.
+var exampleResourceServers = []*ResourceServer{
+	{
+		URI:                "http://localhost:3846/protected",
+		Name:               "Protected resource",
+		ID:                 "my-resource-server",
+		SecretHash:         "$2a$10$iKZngNjpZbgudLgVA2ixuOVFBK7t1946aAcqnBdYHjP/L8O9y0SqK",
+		AllowIntrospection: true,
+	},
+	{URI: "https://photos.my-application.com", Name: "Photos API"},
+}
+
+// validResourceURI checks the syntax of a `resource` parameter: an absolute URI without a fragment, see section 2 of
+// RFC 8707.
+func validResourceURI(resource string) error {
+	u, err := url.Parse(resource)
+	if err != nil {
+		return errInvalidTarget.WithHintf("The resource '%s' is not a valid URI.", resource).WithWrap(err).WithDebug(err.Error())
+	}
+	if !u.IsAbs() {
+		return errInvalidTarget.WithHintf("The resource '%s' is not an absolute URI.", resource)
+	}
+	if u.Fragment != "" || u.RawFragment != "" {
+		return errInvalidTarget.WithHintf("The resource '%s' must not contain a fragment.", resource)
+	}
+	return nil
+}
+
+// checkResources returns errInvalidTarget unless all resources are registered and the client may request tokens for
+// them, i.e. they are among its audiences.
+func (s resourceServers) checkResources(ctx context.Context, client fosite.Client, resources []string) error {
+	for _, resource := range resources {
+		if err := validResourceURI(resource); err != nil {
+			return err
+		}
+		if _, ok := s[resource]; !ok {
+			return errInvalidTarget.WithHintf("The resource '%s' is unknown.", resource)
+		}
+	}
+	if err := config.GetAudienceStrategy(ctx)(client.GetAudience(), resources); err != nil {
+		return errInvalidTarget.WithHint("The OAuth 2.0 Client is not allowed to request tokens for the requested resource.").WithWrap(err).WithDebug(err.Error())
+	}
+	return nil
+}
+
+// requestResources adds the `resource` parameters of an authorize or device authorization request to the requested
+// audience, after checking them. Granting the request grants the audience as well.
+func requestResources(ctx context.Context, requester fosite.Requester, form url.Values) error {
+	resources := fosite.RemoveEmpty(form["resource"])
+	if err := resourceRegistry.checkResources(ctx, requester.GetClient(), resources); err != nil {
+		return err
+	}
+	requester.SetRequestedAudience(append(requester.GetRequestedAudience(), resources...))
+	return nil
+}
+
+// grantResources grants the audience of a token request. The client credentials, password and JWT bearer grants get
+// the resources they ask for and the audience checked by their handler. Grants redeeming an earlier authorization,
+// like the authorization code and refresh token grants, keep its audience, or narrow the access token down to the
+// resources they ask for. The refresh token issued along keeps the whole audience, see section 2.2 of RFC 8707, so
+// later requests can ask for any of the resources again.
+//
+// Token exchange requests are left alone, they treat `resource` like `audience` on their own.
+func grantResources(ctx context.Context, accessRequest fosite.AccessRequester, form url.Values) error {
+	grantTypes := accessRequest.GetGrantTypes()
+	resources := fosite.RemoveEmpty(form["resource"])
+
+	switch {
+	case grantTypes.ExactOne(tokenExchangeGrantType):
+		return nil
+	case grantTypes.ExactOne("client_credentials"), grantTypes.ExactOne("password"), grantTypes.ExactOne(jwtBearerGrantType):
+		if err := resourceRegistry.checkResources(ctx, accessRequest.GetClient(), resources); err != nil {
+			return err
+		}
+		for _, audience := range append(accessRequest.GetRequestedAudience(), resources...) {
+			accessRequest.GrantAudience(audience)
+		}
+		return nil
+	}
+
+	if len(resources) == 0 {
+		return nil
+	}
+	for _, resource := range resources {
+		if !accessRequest.GetGrantedAudience().Has(resource) {
+			return errInvalidTarget.WithHintf("The resource '%s' was not granted.", resource)
+		}
+	}
+	r, ok := accessRequest.(*fosite.AccessRequest)
+	if !ok {
+		return fosite.ErrServerError.WithDebug("The access request must be a *fosite.AccessRequest.")
+	}
+	session, ok := r.GetSession().(*Session)
+	if !ok {
+		return fosite.ErrServerError.WithDebug("The session of the token endpoint must be a *Session.")
+	}
+	session.RefreshTokenAudience = r.GrantedAudience
+	r.GrantedAudience = resources
+	return nil
+}
+
+// grantRequestedAudience grants the audience the client asked for with `audience` or `resource` parameters. Both were
+// checked against the client when the request was made.
+func grantRequestedAudience(ar fosite.Requester) {
+	for _, audience := range ar.GetRequestedAudience() {
+		ar.GrantAudience(audience)
+	}
+}
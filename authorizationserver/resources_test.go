@@ -0,0 +1,92 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+func TestRefreshTokenKeepsAudience(t *testing.T) {
+	ctx := context.Background()
+	protected, photos := "http://localhost:3846/protected", "https://photos.my-application.com"
+
+	client := sqlstore.NewClient("resource-client")
+	client.Secret = []byte(`$2a$10$IxMdI6d.LIRZPpSfEwNoeu4rY3FhDREsxFJXikcgdRRAStxUlsuEO`) // = "foobar"
+	client.GrantTypes = []string{"refresh_token"}
+	client.Scopes = []string{"offline"}
+	client.TokenEndpointAuthMethod = "client_secret_basic"
+	client.Audience = []string{protected, photos}
+	s := useTestStore(t, client)
+
+	// A refresh token granted for both resource servers, as the authorization code grant would have stored it.
+	strategy := compose.NewOAuth2HMACStrategy(config)
+	session := newSession("peter")
+	session.SetExpiresAt(fosite.RefreshToken, time.Now().Add(time.Hour))
+	authorized := fosite.NewRequest()
+	authorized.Client = client
+	authorized.Session = session
+	authorized.RequestedScope = fosite.Arguments{"offline"}
+	authorized.GrantedScope = fosite.Arguments{"offline"}
+	authorized.RequestedAudience = fosite.Arguments{protected, photos}
+	authorized.GrantedAudience = fosite.Arguments{protected, photos}
+	refreshToken, signature, err := strategy.GenerateRefreshToken(ctx, authorized)
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken: %v", err)
+	}
+	if err := s.CreateRefreshTokenSession(ctx, signature, "", authorized); err != nil {
+		t.Fatalf("CreateRefreshTokenSession: %v", err)
+	}
+
+	refresh := func(refreshToken string, resource string) (accessToken string, newRefreshToken string) {
+		t.Helper()
+		form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}, "resource": {resource}}
+		req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("resource-client", "foobar")
+		rw := httptest.NewRecorder()
+		tokenEndpoint(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("refreshing for %s failed with %d: %s", resource, rw.Code, rw.Body)
+		}
+		var response struct {
+			AccessToken  string `json:"access_token"`
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decoding the token response: %v", err)
+		}
+		return response.AccessToken, response.RefreshToken
+	}
+
+	// The access token is narrowed down to the resource asked for, the refresh token keeps both, so the next refresh
+	// may ask for the other one.
+	for _, resource := range []string{protected, photos} {
+		var accessToken string
+		accessToken, refreshToken = refresh(refreshToken, resource)
+
+		accessRequest, err := s.GetAccessTokenSession(ctx, strategy.AccessTokenSignature(ctx, accessToken), newSession(""))
+		if err != nil {
+			t.Fatalf("GetAccessTokenSession: %v", err)
+		}
+		if got := accessRequest.GetGrantedAudience(); len(got) != 1 || got[0] != resource {
+			t.Errorf("the access token has audience %v, want [%s]", got, resource)
+		}
+		refreshRequest, err := s.GetRefreshTokenSession(ctx, strategy.RefreshTokenSignature(ctx, refreshToken), newSession(""))
+		if err != nil {
+			t.Fatalf("GetRefreshTokenSession: %v", err)
+		}
+		if got := refreshRequest.GetGrantedAudience(); !got.Has(protected) || !got.Has(photos) {
+			t.Errorf("the refresh token has audience %v, want [%s %s]", got, protected, photos)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package authorizationserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// eventRefreshTokenReused is reported when a rotated refresh token is used again. All tokens of its family are
+// revoked, as the legitimate client and an attacker can not be told apart.
+const eventRefreshTokenReused = "refresh_token_reused"
+
+// securityEventsURL receives security events as JSON posts, e.g. to alert a SIEM. Set SECURITY_EVENTS_URL to enable
+// it, the events are logged either way.
+var securityEventsURL = os.Getenv("SECURITY_EVENTS_URL")
+
+// securityEventsHTTPClient delivers security events. Receivers that do not answer in time are not waited for.
+var securityEventsHTTPClient = &http.Client{Timeout: time.Second * 5}
+
+// securityEvent is something the authorization server detected that may be an attack.
+type securityEvent struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	ClientID string    `json:"client_id,omitempty"`
+	Subject  string    `json:"sub,omitempty"`
+	// FamilyID is the request ID the tokens concerned share.
+	FamilyID   string `json:"token_family,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+}
+
+// emitSecurityEvent logs the event and posts it to SECURITY_EVENTS_URL. The post does not hold up the request the
+// event was detected in.
+func emitSecurityEvent(ctx context.Context, event securityEvent) {
+	event.Time = time.Now().UTC()
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error occurred in emitSecurityEvent: %+v", err)
+		return
+	}
+	log.Printf("Security event: %s", body)
+
+	if securityEventsURL == "" {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := postSecurityEvent(ctx, body); err != nil {
+			log.Printf("Error occurred in postSecurityEvent: %+v", err)
+		}
+	}()
+}
+
+func postSecurityEvent(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, securityEventsURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := securityEventsHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %d", securityEventsURL, resp.StatusCode)
+	}
+	return nil
+}
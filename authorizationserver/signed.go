@@ -0,0 +1,49 @@
+package authorizationserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var errInvalidSignature = errors.New("invalid signature")
+
+// signValue serializes v and signs it with the global secret, so it can be handed to the browser and trusted when it
+// comes back. The purpose is part of the signature, a value signed for one purpose is rejected for any other.
+func signValue(purpose string, v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(valueMAC(purpose, encoded)), nil
+}
+
+// verifyValue checks the signature of a value created by signValue and decodes it into v.
+func verifyValue(purpose string, signed string, v interface{}) error {
+	encoded, mac, ok := strings.Cut(signed, ".")
+	if !ok {
+		return errInvalidSignature
+	}
+
+	expected, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil || !hmac.Equal(expected, valueMAC(purpose, encoded)) {
+		return errInvalidSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
+
+func valueMAC(purpose string, encoded string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose + "\x00" + encoded))
+	return mac.Sum(nil)
+}
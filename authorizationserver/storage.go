@@ -0,0 +1,233 @@
+package authorizationserver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ory/fosite"
+	_ "modernc.org/sqlite" // registers the pure Go "sqlite" driver
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// exampleStore persists clients and tokens in SQLite and checks the resource owner password credentials against the
+// user directory.
+type exampleStore struct {
+	*sqlstore.Store
+}
+
+// Authenticate checks the credentials of the resource owner password credentials grant and returns the subject.
+func (s *exampleStore) Authenticate(ctx context.Context, name string, secret string) (string, error) {
+	user, err := users.Authenticate(ctx, name, secret)
+	if err != nil {
+		return "", err
+	}
+	return user.Subject, nil
+}
+
+// CreateRefreshTokenSession stores the refresh token with the audience the session says it keeps, if the access token
+// issued along was narrowed down to fewer resources.
+func (s *exampleStore) CreateRefreshTokenSession(ctx context.Context, signature string, accessSignature string, request fosite.Requester) error {
+	if session, ok := request.GetSession().(*Session); ok && session.RefreshTokenAudience != nil {
+		if r, ok := request.(*fosite.Request); ok {
+			stored := *r
+			stored.GrantedAudience = session.RefreshTokenAudience
+			request = &stored
+		}
+	}
+	return s.Store.CreateRefreshTokenSession(ctx, signature, accessSignature, request)
+}
+
+// DeletePARSession keeps a pushed authorization request when fosite loads it: the login and consent pages post to the
+// same request URI until the user decided, see pageAction. authEndpoint deletes the request once the client got its
+// response, abandoned requests are deleted after they expired.
+func (s *exampleStore) DeletePARSession(ctx context.Context, requestURI string) error {
+	return nil
+}
+
+// openExampleDatabase opens the database named by DATABASE_DSN, defaulting to `fosite-example.db` in the working
+// directory, brings its schema up to date, adds the example clients that do not exist yet and the clients of the
+// trusted issuers. Use `DATABASE_DSN=file::memory:` to start from scratch on every run.
+func openExampleDatabase() *sqlstore.Store {
+	dsn := "fosite-example.db"
+	if os.Getenv("DATABASE_DSN") != "" {
+		dsn = os.Getenv("DATABASE_DSN")
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		log.Fatalf("Error occurred in sql.Open: %+v", err)
+	}
+	// SQLite allows a single writer only, serialize access instead of failing with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	s := sqlstore.New(db)
+	if err := s.Migrate(ctx); err != nil {
+		log.Fatalf("Error occurred in Migrate: %+v", err)
+	}
+
+	for _, client := range exampleClients {
+		if _, err := s.GetClient(ctx, client.GetID()); errors.Is(err, fosite.ErrNotFound) {
+			if err := s.CreateClient(ctx, client); err != nil {
+				log.Fatalf("Error occurred in CreateClient: %+v", err)
+			}
+		} else if err != nil {
+			log.Fatalf("Error occurred in GetClient: %+v", err)
+		}
+	}
+
+	// The clients of the trusted issuers follow the registry, changes to it apply on the next start.
+	for _, trusted := range trustedIssuerRegistry {
+		client := trusted.client()
+		if _, err := s.GetClient(ctx, client.GetID()); errors.Is(err, fosite.ErrNotFound) {
+			if err := s.CreateClient(ctx, client); err != nil {
+				log.Fatalf("Error occurred in CreateClient: %+v", err)
+			}
+		} else if err != nil {
+			log.Fatalf("Error occurred in GetClient: %+v", err)
+		} else if err := s.UpdateClient(ctx, client); err != nil {
+			log.Fatalf("Error occurred in UpdateClient: %+v", err)
+		}
+	}
+
+	return s
+}
+
+// runCleanup deletes expired pushed authorization requests, device and CIBA authorizations and JWT IDs every interval
+// until the context is canceled. Rows are kept for another interval after they expired, so a device that polls late
+// is still told that its code expired.
+func runCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.DeleteExpired(ctx, time.Now().Add(-interval)); err != nil {
+				log.Printf("Error occurred in DeleteExpired: %+v", err)
+			}
+		}
+	}
+}
+
+// exampleClients are added to the database on start:
+//   - "my-client" is the client of `storage.NewExampleStore()`, additionally allowed to request the scopes of the
+//     OpenID Connect standard claims served at `/userinfo`. It is notified by front- and back-channel when the user
+//     logs out at `/oauth2/logout`.
+//   - "my-device" is a public client for the device authorization grant, like a CLI tool or a TV app.
+//   - "my-call-center" is a call-center app with secret "foobar". It authenticates users over the phone with CIBA
+//     and polls the token endpoint for the outcome. Users approve its requests at `/ciba`.
+//   - "my-service" is a backend service with secret "foobar". It may exchange tokens of "my-client" for tokens of the
+//     photos API, on its own behalf or the user's.
+//   - "my-jwt-client" is a backend service authenticating with `private_key_jwt`. Its key is served by the example
+//     client at `/client/jwks.json`.
+//   - "my-secret-jwt-client" is a backend service authenticating with `client_secret_jwt`.
+//   - "my-mtls-client" is a backend service authenticating with a certificate for "CN=my-mtls-client" issued by the
+//     certificate authority of MTLS_CA_FILE. Its access tokens are bound to the certificate.
+//
+// The clients may request tokens for the protected resource of the example (`resource=http://localhost:3846/protected`)
+// or the photos API, as their audiences allow. Tokens without an audience are not accepted at `/protected`.
+var exampleClients = []*sqlstore.Client{{
+	DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+		DefaultClient: &fosite.DefaultClient{
+			ID:             "my-client",
+			Secret:         []byte(`$2a$10$IxMdI6d.LIRZPpSfEwNoeu4rY3FhDREsxFJXikcgdRRAStxUlsuEO`),           // = "foobar"
+			RotatedSecrets: [][]byte{[]byte(`$2y$10$X51gLxUQJ.hGw1epgHTE5u0bt64xM0COU7K9iAp.OFg8p2pUd.1zC`)}, // = "foobaz",
+			RedirectURIs:   []string{"http://localhost:3846/callback"},
+			ResponseTypes:  []string{"id_token", "code", "token", "id_token token", "code id_token", "code token", "code id_token token"},
+			GrantTypes:     []string{"implicit", "refresh_token", "authorization_code", "password", "client_credentials"},
+			Scopes:         []string{"fosite", "openid", "photos", "offline", "profile", "email", "address", "phone"},
+			Audience:       []string{"http://localhost:3846/protected", "https://photos.my-application.com"},
+		},
+		TokenEndpointAuthMethod: "client_secret_basic",
+	},
+	PostLogoutRedirectURIs:            []string{"http://localhost:3846/"},
+	FrontChannelLogoutURI:             "http://localhost:3846/logout/frontchannel",
+	FrontChannelLogoutSessionRequired: true,
+	BackChannelLogoutURI:              "http://localhost:3846/logout/backchannel",
+	BackChannelLogoutSessionRequired:  true,
+}, {
+	DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+		DefaultClient: &fosite.DefaultClient{
+			ID:         "my-device",
+			Public:     true,
+			GrantTypes: []string{deviceCodeGrantType, "refresh_token"},
+			Scopes:     []string{"openid", "offline", "profile", "email"},
+			Audience:   []string{"http://localhost:3846/protected"},
+		},
+		TokenEndpointAuthMethod: "none",
+	},
+}, {
+	DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+		DefaultClient: &fosite.DefaultClient{
+			ID:         "my-call-center",
+			Secret:     []byte(`$2a$10$IxMdI6d.LIRZPpSfEwNoeu4rY3FhDREsxFJXikcgdRRAStxUlsuEO`), // = "foobar"
+			GrantTypes: []string{cibaGrantType, "refresh_token"},
+			Scopes:     []string{"openid", "offline", "profile", "email"},
+			Audience:   []string{"http://localhost:3846/protected"},
+		},
+		TokenEndpointAuthMethod: "client_secret_basic",
+	},
+	ClientName:                   "Call Center",
+	BackChannelTokenDeliveryMode: cibaPollMode,
+}, {
+	DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+		DefaultClient: &fosite.DefaultClient{
+			ID:         "my-service",
+			Secret:     []byte(`$2a$10$IxMdI6d.LIRZPpSfEwNoeu4rY3FhDREsxFJXikcgdRRAStxUlsuEO`), // = "foobar"
+			GrantTypes: []string{"client_credentials", tokenExchangeGrantType},
+			Scopes:     []string{"fosite", "photos", "openid", "profile"},
+			Audience:   []string{"https://photos.my-application.com"},
+		},
+		TokenEndpointAuthMethod: "client_secret_basic",
+	},
+	TokenExchange: &sqlstore.TokenExchangePolicy{
+		Audiences:      []string{"https://photos.my-application.com"},
+		SubjectClients: []string{"my-client"},
+		Impersonation:  true,
+		Delegation:     true,
+	},
+}, {
+	DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+		DefaultClient: &fosite.DefaultClient{
+			ID:         "my-mtls-client",
+			GrantTypes: []string{"client_credentials"},
+			Scopes:     []string{"fosite", "photos"},
+			Audience:   []string{"http://localhost:3846/protected"},
+		},
+		TokenEndpointAuthMethod: tlsClientAuth,
+	},
+	TLSClientAuthSubjectDN:                "CN=my-mtls-client",
+	TLSClientCertificateBoundAccessTokens: true,
+}, {
+	DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+		DefaultClient: &fosite.DefaultClient{
+			ID:         "my-jwt-client",
+			GrantTypes: []string{"client_credentials"},
+			Scopes:     []string{"fosite", "photos"},
+			Audience:   []string{"http://localhost:3846/protected"},
+		},
+		JSONWebKeysURI:                    "http://localhost:3846/client/jwks.json",
+		TokenEndpointAuthMethod:           privateKeyJWT,
+		TokenEndpointAuthSigningAlgorithm: "ES256",
+	},
+}, {
+	DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+		DefaultClient: &fosite.DefaultClient{
+			ID:         "my-secret-jwt-client",
+			GrantTypes: []string{"client_credentials"},
+			Scopes:     []string{"fosite", "photos"},
+			Audience:   []string{"http://localhost:3846/protected"},
+		},
+		TokenEndpointAuthMethod:           clientSecretJWT,
+		TokenEndpointAuthSigningAlgorithm: "HS256",
+	},
+	AssertionSecret: "some-cool-assertion-secret-of-32-bytes",
+}}
@@ -0,0 +1,37 @@
+package authorizationserver
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// useTestStore points the store of the server at an empty in-memory database until the test ends, and stores the
+// given clients in it. fosite holds on to the same *exampleStore, so its handlers use the database as well.
+func useTestStore(t *testing.T, clients ...*sqlstore.Client) *sqlstore.Store {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	s := sqlstore.New(db)
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	for _, client := range clients {
+		if err := s.CreateClient(context.Background(), client); err != nil {
+			t.Fatalf("CreateClient: %v", err)
+		}
+	}
+
+	previous := store.Store
+	store.Store = s
+	t.Cleanup(func() { store.Store = previous })
+	return s
+}
@@ -0,0 +1,137 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite"
+
+	"github.com/ory/fosite-example/sqlstore"
+)
+
+// TrustedIssuer is an entry of the trusted issuer registry. Its JWT assertions are traded for access tokens with the
+// JWT bearer grant of RFC 7523, e.g. by batch jobs holding a JWT of an internal certificate authority. The caller does
+// not need client credentials, the tokens are issued to the client of the issuer.
+type TrustedIssuer struct {
+	// Issuer is the `iss` claim of the assertions.
+	Issuer string `json:"issuer"`
+	// JWKSURI is where the keys the issuer signs with are fetched from. Alternatively they are given as JWKS.
+	JWKSURI string              `json:"jwks_uri,omitempty"`
+	JWKS    *jose.JSONWebKeySet `json:"jwks,omitempty"`
+	// ClientID is the client the tokens are issued to, it defaults to the issuer. The client is created on start and
+	// has no credentials, it is only used through assertions of the issuer.
+	ClientID string `json:"client_id,omitempty"`
+	// Audience are the audiences callers may request tokens for with `audience` or `resource` parameters.
+	Audience []string `json:"audience,omitempty"`
+	// Rules are the subjects the issuer may assert and the scopes their tokens may carry. The first rule matching the
+	// `sub` claim applies, subjects the rules do not match are rejected.
+	Rules []TrustedIssuerRule `json:"rules"`
+}
+
+// TrustedIssuerRule grants subjects of a trusted issuer scopes.
+type TrustedIssuerRule struct {
+	// Subject is a pattern of the subjects the rule applies to, as understood by path.Match, e.g. `batch-*`.
+	Subject string   `json:"subject"`
+	Scopes  []string `json:"scopes"`
+}
+
+// scopesFor returns the scopes the issuer may assert for the subject. It returns false if no rule matches the subject.
+func (i *TrustedIssuer) scopesFor(subject string) ([]string, bool) {
+	for _, rule := range i.Rules {
+		if ok, _ := path.Match(rule.Subject, subject); ok {
+			return rule.Scopes, true
+		}
+	}
+	return nil, false
+}
+
+// keys returns the keys the issuer signs with. Keys behind a `jwks_uri` are cached, refresh fetches them again.
+func (i *TrustedIssuer) keys(ctx context.Context, refresh bool) (*jose.JSONWebKeySet, error) {
+	if i.JWKS != nil {
+		return i.JWKS, nil
+	}
+	return config.GetJWKSFetcherStrategy(ctx).Resolve(ctx, i.JWKSURI, refresh)
+}
+
+// client returns the client the tokens of the issuer are issued to. It may request all scopes of the rules.
+func (i *TrustedIssuer) client() *sqlstore.Client {
+	var scopes fosite.Arguments
+	for _, rule := range i.Rules {
+		for _, scope := range rule.Scopes {
+			if !scopes.Has(scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	return &sqlstore.Client{
+		DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+			DefaultClient: &fosite.DefaultClient{
+				ID:         i.ClientID,
+				GrantTypes: []string{jwtBearerGrantType},
+				Scopes:     scopes,
+				Audience:   i.Audience,
+			},
+			TokenEndpointAuthMethod: "client_secret_basic",
+		},
+		ClientName: "Trusted issuer " + i.Issuer,
+	}
+}
+
+// trustedIssuers are the issuers whose assertions are accepted without client authentication, by issuer.
+type trustedIssuers map[string]*TrustedIssuer
+
+// newTrustedIssuers reads the registry from the JSON file named by TRUSTED_ISSUERS_FILE, see
+// `trusted-issuers.example.json`. Without it, the registry contains the certificate authority of the example client.
+func newTrustedIssuers() trustedIssuers {
+	entries := exampleTrustedIssuers
+	if file := os.Getenv("TRUSTED_ISSUERS_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Error occurred in ReadFile: %+v", err)
+		}
+		entries = nil
+		if err := json.Unmarshal(data, &entries); err != nil {
+			log.Fatalf("Invalid TRUSTED_ISSUERS_FILE %q: %+v", file, err)
+		}
+	}
+
+	issuers := make(trustedIssuers, len(entries))
+	for _, entry := range entries {
+		if entry.Issuer == "" {
+			log.Fatalf("Invalid trusted issuer: the issuer is missing")
+		}
+		if (entry.JWKS == nil) == (entry.JWKSURI == "") {
+			log.Fatalf("Invalid trusted issuer %q: exactly one of jwks and jwks_uri is required", entry.Issuer)
+		}
+		if entry.JWKSURI != "" && !isWebURL(entry.JWKSURI) {
+			log.Fatalf("Invalid trusted issuer %q: the jwks_uri must be an absolute http or https URL", entry.Issuer)
+		}
+		for _, rule := range entry.Rules {
+			if _, err := path.Match(rule.Subject, ""); err != nil {
+				log.Fatalf("Invalid trusted issuer %q: the subject pattern %q is malformed", entry.Issuer, rule.Subject)
+			}
+		}
+		if entry.ClientID == "" {
+			entry.ClientID = entry.Issuer
+		}
+		issuers[entry.Issuer] = entry
+	}
+	return issuers
+}
+
+// exampleTrustedIssuers trusts the certificate authority the example client plays at `/ca`. Its batch jobs, subjects
+// starting with "batch-", may get tokens for the protected resource.
+var exampleTrustedIssuers = []*TrustedIssuer{{
+	Issuer:   "http://localhost:3846/ca",
+	JWKSURI:  "http://localhost:3846/ca/jwks.json",
+	ClientID: "my-batch-jobs",
+	Audience: []string{"http://localhost:3846/protected"},
+	Rules: []TrustedIssuerRule{
+		{Subject: "batch-*", Scopes: []string{"fosite", "photos"}},
+	},
+}}
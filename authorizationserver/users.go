@@ -0,0 +1,270 @@
+package authorizationserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ory/fosite"
+)
+
+// User is an entry of the user directory. Besides the login credentials, the fields are the OpenID Connect standard
+// claims, see https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
+type User struct {
+	Subject string `json:"sub"`
+
+	// Username is the name the user logs in with, it defaults to the subject.
+	Username string `json:"username,omitempty"`
+	// PasswordHash is a bcrypt or argon2id hash of the user's password, see comparePassword. Users without a password
+	// hash can not log in.
+	PasswordHash string `json:"password_hash,omitempty"`
+
+	Name                string       `json:"name,omitempty"`
+	GivenName           string       `json:"given_name,omitempty"`
+	FamilyName          string       `json:"family_name,omitempty"`
+	MiddleName          string       `json:"middle_name,omitempty"`
+	Nickname            string       `json:"nickname,omitempty"`
+	PreferredUsername   string       `json:"preferred_username,omitempty"`
+	Profile             string       `json:"profile,omitempty"`
+	Picture             string       `json:"picture,omitempty"`
+	Website             string       `json:"website,omitempty"`
+	Gender              string       `json:"gender,omitempty"`
+	Birthdate           string       `json:"birthdate,omitempty"`
+	Zoneinfo            string       `json:"zoneinfo,omitempty"`
+	Locale              string       `json:"locale,omitempty"`
+	UpdatedAt           time.Time    `json:"updated_at,omitempty"`
+	Email               string       `json:"email,omitempty"`
+	EmailVerified       bool         `json:"email_verified,omitempty"`
+	PhoneNumber         string       `json:"phone_number,omitempty"`
+	PhoneNumberVerified bool         `json:"phone_number_verified,omitempty"`
+	Address             *UserAddress `json:"address,omitempty"`
+}
+
+// UserAddress is the `address` claim.
+type UserAddress struct {
+	Formatted     string `json:"formatted,omitempty"`
+	StreetAddress string `json:"street_address,omitempty"`
+	Locality      string `json:"locality,omitempty"`
+	Region        string `json:"region,omitempty"`
+	PostalCode    string `json:"postal_code,omitempty"`
+	Country       string `json:"country,omitempty"`
+}
+
+// Claims returns the claims the given scopes grant access to, as defined in section 5.4 of OpenID Connect Core.
+// The `sub` claim is always included.
+func (u *User) Claims(scopes fosite.Arguments) map[string]interface{} {
+	claims := map[string]interface{}{"sub": u.Subject}
+	set := func(key string, value interface{}, present bool) {
+		if present {
+			claims[key] = value
+		}
+	}
+
+	if scopes.Has("profile") {
+		set("name", u.Name, u.Name != "")
+		set("given_name", u.GivenName, u.GivenName != "")
+		set("family_name", u.FamilyName, u.FamilyName != "")
+		set("middle_name", u.MiddleName, u.MiddleName != "")
+		set("nickname", u.Nickname, u.Nickname != "")
+		set("preferred_username", u.PreferredUsername, u.PreferredUsername != "")
+		set("profile", u.Profile, u.Profile != "")
+		set("picture", u.Picture, u.Picture != "")
+		set("website", u.Website, u.Website != "")
+		set("gender", u.Gender, u.Gender != "")
+		set("birthdate", u.Birthdate, u.Birthdate != "")
+		set("zoneinfo", u.Zoneinfo, u.Zoneinfo != "")
+		set("locale", u.Locale, u.Locale != "")
+		set("updated_at", u.UpdatedAt.Unix(), !u.UpdatedAt.IsZero())
+	}
+	if scopes.Has("email") {
+		set("email", u.Email, u.Email != "")
+		set("email_verified", u.EmailVerified, u.Email != "")
+	}
+	if scopes.Has("address") {
+		set("address", u.Address, u.Address != nil)
+	}
+	if scopes.Has("phone") {
+		set("phone_number", u.PhoneNumber, u.PhoneNumber != "")
+		set("phone_number_verified", u.PhoneNumberVerified, u.PhoneNumber != "")
+	}
+
+	return claims
+}
+
+// LoginName returns the name the user logs in with.
+func (u *User) LoginName() string {
+	if u.Username != "" {
+		return u.Username
+	}
+	return u.Subject
+}
+
+// UserDirectory looks up the users of the authorization server.
+type UserDirectory interface {
+	// GetUser returns the user with the given subject or fosite.ErrNotFound.
+	GetUser(ctx context.Context, subject string) (*User, error)
+
+	// Authenticate checks a username and password and returns the user. It returns fosite.ErrNotFound if the user
+	// does not exist or the password is wrong, so callers can not tell the two apart.
+	Authenticate(ctx context.Context, username string, password string) (*User, error)
+}
+
+// MemoryUserDirectory is a UserDirectory kept in memory.
+type MemoryUserDirectory struct {
+	mu         sync.RWMutex
+	users      map[string]*User
+	byUsername map[string]*User
+}
+
+// NewMemoryUserDirectory returns a directory containing the given users.
+func NewMemoryUserDirectory(users ...*User) *MemoryUserDirectory {
+	d := &MemoryUserDirectory{}
+	d.set(users)
+	return d
+}
+
+func (d *MemoryUserDirectory) set(users []*User) {
+	byID := make(map[string]*User, len(users))
+	byUsername := make(map[string]*User, len(users))
+	for _, user := range users {
+		byID[user.Subject] = user
+		byUsername[user.LoginName()] = user
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users, d.byUsername = byID, byUsername
+}
+
+func (d *MemoryUserDirectory) GetUser(_ context.Context, subject string) (*User, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	user, ok := d.users[subject]
+	if !ok {
+		return nil, fosite.ErrNotFound
+	}
+	return user, nil
+}
+
+func (d *MemoryUserDirectory) Authenticate(_ context.Context, username string, password string) (*User, error) {
+	d.mu.RLock()
+	user, ok := d.byUsername[username]
+	d.mu.RUnlock()
+
+	if !ok || user.PasswordHash == "" {
+		// Spend the same time as for an existing user.
+		_ = comparePassword(unknownUserHash, password)
+		return nil, fosite.ErrNotFound.WithDebug("Invalid credentials")
+	}
+
+	if err := comparePassword(user.PasswordHash, password); errors.Is(err, errPasswordMismatch) {
+		return nil, fosite.ErrNotFound.WithDebug("Invalid credentials")
+	} else if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// FileUserDirectory is a UserDirectory read from a JSON file containing an array of users, see
+// `users.example.json`. The file is read again when it changes, so users can be added without a restart.
+type FileUserDirectory struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	users   *MemoryUserDirectory
+}
+
+// NewFileUserDirectory reads the users from the given file.
+func NewFileUserDirectory(path string) (*FileUserDirectory, error) {
+	d := &FileUserDirectory{path: path, users: NewMemoryUserDirectory()}
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// reload reads the file if it changed since it was last read.
+func (d *FileUserDirectory) reload() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(d.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	var users []*User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return err
+	}
+
+	d.users.set(users)
+	d.modTime = info.ModTime()
+	return nil
+}
+
+func (d *FileUserDirectory) GetUser(ctx context.Context, subject string) (*User, error) {
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+	return d.users.GetUser(ctx, subject)
+}
+
+func (d *FileUserDirectory) Authenticate(ctx context.Context, username string, password string) (*User, error) {
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+	return d.users.Authenticate(ctx, username, password)
+}
+
+// newUserDirectory reads the users from the file named by USERS_FILE. Without it, the directory only contains the
+// example user "peter" with password "secret".
+func newUserDirectory() UserDirectory {
+	path := os.Getenv("USERS_FILE")
+	if path == "" {
+		return NewMemoryUserDirectory(exampleUsers...)
+	}
+
+	d, err := NewFileUserDirectory(path)
+	if err != nil {
+		log.Fatalf("Error occurred in NewFileUserDirectory: %+v", err)
+	}
+	return d
+}
+
+// exampleUsers mirrors the user of `storage.NewExampleStore()` and adds profile data to it.
+var exampleUsers = []*User{
+	{
+		Subject:           "peter",
+		PasswordHash:      "$2a$10$znUZERoANMryuUQeN0SpSuoFINO5BF6ubKJ5S4RB.tiGMEI.56C/S", // = "secret"
+		Name:              "Peter Example",
+		GivenName:         "Peter",
+		FamilyName:        "Example",
+		PreferredUsername: "peter",
+		Locale:            "en-US",
+		Zoneinfo:          "Europe/Berlin",
+		UpdatedAt:         time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Email:             "peter@example.com",
+		EmailVerified:     true,
+		PhoneNumber:       "+1 555 0100",
+		Address: &UserAddress{
+			StreetAddress: "1 Example Street",
+			Locality:      "Springfield",
+			PostalCode:    "12345",
+			Country:       "US",
+		},
+	},
+}
@@ -0,0 +1,153 @@
+// Package dpop implements the proofs of OAuth 2.0 Demonstrating Proof of Possession (RFC 9449). The authorization
+// server and the resource server use it to check proofs, clients use Transport to send them.
+//
+// A DPoP proof is a JWT signed with a key of the client, carrying the public key in its header. Access tokens issued
+// with a proof are bound to the thumbprint of that key, so they are useless without the private key.
+package dpop
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+)
+
+const (
+	// HeaderName is the header proofs are sent in.
+	HeaderName = "DPoP"
+	// NonceHeaderName is the header servers send nonces in.
+	NonceHeaderName = "DPoP-Nonce"
+	// Scheme is the authorization scheme of DPoP-bound access tokens.
+	Scheme = "DPoP"
+	// TokenType is the `token_type` of DPoP-bound access tokens.
+	TokenType = "DPoP"
+
+	proofType = "dpop+jwt"
+)
+
+// SigningAlgs are the algorithms proofs may be signed with, only asymmetric ones make sense.
+var SigningAlgs = []string{
+	string(jose.RS256), string(jose.RS384), string(jose.RS512),
+	string(jose.PS256), string(jose.PS384), string(jose.PS512),
+	string(jose.ES256), string(jose.ES384), string(jose.ES512),
+}
+
+// ErrInvalidProof is returned for proofs that are malformed, not signed by their key or not made for the request.
+var ErrInvalidProof = errors.New("invalid DPoP proof")
+
+// Proof is a verified DPoP proof.
+type Proof struct {
+	JTI             string `json:"jti"`
+	Method          string `json:"htm"`
+	URL             string `json:"htu"`
+	IssuedAt        int64  `json:"iat"`
+	AccessTokenHash string `json:"ath,omitempty"`
+	Nonce           string `json:"nonce,omitempty"`
+
+	// Thumbprint is the JWK SHA-256 thumbprint (RFC 7638) of the key the proof was signed with. Tokens are bound to
+	// it with the `cnf.jkt` claim.
+	Thumbprint string `json:"-"`
+}
+
+// Parse checks the signature of a proof against the key in its header and returns the proof. It does not check what
+// the proof was made for, see Validate.
+func Parse(raw string) (*Proof, error) {
+	jws, err := jose.ParseSigned(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidProof, err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, fmt.Errorf("%w: expected exactly one signature", ErrInvalidProof)
+	}
+
+	header := jws.Signatures[0].Header
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != proofType {
+		return nil, fmt.Errorf("%w: expected type %q but got %q", ErrInvalidProof, proofType, typ)
+	}
+	if !has(SigningAlgs, header.Algorithm) {
+		return nil, fmt.Errorf("%w: unsupported signing algorithm %q", ErrInvalidProof, header.Algorithm)
+	}
+	if header.JSONWebKey == nil || !header.JSONWebKey.IsPublic() {
+		return nil, fmt.Errorf("%w: the header must carry a public key", ErrInvalidProof)
+	}
+
+	payload, err := jws.Verify(header.JSONWebKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidProof, err)
+	}
+
+	var proof Proof
+	if err := json.Unmarshal(payload, &proof); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidProof, err)
+	}
+	if proof.JTI == "" || proof.Method == "" || proof.URL == "" || proof.IssuedAt == 0 {
+		return nil, fmt.Errorf("%w: the jti, htm, htu and iat claims are required", ErrInvalidProof)
+	}
+
+	if proof.Thumbprint, err = Thumbprint(header.JSONWebKey); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidProof, err)
+	}
+	return &proof, nil
+}
+
+// Validate checks that the proof was made for a request with the given method and URL, no longer than window ago.
+// If accessToken is set, the proof must have been made for it. Nonces and the uniqueness of `jti` are up to the
+// caller.
+func (p *Proof) Validate(method string, uri string, accessToken string, window time.Duration) error {
+	if p.Method != method {
+		return fmt.Errorf("%w: made for method %q, not %q", ErrInvalidProof, p.Method, method)
+	}
+	if normalizeURL(p.URL) != normalizeURL(uri) {
+		return fmt.Errorf("%w: made for %q, not %q", ErrInvalidProof, p.URL, uri)
+	}
+
+	issuedAt := time.Unix(p.IssuedAt, 0)
+	if age := time.Since(issuedAt); age > window || age < -window {
+		return fmt.Errorf("%w: issued at %s, which is too far from now", ErrInvalidProof, issuedAt)
+	}
+
+	if accessToken != "" && p.AccessTokenHash != AccessTokenHash(accessToken) {
+		return fmt.Errorf("%w: not made for the access token", ErrInvalidProof)
+	}
+	return nil
+}
+
+// AccessTokenHash returns the `ath` claim of proofs for the access token.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Thumbprint returns the base64url encoded JWK SHA-256 thumbprint of the key.
+func Thumbprint(key *jose.JSONWebKey) (string, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// normalizeURL drops the query and fragment and lower-cases scheme and host, see section 4.3 of RFC 9449.
+func normalizeURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + u.EscapedPath()
+}
+
+func has(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+)
+
+// signTestProof signs the claims as a proof with the key, embedding its public key. The type header is typ.
+func signTestProof(t *testing.T, key *ecdsa.PrivateKey, typ string, claims interface{}) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{EmbedJWK: true}).WithType(jose.ContentType(typ)))
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	proof, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatalf("CompactSerialize: %v", err)
+	}
+	return proof
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+func TestParse(t *testing.T) {
+	key := newTestKey(t)
+	claims := Proof{JTI: "some-id", Method: "POST", URL: "https://server.example.com/token", IssuedAt: time.Now().Unix()}
+
+	for _, tc := range []struct {
+		name  string
+		proof string
+		valid bool
+	}{
+		{name: "valid", proof: signTestProof(t, key, proofType, claims), valid: true},
+		{name: "other type", proof: signTestProof(t, key, "JWT", claims)},
+		{name: "no jti", proof: signTestProof(t, key, proofType, map[string]interface{}{"htm": "POST", "htu": claims.URL, "iat": claims.IssuedAt})},
+		{name: "no htu", proof: signTestProof(t, key, proofType, map[string]interface{}{"jti": "some-id", "htm": "POST", "iat": claims.IssuedAt})},
+		{name: "not a JWS", proof: "not-a-proof"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			proof, err := Parse(tc.proof)
+			if !tc.valid {
+				if !errors.Is(err, ErrInvalidProof) {
+					t.Errorf("Parse() = %v, want ErrInvalidProof", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+
+			want, err := Thumbprint(&jose.JSONWebKey{Key: &key.PublicKey})
+			if err != nil {
+				t.Fatalf("Thumbprint: %v", err)
+			}
+			if proof.Thumbprint != want || proof.JTI != claims.JTI {
+				t.Errorf("Parse() = %+v, want the claims and the thumbprint %s", proof, want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now().Unix()
+	proof := func(overrides func(p *Proof)) *Proof {
+		p := &Proof{JTI: "some-id", Method: "POST", URL: "https://server.example.com/token", IssuedAt: now}
+		if overrides != nil {
+			overrides(p)
+		}
+		return p
+	}
+
+	for _, tc := range []struct {
+		name        string
+		proof       *Proof
+		method      string
+		uri         string
+		accessToken string
+		valid       bool
+	}{
+		{name: "valid", proof: proof(nil), method: "POST", uri: "https://server.example.com/token", valid: true},
+		{name: "query and case ignored", proof: proof(nil), method: "POST", uri: "HTTPS://Server.Example.com/token?foo=bar", valid: true},
+		{name: "other method", proof: proof(nil), method: "GET", uri: "https://server.example.com/token"},
+		{name: "other path", proof: proof(nil), method: "POST", uri: "https://server.example.com/userinfo"},
+		{name: "other host", proof: proof(nil), method: "POST", uri: "https://other.example.com/token"},
+		{name: "too old", proof: proof(func(p *Proof) { p.IssuedAt = now - 120 }), method: "POST", uri: "https://server.example.com/token"},
+		{name: "issued in the future", proof: proof(func(p *Proof) { p.IssuedAt = now + 120 }), method: "POST", uri: "https://server.example.com/token"},
+		{name: "access token", proof: proof(func(p *Proof) { p.AccessTokenHash = AccessTokenHash("some-token") }), method: "POST", uri: "https://server.example.com/token", accessToken: "some-token", valid: true},
+		{name: "other access token", proof: proof(func(p *Proof) { p.AccessTokenHash = AccessTokenHash("other-token") }), method: "POST", uri: "https://server.example.com/token", accessToken: "some-token"},
+		{name: "no ath", proof: proof(nil), method: "POST", uri: "https://server.example.com/token", accessToken: "some-token"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.proof.Validate(tc.method, tc.uri, tc.accessToken, time.Minute)
+			if tc.valid && err != nil {
+				t.Errorf("Validate() = %v", err)
+			} else if !tc.valid && !errors.Is(err, ErrInvalidProof) {
+				t.Errorf("Validate() = %v, want ErrInvalidProof", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package dpop
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+)
+
+// nonceError is the error servers answer with when a proof lacks their nonce.
+const nonceError = "use_dpop_nonce"
+
+// Transport is an http.RoundTripper that adds a DPoP proof to every request. Requests with an `Authorization: DPoP`
+// header get a proof for their access token. If a server asks for a nonce, the request is sent again with it and
+// the nonce is remembered for the next requests to that host.
+//
+// Use it as the base transport of golang.org/x/oauth2, for example by putting a client using it into the context with
+// oauth2.HTTPClient. Both token requests and calls with the issued tokens then carry proofs.
+type Transport struct {
+	// Base is the transport the requests are sent with, http.DefaultTransport if nil.
+	Base http.RoundTripper
+
+	signer jose.Signer
+
+	mu     sync.Mutex
+	nonces map[string]string
+}
+
+// NewTransport returns a transport that signs proofs with the key.
+func NewTransport(key *ecdsa.PrivateKey, base http.RoundTripper) (*Transport, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: key},
+		(&jose.SignerOptions{EmbedJWK: true}).WithType(proofType),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{Base: base, signer: signer, nonces: map[string]string{}}, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.send(req, t.nonce(req.URL.Host))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := res.Header.Get(NonceHeaderName)
+	if nonce == "" {
+		return res, nil
+	}
+	t.setNonce(req.URL.Host, nonce)
+
+	// Requests with a body can only be sent again if the body can be read again.
+	if !isNonceChallenge(res) || (req.Body != nil && req.GetBody == nil) {
+		return res, nil
+	}
+	res.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.send(retry, nonce)
+}
+
+func (t *Transport) send(req *http.Request, nonce string) (*http.Response, error) {
+	var accessToken string
+	if scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, Scheme) {
+		accessToken = token
+	}
+
+	proof, err := t.proof(req, accessToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// A RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set(HeaderName, proof)
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+func (t *Transport) proof(req *http.Request, accessToken string, nonce string) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	claims := Proof{
+		JTI:      base64.RawURLEncoding.EncodeToString(jti),
+		Method:   req.Method,
+		URL:      normalizeURL(req.URL.String()),
+		IssuedAt: time.Now().Unix(),
+		Nonce:    nonce,
+	}
+	if accessToken != "" {
+		claims.AccessTokenHash = AccessTokenHash(accessToken)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed, err := t.signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return signed.CompactSerialize()
+}
+
+func (t *Transport) nonce(host string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.nonces[host]
+}
+
+func (t *Transport) setNonce(host string, nonce string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nonces[host] = nonce
+}
+
+// isNonceChallenge reports whether the server rejected the request because the proof lacked its nonce. Token
+// endpoints say so in a JSON error, resources in the `WWW-Authenticate` header.
+func isNonceChallenge(res *http.Response) bool {
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		return strings.Contains(res.Header.Get("WWW-Authenticate"), `error="`+nonceError+`"`)
+	case http.StatusBadRequest:
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return false
+		}
+
+		var payload struct {
+			Error string `json:"error"`
+		}
+		return json.Unmarshal(body, &payload) == nil && payload.Error == nonceError
+	}
+	return false
+}
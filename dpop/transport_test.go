@@ -0,0 +1,117 @@
+package dpop
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransportNonce(t *testing.T) {
+	type received struct {
+		proof *Proof
+		body  string
+	}
+	var requests []received
+
+	// The server asks for its nonce like a token endpoint at /token and like a resource anywhere else.
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		proof, err := Parse(req.Header.Get(HeaderName))
+		if err != nil {
+			t.Errorf("Parse() = %v", err)
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		requests = append(requests, received{proof: proof, body: string(body)})
+
+		if proof.Nonce == "server-nonce" {
+			return
+		}
+		rw.Header().Set(NonceHeaderName, "server-nonce")
+		if req.URL.Path == "/token" {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte(`{"error":"use_dpop_nonce"}`))
+			return
+		}
+		rw.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce"`)
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	transport, err := NewTransport(newTestKey(t), nil)
+	if err != nil {
+		t.Fatalf("NewTransport: %v", err)
+	}
+	client := &http.Client{Transport: transport}
+
+	// The token request is sent again with the nonce, body and all.
+	res, err := client.Post(server.URL+"/token", "application/x-www-form-urlencoded", strings.NewReader("grant_type=client_credentials"))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || len(requests) != 2 {
+		t.Fatalf("got %d after %d requests, want 200 after the retry", res.StatusCode, len(requests))
+	}
+	for i, r := range requests {
+		if r.proof.Method != http.MethodPost || r.proof.URL != server.URL+"/token" || r.body != "grant_type=client_credentials" {
+			t.Errorf("request %d carried proof %+v and body %q", i, r.proof, r.body)
+		}
+		if time.Since(time.Unix(r.proof.IssuedAt, 0)) > time.Minute || r.proof.AccessTokenHash != "" {
+			t.Errorf("request %d carried proof %+v", i, r.proof)
+		}
+	}
+	if requests[0].proof.JTI == requests[1].proof.JTI {
+		t.Error("the retry reused the jti of the first proof")
+	}
+
+	// The nonce is remembered for the host, the next request carries it right away.
+	requests = nil
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/resource?query=ignored", nil)
+	req.Header.Set("Authorization", "DPoP some-token")
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || len(requests) != 1 {
+		t.Fatalf("got %d after %d requests, want 200 at once", res.StatusCode, len(requests))
+	}
+	if proof := requests[0].proof; proof.Method != http.MethodGet || proof.URL != server.URL+"/resource" || proof.AccessTokenHash != AccessTokenHash("some-token") {
+		t.Errorf("the request to the resource carried proof %+v", proof)
+	}
+}
+
+func TestTransportResourceNonce(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		attempts++
+		proof, err := Parse(req.Header.Get(HeaderName))
+		if err == nil && proof.Nonce == "server-nonce" {
+			return
+		}
+		rw.Header().Set(NonceHeaderName, "server-nonce")
+		rw.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce"`)
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	transport, err := NewTransport(newTestKey(t), nil)
+	if err != nil {
+		t.Fatalf("NewTransport: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/resource", nil)
+	req.Header.Set("Authorization", "DPoP some-token")
+	res, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || attempts != 2 {
+		t.Errorf("got %d after %d attempts, want 200 after the retry", res.StatusCode, attempts)
+	}
+}
@@ -1,11 +1,23 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"log"
+	"math/big"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/ory/fosite-example/authorizationserver"
 	"github.com/ory/fosite-example/oauth2client"
@@ -19,7 +31,7 @@ var clientConf = goauth.Config{
 	ClientID:     "my-client",
 	ClientSecret: "foobar",
 	RedirectURL:  "http://localhost:3846/callback",
-	Scopes:       []string{"photos", "openid", "offline"},
+	Scopes:       []string{"photos", "openid", "offline", "profile", "email"},
 	Endpoint: goauth.Endpoint{
 		TokenURL: "http://localhost:3846/oauth2/token",
 		AuthURL:  "http://localhost:3846/oauth2/auth",
@@ -28,42 +40,148 @@ var clientConf = goauth.Config{
 
 // The same thing (valid oauth2 client) but for using the client credentials grant
 var appClientConf = clientcredentials.Config{
-	ClientID:     "my-client",
-	ClientSecret: "foobar",
-	Scopes:       []string{"fosite"},
-	TokenURL:     "http://localhost:3846/oauth2/token",
+	ClientID:       "my-client",
+	ClientSecret:   "foobar",
+	Scopes:         []string{"fosite"},
+	TokenURL:       "http://localhost:3846/oauth2/token",
+	EndpointParams: url.Values{"resource": {"http://localhost:3846/protected"}},
 }
 
-// Samle client as above, but using a different secret to demonstrate secret rotation
+// Samle client as above, but using a different secret to demonstrate secret rotation. At runtime, secrets are rotated
+// with the admin API of the authorization server.
 var appClientConfRotated = clientcredentials.Config{
-	ClientID:     "my-client",
-	ClientSecret: "foobaz",
+	ClientID:       "my-client",
+	ClientSecret:   "foobaz",
+	Scopes:         []string{"fosite"},
+	TokenURL:       "http://localhost:3846/oauth2/token",
+	EndpointParams: url.Values{"resource": {"http://localhost:3846/protected"}},
+}
+
+// Clients using the client credentials grant as well, authenticating with a JWT signed with their key or their
+// secret instead of sending the secret along
+var jwtClientConf = clientcredentials.Config{
+	ClientID: "my-jwt-client",
+	Scopes:   []string{"fosite"},
+	TokenURL: "http://localhost:3846/oauth2/token",
+}
+
+var secretJWTClientConf = clientcredentials.Config{
+	ClientID:     "my-secret-jwt-client",
+	ClientSecret: "some-cool-assertion-secret-of-32-bytes",
 	Scopes:       []string{"fosite"},
 	TokenURL:     "http://localhost:3846/oauth2/token",
 }
 
+// The credentials the protected resource introspects tokens with. It is registered at the authorization server as a
+// resource server, not as a client, so it can not obtain tokens with them
+var resourceServerConf = clientcredentials.Config{
+	ClientID:     "my-resource-server",
+	ClientSecret: "foobar",
+	TokenURL:     "http://localhost:3846/oauth2/token",
+}
+
 func main() {
 	// ### oauth2 server ###
 	authorizationserver.RegisterHandlers() // the authorization server (fosite)
+	go rotateKeysOnSignal()                // rotate the signing keys on demand with `kill -HUP <pid>`
 
 	// ### oauth2 client ###
 	http.HandleFunc("/", oauth2client.HomeHandler(clientConf)) // show some links on the index
 
 	// the following handlers are oauth2 consumers
-	http.HandleFunc("/client", oauth2client.ClientEndpoint(appClientConf))            // complete a client credentials flow
-	http.HandleFunc("/client-new", oauth2client.ClientEndpoint(appClientConfRotated)) // complete a client credentials flow using rotated secret
-	http.HandleFunc("/owner", oauth2client.OwnerHandler(clientConf))                  // complete a resource owner password credentials flow
-	http.HandleFunc("/callback", oauth2client.CallbackHandler(clientConf))            // the oauth2 callback endpoint
+	http.HandleFunc("/client", oauth2client.ClientEndpoint(appClientConf))                                                // complete a client credentials flow
+	http.HandleFunc("/client-new", oauth2client.ClientEndpoint(appClientConfRotated))                                     // complete a client credentials flow using rotated secret
+	http.HandleFunc("/client-private-key-jwt", oauth2client.AssertionClientEndpoint(jwtClientConf, "private_key_jwt"))    // complete a client credentials flow using a private key JWT
+	http.HandleFunc("/client-secret-jwt", oauth2client.AssertionClientEndpoint(secretJWTClientConf, "client_secret_jwt")) // complete a client credentials flow using a client secret JWT
+	http.HandleFunc("/client/jwks.json", oauth2client.JWKSHandler)                                                        // the public key of "my-jwt-client"
+	http.HandleFunc("/owner", oauth2client.OwnerHandler(clientConf))                                                      // complete a resource owner password credentials flow
+	http.HandleFunc("/callback", oauth2client.CallbackHandler(clientConf, resourceServerConf))                            // the oauth2 callback endpoint
+	http.HandleFunc("/logout", oauth2client.LogoutHandler(clientConf))                                                    // log out here and at the authorization server
+	http.HandleFunc("/logout/frontchannel", oauth2client.FrontChannelLogoutHandler(clientConf))                           // notified in an iframe when the user logs out
+	http.HandleFunc("/logout/backchannel", oauth2client.BackChannelLogoutHandler(clientConf))                             // receives logout tokens when the user logs out
+
+	// a batch job trading an assertion of the certificate authority the example plays for a token, without client credentials
+	http.HandleFunc("/jwt-bearer", oauth2client.JWTBearerEndpoint(appClientConf.TokenURL, "http://localhost:3846/ca", "batch-nightly-report", "fosite"))
+	http.HandleFunc("/ca/jwks.json", oauth2client.CAJWKSHandler) // the public key of the certificate authority
 
 	// ### protected resource ###
-	http.HandleFunc("/protected", resourceserver.ProtectedEndpoint(appClientConf))
+	http.HandleFunc("/protected", resourceserver.ProtectedEndpoint(resourceServerConf))
 
 	port := "3846"
 	if os.Getenv("PORT") != "" {
 		port = os.Getenv("PORT")
 	}
 
+	// ### mutual TLS ###
+	// Clients authenticating with a certificate, or wanting certificate-bound tokens, connect here, e.g. MTLS_PORT=3847.
+	if os.Getenv("MTLS_PORT") != "" {
+		go serveMutualTLS(os.Getenv("MTLS_PORT"))
+	}
+
 	fmt.Println("Please open your webbrowser at http://localhost:" + port)
 	_ = exec.Command("open", "http://localhost:"+port).Run()
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
+
+// serveMutualTLS serves the same handlers over TLS, asking clients for a certificate. The listener accepts any
+// certificate: whether it authenticates a client is up to the authorization server, which checks it against the
+// certificate authorities of MTLS_CA_FILE or the client's own keys. The server certificate is read from MTLS_CERT_FILE
+// and MTLS_KEY_FILE, or generated for localhost if they are not set.
+func serveMutualTLS(port string) {
+	server := &http.Server{
+		Addr: ":" + port,
+		TLSConfig: &tls.Config{
+			ClientAuth: tls.RequestClientCert,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+
+	certFile, keyFile := os.Getenv("MTLS_CERT_FILE"), os.Getenv("MTLS_KEY_FILE")
+	if certFile == "" || keyFile == "" {
+		cert, err := localhostCertificate()
+		if err != nil {
+			log.Fatalf("Could not generate a server certificate for the mutual TLS listener: %+v", err)
+		}
+		server.TLSConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	fmt.Println("Accepting client certificates at https://localhost:" + port)
+	log.Fatal(server.ListenAndServeTLS(certFile, keyFile))
+}
+
+// localhostCertificate generates a self-signed server certificate for localhost. Clients have to be told to trust it.
+func localhostCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour * 24 * 365),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// rotateKeysOnSignal rotates the authorization server's signing keys whenever the process receives SIGHUP.
+func rotateKeysOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := authorizationserver.RotateSigningKeys(); err != nil {
+			log.Printf("Could not rotate signing keys: %+v", err)
+			continue
+		}
+		log.Println("Rotated signing keys")
+	}
+}
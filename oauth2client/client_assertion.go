@@ -0,0 +1,121 @@
+package oauth2client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	goauth "golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// The following provides the setup required for the client to authenticate with a JWT client assertion (RFC 7523)
+// instead of sending its secret. With `private_key_jwt` the assertion is signed with a key of the client, whose public
+// part the authorization server fetches from JWKSHandler. With `client_secret_jwt` it is signed with a shared secret.
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientAssertionLifespan is how long a client assertion is valid, it is used right away.
+const clientAssertionLifespan = time.Minute
+
+// clientKey is the key `private_key_jwt` assertions are signed with.
+var clientKey = newClientKey()
+
+func newClientKey() *jose.JSONWebKey {
+	// A new key on every start is fine for this example, the authorization server fetches the keys again when an
+	// assertion does not verify.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatalf("Could not generate the client key: %+v", err)
+	}
+
+	jwk := &jose.JSONWebKey{Key: key, Algorithm: string(jose.ES256), Use: "sig"}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		log.Fatalf("Could not compute the thumbprint of the client key: %+v", err)
+	}
+	jwk.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	return jwk
+}
+
+// JWKSHandler serves the public key of the client, the authorization server knows it as the client's `jwks_uri`.
+func JWKSHandler(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{clientKey.Public()}}); err != nil {
+		log.Printf("Error occurred in JWKSHandler: %+v", err)
+	}
+}
+
+// AssertionClientEndpoint completes a client credentials flow like ClientEndpoint, authenticating the client with a
+// JWT client assertion. method is either `private_key_jwt` or `client_secret_jwt`, the latter signs with the client
+// secret of the config.
+func AssertionClientEndpoint(c clientcredentials.Config, method string) func(rw http.ResponseWriter, req *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		// Assertions can be used once, so every token request needs a new one.
+		assertion, err := signClientAssertion(c.ClientID, c.ClientSecret, c.TokenURL, method)
+		if err != nil {
+			rw.Write([]byte(fmt.Sprintf(`<p>I tried to sign a client assertion but received an error: %s</p>`, err.Error())))
+			return
+		}
+
+		// The secret never leaves the client, the assertion is sent instead.
+		conf := c
+		conf.ClientSecret = ""
+		conf.AuthStyle = goauth.AuthStyleInParams
+		conf.EndpointParams = url.Values{
+			"client_assertion_type": {clientAssertionType},
+			"client_assertion":      {assertion},
+		}
+		ClientEndpoint(conf)(rw, req)
+	}
+}
+
+// signClientAssertion returns a client assertion for the token endpoint at audience.
+func signClientAssertion(clientID string, secret string, audience string, method string) (string, error) {
+	var key jose.SigningKey
+	switch method {
+	case "private_key_jwt":
+		key = jose.SigningKey{Algorithm: jose.ES256, Key: clientKey}
+	case "client_secret_jwt":
+		key = jose.SigningKey{Algorithm: jose.HS256, Key: []byte(secret)}
+	default:
+		return "", fmt.Errorf("unknown client authentication method %q", method)
+	}
+
+	signer, err := jose.NewSigner(key, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", err
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	payload, err := json.Marshal(map[string]interface{}{
+		"iss": clientID,
+		"sub": clientID,
+		"aud": audience,
+		"jti": base64.RawURLEncoding.EncodeToString(jti),
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionLifespan).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return signed.CompactSerialize()
+}
@@ -0,0 +1,54 @@
+package oauth2client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ory/fosite-example/dpop"
+)
+
+// The following provides the setup required for the client to bind its access tokens to a key with DPoP (RFC 9449).
+// The key never leaves the client, so a token that leaks, e.g. into a log, can not be used by anyone else.
+
+const cookieDPoP = "isDPoP"
+
+// dpopClient sends a DPoP proof with every request. Put it into the context with oauth2.HTTPClient to have the token
+// requests and the requests made with the issued tokens signed.
+var dpopClient = newDPoPClient()
+
+func newDPoPClient() *http.Client {
+	// A new key on every start is fine for this example, tokens issued before a restart are simply unusable.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatalf("Could not generate the DPoP key: %+v", err)
+	}
+
+	transport, err := dpop.NewTransport(key, nil)
+	if err != nil {
+		log.Fatalf("Could not create the DPoP transport: %+v", err)
+	}
+	return &http.Client{Transport: transport, Timeout: time.Second * 5}
+}
+
+// isDPoP detects whether the authorization code should be exchanged for a DPoP-bound access token.
+func isDPoP(r *http.Request) bool {
+	cookie, err := r.Cookie(cookieDPoP)
+	if err != nil {
+		return false
+	}
+
+	return cookie.Value == "true"
+}
+
+// resetDPoP removes the cookie that informs the client the callback request was a DPoP request.
+func resetDPoP(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:    cookieDPoP,
+		Path:    "/",
+		Expires: time.Unix(0, 0),
+	})
+}
@@ -2,17 +2,24 @@ package oauth2client
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
 
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 )
 
-func CallbackHandler(c oauth2.Config) func(rw http.ResponseWriter, req *http.Request) {
+// CallbackHandler completes the authorize code flow. Revoking and refreshing tokens, it introspects them with the
+// credentials of the resource server, as a client may not introspect tokens.
+func CallbackHandler(c oauth2.Config, resourceServer clientcredentials.Config) func(rw http.ResponseWriter, req *http.Request) {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		codeVerifier := resetPKCE(rw)
+		withDPoP := isDPoP(req)
+		resetDPoP(rw)
 		rw.Write([]byte(`<h1>Callback site</h1><a href="/">Go back</a>`))
 		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
 		if req.URL.Query().Get("error") != "" {
@@ -30,6 +37,13 @@ func CallbackHandler(c oauth2.Config) func(rw http.ResponseWriter, req *http.Req
 
 		client := newBasicClient(c.ClientID, c.ClientSecret)
 		if req.URL.Query().Get("revoke") != "" {
+			// Revoking the refresh token revokes the access token issued along with it as well, introspect the
+			// access token before and after to see it.
+			accessToken := req.URL.Query().Get("access_token")
+			if accessToken != "" {
+				rw.Write([]byte(fmt.Sprintf(`<p>Introspected the access token before revoking the refresh token:<br><code>%s</code></p>`, introspect(resourceServer, accessToken))))
+			}
+
 			revokeURL := strings.Replace(c.Endpoint.TokenURL, "token", "revoke", 1)
 			payload := url.Values{
 				"token_type_hint": {"refresh_token"},
@@ -46,8 +60,12 @@ func CallbackHandler(c oauth2.Config) func(rw http.ResponseWriter, req *http.Req
 				rw.Write([]byte(fmt.Sprintf(`<p>Got a response from the revoke endpoint:<br><code>%s</code></p>`, body)))
 			}
 
+			if accessToken != "" {
+				rw.Write([]byte(fmt.Sprintf(`<p>Introspected the access token after revoking the refresh token:<br><code>%s</code></p>`, introspect(resourceServer, accessToken))))
+			}
+
 			rw.Write([]byte(fmt.Sprintf(`<p>These tokens have been revoked, try to use the refresh token by <br><a href="%s">by clicking here</a></p>`, "?refresh="+url.QueryEscape(req.URL.Query().Get("revoke")))))
-			rw.Write([]byte(fmt.Sprintf(`<p>Try to use the access token by <br><a href="%s">by clicking here</a></p>`, "/protected?token="+url.QueryEscape(req.URL.Query().Get("access_token")))))
+			rw.Write([]byte(fmt.Sprintf(`<p>Try to use the access token by <br><a href="%s">by clicking here</a></p>`, "/protected?token="+url.QueryEscape(accessToken))))
 
 			return
 		}
@@ -58,12 +76,25 @@ func CallbackHandler(c oauth2.Config) func(rw http.ResponseWriter, req *http.Req
 				"refresh_token": {req.URL.Query().Get("refresh")},
 				"scope":         {"fosite"},
 			}
-			_, body, err := client.Post(c.Endpoint.TokenURL, payload)
+			resp, body, err := client.Post(c.Endpoint.TokenURL, payload)
 			if err != nil {
 				rw.Write([]byte(fmt.Sprintf(`<p>Could not refresh token %s</p>`, err)))
 				return
 			}
 			rw.Write([]byte(fmt.Sprintf(`<p>Got a response from the refresh grant:<br><code>%s</code></p>`, body)))
+
+			// Using a refresh token that was rotated before revokes the tokens issued in its place as well.
+			if accessToken := req.URL.Query().Get("access_token"); accessToken != "" {
+				rw.Write([]byte(fmt.Sprintf(`<p>Introspected the access token issued by the previous refresh:<br><code>%s</code></p>`, introspect(resourceServer, accessToken))))
+			}
+
+			var refreshed struct {
+				AccessToken string `json:"access_token"`
+			}
+			if resp.StatusCode == http.StatusOK && json.Unmarshal([]byte(body), &refreshed) == nil {
+				rw.Write([]byte(fmt.Sprintf(`<p>The refresh token was rotated. <a href="%s">Use it again</a> to see the reuse being detected, which revokes the tokens just issued.</p>`,
+					"?refresh="+url.QueryEscape(req.URL.Query().Get("refresh"))+"&access_token="+url.QueryEscape(refreshed.AccessToken))))
+			}
 			return
 		}
 
@@ -87,12 +118,34 @@ func CallbackHandler(c oauth2.Config) func(rw http.ResponseWriter, req *http.Req
 			opts = append(opts, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
 		}
 
-		token, err := c.Exchange(context.Background(), req.URL.Query().Get("code"), opts...)
+		// With DPoP, the token request and all requests made with the token carry a proof of the client's key.
+		ctx := context.Background()
+		if withDPoP {
+			ctx = context.WithValue(ctx, oauth2.HTTPClient, dpopClient)
+		}
+
+		token, err := c.Exchange(ctx, req.URL.Query().Get("code"), opts...)
 		if err != nil {
 			rw.Write([]byte(fmt.Sprintf(`<p>I tried to exchange the authorize code for an access token but it did not work but got error: %s</p>`, err.Error())))
 			return
 		}
 
+		// The ID token starts the session of the user at this client, it is sent along when the user logs out.
+		if idToken, ok := token.Extra("id_token").(string); ok {
+			signIn(idToken)
+		}
+
+		// Fetch the claims of the user who just logged in. The UserInfo endpoint lives next to the token endpoint.
+		var userinfo string
+		userinfoURL := strings.Replace(c.Endpoint.TokenURL, "oauth2/token", "userinfo", 1)
+		if resp, err := c.Client(ctx, token).Get(userinfoURL); err != nil {
+			userinfo = err.Error()
+		} else {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			userinfo = string(body)
+		}
+
 		rw.Write([]byte(fmt.Sprintf(`<p>Cool! You are now a proud token owner.<br>
 		<ul>
 			<li>
@@ -107,6 +160,10 @@ func CallbackHandler(c oauth2.Config) func(rw http.ResponseWriter, req *http.Req
 				Extra info: <br>
 				<code>%s</code>
 			</li>
+			<li>
+				UserInfo: <br>
+				<code>%s</code>
+			</li>
 		</ul>`,
 			"/protected?token="+token.AccessToken,
 			token.AccessToken,
@@ -114,6 +171,30 @@ func CallbackHandler(c oauth2.Config) func(rw http.ResponseWriter, req *http.Req
 			"?revoke="+url.QueryEscape(token.RefreshToken)+"&access_token="+url.QueryEscape(token.AccessToken),
 			token.RefreshToken,
 			token,
+			userinfo,
 		)))
+
+		// A DPoP-bound token is useless without a proof, so the link above fails. Call the protected resource with one.
+		if withDPoP {
+			protectedURL := protectedResource(c)
+			if resp, err := c.Client(ctx, token).Get(protectedURL); err != nil {
+				rw.Write([]byte(fmt.Sprintf(`<p>Could not call the protected resource with a DPoP proof: %s</p>`, err)))
+			} else {
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				rw.Write([]byte(fmt.Sprintf(`<p>Called the protected resource with a DPoP proof:<br>%s</p>`, body)))
+			}
+		}
+	}
+}
+
+// introspect returns the introspection response for the token, as the resource server gets it. The introspection
+// endpoint lives next to the token endpoint.
+func introspect(resourceServer clientcredentials.Config, token string) string {
+	introspectURL := strings.Replace(resourceServer.TokenURL, "oauth2/token", "oauth2/introspect", 1)
+	_, body, err := newBasicClient(resourceServer.ClientID, resourceServer.ClientSecret).Post(introspectURL, url.Values{"token": {token}})
+	if err != nil {
+		return err.Error()
 	}
+	return body
 }
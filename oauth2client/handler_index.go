@@ -3,10 +3,17 @@ package oauth2client
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	goauth "golang.org/x/oauth2"
 )
 
+// protectedResource returns the URL of the protected resource served next to the authorization server. The client
+// names it as `resource` when asking for tokens, so they are meant for it.
+func protectedResource(c goauth.Config) string {
+	return strings.Replace(c.Endpoint.TokenURL, "oauth2/token", "protected", 1)
+}
+
 func HomeHandler(c goauth.Config) func(rw http.ResponseWriter, req *http.Request) {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		if req.URL.Path != "/" {
@@ -19,6 +26,8 @@ func HomeHandler(c goauth.Config) func(rw http.ResponseWriter, req *http.Request
 		pkceCodeVerifier = generateCodeVerifier(64)
 		pkceCodeChallenge = generateCodeChallenge(pkceCodeVerifier)
 
+		resource := goauth.SetAuthURLParam("resource", protectedResource(c))
+
 		rw.Write([]byte(fmt.Sprintf(`
 		<p>You can obtain an access token using various methods</p>
 		<ul>
@@ -28,11 +37,19 @@ func HomeHandler(c goauth.Config) func(rw http.ResponseWriter, req *http.Request
 			<li>
 				<a href="%s" onclick="setPKCE()">Authorize code grant (with OpenID Connect) with PKCE</a>
 			</li>
+			<li>
+				<a href="%s" onclick="setDPoP()">Authorize code grant (with OpenID Connect) with DPoP</a>
+			</li>
 			<li>
 				<a href="%s">Implicit grant (with OpenID Connect)</a>
 			</li>
 			<li>
 				Client credentials grant <a href="/client">using primary secret</a> or <a href="/client-new">using rotateted secret</a>
+				or <a href="/client-private-key-jwt">using a private key JWT</a> or <a href="/client-secret-jwt">using a client secret JWT</a>
+			</li>
+			<li>
+				<a href="/jwt-bearer">JWT bearer grant</a> with an assertion of the certificate authority, or
+				<a href="/jwt-bearer?sub=intruder">for a subject it may not assert</a>
 			</li>
 			<li>
 				<a href="/owner">Resource owner password credentials grant</a>
@@ -50,17 +67,26 @@ func HomeHandler(c goauth.Config) func(rw http.ResponseWriter, req *http.Request
 				// push in a cookie that the user-agent can check to see if last request was a PKCE request.
 				document.cookie = '`+cookiePKCE+`=true';
 			}
+
+			function setDPoP() {
+				// push in a cookie that the user-agent can check to see if last request should get DPoP-bound tokens.
+				document.cookie = '`+cookieDPoP+`=true';
+			}
 			
 			(function(){
 				// clear existing isPKCE cookie if returning to the home page.
 				document.cookie = '`+cookiePKCE+`=; expires=Thu, 01 Jan 1970 00:00:00 UTC; path=/;';
+				document.cookie = '`+cookieDPoP+`=; expires=Thu, 01 Jan 1970 00:00:00 UTC; path=/;';
 			})();
 		</script>`,
-			c.AuthCodeURL("some-random-state-foobar")+"&nonce=some-random-nonce",
-			c.AuthCodeURL("some-random-state-foobar")+"&nonce=some-random-nonce&code_challenge="+pkceCodeChallenge+"&code_challenge_method=S256",
-			"http://localhost:3846/oauth2/auth?client_id=my-client&redirect_uri=http%3A%2F%2Flocalhost%3A3846%2Fcallback&response_type=token%20id_token&scope=fosite%20openid&state=some-random-state-foobar&nonce=some-random-nonce",
-			c.AuthCodeURL("some-random-state-foobar")+"&nonce=some-random-nonce",
+			c.AuthCodeURL("some-random-state-foobar", resource)+"&nonce=some-random-nonce",
+			c.AuthCodeURL("some-random-state-foobar", resource)+"&nonce=some-random-nonce&code_challenge="+pkceCodeChallenge+"&code_challenge_method=S256",
+			c.AuthCodeURL("some-random-state-foobar", resource)+"&nonce=some-random-nonce",
+			"http://localhost:3846/oauth2/auth?client_id=my-client&redirect_uri=http%3A%2F%2Flocalhost%3A3846%2Fcallback&response_type=token%20id_token&scope=fosite%20openid&state=some-random-state-foobar&nonce=some-random-nonce&resource=http%3A%2F%2Flocalhost%3A3846%2Fprotected",
+			c.AuthCodeURL("some-random-state-foobar", resource)+"&nonce=some-random-nonce",
 			"/oauth2/auth?client_id=my-client&scope=fosite&response_type=123&redirect_uri=http://localhost:3846/callback",
 		)))
+
+		rw.Write([]byte(logoutStatus()))
 	}
 }
@@ -0,0 +1,113 @@
+package oauth2client
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+)
+
+// The following provides the setup required for a batch job to trade a JWT of an internal certificate authority for
+// an access token with the JWT bearer grant (RFC 7523). The example plays the certificate authority as well, the
+// authorization server trusts it and fetches its key from CAJWKSHandler.
+
+const jwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// caAssertionLifespan is how long the assertions of the certificate authority are valid.
+const caAssertionLifespan = time.Minute * 5
+
+// caKey is the key the certificate authority signs with.
+var caKey = newClientKey()
+
+// CAJWKSHandler serves the public key of the certificate authority, the authorization server knows it as the
+// `jwks_uri` of the trusted issuer.
+func CAJWKSHandler(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{caKey.Public()}}); err != nil {
+		log.Printf("Error occurred in CAJWKSHandler: %+v", err)
+	}
+}
+
+// JWTBearerEndpoint trades an assertion of the certificate authority about subject for an access token. No client
+// credentials are sent, the authorization server trusts the issuer.
+func JWTBearerEndpoint(tokenURL string, issuer string, subject string, scopes string) func(rw http.ResponseWriter, req *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("<h1>JWT Bearer Grant</h1>"))
+
+		// The subject may be changed with `?sub=`, to see the rules of the issuer at work.
+		sub := subject
+		if s := req.URL.Query().Get("sub"); s != "" {
+			sub = s
+		}
+
+		assertion, err := signCAAssertion(issuer, sub, tokenURL)
+		if err != nil {
+			rw.Write([]byte(fmt.Sprintf(`<p>I tried to sign an assertion but received an error: %s</p>`, err.Error())))
+			return
+		}
+
+		resp, err := http.PostForm(tokenURL, url.Values{
+			"grant_type": {jwtBearerGrantType},
+			"assertion":  {assertion},
+			"scope":      {scopes},
+			"resource":   {strings.Replace(tokenURL, "oauth2/token", "protected", 1)},
+		})
+		if err != nil {
+			rw.Write([]byte(fmt.Sprintf(`<p>I tried to get a token but received an error: %s</p>`, err.Error())))
+			return
+		}
+		defer resp.Body.Close()
+
+		var body map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			rw.Write([]byte(fmt.Sprintf(`<p>I tried to get a token but received an error: %s</p>`, err.Error())))
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			rw.Write([]byte(fmt.Sprintf(`<p>I tried to get a token for <code>%s</code> but received an error: %s: %s</p>`, html.EscapeString(sub), body["error"], body["error_description"])))
+			rw.Write([]byte(`<p><a href="/">Go back</a></p>`))
+			return
+		}
+		rw.Write([]byte(fmt.Sprintf(`<p>Awesome, <code>%s</code> just received an access token!<br><br>%s<br><br><strong>more info:</strong><br><br>%v</p>`, html.EscapeString(sub), body["access_token"], body)))
+		rw.Write([]byte(`<p><a href="/">Go back</a></p>`))
+	}
+}
+
+// signCAAssertion returns an assertion of the certificate authority about subject, for the token endpoint at audience.
+func signCAAssertion(issuer string, subject string, audience string) (string, error) {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: caKey}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", err
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	payload, err := json.Marshal(map[string]interface{}{
+		"iss": issuer,
+		"sub": subject,
+		"aud": audience,
+		"jti": base64.RawURLEncoding.EncodeToString(jti),
+		"iat": now.Unix(),
+		"exp": now.Add(caAssertionLifespan).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return signed.CompactSerialize()
+}
@@ -0,0 +1,282 @@
+package oauth2client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	goauth "golang.org/x/oauth2"
+)
+
+// The following provides the setup required for the client to take part in OpenID Connect logout. The user signs out
+// at the authorization server, which then tells every client of the session by front-channel (an iframe loading
+// `frontchannel_logout_uri`) and back-channel (a logout token posted to `backchannel_logout_uri`).
+
+// backChannelLogoutEvent is the member of the `events` claim of a logout token.
+const backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// maxLogoutNotifications is how many of the received logout notifications are shown on the index.
+const maxLogoutNotifications = 10
+
+// signedIn is the session of the user at this client, started by the callback. The example only keeps a single one.
+var signedIn struct {
+	sync.Mutex
+	idToken   string
+	subject   string
+	sessionID string
+}
+
+// logoutNotification is a logout the authorization server told this client about.
+type logoutNotification struct {
+	Channel    string
+	Subject    string
+	SessionID  string
+	ReceivedAt time.Time
+}
+
+var logoutNotifications struct {
+	sync.Mutex
+	received []logoutNotification
+}
+
+// signIn starts the session of the user at this client with the ID token issued by the callback. The token is sent
+// along as `id_token_hint` when the user logs out.
+func signIn(idToken string) {
+	var claims struct {
+		Subject   string `json:"sub"`
+		SessionID string `json:"sid"`
+	}
+	// The token was received straight from the token endpoint, so there is no need to verify it again here.
+	if tok, err := jwt.ParseSigned(idToken); err == nil {
+		_ = tok.UnsafeClaimsWithoutVerification(&claims)
+	}
+
+	signedIn.Lock()
+	defer signedIn.Unlock()
+	signedIn.idToken = idToken
+	signedIn.subject = claims.Subject
+	signedIn.sessionID = claims.SessionID
+}
+
+// signOut ends the session of the user at this client. With a subject or session ID, only a session matching them is
+// ended.
+func signOut(subject string, sessionID string) {
+	signedIn.Lock()
+	defer signedIn.Unlock()
+	if subject != "" && subject != signedIn.subject || sessionID != "" && sessionID != signedIn.sessionID {
+		return
+	}
+	signedIn.idToken = ""
+	signedIn.subject = ""
+	signedIn.sessionID = ""
+}
+
+func recordLogout(channel string, subject string, sessionID string) {
+	logoutNotifications.Lock()
+	defer logoutNotifications.Unlock()
+	logoutNotifications.received = append(logoutNotifications.received, logoutNotification{
+		Channel:    channel,
+		Subject:    subject,
+		SessionID:  sessionID,
+		ReceivedAt: time.Now(),
+	})
+	if len(logoutNotifications.received) > maxLogoutNotifications {
+		logoutNotifications.received = logoutNotifications.received[1:]
+	}
+}
+
+// logoutStatus renders the session of the user at this client and the logout notifications received, for the index.
+func logoutStatus() string {
+	var b strings.Builder
+
+	signedIn.Lock()
+	if signedIn.idToken != "" {
+		fmt.Fprintf(&b, `<p>Signed in as <code>%s</code> (session <code>%s</code>), <a href="/logout">log out</a></p>`,
+			html.EscapeString(signedIn.subject), html.EscapeString(signedIn.sessionID))
+	} else {
+		b.WriteString(`<p>Not signed in, use one of the authorize code grants above.</p>`)
+	}
+	signedIn.Unlock()
+
+	logoutNotifications.Lock()
+	defer logoutNotifications.Unlock()
+	if len(logoutNotifications.received) == 0 {
+		return b.String()
+	}
+	b.WriteString(`<p>Logout notifications received:</p><ul>`)
+	for i := len(logoutNotifications.received) - 1; i >= 0; i-- {
+		n := logoutNotifications.received[i]
+		fmt.Fprintf(&b, `<li>%s: %s logout of subject <code>%s</code>, session <code>%s</code></li>`,
+			n.ReceivedAt.Format(time.RFC3339), n.Channel, html.EscapeString(n.Subject), html.EscapeString(n.SessionID))
+	}
+	b.WriteString(`</ul>`)
+	return b.String()
+}
+
+// LogoutHandler ends the session at this client and sends the user to the authorization server to end the session
+// there as well. The authorization server returns the user to the index afterwards.
+func LogoutHandler(c goauth.Config) func(rw http.ResponseWriter, req *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		signedIn.Lock()
+		idToken := signedIn.idToken
+		signedIn.Unlock()
+		signOut("", "")
+
+		query := url.Values{
+			"client_id":                {c.ClientID},
+			"post_logout_redirect_uri": {strings.Replace(c.RedirectURL, "callback", "", 1)},
+			"state":                    {"some-random-state-foobar"},
+		}
+		if idToken != "" {
+			query.Set("id_token_hint", idToken)
+		}
+		endSessionURL := strings.Replace(c.Endpoint.AuthURL, "oauth2/auth", "oauth2/logout", 1)
+		http.Redirect(rw, req, endSessionURL+"?"+query.Encode(), http.StatusFound)
+	}
+}
+
+// FrontChannelLogoutHandler is the `frontchannel_logout_uri` of the client. The authorization server renders it in
+// an iframe when the user logs out, with the `iss` and `sid` of the session.
+func FrontChannelLogoutHandler(c goauth.Config) func(rw http.ResponseWriter, req *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "no-cache, no-store")
+		rw.Header().Set("Pragma", "no-cache")
+
+		if iss := req.URL.Query().Get("iss"); iss != issuerOf(c) {
+			http.Error(rw, fmt.Sprintf("Unexpected issuer %q", iss), http.StatusBadRequest)
+			return
+		}
+
+		sid := req.URL.Query().Get("sid")
+		signOut("", sid)
+		recordLogout("front-channel", "", sid)
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Write([]byte(`<p>Logged out</p>`))
+	}
+}
+
+// BackChannelLogoutHandler is the `backchannel_logout_uri` of the client. The authorization server posts a logout
+// token to it when the user logs out, see https://openid.net/specs/openid-connect-backchannel-1_0.html.
+func BackChannelLogoutHandler(c goauth.Config) func(rw http.ResponseWriter, req *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "no-store")
+
+		if req.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		subject, sid, err := verifyLogoutToken(c, req.PostFormValue("logout_token"))
+		if err != nil {
+			log.Printf("Error occurred in BackChannelLogoutHandler: %+v", err)
+			rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+			rw.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(rw).Encode(map[string]string{
+				"error":             "invalid_request",
+				"error_description": err.Error(),
+			})
+			return
+		}
+
+		signOut(subject, sid)
+		recordLogout("back-channel", subject, sid)
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+// verifyLogoutToken validates a logout token as section 2.6 of the back-channel logout specification describes, and
+// returns the subject and session ID it is about.
+func verifyLogoutToken(c goauth.Config, token string) (subject string, sid string, err error) {
+	if token == "" {
+		return "", "", errors.New("the logout_token is missing")
+	}
+
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return "", "", err
+	}
+	if len(tok.Headers) != 1 {
+		return "", "", errors.New("the logout token must have exactly one signature")
+	}
+	header := tok.Headers[0]
+	if typ, ok := header.ExtraHeaders[jose.HeaderType].(string); ok && !strings.EqualFold(typ, "logout+jwt") {
+		return "", "", fmt.Errorf("the logout token is of type %q", typ)
+	}
+
+	key, err := fetchSigningKey(issuerOf(c)+"/.well-known/jwks.json", header.KeyID)
+	if err != nil {
+		return "", "", err
+	}
+	if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+		return "", "", fmt.Errorf("the logout token is signed with %s, but the key is for %s", header.Algorithm, key.Algorithm)
+	}
+
+	var claims jwt.Claims
+	var logout struct {
+		SessionID string                     `json:"sid"`
+		Events    map[string]json.RawMessage `json:"events"`
+		Nonce     *string                    `json:"nonce"`
+	}
+	if err := tok.Claims(key.Key, &claims, &logout); err != nil {
+		return "", "", err
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:   issuerOf(c),
+		Audience: jwt.Audience{c.ClientID},
+		Time:     time.Now(),
+	}, time.Minute); err != nil {
+		return "", "", err
+	}
+	if claims.IssuedAt == nil {
+		return "", "", errors.New("the logout token has no iat claim")
+	}
+	if _, ok := logout.Events[backChannelLogoutEvent]; !ok {
+		return "", "", errors.New("the logout token has no back-channel logout event")
+	}
+	if logout.Nonce != nil {
+		return "", "", errors.New("the logout token must not contain a nonce")
+	}
+	if claims.Subject == "" && logout.SessionID == "" {
+		return "", "", errors.New("the logout token has neither a sub nor a sid claim")
+	}
+	return claims.Subject, logout.SessionID, nil
+}
+
+// fetchSigningKey returns the signing key with the given ID from the keys the authorization server publishes.
+// Logouts are rare, so the keys are fetched every time instead of being cached.
+func fetchSigningKey(jwksURL string, kid string) (*jose.JSONWebKey, error) {
+	resp, err := http.Get(jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s returned status %d", jwksURL, resp.StatusCode)
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return nil, err
+	}
+	for _, key := range keys.Key(kid) {
+		if key.Use == "" || key.Use == "sig" {
+			return &key, nil
+		}
+	}
+	return nil, fmt.Errorf("the authorization server has no signing key %q", kid)
+}
+
+// issuerOf returns the issuer of the authorization server the token endpoint belongs to.
+func issuerOf(c goauth.Config) string {
+	return strings.TrimSuffix(c.Endpoint.TokenURL, "/oauth2/token")
+}
@@ -0,0 +1,30 @@
+package resourceserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// audience is what access tokens must be meant for to be accepted here, read from RESOURCE_SERVER_AUDIENCE. It
+// defaults to the URL of the protected endpoint, which clients name as `resource` when they request a token for it.
+var audience = os.Getenv("RESOURCE_SERVER_AUDIENCE")
+
+// checkAudience returns an error unless the `aud` claim, or the `aud` of an introspection response, contains the
+// expected audience. The claim may be a single string or an array of strings.
+func checkAudience(aud json.RawMessage, expected string) error {
+	var audiences []string
+	if err := json.Unmarshal(aud, &audiences); err != nil {
+		var single string
+		if err := json.Unmarshal(aud, &single); err != nil {
+			return fmt.Errorf("the access token is not meant for %q, it has no audience", expected)
+		}
+		audiences = []string{single}
+	}
+	for _, a := range audiences {
+		if a == expected {
+			return nil
+		}
+	}
+	return fmt.Errorf("the access token is not meant for %q", expected)
+}
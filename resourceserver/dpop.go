@@ -0,0 +1,91 @@
+package resourceserver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ory/fosite-example/dpop"
+)
+
+// proofWindow is how far the `iat` claim of a DPoP proof may be from now.
+const proofWindow = time.Minute
+
+// seenProofs remembers the proofs used within the window, so a proof can not be sent twice.
+var seenProofs = &replayCache{seen: map[string]time.Time{}}
+
+// accessTokenFromRequest returns the access token of a request and the scheme it was sent with. Tokens in the `token`
+// query parameter count as bearer tokens.
+func accessTokenFromRequest(req *http.Request) (scheme string, token string) {
+	if s, t, ok := strings.Cut(req.Header.Get("Authorization"), " "); ok {
+		switch {
+		case strings.EqualFold(s, "Bearer"):
+			return "Bearer", t
+		case strings.EqualFold(s, dpop.Scheme):
+			return dpop.Scheme, t
+		}
+	}
+	return "Bearer", req.URL.Query().Get("token")
+}
+
+// checkDPoPProof checks that a request with a DPoP-bound access token was made by the holder of the key, see section 7
+// of RFC 9449. jkt is the thumbprint of the key the token is bound to.
+func checkDPoPProof(req *http.Request, scheme string, token string, jkt string) error {
+	if scheme != dpop.Scheme {
+		return errors.New("the access token is bound to a DPoP key and must be sent with the DPoP scheme")
+	}
+
+	headers := req.Header.Values(dpop.HeaderName)
+	if len(headers) != 1 {
+		return errors.New("the request must carry exactly one DPoP proof")
+	}
+
+	proof, err := dpop.Parse(headers[0])
+	if err != nil {
+		return err
+	}
+
+	uri := "http://" + req.Host + req.URL.Path
+	if req.TLS != nil {
+		uri = "https://" + req.Host + req.URL.Path
+	}
+	if err := proof.Validate(req.Method, uri, token, proofWindow); err != nil {
+		return err
+	}
+	if proof.Thumbprint != jkt {
+		return errors.New("the DPoP proof was not made with the key the access token is bound to")
+	}
+
+	if !seenProofs.add(proof.Thumbprint+":"+proof.JTI, time.Unix(proof.IssuedAt, 0).Add(proofWindow)) {
+		return errors.New("the DPoP proof has been used before")
+	}
+	return nil
+}
+
+// replayCache is an in-memory set of proof IDs. A resource server with more than one instance would share it, e.g. in
+// the database the authorization server uses for the same purpose.
+type replayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// add remembers the ID until it expires. It returns false if the ID is already known.
+func (c *replayCache) add(id string, expiresAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for known, exp := range c.seen {
+		if exp.Before(now) {
+			delete(c.seen, known)
+		}
+	}
+
+	if _, ok := c.seen[id]; ok {
+		return false
+	}
+	c.seen[id] = expiresAt
+	return true
+}
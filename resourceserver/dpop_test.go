@@ -0,0 +1,101 @@
+package resourceserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jose "github.com/go-jose/go-jose/v3"
+
+	"github.com/ory/fosite-example/dpop"
+)
+
+// roundTripperFunc lets a function stand in for the network.
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newTestProof returns the proof a client signing with the key sends with a request, for the access token if any.
+func newTestProof(t *testing.T, key *ecdsa.PrivateKey, method string, uri string, accessToken string) string {
+	t.Helper()
+
+	var proof string
+	transport, err := dpop.NewTransport(key, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		proof = req.Header.Get(dpop.HeaderName)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}))
+	if err != nil {
+		t.Fatalf("NewTransport: %v", err)
+	}
+
+	req, err := http.NewRequest(method, uri, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", dpop.Scheme+" "+accessToken)
+	}
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	return proof
+}
+
+func TestCheckDPoPProof(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	jkt, err := dpop.Thumbprint(&jose.JSONWebKey{Key: &key.PublicKey})
+	if err != nil {
+		t.Fatalf("Thumbprint: %v", err)
+	}
+
+	const resource = "http://localhost:3846/protected"
+	// The cases run in order, the replayed proof is used for the first time by the case before.
+	replayed := newTestProof(t, key, http.MethodGet, resource, "some-token")
+
+	for _, tc := range []struct {
+		name   string
+		scheme string
+		proofs []string
+		valid  bool
+	}{
+		{name: "valid", scheme: dpop.Scheme, proofs: []string{newTestProof(t, key, http.MethodGet, resource, "some-token")}, valid: true},
+		{name: "bearer scheme", scheme: "Bearer", proofs: []string{newTestProof(t, key, http.MethodGet, resource, "some-token")}},
+		{name: "no proof", scheme: dpop.Scheme},
+		{name: "two proofs", scheme: dpop.Scheme, proofs: []string{newTestProof(t, key, http.MethodGet, resource, "some-token"), newTestProof(t, key, http.MethodGet, resource, "some-token")}},
+		{name: "other method", scheme: dpop.Scheme, proofs: []string{newTestProof(t, key, http.MethodPost, resource, "some-token")}},
+		{name: "other URL", scheme: dpop.Scheme, proofs: []string{newTestProof(t, key, http.MethodGet, "http://localhost:3846/other", "some-token")}},
+		{name: "no ath", scheme: dpop.Scheme, proofs: []string{newTestProof(t, key, http.MethodGet, resource, "")}},
+		{name: "ath of another token", scheme: dpop.Scheme, proofs: []string{newTestProof(t, key, http.MethodGet, resource, "other-token")}},
+		{name: "other key", scheme: dpop.Scheme, proofs: []string{newTestProof(t, otherKey, http.MethodGet, resource, "some-token")}},
+		{name: "first use", scheme: dpop.Scheme, proofs: []string{replayed}, valid: true},
+		{name: "replayed", scheme: dpop.Scheme, proofs: []string{replayed}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, resource+"?query=ignored", nil)
+			for _, proof := range tc.proofs {
+				req.Header.Add(dpop.HeaderName, proof)
+			}
+
+			err := checkDPoPProof(req, tc.scheme, "some-token", jkt)
+			if tc.valid && err != nil {
+				t.Errorf("checkDPoPProof() = %v", err)
+			} else if !tc.valid && err == nil {
+				t.Error("checkDPoPProof() accepted the request")
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package resourceserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// introspectionJWTMediaType asks the authorization server for a signed introspection response, see RFC 9701.
+const introspectionJWTMediaType = "application/token-introspection+jwt"
+
+// introspectionMaxAge is how old a signed introspection response may be.
+const introspectionMaxAge = time.Minute
+
+// introspect asks the authorization server about the token and returns the introspection response, along with the
+// signed JWT it came in. The resource server authenticates with the ID and secret of c, its own credentials at the
+// authorization server. The signature is verified with the keys of the authorization server, so the JWT proves that
+// the server considered the token active, or not, when the request was authorized.
+func introspect(c clientcredentials.Config, keys *keySet, issuer string, token string, scope string) (response []byte, signed string, err error) {
+	req, err := http.NewRequest(http.MethodPost, strings.Replace(c.TokenURL, "token", "introspect", -1), strings.NewReader(url.Values{"token": {token}, "scope": {scope}}.Encode()))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", introspectionJWTMediaType)
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not perform introspection request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("the introspection request failed with status %d: %s", resp.StatusCode, body)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, introspectionJWTMediaType) {
+		return nil, "", fmt.Errorf("the introspection response is of type %q, not a signed JWT", contentType)
+	}
+
+	signed = string(body)
+	response, err = verifyIntrospectionJWT(keys, issuer, c.ClientID, signed)
+	if err != nil {
+		return nil, "", err
+	}
+	return response, signed, nil
+}
+
+// verifyIntrospectionJWT checks a JWT introspection response for the resource server with the given ID, as section 6
+// of RFC 9701 describes, and returns its `token_introspection` claim.
+func verifyIntrospectionJWT(keys *keySet, issuer string, id string, token string) ([]byte, error) {
+	jws, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, errors.New("the introspection response must have exactly one signature")
+	}
+
+	header := jws.Signatures[0].Header
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); !strings.EqualFold(typ, "token-introspection+jwt") && !strings.EqualFold(typ, introspectionJWTMediaType) {
+		return nil, errors.New("the introspection response is not of type token-introspection+jwt")
+	}
+	key, err := keys.key(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+		return nil, fmt.Errorf("the introspection response is signed with %s, but the key is for %s", header.Algorithm, key.Algorithm)
+	}
+	payload, err := jws.Verify(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims struct {
+		Issuer             string          `json:"iss"`
+		Audience           json.RawMessage `json:"aud"`
+		IssuedAt           int64           `json:"iat"`
+		TokenIntrospection json.RawMessage `json:"token_introspection"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Issuer != issuer {
+		return nil, fmt.Errorf("the introspection response was issued by %q", claims.Issuer)
+	}
+	if err := checkAudience(claims.Audience, id); err != nil {
+		return nil, errors.New("the introspection response is not meant for this resource server")
+	}
+	if age := time.Since(time.Unix(claims.IssuedAt, 0)); age > introspectionMaxAge || age < -introspectionMaxAge {
+		return nil, errors.New("the introspection response is not recent")
+	}
+	if len(claims.TokenIntrospection) == 0 || string(claims.TokenIntrospection) == "null" {
+		return nil, errors.New("the introspection response has no token_introspection claim")
+	}
+	return claims.TokenIntrospection, nil
+}
@@ -0,0 +1,55 @@
+package resourceserver
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifyIntrospectionJWT(t *testing.T) {
+	key := newTestRSAKey(t)
+	authorizationServer := &testAuthorizationServer{}
+	authorizationServer.publish(key, "key-1")
+	server := httptest.NewServer(authorizationServer)
+	defer server.Close()
+	keys := &keySet{url: server.URL}
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":                 "http://localhost:3846",
+			"aud":                 "my-resource-server",
+			"iat":                 time.Now().Unix(),
+			"token_introspection": map[string]interface{}{"active": true, "sub": "peter"},
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{name: "valid", token: signTestJWT(t, key, "key-1", "token-introspection+jwt", claims(nil)), ok: true},
+		{name: "audience list", token: signTestJWT(t, key, "key-1", "application/token-introspection+jwt", claims(map[string]interface{}{"aud": []string{"other", "my-resource-server"}})), ok: true},
+		{name: "other type", token: signTestJWT(t, key, "key-1", "at+jwt", claims(nil))},
+		{name: "other issuer", token: signTestJWT(t, key, "key-1", "token-introspection+jwt", claims(map[string]interface{}{"iss": "https://evil.example.com"}))},
+		{name: "meant for another resource server", token: signTestJWT(t, key, "key-1", "token-introspection+jwt", claims(map[string]interface{}{"aud": "other-resource-server"}))},
+		{name: "no audience", token: signTestJWT(t, key, "key-1", "token-introspection+jwt", claims(map[string]interface{}{"aud": nil}))},
+		{name: "too old", token: signTestJWT(t, key, "key-1", "token-introspection+jwt", claims(map[string]interface{}{"iat": time.Now().Add(-2 * introspectionMaxAge).Unix()}))},
+		{name: "issued in the future", token: signTestJWT(t, key, "key-1", "token-introspection+jwt", claims(map[string]interface{}{"iat": time.Now().Add(2 * introspectionMaxAge).Unix()}))},
+		{name: "no token_introspection", token: signTestJWT(t, key, "key-1", "token-introspection+jwt", claims(map[string]interface{}{"token_introspection": nil}))},
+		{name: "signed with another key", token: signTestJWT(t, newTestRSAKey(t), "key-1", "token-introspection+jwt", claims(nil))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := verifyIntrospectionJWT(keys, "http://localhost:3846", "my-resource-server", tc.token)
+			if tc.ok && (err != nil || string(response) != `{"active":true,"sub":"peter"}`) {
+				t.Errorf("verifyIntrospectionJWT() = %s, %v", response, err)
+			} else if !tc.ok && err == nil {
+				t.Error("verifyIntrospectionJWT() accepted the response")
+			}
+		})
+	}
+}
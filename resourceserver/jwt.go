@@ -0,0 +1,113 @@
+package resourceserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+)
+
+// jwksRefreshInterval is how often the keys of the authorization server are fetched at most. Tokens signed with a key
+// that is not known yet trigger a fetch, so rotated keys are picked up.
+const jwksRefreshInterval = time.Minute
+
+// keySet caches the keys the authorization server publishes at its `jwks_uri`.
+type keySet struct {
+	url string
+
+	mu      sync.Mutex
+	keys    jose.JSONWebKeySet
+	fetched time.Time
+}
+
+// key returns the signing key with the given ID, fetching the keys again if it is not known.
+func (s *keySet) key(kid string) (*jose.JSONWebKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key := s.signingKey(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(s.fetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("the authorization server has no signing key %q", kid)
+	}
+
+	resp, err := http.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s returned status %d", s.url, resp.StatusCode)
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return nil, err
+	}
+	s.keys = keys
+	s.fetched = time.Now()
+
+	if key := s.signingKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the authorization server has no signing key %q", kid)
+}
+
+func (s *keySet) signingKey(kid string) *jose.JSONWebKey {
+	for _, key := range s.keys.Key(kid) {
+		if key.Use == "" || key.Use == "sig" {
+			return &key
+		}
+	}
+	return nil
+}
+
+// verifyJWTAccessToken validates a JWT access token offline, as section 4 of RFC 9068 describes, and returns its
+// claims. Revoked tokens remain valid until they expire. The audience is checked by the caller, like that of
+// introspected tokens.
+func verifyJWTAccessToken(keys *keySet, issuer string, token string) ([]byte, error) {
+	jws, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, errors.New("the access token must have exactly one signature")
+	}
+
+	header := jws.Signatures[0].Header
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); !strings.EqualFold(typ, "at+jwt") && !strings.EqualFold(typ, "application/at+jwt") {
+		return nil, errors.New("the access token is not of type at+jwt")
+	}
+	key, err := keys.key(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+		return nil, fmt.Errorf("the access token is signed with %s, but the key is for %s", header.Algorithm, key.Algorithm)
+	}
+	payload, err := jws.Verify(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims struct {
+		Issuer    string `json:"iss"`
+		ExpiresAt int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Issuer != issuer {
+		return nil, fmt.Errorf("the access token was issued by %q", claims.Issuer)
+	}
+	if claims.ExpiresAt == 0 || time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.New("the access token has expired")
+	}
+	return payload, nil
+}
@@ -0,0 +1,140 @@
+package resourceserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+)
+
+// testAuthorizationServer publishes signing keys at its `jwks_uri`, the keys can be rotated while it runs.
+type testAuthorizationServer struct {
+	mu   sync.Mutex
+	keys jose.JSONWebKeySet
+}
+
+func (s *testAuthorizationServer) publish(key *rsa.PrivateKey, kid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys.Keys = append(s.keys.Keys, jose.JSONWebKey{Key: &key.PublicKey, KeyID: kid, Algorithm: string(jose.RS256), Use: "sig"})
+}
+
+func (s *testAuthorizationServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(s.keys)
+}
+
+// newTestRSAKey generates an RSA signing key.
+func newTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+// signTestJWT signs the claims with the key, with the `typ` header typ.
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, kid string, typ string, claims map[string]interface{}) string {
+	t.Helper()
+
+	options := (&jose.SignerOptions{}).WithType(jose.ContentType(typ)).WithHeader(jose.HeaderKey("kid"), kid)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, options)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatalf("CompactSerialize: %v", err)
+	}
+	return token
+}
+
+func TestVerifyJWTAccessToken(t *testing.T) {
+	key := newTestRSAKey(t)
+	authorizationServer := &testAuthorizationServer{}
+	authorizationServer.publish(key, "key-1")
+	server := httptest.NewServer(authorizationServer)
+	defer server.Close()
+	keys := &keySet{url: server.URL}
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "http://localhost:3846",
+			"sub": "peter",
+			"aud": []string{"http://localhost:3846/protected"},
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{name: "valid", token: signTestJWT(t, key, "key-1", "at+jwt", claims(nil)), ok: true},
+		{name: "media type", token: signTestJWT(t, key, "key-1", "application/at+jwt", claims(nil)), ok: true},
+		{name: "other type", token: signTestJWT(t, key, "key-1", "JWT", claims(nil))},
+		{name: "other issuer", token: signTestJWT(t, key, "key-1", "at+jwt", claims(map[string]interface{}{"iss": "https://evil.example.com"}))},
+		{name: "expired", token: signTestJWT(t, key, "key-1", "at+jwt", claims(map[string]interface{}{"exp": time.Now().Add(-time.Second).Unix()}))},
+		{name: "no expiry", token: signTestJWT(t, key, "key-1", "at+jwt", claims(map[string]interface{}{"exp": nil}))},
+		{name: "signed with another key", token: signTestJWT(t, newTestRSAKey(t), "key-1", "at+jwt", claims(nil))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			payload, err := verifyJWTAccessToken(keys, "http://localhost:3846", tc.token)
+			if tc.ok && (err != nil || payload == nil) {
+				t.Errorf("verifyJWTAccessToken() = %s, %v", payload, err)
+			} else if !tc.ok && err == nil {
+				t.Error("verifyJWTAccessToken() accepted the token")
+			}
+		})
+	}
+}
+
+func TestVerifyJWTAccessTokenKeyRotation(t *testing.T) {
+	authorizationServer := &testAuthorizationServer{}
+	authorizationServer.publish(newTestRSAKey(t), "key-1")
+	server := httptest.NewServer(authorizationServer)
+	defer server.Close()
+	keys := &keySet{url: server.URL}
+
+	claims := map[string]interface{}{"iss": "http://localhost:3846", "exp": time.Now().Add(time.Hour).Unix()}
+	rotated := newTestRSAKey(t)
+	token := signTestJWT(t, rotated, "key-2", "at+jwt", claims)
+
+	// The first token with an unknown kid fetches the keys, and a key that is not published is not found.
+	if _, err := verifyJWTAccessToken(keys, "http://localhost:3846", token); err == nil {
+		t.Fatal("a token signed with a key that is not published was accepted")
+	}
+
+	// The keys are fetched at most once per jwksRefreshInterval, even if tokens name unknown keys.
+	authorizationServer.publish(rotated, "key-2")
+	if _, err := verifyJWTAccessToken(keys, "http://localhost:3846", token); err == nil {
+		t.Fatal("the keys were fetched again right away")
+	}
+
+	keys.fetched = time.Now().Add(-jwksRefreshInterval)
+	if _, err := verifyJWTAccessToken(keys, "http://localhost:3846", token); err != nil {
+		t.Errorf("the rotated key was not picked up: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package resourceserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+)
+
+// clientCertificateThumbprint returns the `x5t#S256` thumbprint (RFC 8705) of the client certificate of the request,
+// or "" if the request did not come in over a TLS connection with one.
+func clientCertificateThumbprint(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(req.TLS.PeerCertificates[0].Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
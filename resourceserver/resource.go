@@ -2,37 +2,86 @@ package resourceserver
 
 import (
 	"fmt"
+	"html"
 	"net/http"
 
 	"encoding/json"
-	"io/ioutil"
-	"net/url"
 	"strings"
 
-	"golang.org/x/net/context"
 	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/ory/fosite-example/dpop"
 )
 
 type session struct {
 	User string
 }
 
+// ProtectedEndpoint serves a resource to requests with an active access token, sent in the `Authorization` header or
+// the `token` query parameter. Tokens bound to a DPoP key are only accepted in the header with the DPoP scheme and a
+// proof made with that key, tokens bound to a client certificate only over a TLS connection authenticated with it. A
+// copy of a bound token alone is worthless.
+//
+// JWT access tokens (RFC 9068) are verified offline with the keys of the authorization server, all other tokens are
+// introspected with the credentials of c, which are those of the resource server rather than of a client. The
+// introspection response is requested as a JWT signed by the authorization server (RFC 9701) and its signature is
+// verified. Either way, the token must be meant for this endpoint: clients request it with the endpoint's URL as
+// `resource` parameter (RFC 8707), so it becomes the token's audience.
 func ProtectedEndpoint(c clientcredentials.Config) func(rw http.ResponseWriter, req *http.Request) {
+	issuer := strings.TrimSuffix(c.TokenURL, "/oauth2/token")
+	keys := &keySet{url: issuer + "/.well-known/jwks.json"}
+
+	expectedAudience := audience
+	if expectedAudience == "" {
+		expectedAudience = issuer + "/protected"
+	}
+
 	return func(rw http.ResponseWriter, req *http.Request) {
-		resp, err := c.Client(context.Background()).PostForm(strings.Replace(c.TokenURL, "token", "introspect", -1), url.Values{"token": []string{req.URL.Query().Get("token")}, "scope": []string{req.URL.Query().Get("scope")}})
-		if err != nil {
-			fmt.Fprintf(rw, "<h1>An error occurred!</h1><p>Could not perform introspection request: %v</p>", err)
-			return
-		}
-		defer resp.Body.Close()
+		scheme, token := accessTokenFromRequest(req)
 
 		var introspection = struct {
-			Active bool `json:"active"`
+			Active   bool            `json:"active"`
+			Scope    string          `json:"scope"`
+			Audience json.RawMessage `json:"aud"`
+			Cnf      struct {
+				JKT     string `json:"jkt"`
+				X5tS256 string `json:"x5t#S256"`
+			} `json:"cnf"`
 		}{}
-		out, _ := ioutil.ReadAll(resp.Body)
-		if err := json.Unmarshal(out, &introspection); err != nil {
-			fmt.Fprintf(rw, "<h1>An error occurred!</h1>%s\n%s", err.Error(), out)
-			return
+		var out []byte
+		var signed string
+		if strings.Count(token, ".") == 2 {
+			claims, err := verifyJWTAccessToken(keys, issuer, token)
+			if err != nil {
+				fmt.Fprintf(rw, `<h1>Request could not be authorized.</h1>
+<p>%s</p>
+<a href="/">return</a>`, html.EscapeString(err.Error()))
+				return
+			}
+
+			out = claims
+			if err := json.Unmarshal(out, &introspection); err != nil {
+				fmt.Fprintf(rw, "<h1>An error occurred!</h1>%s\n%s", err.Error(), out)
+				return
+			}
+			// Like introspection, the token must have been granted all scopes the request asks for.
+			introspection.Active = true
+			for _, scope := range strings.Fields(req.URL.Query().Get("scope")) {
+				if !strings.Contains(" "+introspection.Scope+" ", " "+scope+" ") {
+					introspection.Active = false
+				}
+			}
+		} else {
+			var err error
+			out, signed, err = introspect(c, keys, issuer, token, req.URL.Query().Get("scope"))
+			if err != nil {
+				fmt.Fprintf(rw, "<h1>An error occurred!</h1><p>%s</p>", html.EscapeString(err.Error()))
+				return
+			}
+			if err := json.Unmarshal(out, &introspection); err != nil {
+				fmt.Fprintf(rw, "<h1>An error occurred!</h1>%s\n%s", err.Error(), out)
+				return
+			}
 		}
 
 		if !introspection.Active {
@@ -41,12 +90,49 @@ func ProtectedEndpoint(c clientcredentials.Config) func(rw http.ResponseWriter,
 			return
 		}
 
+		// A token for another resource server must not work here, whoever got hold of it.
+		if err := checkAudience(introspection.Audience, expectedAudience); err != nil {
+			rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			rw.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(rw, `<h1>Request could not be authorized.</h1>
+<p>%s</p>
+<a href="/">return</a>`, html.EscapeString(err.Error()))
+			return
+		}
+
+		// Tokens bound to a client certificate are only accepted over a connection authenticated with it, see RFC 8705.
+		if x5t := introspection.Cnf.X5tS256; x5t != "" && clientCertificateThumbprint(req) != x5t {
+			rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			rw.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(rw, `<h1>Request could not be authorized.</h1>
+<p>the access token is bound to a client certificate the request was not made with</p>
+<a href="/">return</a>`)
+			return
+		}
+
+		if jkt := introspection.Cnf.JKT; jkt != "" || scheme == dpop.Scheme {
+			if err := checkDPoPProof(req, scheme, token, jkt); err != nil {
+				rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`%s algs="%s", error="%s"`, dpop.Scheme, strings.Join(dpop.SigningAlgs, " "), "invalid_dpop_proof"))
+				rw.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprintf(rw, `<h1>Request could not be authorized.</h1>
+<p>%s</p>
+<a href="/">return</a>`, html.EscapeString(err.Error()))
+				return
+			}
+		}
+
+		// The signed introspection response is what a resource server would keep as proof of the authorization.
+		var proof string
+		if signed != "" {
+			proof = fmt.Sprintf("<p>Signed introspection response:<br><code>%s</code></p>\n", html.EscapeString(signed))
+		}
 		fmt.Fprintf(rw, `<h1>Request authorized!</h1>
 <code>%s</code><br>
-<hr>
+%s<hr>
 <a href="/">return</a>
 `,
 			out,
+			proof,
 		)
 	}
 }
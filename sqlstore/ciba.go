@@ -0,0 +1,192 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ory/fosite"
+)
+
+// BackchannelAuthenticationStatus is the state of a CIBA authentication request.
+type BackchannelAuthenticationStatus string
+
+const (
+	// BackchannelAuthenticationPending means the user did not decide yet.
+	BackchannelAuthenticationPending BackchannelAuthenticationStatus = "pending"
+	// BackchannelAuthenticationApproved means the user approved, the client may fetch its tokens.
+	BackchannelAuthenticationApproved BackchannelAuthenticationStatus = "approved"
+	// BackchannelAuthenticationDenied means the user declined the request.
+	BackchannelAuthenticationDenied BackchannelAuthenticationStatus = "denied"
+)
+
+// BackchannelAuthentication is an OpenID Connect Client-Initiated Backchannel Authentication (CIBA) request. The
+// client refers to it with the auth_req_id, the user approves or denies it on their authentication device.
+//
+// The auth_req_id is stored as is: it is sent to the client in ping and push mode, and exchanging it for tokens
+// requires client authentication anyway.
+type BackchannelAuthentication struct {
+	AuthReqID string
+	Subject   string
+	Status    BackchannelAuthenticationStatus
+	// DeliveryMode is the client's backchannel_token_delivery_mode at the time of the request.
+	DeliveryMode string
+	// NotificationToken is the bearer token the client wants to be notified with in ping and push mode.
+	NotificationToken string
+	// BindingMessage is shown to the user on both the client's and the authentication device, so the user can tell
+	// the request apart from others.
+	BindingMessage string
+	Interval       time.Duration
+	LastPolledAt   time.Time
+	ExpiresAt      time.Time
+	// Request is the authentication request. Once approved, it carries the granted scopes and the session of the user.
+	Request fosite.Requester
+}
+
+// CreateBackchannelAuthentication stores a pending authentication request.
+func (s *Store) CreateBackchannelAuthentication(ctx context.Context, auth *BackchannelAuthentication) error {
+	data, err := encodeRequestData(auth.Request)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO backchannel_authentications (auth_req_id, request_id, client_id, subject, status, delivery_mode, notification_token, binding_message, interval_seconds, expires_at, data)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		auth.AuthReqID, auth.Request.GetID(), auth.Request.GetClient().GetID(), auth.Subject, BackchannelAuthenticationPending,
+		auth.DeliveryMode, auth.NotificationToken, auth.BindingMessage, int64(auth.Interval/time.Second), auth.ExpiresAt.Unix(), data)
+	return err
+}
+
+// GetBackchannelAuthentication loads an authentication request by its auth_req_id. The session of the user is decoded
+// into the given session.
+func (s *Store) GetBackchannelAuthentication(ctx context.Context, authReqID string, session fosite.Session) (*BackchannelAuthentication, error) {
+	row := s.conn(ctx).QueryRowContext(ctx, `SELECT `+backchannelAuthenticationColumns+` FROM backchannel_authentications WHERE auth_req_id = ?`, authReqID)
+	return s.scanBackchannelAuthentication(ctx, row, session)
+}
+
+// GetBackchannelAuthenticationByRequestID loads an authentication request by the ID of its request, which is what
+// the authentication device refers to it by.
+func (s *Store) GetBackchannelAuthenticationByRequestID(ctx context.Context, requestID string, session fosite.Session) (*BackchannelAuthentication, error) {
+	row := s.conn(ctx).QueryRowContext(ctx, `SELECT `+backchannelAuthenticationColumns+` FROM backchannel_authentications WHERE request_id = ?`, requestID)
+	return s.scanBackchannelAuthentication(ctx, row, session)
+}
+
+// ListPendingBackchannelAuthentications returns the authentication requests waiting for the user's decision, oldest
+// first. Expired requests are left out.
+func (s *Store) ListPendingBackchannelAuthentications(ctx context.Context, subject string) ([]*BackchannelAuthentication, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT auth_req_id FROM backchannel_authentications
+WHERE subject = ? AND status = ? AND expires_at >= ? ORDER BY expires_at`, subject, BackchannelAuthenticationPending, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Decoding a request looks up its client, which can not be done while the rows above are open.
+	var auths []*BackchannelAuthentication
+	for _, id := range ids {
+		auth, err := s.GetBackchannelAuthentication(ctx, id, nil)
+		if errors.Is(err, fosite.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		auths = append(auths, auth)
+	}
+	return auths, nil
+}
+
+const backchannelAuthenticationColumns = `auth_req_id, subject, status, delivery_mode, notification_token, binding_message, interval_seconds, last_polled_at, expires_at, data`
+
+func (s *Store) scanBackchannelAuthentication(ctx context.Context, row *sql.Row, session fosite.Session) (*BackchannelAuthentication, error) {
+	var auth BackchannelAuthentication
+	var interval, expiresAt int64
+	var lastPolledAt sql.NullInt64
+	var data string
+	err := row.Scan(&auth.AuthReqID, &auth.Subject, &auth.Status, &auth.DeliveryMode, &auth.NotificationToken, &auth.BindingMessage,
+		&interval, &lastPolledAt, &expiresAt, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fosite.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	auth.Interval = time.Duration(interval) * time.Second
+	auth.ExpiresAt = time.Unix(expiresAt, 0)
+	if lastPolledAt.Valid {
+		auth.LastPolledAt = time.Unix(lastPolledAt.Int64, 0)
+	}
+
+	request, _, err := s.decodeRequest(ctx, data, session)
+	if err != nil {
+		return nil, err
+	}
+	auth.Request = request
+	return &auth, nil
+}
+
+// CompleteBackchannelAuthentication records the decision of the user. The request is stored again, so it carries the
+// granted scopes and the session once approved. It returns fosite.ErrNotFound if the request is not pending.
+func (s *Store) CompleteBackchannelAuthentication(ctx context.Context, authReqID string, status BackchannelAuthenticationStatus, request fosite.Requester) error {
+	data, err := encodeRequestData(request)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.conn(ctx).ExecContext(ctx, `UPDATE backchannel_authentications SET status = ?, data = ? WHERE auth_req_id = ? AND status = ?`,
+		status, data, authReqID, BackchannelAuthenticationPending)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// PollBackchannelAuthentication records that the client polled. It reports whether the client polled faster than the
+// interval allows, in which case the interval is increased.
+func (s *Store) PollBackchannelAuthentication(ctx context.Context, authReqID string) (slowDown bool, err error) {
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		var interval int64
+		var lastPolledAt sql.NullInt64
+		err := tx.QueryRowContext(ctx, `SELECT interval_seconds, last_polled_at FROM backchannel_authentications WHERE auth_req_id = ?`, authReqID).
+			Scan(&interval, &lastPolledAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fosite.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if lastPolledAt.Valid && time.Unix(lastPolledAt.Int64, 0).Add(time.Duration(interval)*time.Second).After(now) {
+			slowDown = true
+			interval += int64(slowDownIncrement / time.Second)
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE backchannel_authentications SET interval_seconds = ?, last_polled_at = ? WHERE auth_req_id = ?`,
+			interval, now.Unix(), authReqID)
+		return err
+	})
+	return slowDown, err
+}
+
+// DeleteBackchannelAuthentication removes an authentication request, its tokens are issued once only. It returns
+// fosite.ErrNotFound if the request was removed before.
+func (s *Store) DeleteBackchannelAuthentication(ctx context.Context, authReqID string) error {
+	res, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM backchannel_authentications WHERE auth_req_id = ?`, authReqID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
@@ -0,0 +1,331 @@
+package sqlstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ory/fosite"
+)
+
+// Client is the client model persisted by the store. It carries fosite's OpenID Connect client metadata, which
+// includes the JSON Web Keys used for `private_key_jwt` and request objects.
+type Client struct {
+	fosite.DefaultOpenIDConnectClient
+
+	// Secrets are the hashed secrets the client may authenticate with, oldest first. Holding several lets a new
+	// secret be rolled out before the old one is retired. The secret and rotated secrets of fosite's DefaultClient are
+	// moved here when a client is loaded.
+	Secrets []ClientSecret `json:"secrets,omitempty"`
+
+	// The metadata of section 2 of RFC 7591 shown to users. Clients registered at the registration endpoint provide
+	// them, the consent page shows the name and logo instead of the client ID.
+	ClientName string   `json:"client_name,omitempty"`
+	ClientURI  string   `json:"client_uri,omitempty"`
+	LogoURI    string   `json:"logo_uri,omitempty"`
+	TOSURI     string   `json:"tos_uri,omitempty"`
+	PolicyURI  string   `json:"policy_uri,omitempty"`
+	Contacts   []string `json:"contacts,omitempty"`
+
+	// RegistrationAccessTokenHash is the SHA-256 hash of the registration access token of a client registered at the
+	// registration endpoint, which lets it read, update and delete its own registration (RFC 7592). Clients without
+	// one can not be managed that way.
+	RegistrationAccessTokenHash string `json:"registration_access_token_hash,omitempty"`
+
+	// AssertionSecret is the secret the client signs `client_secret_jwt` assertions with. Unlike the client secret,
+	// which is only needed to compare against, HMAC needs the secret itself, so it is stored as is.
+	AssertionSecret string `json:"assertion_secret,omitempty"`
+
+	// AccessTokenStrategy is the kind of access tokens the client gets, "jwt" for JWT access tokens (RFC 9068) or
+	// "opaque" for HMAC tokens. Clients without one get the kind the server issues by default.
+	AccessTokenStrategy string `json:"access_token_strategy,omitempty"`
+
+	// RequirePushedAuthorizationRequests makes the client send every authorize request through the pushed
+	// authorization request endpoint first, see RFC 9126.
+	RequirePushedAuthorizationRequests bool `json:"require_pushed_authorization_requests,omitempty"`
+
+	// DPoPBoundAccessTokens makes the client send a DPoP proof with every token request, so all its access tokens are
+	// bound to its key, see section 5.2 of RFC 9449.
+	DPoPBoundAccessTokens bool `json:"dpop_bound_access_tokens,omitempty"`
+
+	// The certificate of a client using the `tls_client_auth` method must match exactly one of these, see section
+	// 2.1.2 of RFC 8705.
+	TLSClientAuthSubjectDN string `json:"tls_client_auth_subject_dn,omitempty"`
+	TLSClientAuthSANDNS    string `json:"tls_client_auth_san_dns,omitempty"`
+	TLSClientAuthSANURI    string `json:"tls_client_auth_san_uri,omitempty"`
+	TLSClientAuthSANIP     string `json:"tls_client_auth_san_ip,omitempty"`
+	TLSClientAuthSANEmail  string `json:"tls_client_auth_san_email,omitempty"`
+
+	// TLSClientCertificateBoundAccessTokens makes the client send every token request with its client certificate,
+	// so all its access tokens are bound to the certificate, see section 3.4 of RFC 8705.
+	TLSClientCertificateBoundAccessTokens bool `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+
+	// PostLogoutRedirectURIs are the URLs users may be sent back to after logging out at the client's request, see
+	// OpenID Connect RP-Initiated Logout 1.0.
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris,omitempty"`
+
+	// FrontChannelLogoutURI is loaded in an iframe when the user logs out, so the client can end its own session, see
+	// OpenID Connect Front-Channel Logout 1.0. With FrontChannelLogoutSessionRequired, the `iss` and `sid` of the
+	// session are added as query parameters.
+	FrontChannelLogoutURI             string `json:"frontchannel_logout_uri,omitempty"`
+	FrontChannelLogoutSessionRequired bool   `json:"frontchannel_logout_session_required,omitempty"`
+
+	// BackChannelLogoutURI is sent a logout token when the user logs out, see OpenID Connect Back-Channel Logout 1.0.
+	// The token always carries the `sid` of the session, BackChannelLogoutSessionRequired only records that the
+	// client relies on it.
+	BackChannelLogoutURI             string `json:"backchannel_logout_uri,omitempty"`
+	BackChannelLogoutSessionRequired bool   `json:"backchannel_logout_session_required,omitempty"`
+
+	// BackChannelTokenDeliveryMode is how the client learns about the outcome of OpenID Connect Client-Initiated
+	// Backchannel Authentication (CIBA) requests: "poll", "ping" or "push". Ping and push clients are notified at their
+	// BackChannelClientNotificationEndpoint.
+	BackChannelTokenDeliveryMode          string `json:"backchannel_token_delivery_mode,omitempty"`
+	BackChannelClientNotificationEndpoint string `json:"backchannel_client_notification_endpoint,omitempty"`
+
+	// TokenExchange is what the client may do with the token exchange grant of RFC 8693. Clients without a policy may
+	// not exchange tokens.
+	TokenExchange *TokenExchangePolicy `json:"token_exchange,omitempty"`
+}
+
+// TokenExchangePolicy limits the tokens a client may obtain with the token exchange grant.
+type TokenExchangePolicy struct {
+	// Audiences are the audiences and resources the client may exchange tokens for.
+	Audiences []string `json:"audiences"`
+	// SubjectClients are the clients whose tokens the client may exchange. Tokens issued to the client itself, or
+	// naming it as their audience, are always accepted.
+	SubjectClients []string `json:"subject_clients,omitempty"`
+	// Impersonation allows exchanging a subject token on its own. The new token is indistinguishable from one issued
+	// to the subject.
+	Impersonation bool `json:"impersonation,omitempty"`
+	// Delegation allows exchanging a subject token together with an actor token. The new token names the actor in
+	// its `act` claim.
+	Delegation bool `json:"delegation,omitempty"`
+}
+
+// ClientSecret is one of the hashed secrets of a client.
+type ClientSecret struct {
+	// ID identifies the secret without revealing it, it is derived from the hash.
+	ID   string `json:"id"`
+	Hash []byte `json:"hash"`
+	// ExpiresAt is when the secret stops working, nil if it does not expire.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// NewClientSecret returns a secret with the given hash, expiring at expiresAt unless it is nil.
+func NewClientSecret(hash []byte, expiresAt *time.Time) ClientSecret {
+	sum := sha256.Sum256(hash)
+	return ClientSecret{ID: base64.RawURLEncoding.EncodeToString(sum[:12]), Hash: hash, ExpiresAt: expiresAt}
+}
+
+// Expired reports whether the secret stopped working.
+func (s ClientSecret) Expired() bool {
+	return s.ExpiresAt != nil && !s.ExpiresAt.After(time.Now())
+}
+
+// GetHashedSecret returns the hash of the newest secret that has not expired. fosite compares the secret a client
+// authenticates with against it first.
+func (c *Client) GetHashedSecret() []byte {
+	hashes := c.secretHashes()
+	if len(hashes) == 0 {
+		return nil
+	}
+	return hashes[0]
+}
+
+// GetRotatedHashes returns the hashes of the other secrets that have not expired, fosite tries them in turn.
+func (c *Client) GetRotatedHashes() [][]byte {
+	hashes := c.secretHashes()
+	if len(hashes) < 2 {
+		return nil
+	}
+	return hashes[1:]
+}
+
+// secretHashes returns the hashes of the secrets that have not expired, newest first.
+func (c *Client) secretHashes() [][]byte {
+	var hashes [][]byte
+	for i := len(c.Secrets) - 1; i >= 0; i-- {
+		if !c.Secrets[i].Expired() {
+			hashes = append(hashes, c.Secrets[i].Hash)
+		}
+	}
+	return hashes
+}
+
+// foldSecrets moves the secret and rotated secrets of fosite's DefaultClient, which the example clients are declared
+// with, in front of Secrets, so all secrets are managed the same way.
+func (c *Client) foldSecrets() {
+	var secrets []ClientSecret
+	for i := len(c.RotatedSecrets) - 1; i >= 0; i-- {
+		secrets = append(secrets, NewClientSecret(c.RotatedSecrets[i], nil))
+	}
+	if len(c.Secret) > 0 {
+		secrets = append(secrets, NewClientSecret(c.Secret, nil))
+	}
+	if len(secrets) == 0 {
+		return
+	}
+
+	c.Secrets = append(secrets, c.Secrets...)
+	c.Secret = nil
+	c.RotatedSecrets = nil
+}
+
+// NewClient returns a client with the given id and no metadata.
+func NewClient(id string) *Client {
+	return &Client{
+		DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+			DefaultClient: &fosite.DefaultClient{ID: id},
+		},
+	}
+}
+
+// GetClient loads the client with the given id.
+func (s *Store) GetClient(ctx context.Context, id string) (fosite.Client, error) {
+	return s.getClient(ctx, id)
+}
+
+func (s *Store) getClient(ctx context.Context, id string) (*Client, error) {
+	var data string
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT data FROM clients WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fosite.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	client := NewClient(id)
+	if err := json.Unmarshal([]byte(data), client); err != nil {
+		return nil, err
+	}
+	client.foldSecrets()
+	return client, nil
+}
+
+// ListClients returns all clients ordered by id.
+func (s *Store) ListClients(ctx context.Context) ([]*Client, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT id, data FROM clients ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []*Client
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+
+		client := NewClient(id)
+		if err := json.Unmarshal([]byte(data), client); err != nil {
+			return nil, err
+		}
+		client.foldSecrets()
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// CreateClient stores a new client. Secrets must already be hashed.
+func (s *Store) CreateClient(ctx context.Context, client *Client) error {
+	data, err := json.Marshal(client)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO clients (id, data, created_at, updated_at) VALUES (?, ?, ?, ?)`,
+		client.GetID(), string(data), now, now)
+	return err
+}
+
+// UpdateClient replaces a stored client.
+func (s *Store) UpdateClient(ctx context.Context, client *Client) error {
+	data, err := json.Marshal(client)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.conn(ctx).ExecContext(ctx, `UPDATE clients SET data = ?, updated_at = ? WHERE id = ?`,
+		string(data), time.Now().Unix(), client.GetID())
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// DeleteClient removes a client together with its authorize codes, tokens, consents and pending device and CIBA
+// authorizations, so none of them work anymore even if a client with the same ID is created later.
+func (s *Store) DeleteClient(ctx context.Context, id string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM clients WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		if err := expectRow(res); err != nil {
+			return err
+		}
+
+		for _, table := range []string{"requests", "consents", "device_authorizations", "backchannel_authentications", "login_session_clients"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE client_id = ?`, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ClientAssertionJWTValid returns fosite.ErrJTIKnown if the JWT ID was used before and has not expired yet.
+func (s *Store) ClientAssertionJWTValid(ctx context.Context, jti string) error {
+	var expiresAt int64
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT expires_at FROM jwt_assertions WHERE jti = ?`, jti).Scan(&expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if time.Unix(expiresAt, 0).After(time.Now()) {
+		return fosite.ErrJTIKnown
+	}
+	return nil
+}
+
+// SetClientAssertionJWT remembers a JWT ID until it expires.
+func (s *Store) SetClientAssertionJWT(ctx context.Context, jti string, exp time.Time) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		// Expired IDs can not be replayed anymore, so there is no point in keeping them around.
+		if _, err := tx.ExecContext(ctx, `DELETE FROM jwt_assertions WHERE expires_at < ?`, time.Now().Unix()); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `INSERT INTO jwt_assertions (jti, expires_at) VALUES (?, ?) ON CONFLICT (jti) DO NOTHING`,
+			jti, exp.Unix())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fosite.ErrJTIKnown
+		}
+		return nil
+	})
+}
+
+// expectRow returns fosite.ErrNotFound if a statement did not touch any row.
+func expectRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fosite.ErrNotFound
+	}
+	return nil
+}
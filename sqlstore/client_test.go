@@ -0,0 +1,186 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+)
+
+func TestClientCRUD(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	client := NewClient("my-client")
+	client.Secret = []byte("hash-of-foobar")
+	client.RotatedSecrets = [][]byte{[]byte("hash-of-foobaz")}
+	client.RedirectURIs = []string{"http://localhost:3846/callback"}
+	client.ClientName = "My App"
+	if err := store.CreateClient(ctx, client); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	if err := store.CreateClient(ctx, NewClient("my-client")); err == nil {
+		t.Error("CreateClient accepted a duplicate client ID")
+	}
+
+	found, err := store.GetClient(ctx, "my-client")
+	if err != nil {
+		t.Fatalf("GetClient: %v", err)
+	}
+	got := found.(*Client)
+	if got.ClientName != "My App" || len(got.RedirectURIs) != 1 {
+		t.Errorf("GetClient returned %+v", got)
+	}
+	// The secrets of fosite's DefaultClient are folded into Secrets, newest first for fosite.
+	if len(got.Secrets) != 2 || got.Secret != nil || got.RotatedSecrets != nil {
+		t.Fatalf("secrets were not folded: %+v", got.Secrets)
+	}
+	if string(got.GetHashedSecret()) != "hash-of-foobar" || len(got.GetRotatedHashes()) != 1 {
+		t.Errorf("got hashed secret %q and rotated hashes %q", got.GetHashedSecret(), got.GetRotatedHashes())
+	}
+
+	got.ClientName = "My Renamed App"
+	if err := store.UpdateClient(ctx, got); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	if err := store.CreateClient(ctx, NewClient("another-client")); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	clients, err := store.ListClients(ctx)
+	if err != nil {
+		t.Fatalf("ListClients: %v", err)
+	}
+	if len(clients) != 2 || clients[0].GetID() != "another-client" || clients[1].ClientName != "My Renamed App" {
+		t.Errorf("ListClients returned %+v", clients)
+	}
+
+	if err := store.DeleteClient(ctx, "my-client"); err != nil {
+		t.Fatalf("DeleteClient: %v", err)
+	}
+	for name, err := range map[string]error{
+		"GetClient":    func() error { _, err := store.GetClient(ctx, "my-client"); return err }(),
+		"UpdateClient": store.UpdateClient(ctx, got),
+		"DeleteClient": store.DeleteClient(ctx, "my-client"),
+	} {
+		if !errors.Is(err, fosite.ErrNotFound) {
+			t.Errorf("%s of a deleted client returned %v, want fosite.ErrNotFound", name, err)
+		}
+	}
+}
+
+func TestDeleteClientRevokesGrants(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	client := newTestClient(t, store, "my-client")
+	other := newTestClient(t, store, "other-client")
+
+	for _, c := range []*Client{client, other} {
+		request := newTestRequest(c, "peter")
+		if err := store.CreateAccessTokenSession(ctx, "access-"+c.GetID(), request); err != nil {
+			t.Fatalf("CreateAccessTokenSession: %v", err)
+		}
+		if err := store.CreateRefreshTokenSession(ctx, "refresh-"+c.GetID(), "access-"+c.GetID(), request); err != nil {
+			t.Fatalf("CreateRefreshTokenSession: %v", err)
+		}
+		if err := store.SaveConsent(ctx, &Consent{Subject: "peter", ClientID: c.GetID(), Scopes: fosite.Arguments{"openid"}, GrantedAt: time.Now()}); err != nil {
+			t.Fatalf("SaveConsent: %v", err)
+		}
+	}
+
+	if err := store.DeleteClient(ctx, "my-client"); err != nil {
+		t.Fatalf("DeleteClient: %v", err)
+	}
+
+	// A client registered with the same ID later does not inherit anything.
+	newTestClient(t, store, "my-client")
+	if _, err := store.GetAccessTokenSession(ctx, "access-my-client", &fosite.DefaultSession{}); !errors.Is(err, fosite.ErrNotFound) {
+		t.Errorf("GetAccessTokenSession() = %v, want fosite.ErrNotFound", err)
+	}
+	if _, err := store.GetRefreshTokenSession(ctx, "refresh-my-client", &fosite.DefaultSession{}); !errors.Is(err, fosite.ErrNotFound) {
+		t.Errorf("GetRefreshTokenSession() = %v, want fosite.ErrNotFound", err)
+	}
+	if _, err := store.GetConsent(ctx, "peter", "my-client"); !errors.Is(err, fosite.ErrNotFound) {
+		t.Errorf("GetConsent() = %v, want fosite.ErrNotFound", err)
+	}
+
+	// The grants of other clients are left alone.
+	if _, err := store.GetAccessTokenSession(ctx, "access-other-client", &fosite.DefaultSession{}); err != nil {
+		t.Errorf("the access token of another client was revoked: %v", err)
+	}
+	if _, err := store.GetConsent(ctx, "peter", "other-client"); err != nil {
+		t.Errorf("the consent for another client was revoked: %v", err)
+	}
+}
+
+func TestClientSecretExpiry(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	for _, tc := range []struct {
+		name    string
+		secrets []ClientSecret
+		want    string
+		rotated int
+	}{
+		{name: "no secrets"},
+		{
+			name:    "newest secret first",
+			secrets: []ClientSecret{NewClientSecret([]byte("old"), nil), NewClientSecret([]byte("new"), nil)},
+			want:    "new",
+			rotated: 1,
+		},
+		{
+			name:    "expired secrets are skipped",
+			secrets: []ClientSecret{NewClientSecret([]byte("old"), &future), NewClientSecret([]byte("new"), &past)},
+			want:    "old",
+		},
+		{
+			name:    "all secrets expired",
+			secrets: []ClientSecret{NewClientSecret([]byte("old"), &past)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewClient("my-client")
+			client.Secrets = tc.secrets
+			if got := string(client.GetHashedSecret()); got != tc.want {
+				t.Errorf("GetHashedSecret() = %q, want %q", got, tc.want)
+			}
+			if got := len(client.GetRotatedHashes()); got != tc.rotated {
+				t.Errorf("got %d rotated hashes, want %d", got, tc.rotated)
+			}
+		})
+	}
+}
+
+func TestClientAssertionJWTReplay(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	// Each step runs against the state the previous steps left behind.
+	for _, tc := range []struct {
+		name    string
+		jti     string
+		exp     time.Duration
+		wantSet error
+	}{
+		{name: "first use", jti: "jti-1", exp: time.Hour},
+		{name: "replay", jti: "jti-1", exp: time.Hour, wantSet: fosite.ErrJTIKnown},
+		{name: "another ID", jti: "jti-2", exp: time.Hour},
+		{name: "expired ID", jti: "jti-3", exp: -time.Hour},
+		{name: "reuse of an expired ID", jti: "jti-3", exp: time.Hour},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := store.SetClientAssertionJWT(ctx, tc.jti, time.Now().Add(tc.exp)); !errors.Is(err, tc.wantSet) {
+				t.Fatalf("SetClientAssertionJWT() = %v, want %v", err, tc.wantSet)
+			}
+			if err := store.ClientAssertionJWTValid(ctx, tc.jti); !errors.Is(err, fosite.ErrJTIKnown) && tc.exp > 0 {
+				t.Errorf("ClientAssertionJWTValid() = %v, want fosite.ErrJTIKnown", err)
+			}
+			used, err := store.IsJWTUsed(ctx, tc.jti)
+			if err != nil || used != (tc.exp > 0) {
+				t.Errorf("IsJWTUsed() = %v, %v, want %v", used, err, tc.exp > 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ory/fosite"
+)
+
+// Consent records the scopes a user granted to a client, and the resource servers the access is meant for.
+type Consent struct {
+	Subject   string
+	ClientID  string
+	Scopes    fosite.Arguments
+	Audience  fosite.Arguments
+	GrantedAt time.Time
+	// ExpiresAt is zero if the consent does not expire.
+	ExpiresAt time.Time
+}
+
+// GetConsent returns the consent the user gave to the client, or fosite.ErrNotFound if there is none or it expired.
+func (s *Store) GetConsent(ctx context.Context, subject string, clientID string) (*Consent, error) {
+	var scopes, audience string
+	var grantedAt int64
+	var expiresAt sql.NullInt64
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT scopes, audience, granted_at, expires_at FROM consents WHERE subject = ? AND client_id = ?`,
+		subject, clientID).Scan(&scopes, &audience, &grantedAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fosite.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	consent := &Consent{Subject: subject, ClientID: clientID, GrantedAt: time.Unix(grantedAt, 0)}
+	if expiresAt.Valid {
+		consent.ExpiresAt = time.Unix(expiresAt.Int64, 0)
+		if consent.ExpiresAt.Before(time.Now()) {
+			return nil, fosite.ErrNotFound
+		}
+	}
+	if err := json.Unmarshal([]byte(scopes), &consent.Scopes); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(audience), &consent.Audience); err != nil {
+		return nil, err
+	}
+	return consent, nil
+}
+
+// SaveConsent stores a consent, replacing the previous consent of the user to the client.
+func (s *Store) SaveConsent(ctx context.Context, consent *Consent) error {
+	scopes, err := json.Marshal(consent.Scopes)
+	if err != nil {
+		return err
+	}
+	audience, err := json.Marshal(consent.Audience)
+	if err != nil {
+		return err
+	}
+
+	var expiresAt sql.NullInt64
+	if !consent.ExpiresAt.IsZero() {
+		expiresAt = sql.NullInt64{Int64: consent.ExpiresAt.Unix(), Valid: true}
+	}
+
+	_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO consents (subject, client_id, scopes, audience, granted_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (subject, client_id) DO UPDATE SET scopes = excluded.scopes, audience = excluded.audience, granted_at = excluded.granted_at, expires_at = excluded.expires_at`,
+		consent.Subject, consent.ClientID, string(scopes), string(audience), consent.GrantedAt.Unix(), expiresAt)
+	return err
+}
+
+// RevokeConsent forgets the consent the user gave to the client.
+func (s *Store) RevokeConsent(ctx context.Context, subject string, clientID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM consents WHERE subject = ? AND client_id = ?`, subject, clientID)
+	return err
+}
@@ -0,0 +1,142 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ory/fosite"
+)
+
+// DeviceAuthorizationStatus is the state of a device authorization, see RFC 8628.
+type DeviceAuthorizationStatus string
+
+const (
+	// DeviceAuthorizationPending means the user did not enter the user code yet.
+	DeviceAuthorizationPending DeviceAuthorizationStatus = "pending"
+	// DeviceAuthorizationApproved means the user logged in and consented, the device may fetch its tokens.
+	DeviceAuthorizationApproved DeviceAuthorizationStatus = "approved"
+	// DeviceAuthorizationDenied means the user declined the request.
+	DeviceAuthorizationDenied DeviceAuthorizationStatus = "denied"
+)
+
+// slowDownIncrement is added to the polling interval whenever a device polls too fast, see section 3.5 of RFC 8628.
+const slowDownIncrement = time.Second * 5
+
+// DeviceAuthorization is an RFC 8628 device authorization request. The device polls for it with the device code,
+// which is stored as a signature only, while the user finds it with the user code.
+type DeviceAuthorization struct {
+	UserCode     string
+	Status       DeviceAuthorizationStatus
+	Interval     time.Duration
+	LastPolledAt time.Time
+	ExpiresAt    time.Time
+	// Request is the device authorization request. Once approved, it carries the granted scopes and the session of
+	// the user.
+	Request fosite.Requester
+}
+
+// CreateDeviceAuthorization stores a pending device authorization.
+func (s *Store) CreateDeviceAuthorization(ctx context.Context, signature string, auth *DeviceAuthorization) error {
+	data, err := encodeRequestData(auth.Request)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO device_authorizations (signature, user_code, client_id, status, interval_seconds, expires_at, data)
+VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		signature, auth.UserCode, auth.Request.GetClient().GetID(), DeviceAuthorizationPending, int64(auth.Interval/time.Second), auth.ExpiresAt.Unix(), data)
+	return err
+}
+
+// GetDeviceAuthorization loads a device authorization by the signature of its device code. The session of the user
+// is decoded into the given session.
+func (s *Store) GetDeviceAuthorization(ctx context.Context, signature string, session fosite.Session) (*DeviceAuthorization, error) {
+	return s.getDeviceAuthorization(ctx, `signature = ?`, signature, session)
+}
+
+// GetDeviceAuthorizationByUserCode loads a device authorization by its user code.
+func (s *Store) GetDeviceAuthorizationByUserCode(ctx context.Context, userCode string, session fosite.Session) (*DeviceAuthorization, error) {
+	return s.getDeviceAuthorization(ctx, `user_code = ?`, userCode, session)
+}
+
+func (s *Store) getDeviceAuthorization(ctx context.Context, where string, arg string, session fosite.Session) (*DeviceAuthorization, error) {
+	var auth DeviceAuthorization
+	var interval, expiresAt int64
+	var lastPolledAt sql.NullInt64
+	var data string
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT user_code, status, interval_seconds, last_polled_at, expires_at, data FROM device_authorizations WHERE `+where, arg).
+		Scan(&auth.UserCode, &auth.Status, &interval, &lastPolledAt, &expiresAt, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fosite.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	auth.Interval = time.Duration(interval) * time.Second
+	auth.ExpiresAt = time.Unix(expiresAt, 0)
+	if lastPolledAt.Valid {
+		auth.LastPolledAt = time.Unix(lastPolledAt.Int64, 0)
+	}
+
+	request, _, err := s.decodeRequest(ctx, data, session)
+	if err != nil {
+		return nil, err
+	}
+	auth.Request = request
+	return &auth, nil
+}
+
+// CompleteDeviceAuthorization records the decision of the user. The request is stored again, so it carries the
+// granted scopes and the session once approved. It returns fosite.ErrNotFound if the authorization is not pending.
+func (s *Store) CompleteDeviceAuthorization(ctx context.Context, userCode string, status DeviceAuthorizationStatus, request fosite.Requester) error {
+	data, err := encodeRequestData(request)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.conn(ctx).ExecContext(ctx, `UPDATE device_authorizations SET status = ?, data = ? WHERE user_code = ? AND status = ?`,
+		status, data, userCode, DeviceAuthorizationPending)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// PollDeviceAuthorization records that the device polled. It reports whether the device polled faster than the
+// interval allows, in which case the interval is increased.
+func (s *Store) PollDeviceAuthorization(ctx context.Context, signature string) (slowDown bool, err error) {
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		var interval int64
+		var lastPolledAt sql.NullInt64
+		err := tx.QueryRowContext(ctx, `SELECT interval_seconds, last_polled_at FROM device_authorizations WHERE signature = ?`, signature).
+			Scan(&interval, &lastPolledAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fosite.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if lastPolledAt.Valid && time.Unix(lastPolledAt.Int64, 0).Add(time.Duration(interval)*time.Second).After(now) {
+			slowDown = true
+			interval += int64(slowDownIncrement / time.Second)
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE device_authorizations SET interval_seconds = ?, last_polled_at = ? WHERE signature = ?`,
+			interval, now.Unix(), signature)
+		return err
+	})
+	return slowDown, err
+}
+
+// DeleteDeviceAuthorization removes a device authorization, device codes can be exchanged for tokens once only. It
+// returns fosite.ErrNotFound if the device authorization was removed before.
+func (s *Store) DeleteDeviceAuthorization(ctx context.Context, signature string) error {
+	res, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM device_authorizations WHERE signature = ?`, signature)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
@@ -0,0 +1,90 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ory/fosite"
+)
+
+// LoginSession is the session a user starts at the authorization server by logging in. It remembers the clients the
+// user authorized during the session, so they can be notified when the user logs out.
+type LoginSession struct {
+	ID        string
+	Subject   string
+	AuthTime  time.Time
+	ExpiresAt time.Time
+	// ClientIDs are the clients the user authorized during the session, in the order they were first authorized.
+	ClientIDs []string
+}
+
+// CreateLoginSession stores a new login session.
+func (s *Store) CreateLoginSession(ctx context.Context, session *LoginSession) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		// Expired sessions can not be logged out of anymore, so there is no point in keeping them around.
+		if _, err := tx.ExecContext(ctx, `DELETE FROM login_session_clients WHERE session_id IN (SELECT id FROM login_sessions WHERE expires_at < ?)`, time.Now().Unix()); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM login_sessions WHERE expires_at < ?`, time.Now().Unix()); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `INSERT INTO login_sessions (id, subject, auth_time, expires_at) VALUES (?, ?, ?, ?)`,
+			session.ID, session.Subject, session.AuthTime.Unix(), session.ExpiresAt.Unix())
+		return err
+	})
+}
+
+// GetLoginSession returns the login session with the given ID and the clients authorized during it, or
+// fosite.ErrNotFound if there is none or it expired.
+func (s *Store) GetLoginSession(ctx context.Context, id string) (*LoginSession, error) {
+	var authTime, expiresAt int64
+	session := &LoginSession{ID: id}
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT subject, auth_time, expires_at FROM login_sessions WHERE id = ?`, id).
+		Scan(&session.Subject, &authTime, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fosite.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	session.AuthTime = time.Unix(authTime, 0)
+	session.ExpiresAt = time.Unix(expiresAt, 0)
+	if session.ExpiresAt.Before(time.Now()) {
+		return nil, fosite.ErrNotFound
+	}
+
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT client_id FROM login_session_clients WHERE session_id = ? ORDER BY added_at, client_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var clientID string
+		if err := rows.Scan(&clientID); err != nil {
+			return nil, err
+		}
+		session.ClientIDs = append(session.ClientIDs, clientID)
+	}
+	return session, rows.Err()
+}
+
+// AddLoginSessionClient records that the user authorized the client during the login session.
+func (s *Store) AddLoginSessionClient(ctx context.Context, id string, clientID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `INSERT INTO login_session_clients (session_id, client_id, added_at) VALUES (?, ?, ?) ON CONFLICT (session_id, client_id) DO NOTHING`,
+		id, clientID, time.Now().Unix())
+	return err
+}
+
+// DeleteLoginSession ends a login session.
+func (s *Store) DeleteLoginSession(ctx context.Context, id string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM login_session_clients WHERE session_id = ?`, id); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM login_sessions WHERE id = ?`, id)
+		return err
+	})
+}
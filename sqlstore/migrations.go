@@ -0,0 +1,105 @@
+package sqlstore
+
+// migrations are applied in order, the version of a migration is its position in the list starting at 1. Never
+// change a migration that has been released, append a new one instead.
+var migrations = []string{
+	// 1: clients
+	`CREATE TABLE clients (
+	id TEXT NOT NULL PRIMARY KEY,
+	data TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+)`,
+
+	// 2: authorize codes, access tokens, refresh tokens, OpenID Connect and PKCE sessions and pushed authorization
+	// requests, keyed by the kind of request and its signature
+	`CREATE TABLE requests (
+	kind TEXT NOT NULL,
+	signature TEXT NOT NULL,
+	request_id TEXT NOT NULL,
+	client_id TEXT NOT NULL,
+	subject TEXT NOT NULL DEFAULT '',
+	access_signature TEXT NOT NULL DEFAULT '',
+	active INTEGER NOT NULL DEFAULT 1,
+	requested_at INTEGER NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (kind, signature)
+);
+CREATE INDEX requests_request_id_idx ON requests (kind, request_id)`,
+
+	// 3: JWT IDs of client assertions and RFC 7523 grants, remembered until they expire to prevent replays
+	`CREATE TABLE jwt_assertions (
+	jti TEXT NOT NULL PRIMARY KEY,
+	expires_at INTEGER NOT NULL
+)`,
+
+	// 4: public keys of issuers trusted for the RFC 7523 JWT bearer grant
+	`CREATE TABLE jwt_bearer_keys (
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	key_id TEXT NOT NULL,
+	jwk TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	PRIMARY KEY (issuer, subject, key_id)
+)`,
+
+	// 5: scopes users consented to per client, so the consent screen can be skipped
+	`CREATE TABLE consents (
+	subject TEXT NOT NULL,
+	client_id TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	granted_at INTEGER NOT NULL,
+	expires_at INTEGER,
+	PRIMARY KEY (subject, client_id)
+)`,
+
+	// 6: RFC 8628 device authorizations, keyed by the signature of the device code
+	`CREATE TABLE device_authorizations (
+	signature TEXT NOT NULL PRIMARY KEY,
+	user_code TEXT NOT NULL UNIQUE,
+	client_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	interval_seconds INTEGER NOT NULL,
+	last_polled_at INTEGER,
+	expires_at INTEGER NOT NULL,
+	data TEXT NOT NULL
+)`,
+
+	// 7: login sessions at the authorization server and the clients users authorized during them, so the clients can
+	// be notified when the user logs out
+	`CREATE TABLE login_sessions (
+	id TEXT NOT NULL PRIMARY KEY,
+	subject TEXT NOT NULL,
+	auth_time INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+CREATE TABLE login_session_clients (
+	session_id TEXT NOT NULL,
+	client_id TEXT NOT NULL,
+	added_at INTEGER NOT NULL,
+	PRIMARY KEY (session_id, client_id)
+)`,
+
+	// 8: OpenID Connect CIBA authentication requests, keyed by the auth_req_id and listed per user for approval
+	`CREATE TABLE backchannel_authentications (
+	auth_req_id TEXT NOT NULL PRIMARY KEY,
+	request_id TEXT NOT NULL UNIQUE,
+	client_id TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	status TEXT NOT NULL,
+	delivery_mode TEXT NOT NULL,
+	notification_token TEXT NOT NULL,
+	binding_message TEXT NOT NULL,
+	interval_seconds INTEGER NOT NULL,
+	last_polled_at INTEGER,
+	expires_at INTEGER NOT NULL,
+	data TEXT NOT NULL
+);
+CREATE INDEX backchannel_authentications_subject ON backchannel_authentications (subject, status)`,
+
+	// 9: resource servers users consented to per client, next to the scopes
+	`ALTER TABLE consents ADD COLUMN audience TEXT NOT NULL DEFAULT '[]'`,
+
+	// 10: when pushed authorization requests expire, so expired ones can be deleted without decoding them
+	`ALTER TABLE requests ADD COLUMN expires_at INTEGER`,
+}
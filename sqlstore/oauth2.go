@@ -0,0 +1,122 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ory/fosite"
+)
+
+func (s *Store) CreateAuthorizeCodeSession(ctx context.Context, signature string, request fosite.Requester) error {
+	return s.createRequest(ctx, kindAuthorizeCode, signature, "", request)
+}
+
+// GetAuthorizeCodeSession returns the request together with fosite.ErrInvalidatedAuthorizeCode if the code was used
+// before, so fosite can revoke the tokens issued with it.
+func (s *Store) GetAuthorizeCodeSession(ctx context.Context, signature string, session fosite.Session) (fosite.Requester, error) {
+	request, active, err := s.getRequest(ctx, kindAuthorizeCode, signature, session)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return request, fosite.ErrInvalidatedAuthorizeCode
+	}
+	return request, nil
+}
+
+func (s *Store) InvalidateAuthorizeCodeSession(ctx context.Context, signature string) error {
+	return s.deactivateRequest(ctx, kindAuthorizeCode, signature)
+}
+
+func (s *Store) CreateAccessTokenSession(ctx context.Context, signature string, request fosite.Requester) error {
+	return s.createRequest(ctx, kindAccessToken, signature, "", request)
+}
+
+func (s *Store) GetAccessTokenSession(ctx context.Context, signature string, session fosite.Session) (fosite.Requester, error) {
+	request, _, err := s.getRequest(ctx, kindAccessToken, signature, session)
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (s *Store) DeleteAccessTokenSession(ctx context.Context, signature string) error {
+	return s.deleteRequest(ctx, kindAccessToken, signature)
+}
+
+func (s *Store) CreateRefreshTokenSession(ctx context.Context, signature string, accessSignature string, request fosite.Requester) error {
+	return s.createRequest(ctx, kindRefreshToken, signature, accessSignature, request)
+}
+
+// RefreshTokenReusedError is returned for a refresh token that was rotated before, while a newer refresh token of
+// its family is still active. That is either the client or an attacker presenting a stolen token.
+//
+// The tokens of a family share the request ID, fosite keeps the ID of the authorize request through every refresh.
+// The error is a fosite.ErrInactiveToken, which makes fosite revoke all tokens of the family.
+type RefreshTokenReusedError struct {
+	// FamilyID is the request ID of the tokens of the family.
+	FamilyID string
+	ClientID string
+	Subject  string
+}
+
+func (e *RefreshTokenReusedError) Error() string {
+	return fmt.Sprintf("the rotated refresh token of family %q of client %q was used again", e.FamilyID, e.ClientID)
+}
+
+func (e *RefreshTokenReusedError) Unwrap() error {
+	return fosite.ErrInactiveToken
+}
+
+// GetRefreshTokenSession returns the request together with fosite.ErrInactiveToken if the refresh token was revoked
+// or rotated. A *RefreshTokenReusedError is returned if it was rotated and its family is still active.
+func (s *Store) GetRefreshTokenSession(ctx context.Context, signature string, session fosite.Session) (fosite.Requester, error) {
+	request, active, err := s.getRequest(ctx, kindRefreshToken, signature, session)
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		return request, nil
+	}
+
+	var subject string
+	err = s.conn(ctx).QueryRowContext(ctx, `SELECT subject FROM requests WHERE kind = ? AND request_id = ? AND active = 1 LIMIT 1`,
+		kindRefreshToken, request.GetID()).Scan(&subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The family was revoked already.
+		return request, fosite.ErrInactiveToken
+	} else if err != nil {
+		return nil, err
+	}
+	return request, &RefreshTokenReusedError{FamilyID: request.GetID(), ClientID: request.GetClient().GetID(), Subject: subject}
+}
+
+func (s *Store) DeleteRefreshTokenSession(ctx context.Context, signature string) error {
+	return s.deleteRequest(ctx, kindRefreshToken, signature)
+}
+
+// RotateRefreshToken revokes the refresh token and the access tokens of the request it was issued with, a new pair
+// is stored right after. If the refresh token was rotated since fosite read it, fosite.ErrSerializationFailure is
+// returned and no new pair is issued.
+func (s *Store) RotateRefreshToken(ctx context.Context, requestID string, refreshTokenSignature string) error {
+	if err := s.deactivateRequest(ctx, kindRefreshToken, refreshTokenSignature); err != nil {
+		return err
+	}
+	return s.RevokeAccessToken(ctx, requestID)
+}
+
+// RevokeRefreshToken marks all refresh tokens of a request, the whole family, as inactive. They are kept, so a later
+// use can be detected.
+func (s *Store) RevokeRefreshToken(ctx context.Context, requestID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `UPDATE requests SET active = 0 WHERE kind = ? AND request_id = ?`, kindRefreshToken, requestID)
+	return err
+}
+
+// RevokeAccessToken deletes all access tokens of a request. fosite revokes the refresh and access tokens of a request
+// together, so revoking either token of a family revokes its siblings as well.
+func (s *Store) RevokeAccessToken(ctx context.Context, requestID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM requests WHERE kind = ? AND request_id = ?`, kindAccessToken, requestID)
+	return err
+}
@@ -0,0 +1,277 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/ory/fosite/storage"
+)
+
+func TestRequestSessions(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	client := newTestClient(t, store, "my-client")
+
+	for _, tc := range []struct {
+		name        string
+		create      func(signature string, r fosite.Requester) error
+		get         func(signature string, session fosite.Session) (fosite.Requester, error)
+		delete      func(signature string) error
+		wantMissing error
+	}{
+		{
+			name: "authorize code",
+			create: func(signature string, r fosite.Requester) error {
+				return store.CreateAuthorizeCodeSession(ctx, signature, r)
+			},
+			get: func(signature string, s fosite.Session) (fosite.Requester, error) {
+				return store.GetAuthorizeCodeSession(ctx, signature, s)
+			},
+			delete:      func(signature string) error { return store.deleteRequest(ctx, kindAuthorizeCode, signature) },
+			wantMissing: fosite.ErrNotFound,
+		},
+		{
+			name: "access token",
+			create: func(signature string, r fosite.Requester) error {
+				return store.CreateAccessTokenSession(ctx, signature, r)
+			},
+			get: func(signature string, s fosite.Session) (fosite.Requester, error) {
+				return store.GetAccessTokenSession(ctx, signature, s)
+			},
+			delete:      func(signature string) error { return store.DeleteAccessTokenSession(ctx, signature) },
+			wantMissing: fosite.ErrNotFound,
+		},
+		{
+			name: "refresh token",
+			create: func(signature string, r fosite.Requester) error {
+				return store.CreateRefreshTokenSession(ctx, signature, "access-"+signature, r)
+			},
+			get: func(signature string, s fosite.Session) (fosite.Requester, error) {
+				return store.GetRefreshTokenSession(ctx, signature, s)
+			},
+			delete:      func(signature string) error { return store.DeleteRefreshTokenSession(ctx, signature) },
+			wantMissing: fosite.ErrNotFound,
+		},
+		{
+			name: "PKCE",
+			create: func(signature string, r fosite.Requester) error {
+				return store.CreatePKCERequestSession(ctx, signature, r)
+			},
+			get: func(signature string, s fosite.Session) (fosite.Requester, error) {
+				return store.GetPKCERequestSession(ctx, signature, s)
+			},
+			delete:      func(signature string) error { return store.DeletePKCERequestSession(ctx, signature) },
+			wantMissing: fosite.ErrNotFound,
+		},
+		{
+			name: "OpenID Connect",
+			create: func(signature string, r fosite.Requester) error {
+				return store.CreateOpenIDConnectSession(ctx, signature, r)
+			},
+			get: func(signature string, s fosite.Session) (fosite.Requester, error) {
+				return store.GetOpenIDConnectSession(ctx, signature, &fosite.Request{Session: s})
+			},
+			delete:      func(signature string) error { return store.DeleteOpenIDConnectSession(ctx, signature) },
+			wantMissing: openid.ErrNoSessionFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := newTestRequest(client, "peter")
+			if err := tc.create("signature", request); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+
+			session := &fosite.DefaultSession{}
+			got, err := tc.get("signature", session)
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			if got.GetID() != request.GetID() || got.GetClient().GetID() != "my-client" || session.GetSubject() != "peter" {
+				t.Errorf("got request %s of client %s for %q", got.GetID(), got.GetClient().GetID(), session.GetSubject())
+			}
+			if !got.GetGrantedScopes().Has("openid", "offline") || !got.GetGrantedAudience().Has("https://photos.my-application.com") {
+				t.Errorf("got scopes %v and audience %v", got.GetGrantedScopes(), got.GetGrantedAudience())
+			}
+			if got.GetRequestForm().Get("redirect_uri") == "" || got.GetRequestForm().Get("client_secret") != "" {
+				t.Errorf("the form was not stored without its secrets: %v", got.GetRequestForm())
+			}
+
+			if err := tc.delete("signature"); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			if _, err := tc.get("signature", &fosite.DefaultSession{}); !errors.Is(err, tc.wantMissing) {
+				t.Errorf("get after delete returned %v, want %v", err, tc.wantMissing)
+			}
+		})
+	}
+}
+
+func TestInvalidateAuthorizeCodeSession(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	request := newTestRequest(newTestClient(t, store, "my-client"), "peter")
+
+	if err := store.CreateAuthorizeCodeSession(ctx, "code", request); err != nil {
+		t.Fatalf("CreateAuthorizeCodeSession: %v", err)
+	}
+	if err := store.InvalidateAuthorizeCodeSession(ctx, "code"); err != nil {
+		t.Fatalf("InvalidateAuthorizeCodeSession: %v", err)
+	}
+
+	// fosite needs the request of a used code to revoke the tokens issued with it.
+	got, err := store.GetAuthorizeCodeSession(ctx, "code", &fosite.DefaultSession{})
+	if !errors.Is(err, fosite.ErrInvalidatedAuthorizeCode) {
+		t.Errorf("GetAuthorizeCodeSession() = %v, want fosite.ErrInvalidatedAuthorizeCode", err)
+	}
+	if got == nil || got.GetID() != request.GetID() {
+		t.Errorf("the request of the used code was not returned")
+	}
+	// A concurrent request that read the code before it was used must not redeem it again.
+	if err := store.InvalidateAuthorizeCodeSession(ctx, "code"); !errors.Is(err, fosite.ErrSerializationFailure) {
+		t.Errorf("InvalidateAuthorizeCodeSession of a used code returned %v, want fosite.ErrSerializationFailure", err)
+	}
+	if err := store.InvalidateAuthorizeCodeSession(ctx, "unknown"); !errors.Is(err, fosite.ErrNotFound) {
+		t.Errorf("InvalidateAuthorizeCodeSession of an unknown code returned %v, want fosite.ErrNotFound", err)
+	}
+}
+
+func TestRevocation(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name        string
+		revoke      func(store *Store, requestID string) error
+		wantAccess  error
+		wantRefresh error
+	}{
+		{
+			name:        "access token",
+			revoke:      func(store *Store, requestID string) error { return store.RevokeAccessToken(ctx, requestID) },
+			wantAccess:  fosite.ErrNotFound,
+			wantRefresh: nil,
+		},
+		{
+			name:        "refresh token",
+			revoke:      func(store *Store, requestID string) error { return store.RevokeRefreshToken(ctx, requestID) },
+			wantAccess:  nil,
+			wantRefresh: fosite.ErrInactiveToken,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newTestStore(t)
+			client := newTestClient(t, store, "my-client")
+			request := newTestRequest(client, "peter")
+			other := newTestRequest(client, "peter")
+
+			for _, r := range []*fosite.Request{request, other} {
+				if err := store.CreateAccessTokenSession(ctx, "access-"+r.GetID(), r); err != nil {
+					t.Fatalf("CreateAccessTokenSession: %v", err)
+				}
+				if err := store.CreateRefreshTokenSession(ctx, "refresh-"+r.GetID(), "access-"+r.GetID(), r); err != nil {
+					t.Fatalf("CreateRefreshTokenSession: %v", err)
+				}
+			}
+
+			if err := tc.revoke(store, request.GetID()); err != nil {
+				t.Fatalf("revoke: %v", err)
+			}
+			if _, err := store.GetAccessTokenSession(ctx, "access-"+request.GetID(), &fosite.DefaultSession{}); !errors.Is(err, tc.wantAccess) {
+				t.Errorf("GetAccessTokenSession() = %v, want %v", err, tc.wantAccess)
+			}
+			if _, err := store.GetRefreshTokenSession(ctx, "refresh-"+request.GetID(), &fosite.DefaultSession{}); !errors.Is(err, tc.wantRefresh) {
+				t.Errorf("GetRefreshTokenSession() = %v, want %v", err, tc.wantRefresh)
+			}
+
+			// The tokens of other requests are left alone.
+			if _, err := store.GetAccessTokenSession(ctx, "access-"+other.GetID(), &fosite.DefaultSession{}); err != nil {
+				t.Errorf("the access token of another request was revoked: %v", err)
+			}
+			if _, err := store.GetRefreshTokenSession(ctx, "refresh-"+other.GetID(), &fosite.DefaultSession{}); err != nil {
+				t.Errorf("the refresh token of another request was revoked: %v", err)
+			}
+		})
+	}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	request := newTestRequest(newTestClient(t, store, "my-client"), "peter")
+
+	if err := store.CreateAccessTokenSession(ctx, "access", request); err != nil {
+		t.Fatalf("CreateAccessTokenSession: %v", err)
+	}
+	if err := store.CreateRefreshTokenSession(ctx, "refresh", "access", request); err != nil {
+		t.Fatalf("CreateRefreshTokenSession: %v", err)
+	}
+	if err := store.RotateRefreshToken(ctx, request.GetID(), "refresh"); err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if _, err := store.GetAccessTokenSession(ctx, "access", &fosite.DefaultSession{}); !errors.Is(err, fosite.ErrNotFound) {
+		t.Errorf("GetAccessTokenSession() = %v, want fosite.ErrNotFound", err)
+	}
+
+	// Two requests presenting the same refresh token both read it as active, only the first may rotate it.
+	if err := store.RotateRefreshToken(ctx, request.GetID(), "refresh"); !errors.Is(err, fosite.ErrSerializationFailure) {
+		t.Errorf("rotating the refresh token again returned %v, want fosite.ErrSerializationFailure", err)
+	}
+	if err := store.RotateRefreshToken(ctx, request.GetID(), "unknown"); !errors.Is(err, fosite.ErrNotFound) {
+		t.Errorf("rotating an unknown refresh token returned %v, want fosite.ErrNotFound", err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	request := newTestRequest(newTestClient(t, store, "my-client"), "peter")
+
+	if err := store.CreateAccessTokenSession(ctx, "access", request); err != nil {
+		t.Fatalf("CreateAccessTokenSession: %v", err)
+	}
+	if err := store.CreateRefreshTokenSession(ctx, "refresh", "access", request); err != nil {
+		t.Fatalf("CreateRefreshTokenSession: %v", err)
+	}
+
+	// The refresh handler of fosite rotates the refresh token and stores the new pair in one transaction.
+	rotate := func() context.Context {
+		t.Helper()
+		txCtx, err := storage.MaybeBeginTx(ctx, store)
+		if err != nil {
+			t.Fatalf("BeginTX: %v", err)
+		}
+		if err := store.RotateRefreshToken(txCtx, request.GetID(), "refresh"); err != nil {
+			t.Fatalf("RotateRefreshToken: %v", err)
+		}
+		if err := store.CreateAccessTokenSession(txCtx, "new-access", request); err != nil {
+			t.Fatalf("CreateAccessTokenSession: %v", err)
+		}
+		return txCtx
+	}
+
+	if err := storage.MaybeRollbackTx(rotate(), store); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if _, err := store.GetRefreshTokenSession(ctx, "refresh", &fosite.DefaultSession{}); err != nil {
+		t.Errorf("the rotation was not rolled back: %v", err)
+	}
+	if _, err := store.GetAccessTokenSession(ctx, "new-access", &fosite.DefaultSession{}); !errors.Is(err, fosite.ErrNotFound) {
+		t.Errorf("GetAccessTokenSession() = %v, want fosite.ErrNotFound", err)
+	}
+
+	if err := storage.MaybeCommitTx(rotate(), store); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if _, err := store.GetRefreshTokenSession(ctx, "refresh", &fosite.DefaultSession{}); !errors.Is(err, fosite.ErrInactiveToken) {
+		t.Errorf("GetRefreshTokenSession() = %v, want fosite.ErrInactiveToken", err)
+	}
+	if _, err := store.GetAccessTokenSession(ctx, "new-access", &fosite.DefaultSession{}); err != nil {
+		t.Errorf("the new access token was not committed: %v", err)
+	}
+
+	if err := store.Commit(ctx); err == nil {
+		t.Error("Commit succeeded without a transaction")
+	}
+}
@@ -0,0 +1,27 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/openid"
+)
+
+func (s *Store) CreateOpenIDConnectSession(ctx context.Context, authorizeCode string, requester fosite.Requester) error {
+	return s.createRequest(ctx, kindOpenIDConnect, authorizeCode, "", requester)
+}
+
+func (s *Store) GetOpenIDConnectSession(ctx context.Context, authorizeCode string, requester fosite.Requester) (fosite.Requester, error) {
+	request, _, err := s.getRequest(ctx, kindOpenIDConnect, authorizeCode, requester.GetSession())
+	if errors.Is(err, fosite.ErrNotFound) {
+		return nil, openid.ErrNoSessionFound
+	} else if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (s *Store) DeleteOpenIDConnectSession(ctx context.Context, authorizeCode string) error {
+	return s.deleteRequest(ctx, kindOpenIDConnect, authorizeCode)
+}
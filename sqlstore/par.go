@@ -0,0 +1,66 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/ory/fosite"
+)
+
+// CreatePARSession stores a pushed authorization request under its request URI. The expiry fosite sets on the
+// session is recorded next to it for DeleteExpired.
+func (s *Store) CreatePARSession(ctx context.Context, requestURI string, request fosite.AuthorizeRequester) error {
+	if err := s.createRequest(ctx, kindPAR, requestURI, "", request); err != nil {
+		return err
+	}
+
+	var expiresAt sql.NullInt64
+	if session := request.GetSession(); session != nil && !session.GetExpiresAt(fosite.PushedAuthorizeRequestContext).IsZero() {
+		expiresAt = sql.NullInt64{Int64: session.GetExpiresAt(fosite.PushedAuthorizeRequestContext).Unix(), Valid: true}
+	}
+	_, err := s.conn(ctx).ExecContext(ctx, `UPDATE requests SET expires_at = ? WHERE kind = ? AND signature = ?`, expiresAt, kindPAR, requestURI)
+	return err
+}
+
+// GetPARSession loads a pushed authorization request, including the authorize parameters fosite merges into the
+// request that references it. Expired requests are reported as fosite.ErrNotFound.
+func (s *Store) GetPARSession(ctx context.Context, requestURI string) (fosite.AuthorizeRequester, error) {
+	var data string
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT data FROM requests WHERE kind = ? AND signature = ?`, kindPAR, requestURI).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fosite.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The session only carries the expiry of the request, the session of the user is set once they logged in.
+	session := new(fosite.DefaultSession)
+	request, stored, err := s.decodeRequest(ctx, data, session)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt := session.GetExpiresAt(fosite.PushedAuthorizeRequestContext); !expiresAt.IsZero() && expiresAt.Before(time.Now()) {
+		return nil, fosite.ErrNotFound
+	}
+
+	ar := fosite.NewAuthorizeRequest()
+	ar.Request = *request
+	ar.ResponseTypes = stored.ResponseTypes
+	ar.State = stored.State
+	ar.ResponseMode = fosite.ResponseModeType(stored.ResponseMode)
+	ar.DefaultResponseMode = fosite.ResponseModeType(stored.DefaultResponseMode)
+	if stored.RedirectURI != "" {
+		if ar.RedirectURI, err = url.Parse(stored.RedirectURI); err != nil {
+			return nil, err
+		}
+	}
+	return ar, nil
+}
+
+// DeletePARSession removes a pushed authorization request, request URIs are single use.
+func (s *Store) DeletePARSession(ctx context.Context, requestURI string) error {
+	return s.deleteRequest(ctx, kindPAR, requestURI)
+}
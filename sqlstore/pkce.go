@@ -0,0 +1,23 @@
+package sqlstore
+
+import (
+	"context"
+
+	"github.com/ory/fosite"
+)
+
+func (s *Store) CreatePKCERequestSession(ctx context.Context, signature string, requester fosite.Requester) error {
+	return s.createRequest(ctx, kindPKCE, signature, "", requester)
+}
+
+func (s *Store) GetPKCERequestSession(ctx context.Context, signature string, session fosite.Session) (fosite.Requester, error) {
+	request, _, err := s.getRequest(ctx, kindPKCE, signature, session)
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (s *Store) DeletePKCERequestSession(ctx context.Context, signature string) error {
+	return s.deleteRequest(ctx, kindPKCE, signature)
+}
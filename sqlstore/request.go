@@ -0,0 +1,194 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/ory/fosite"
+)
+
+// requestKind tells apart the different requests kept in the requests table.
+type requestKind string
+
+const (
+	kindAuthorizeCode requestKind = "authorize_code"
+	kindAccessToken   requestKind = "access_token"
+	kindRefreshToken  requestKind = "refresh_token"
+	kindOpenIDConnect requestKind = "openid_connect"
+	kindPKCE          requestKind = "pkce"
+	kindPAR           requestKind = "par"
+)
+
+// sensitiveFormKeys are never persisted, the stored request form is only needed to replay the original parameters.
+var sensitiveFormKeys = []string{"client_secret", "client_assertion", "password", "subject_token", "actor_token"}
+
+// storedRequest is the JSON form of a fosite.Requester. The client is stored by reference and loaded again when the
+// request is read, the session is decoded into the session the caller provides.
+type storedRequest struct {
+	ID                string          `json:"id"`
+	RequestedAt       time.Time       `json:"requested_at"`
+	ClientID          string          `json:"client_id"`
+	RequestedScope    []string        `json:"requested_scope"`
+	GrantedScope      []string        `json:"granted_scope"`
+	RequestedAudience []string        `json:"requested_audience"`
+	GrantedAudience   []string        `json:"granted_audience"`
+	Form              url.Values      `json:"form"`
+	Session           json.RawMessage `json:"session,omitempty"`
+
+	// The following are only set for authorize requests, which are stored for pushed authorization requests.
+	ResponseTypes       []string `json:"response_types,omitempty"`
+	RedirectURI         string   `json:"redirect_uri,omitempty"`
+	State               string   `json:"state,omitempty"`
+	ResponseMode        string   `json:"response_mode,omitempty"`
+	DefaultResponseMode string   `json:"default_response_mode,omitempty"`
+}
+
+func encodeRequest(r fosite.Requester) (*storedRequest, error) {
+	form := url.Values{}
+	for key, values := range r.GetRequestForm() {
+		form[key] = values
+	}
+	for _, key := range sensitiveFormKeys {
+		form.Del(key)
+	}
+
+	stored := &storedRequest{
+		ID:                r.GetID(),
+		RequestedAt:       r.GetRequestedAt(),
+		ClientID:          r.GetClient().GetID(),
+		RequestedScope:    r.GetRequestedScopes(),
+		GrantedScope:      r.GetGrantedScopes(),
+		RequestedAudience: r.GetRequestedAudience(),
+		GrantedAudience:   r.GetGrantedAudience(),
+		Form:              form,
+	}
+
+	if session := r.GetSession(); session != nil {
+		raw, err := json.Marshal(session)
+		if err != nil {
+			return nil, err
+		}
+		stored.Session = raw
+	}
+
+	if ar, ok := r.(fosite.AuthorizeRequester); ok {
+		stored.ResponseTypes = ar.GetResponseTypes()
+		if ar.GetRedirectURI() != nil {
+			stored.RedirectURI = ar.GetRedirectURI().String()
+		}
+		stored.State = ar.GetState()
+		stored.ResponseMode = string(ar.GetResponseMode())
+		stored.DefaultResponseMode = string(ar.GetDefaultResponseMode())
+	}
+
+	return stored, nil
+}
+
+// encodeRequestData returns the JSON form of a request.
+func encodeRequestData(r fosite.Requester) (string, error) {
+	stored, err := encodeRequest(r)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(stored)
+	return string(data), err
+}
+
+// decodeRequest turns a stored request back into a fosite.Request. The session is decoded into the given session,
+// which may be nil if the caller does not need it.
+func (s *Store) decodeRequest(ctx context.Context, data string, session fosite.Session) (*fosite.Request, *storedRequest, error) {
+	var stored storedRequest
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return nil, nil, err
+	}
+
+	client, err := s.getClient(ctx, stored.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if session != nil && len(stored.Session) > 0 {
+		if err := json.Unmarshal(stored.Session, session); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return &fosite.Request{
+		ID:                stored.ID,
+		RequestedAt:       stored.RequestedAt,
+		Client:            client,
+		RequestedScope:    stored.RequestedScope,
+		GrantedScope:      stored.GrantedScope,
+		RequestedAudience: stored.RequestedAudience,
+		GrantedAudience:   stored.GrantedAudience,
+		Form:              stored.Form,
+		Session:           session,
+	}, &stored, nil
+}
+
+func (s *Store) createRequest(ctx context.Context, kind requestKind, signature string, accessSignature string, r fosite.Requester) error {
+	data, err := encodeRequestData(r)
+	if err != nil {
+		return err
+	}
+
+	var subject string
+	if r.GetSession() != nil {
+		subject = r.GetSession().GetSubject()
+	}
+
+	_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO requests (kind, signature, request_id, client_id, subject, access_signature, active, requested_at, data)
+VALUES (?, ?, ?, ?, ?, ?, 1, ?, ?)`,
+		kind, signature, r.GetID(), r.GetClient().GetID(), subject, accessSignature, r.GetRequestedAt().Unix(), data)
+	return err
+}
+
+// getRequest loads a request. The active flag is returned so callers can tell invalidated requests apart, the
+// request itself is returned either way.
+func (s *Store) getRequest(ctx context.Context, kind requestKind, signature string, session fosite.Session) (*fosite.Request, bool, error) {
+	var data string
+	var active bool
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT data, active FROM requests WHERE kind = ? AND signature = ?`, kind, signature).Scan(&data, &active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, fosite.ErrNotFound
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	request, _, err := s.decodeRequest(ctx, data, session)
+	if err != nil {
+		return nil, false, err
+	}
+	return request, active, nil
+}
+
+func (s *Store) deleteRequest(ctx context.Context, kind requestKind, signature string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM requests WHERE kind = ? AND signature = ?`, kind, signature)
+	return err
+}
+
+// deactivateRequest marks an active request as used. Only one caller can: if a concurrent request presenting the same
+// code or refresh token deactivated it since it was read, fosite.ErrSerializationFailure is returned, so the token is
+// not redeemed twice. Unknown requests are fosite.ErrNotFound.
+func (s *Store) deactivateRequest(ctx context.Context, kind requestKind, signature string) error {
+	res, err := s.conn(ctx).ExecContext(ctx, `UPDATE requests SET active = 0 WHERE kind = ? AND signature = ? AND active = 1`, kind, signature)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); !errors.Is(err, fosite.ErrNotFound) {
+		return err
+	}
+
+	var exists int
+	err = s.conn(ctx).QueryRowContext(ctx, `SELECT 1 FROM requests WHERE kind = ? AND signature = ?`, kind, signature).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fosite.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return fosite.ErrSerializationFailure
+}
@@ -0,0 +1,107 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite"
+)
+
+// AddJWTBearerKey trusts a public key of an issuer to sign RFC 7523 assertions for the given subject, granting at
+// most the given scopes.
+func (s *Store) AddJWTBearerKey(ctx context.Context, issuer string, subject string, key *jose.JSONWebKey, scopes []string) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	rawScopes, err := json.Marshal(scopes)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn(ctx).ExecContext(ctx, `INSERT INTO jwt_bearer_keys (issuer, subject, key_id, jwk, scopes) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (issuer, subject, key_id) DO UPDATE SET jwk = excluded.jwk, scopes = excluded.scopes`,
+		issuer, subject, key.KeyID, string(data), string(rawScopes))
+	return err
+}
+
+func (s *Store) GetPublicKey(ctx context.Context, issuer string, subject string, keyId string) (*jose.JSONWebKey, error) {
+	var data string
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT jwk FROM jwt_bearer_keys WHERE issuer = ? AND subject = ? AND key_id = ?`,
+		issuer, subject, keyId).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fosite.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var key jose.JSONWebKey
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *Store) GetPublicKeys(ctx context.Context, issuer string, subject string) (*jose.JSONWebKeySet, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT jwk FROM jwt_bearer_keys WHERE issuer = ? AND subject = ?`, issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	set := &jose.JSONWebKeySet{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var key jose.JSONWebKey
+		if err := json.Unmarshal([]byte(data), &key); err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(set.Keys) == 0 {
+		return nil, fosite.ErrNotFound
+	}
+	return set, nil
+}
+
+func (s *Store) GetPublicKeyScopes(ctx context.Context, issuer string, subject string, keyId string) ([]string, error) {
+	var data string
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT scopes FROM jwt_bearer_keys WHERE issuer = ? AND subject = ? AND key_id = ?`,
+		issuer, subject, keyId).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fosite.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var scopes []string
+	if err := json.Unmarshal([]byte(data), &scopes); err != nil {
+		return nil, err
+	}
+	return scopes, nil
+}
+
+func (s *Store) IsJWTUsed(ctx context.Context, jti string) (bool, error) {
+	if err := s.ClientAssertionJWTValid(ctx, jti); errors.Is(err, fosite.ErrJTIKnown) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (s *Store) MarkJWTUsedForTime(ctx context.Context, jti string, exp time.Time) error {
+	return s.SetClientAssertionJWT(ctx, jti, exp)
+}
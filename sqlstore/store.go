@@ -0,0 +1,152 @@
+// Package sqlstore persists everything fosite needs to remember (clients, authorize codes, access and refresh tokens,
+// OpenID Connect and PKCE sessions, pushed authorization requests, device authorizations, CIBA authentication
+// requests, JWT assertions and RFC 7523 issuer keys), the consents users gave to clients and their login sessions in a
+// SQL database using database/sql.
+//
+// The schema is written for SQLite, but sticks to plain SQL so it is easy to port to other databases.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// errNoTransaction is returned by Commit and Rollback for a context that BeginTX did not return.
+var errNoTransaction = errors.New("the context carries no transaction")
+
+// txKey is the context key of the transaction started by BeginTX.
+type txKey struct{}
+
+// querier runs queries, on the database or in a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Store implements fosite's storage interfaces on top of a *sql.DB. Call Migrate before using it.
+type Store struct {
+	db *sql.DB
+}
+
+// New returns a store using the given database.
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// DB returns the underlying database.
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// Migrate brings the schema up to date. Migrations that were applied before are skipped, so it is safe to call on
+// every start.
+func (s *Store) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER NOT NULL PRIMARY KEY,
+	applied_at INTEGER NOT NULL
+)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for i, migration := range migrations {
+		version := i + 1
+		if version <= current {
+			continue
+		}
+
+		if err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migration); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, time.Now().Unix())
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BeginTX starts a transaction, which the methods of the store called with the returned context take part in until
+// Commit or Rollback. It implements fosite's storage.Transactional, fosite uses it to rotate refresh tokens and to
+// revoke a reused token's family atomically.
+func (s *Store) BeginTX(ctx context.Context) (context.Context, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, txKey{}, tx), nil
+}
+
+// Commit commits the transaction of a context returned by BeginTX.
+func (s *Store) Commit(ctx context.Context) error {
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+	if !ok {
+		return errNoTransaction
+	}
+	return tx.Commit()
+}
+
+// Rollback rolls back the transaction of a context returned by BeginTX.
+func (s *Store) Rollback(ctx context.Context) error {
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+	if !ok {
+		return errNoTransaction
+	}
+	return tx.Rollback()
+}
+
+// conn returns the transaction BeginTX started for ctx, or the database if there is none.
+func (s *Store) conn(ctx context.Context) querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return s.db
+}
+
+// inTx runs fn in a transaction and commits if fn succeeds. Within a transaction of BeginTX, fn runs in that one.
+// DeleteExpired deletes the pushed authorization requests, device authorizations, CIBA authentication requests and
+// JWT IDs that expired before the given time. They can not be used anymore, but nothing else removes them. Tokens are
+// kept, fosite needs them to detect reused refresh tokens.
+func (s *Store) DeleteExpired(ctx context.Context, before time.Time) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM requests WHERE kind = ? AND expires_at < ?`, kindPAR, before.Unix()); err != nil {
+			return err
+		}
+		for _, query := range []string{
+			`DELETE FROM device_authorizations WHERE expires_at < ?`,
+			`DELETE FROM backchannel_authentications WHERE expires_at < ?`,
+			`DELETE FROM jwt_assertions WHERE expires_at < ?`,
+		} {
+			if _, err := tx.ExecContext(ctx, query, before.Unix()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(tx)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,141 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+
+	_ "modernc.org/sqlite" // registers the pure Go "sqlite" driver
+)
+
+// newTestStore returns a migrated store backed by an in-memory SQLite database, which lives as long as its single
+// connection.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	store := New(db)
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return store
+}
+
+// newTestClient stores a client the requests of a test can refer to.
+func newTestClient(t *testing.T, store *Store, id string) *Client {
+	t.Helper()
+
+	client := NewClient(id)
+	client.RedirectURIs = []string{"http://localhost:3846/callback"}
+	client.Scopes = []string{"openid", "offline", "photos"}
+	if err := store.CreateClient(context.Background(), client); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	return client
+}
+
+// newTestRequest returns a request of the client for the given subject, as fosite would store it.
+func newTestRequest(client fosite.Client, subject string) *fosite.Request {
+	request := fosite.NewRequest()
+	request.Client = client
+	request.RequestedScope = fosite.Arguments{"openid", "offline"}
+	request.GrantedScope = fosite.Arguments{"openid", "offline"}
+	request.GrantedAudience = fosite.Arguments{"https://photos.my-application.com"}
+	request.Form.Set("redirect_uri", "http://localhost:3846/callback")
+	request.Form.Set("client_secret", "foobar")
+	request.Session = &fosite.DefaultSession{Subject: subject}
+	return request
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	// Migrating again must skip every migration that was applied before.
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+
+	var applied, latest int
+	if err := store.DB().QueryRowContext(ctx, `SELECT COUNT(*), MAX(version) FROM schema_migrations`).Scan(&applied, &latest); err != nil {
+		t.Fatalf("reading schema_migrations: %v", err)
+	}
+	if applied != len(migrations) || latest != len(migrations) {
+		t.Errorf("got %d migrations up to version %d, want %d", applied, latest, len(migrations))
+	}
+
+	for _, table := range []string{
+		"clients", "requests", "jwt_assertions", "jwt_bearer_keys", "consents", "device_authorizations",
+		"login_sessions", "login_session_clients", "backchannel_authentications",
+	} {
+		var name string
+		err := store.DB().QueryRowContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&name)
+		if err != nil {
+			t.Errorf("table %s: %v", table, err)
+		}
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	client := newTestClient(t, store, "my-client")
+
+	for _, tc := range []struct {
+		prefix    string
+		expiresAt time.Time
+	}{
+		{prefix: "expired", expiresAt: time.Now().Add(-time.Minute)},
+		{prefix: "valid", expiresAt: time.Now().Add(time.Minute)},
+	} {
+		par := fosite.NewAuthorizeRequest()
+		par.Client = client
+		session := new(fosite.DefaultSession)
+		session.SetExpiresAt(fosite.PushedAuthorizeRequestContext, tc.expiresAt)
+		par.Session = session
+		if err := store.CreatePARSession(ctx, tc.prefix+"-request-uri", par); err != nil {
+			t.Fatalf("CreatePARSession: %v", err)
+		}
+		if err := store.CreateDeviceAuthorization(ctx, tc.prefix+"-device-code", &DeviceAuthorization{UserCode: tc.prefix, ExpiresAt: tc.expiresAt, Request: newTestRequest(client, "")}); err != nil {
+			t.Fatalf("CreateDeviceAuthorization: %v", err)
+		}
+		if err := store.CreateBackchannelAuthentication(ctx, &BackchannelAuthentication{AuthReqID: tc.prefix + "-auth-req-id", Subject: "peter", ExpiresAt: tc.expiresAt, Request: newTestRequest(client, "peter")}); err != nil {
+			t.Fatalf("CreateBackchannelAuthentication: %v", err)
+		}
+		if err := store.SetClientAssertionJWT(ctx, tc.prefix+"-jti", tc.expiresAt); err != nil {
+			t.Fatalf("SetClientAssertionJWT: %v", err)
+		}
+	}
+	if err := store.DeleteExpired(ctx, time.Now()); err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+
+	for _, tc := range []struct {
+		table string
+		where string
+	}{
+		{table: "requests", where: "signature LIKE ?"},
+		{table: "device_authorizations", where: "signature LIKE ?"},
+		{table: "backchannel_authentications", where: "auth_req_id LIKE ?"},
+		{table: "jwt_assertions", where: "jti LIKE ?"},
+	} {
+		for prefix, want := range map[string]int{"expired": 0, "valid": 1} {
+			var got int
+			if err := store.DB().QueryRowContext(ctx, `SELECT COUNT(*) FROM `+tc.table+` WHERE `+tc.where, prefix+"-%").Scan(&got); err != nil {
+				t.Fatalf("counting %s: %v", tc.table, err)
+			}
+			if got != want {
+				t.Errorf("%d %s rows are left in %s, want %d", got, prefix, tc.table, want)
+			}
+		}
+	}
+}